@@ -0,0 +1,13 @@
+package main
+
+import (
+	"github.com/aws/aws-lambda-go/cfn"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/krunal4amity/cfn-infra/custom_resources/eks/ekscluster"
+	"github.com/krunal4amity/cfn-infra/custom_resources/resource"
+)
+
+//custom resource lambda function execution starts here. Packaged as ekscluster.zip
+func main() {
+	lambda.Start(cfn.LambdaWrap(resource.Serve(ekscluster.Handler{})))
+}
@@ -1,10 +1,9 @@
-package main
+package ekscluster
 
 import (
 	"context"
 	"fmt"
 	"github.com/aws/aws-lambda-go/cfn"
-	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/session"
@@ -12,6 +11,7 @@ import (
 	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
 	"github.com/aws/aws-sdk-go/service/eks"
 	"github.com/aws/aws-sdk-go/service/eks/eksiface"
+	"github.com/krunal4amity/cfn-infra/custom_resources/resource"
 	"log"
 	"reflect"
 	"strconv"
@@ -286,11 +286,13 @@ func (e *EksClient) createCluster(ctx context.Context, config EksClusterConfig)
 	return output, nil
 }
 
-func manageEksCluster(ctx context.Context, event cfn.Event) (physicalResourceId string, data map[string]interface{}, err error) {
-	log.Println("Initializing...")
-	sess := session.Must(session.NewSession())
-	eksApi := EksClient{Client: eks.New(sess)}
-	ec2Api := Ec2Client{Client: ec2.New(sess)}
+//Handler serves the EKS cluster custom resource. It takes a ClusterConfig object under ResourceProperties.
+type Handler struct{}
+
+var _ resource.Handler = Handler{}
+
+//reads the eks cluster configuration from the event.
+func clusterConfig(event cfn.Event) (EksClusterConfig, error) {
 	var input EksClusterConfig
 
 	if conf, ok := event.ResourceProperties["ClusterConfig"].(map[string]interface{}); ok {
@@ -299,12 +301,12 @@ func manageEksCluster(ctx context.Context, event cfn.Event) (physicalResourceId
 		input.RoleArn = conf["RoleArn"].(string)
 		pubAccess, err := strconv.ParseBool(conf["EndpointPublicAccess"].(string))
 		if err != nil {
-			return "", nil, fmt.Errorf("unable to parse to bool")
+			return input, fmt.Errorf("unable to parse to bool")
 		}
 		input.EndpointPublicAccess = pubAccess
 		privAccess, err := strconv.ParseBool(conf["EndpointPrivateAccess"].(string))
 		if err != nil {
-			return "", nil, fmt.Errorf("unable to parse to bool")
+			return input, fmt.Errorf("unable to parse to bool")
 		}
 		input.EndpointPrivateAccess = privAccess
 		tempPrivateSubnets := conf["PrivateSubnets"].([]interface{})
@@ -323,59 +325,72 @@ func manageEksCluster(ctx context.Context, event cfn.Event) (physicalResourceId
 			input.SecurityGroupIds = append(input.SecurityGroupIds, j.(string))
 		}
 	}
+	return input, nil
+}
 
-	switch event.RequestType {
-	//Event Type: Create
-	case cfn.RequestCreate:
-		log.Println("CREATE: creating an EKS cluster")
-		log.Printf("event is : %+v\n", event)
+//Create creates an EKS cluster, or brings an existing one in line with the configuration.
+func (h Handler) Create(ctx context.Context, event cfn.Event) (physicalResourceId string, data map[string]interface{}, err error) {
+	log.Println("Initializing...")
+	sess := session.Must(session.NewSession())
+	eksApi := EksClient{Client: eks.New(sess)}
+	ec2Api := Ec2Client{Client: ec2.New(sess)}
+	input, err := clusterConfig(event)
+	if err != nil {
+		return "", nil, err
+	}
 
-		//first create the tags required for alb ingress controller to be used on aws
-		err := ec2Api.addElbIngressTags(ctx, input)
-		if err != nil {
-			return "", nil, err
-		}
+	log.Println("CREATE: creating an EKS cluster")
+	log.Printf("event is : %+v\n", event)
 
-		log.Printf("input to create cluster method is : %v\n", input)
-		out, err := eksApi.createCluster(ctx, input)
-		if err != nil {
-			return "", nil, err
-		}
+	//first create the tags required for alb ingress controller to be used on aws
+	err = ec2Api.addElbIngressTags(ctx, input)
+	if err != nil {
+		return "", nil, err
+	}
 
-		data = map[string]interface{}{
-			"Arn":                      out.Arn,
-			"EndPoint":                 out.Endpoint,
-			"CertificateAuthorityData": out.CertificateAuthorityData,
-			"OidcIssuer":               out.OidcIssuer,
-		}
-		log.Printf("Data being return is : %v\n", data)
+	log.Printf("input to create cluster method is : %v\n", input)
+	out, err := eksApi.createCluster(ctx, input)
+	if err != nil {
+		return "", nil, err
+	}
 
-		return input.Name, data, nil //returns cluster name back, so that it can be used while deleting the cluster.
+	data = map[string]interface{}{
+		"Arn":                      out.Arn,
+		"EndPoint":                 out.Endpoint,
+		"CertificateAuthorityData": out.CertificateAuthorityData,
+		"OidcIssuer":               out.OidcIssuer,
+	}
+	log.Printf("Data being return is : %v\n", data)
 
-	//Event Type: Update.
-	case cfn.RequestUpdate:
-		log.Println("UPDATE: updating an EKS cluster")
-		log.Printf("event is: %+v\n", event)
-		event.RequestType = cfn.RequestCreate
-		return manageEksCluster(ctx, event)
+	return input.Name, data, nil //returns cluster name back, so that it can be used while deleting the cluster.
+}
 
-	//Event Type: Delete
-	case cfn.RequestDelete:
-		log.Println("DELETE: deleting an EKS cluster")
-		err := ec2Api.removeElbIngressTags(ctx, input)
-		if err != nil {
-			return "", nil, err
-		}
-		err = eksApi.deleteCluster(ctx, event.PhysicalResourceID)
-		if err != nil {
-			return "", nil, err
-		}
-		return "", nil, nil
-	}
-	return "", nil, nil
+//Update goes through create, which updates the cluster config and version of an existing cluster.
+func (h Handler) Update(ctx context.Context, event cfn.Event) (physicalResourceId string, data map[string]interface{}, err error) {
+	log.Println("UPDATE: updating an EKS cluster")
+	log.Printf("event is: %+v\n", event)
+	return h.Create(ctx, event)
 }
 
-//custom resource lambda function execution starts here.
-func main() {
-	lambda.Start(cfn.LambdaWrap(manageEksCluster))
+//Delete removes the alb ingress tags and deletes the cluster named by the physical resource id.
+func (h Handler) Delete(ctx context.Context, event cfn.Event) (physicalResourceId string, data map[string]interface{}, err error) {
+	log.Println("Initializing...")
+	sess := session.Must(session.NewSession())
+	eksApi := EksClient{Client: eks.New(sess)}
+	ec2Api := Ec2Client{Client: ec2.New(sess)}
+	input, err := clusterConfig(event)
+	if err != nil {
+		return "", nil, err
+	}
+
+	log.Println("DELETE: deleting an EKS cluster")
+	err = ec2Api.removeElbIngressTags(ctx, input)
+	if err != nil {
+		return "", nil, err
+	}
+	err = eksApi.deleteCluster(ctx, event.PhysicalResourceID)
+	if err != nil {
+		return "", nil, err
+	}
+	return "", nil, nil
 }
@@ -1,11 +1,10 @@
-package main
+package publishlogoptions
 
 import (
 	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/aws/aws-lambda-go/cfn"
-	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/session"
@@ -13,6 +12,7 @@ import (
 	"github.com/aws/aws-sdk-go/service/cloudwatchlogs/cloudwatchlogsiface"
 	"github.com/aws/aws-sdk-go/service/elasticsearchservice"
 	"github.com/aws/aws-sdk-go/service/elasticsearchservice/elasticsearchserviceiface"
+	"github.com/krunal4amity/cfn-infra/custom_resources/resource"
 	"log"
 )
 
@@ -202,11 +202,24 @@ func (e *esclient) deletePublishLog(ctx context.Context, config esDomainConfig)
 	return nil
 }
 
-func publishLog(ctx context.Context, event cfn.Event) (physicalResourceId string, data map[string]interface{}, err error) {
-	log.Println("Initializing...")
-	sess := session.Must(session.NewSession())
-	esApi := esclient{Client: elasticsearchservice.New(sess)}
-	cwApi := cwlogs{Client: cloudwatchlogs.New(sess)}
+//expected Input
+//{
+//	"DomainName":"The name of the elasticsearch domain",
+//	"IndexSlowLogsArn":"The arn of the cloudwatch logs to be used to store index logs of the ES domain",
+//	"SearchSlowLogsArn":"The arn of the cloudwatch logs to be used to store search logs of the ES domain",
+//	"ESApplicationLogsArn":"The arn of the cloudwatch logs to be used to store application error logs of the ES domain"
+//}
+
+//expected output
+//{
+//	"LogPublishingOptionsStatus":"The status of the log publishing options."
+//}
+type Handler struct{}
+
+var _ resource.Handler = Handler{}
+
+//reads the es domain config from the event along with the resource policy inputs for each kind of log.
+func domainConfig(event cfn.Event) (esDomainConfig, []resPolicyInput) {
 	config := esDomainConfig{
 		Domain:               event.ResourceProperties["DomainName"].(string),
 		IndexSlowLogsArn:     event.ResourceProperties["IndexSlowLogsArn"].(string),
@@ -226,88 +239,69 @@ func publishLog(ctx context.Context, event cfn.Event) (physicalResourceId string
 		policyName: fmt.Sprintf("AES-" + config.Domain + "-" + ESAPPLOGS),
 		logKind:    ESAPPLOGS,
 	}
+	return config, []resPolicyInput{indexSlowLogInput, searchSlowLogInput, esAppLogInput}
+}
 
-	switch event.RequestType {
-	case cfn.RequestCreate:
-		log.Println("CREATE: starting the create operation ")
-		log.Printf("event data :%+v\n", event)
-		log.Printf("Config Object : %+v\n", config)
+//Create creates the resource policies for index, search and application error logs and enables log publishing.
+func (h Handler) Create(ctx context.Context, event cfn.Event) (physicalResourceId string, data map[string]interface{}, err error) {
+	log.Println("Initializing...")
+	sess := session.Must(session.NewSession())
+	esApi := esclient{Client: elasticsearchservice.New(sess)}
+	cwApi := cwlogs{Client: cloudwatchlogs.New(sess)}
+	config, policyInputs := domainConfig(event)
 
-		//creating index slow logs resource policy
-		err := cwApi.resourcePolicy(ctx, config, indexSlowLogInput)
-		if err != nil {
-			return "", nil, err
-		}
-		//creating search slow logs resource policy
-		err = cwApi.resourcePolicy(ctx, config, searchSlowLogInput)
-		if err != nil {
-			return "", nil, err
-		}
-		//creating application error logs resource policy
-		err = cwApi.resourcePolicy(ctx, config, esAppLogInput)
-		if err != nil {
-			return "", nil, err
-		}
+	log.Println("CREATE: starting the create operation ")
+	log.Printf("event data :%+v\n", event)
+	log.Printf("Config Object : %+v\n", config)
 
-		resp, err := esApi.publishLog(ctx, config)
+	//creating index slow logs, search slow logs and application error logs resource policies
+	for _, policyInput := range policyInputs {
+		err := cwApi.resourcePolicy(ctx, config, policyInput)
 		if err != nil {
 			return "", nil, err
 		}
-		data = map[string]interface{}{
-			"LogPublishingOptionsStatus": resp,
-		}
-		return "", data, nil
-
-	case cfn.RequestUpdate:
-		log.Println("UPDATE: starting the update operation ")
-		log.Printf("event data : %+v\n", event)
-		event.RequestType = cfn.RequestUpdate
-		return publishLog(ctx, event)
+	}
 
-	case cfn.RequestDelete:
-		log.Println("DELETE: starting the delete operation")
-		log.Printf("event data : %+v\n", event)
+	resp, err := esApi.publishLog(ctx, config)
+	if err != nil {
+		return "", nil, err
+	}
+	data = map[string]interface{}{
+		"LogPublishingOptionsStatus": resp,
+	}
+	return "", data, nil
+}
 
-		//delete index slow logs resource policy
-		err := cwApi.delResourcePolicy(ctx, indexSlowLogInput.policyName)
-		if err != nil {
-			return "", nil, err
-		}
+//Update puts the resource policies and log publishing options again, both operations being idempotent.
+func (h Handler) Update(ctx context.Context, event cfn.Event) (physicalResourceId string, data map[string]interface{}, err error) {
+	log.Println("UPDATE: starting the update operation ")
+	log.Printf("event data : %+v\n", event)
+	return h.Create(ctx, event)
+}
 
-		//delete search logs resource policy
-		err = cwApi.delResourcePolicy(ctx, searchSlowLogInput.policyName)
-		if err != nil {
-			return "", nil, err
-		}
+//Delete removes the resource policies and disables log publishing.
+func (h Handler) Delete(ctx context.Context, event cfn.Event) (physicalResourceId string, data map[string]interface{}, err error) {
+	log.Println("Initializing...")
+	sess := session.Must(session.NewSession())
+	esApi := esclient{Client: elasticsearchservice.New(sess)}
+	cwApi := cwlogs{Client: cloudwatchlogs.New(sess)}
+	config, policyInputs := domainConfig(event)
 
-		//delete application error logs resource policy
-		err = cwApi.delResourcePolicy(ctx, esAppLogInput.policyName)
-		if err != nil {
-			return "", nil, err
-		}
+	log.Println("DELETE: starting the delete operation")
+	log.Printf("event data : %+v\n", event)
 
-		//disable publish logs configuration
-		err = esApi.deletePublishLog(ctx, config)
+	//delete index slow logs, search slow logs and application error logs resource policies
+	for _, policyInput := range policyInputs {
+		err := cwApi.delResourcePolicy(ctx, policyInput.policyName)
 		if err != nil {
 			return "", nil, err
 		}
-		return "", nil, nil
 	}
-	return
-}
 
-//expected Input
-//{
-//	"DomainName":"The name of the elasticsearch domain",
-//	"IndexSlowLogsArn":"The arn of the cloudwatch logs to be used to store index logs of the ES domain",
-//	"SearchSlowLogsArn":"The arn of the cloudwatch logs to be used to store search logs of the ES domain",
-//	"ESApplicationLogsArn":"The arn of the cloudwatch logs to be used to store application error logs of the ES domain"
-//}
-
-//expected output
-//{
-//	"LogPublishingOptionsStatus":"The status of the log publishing options."
-//}
-func main() {
-	lambda.Start(cfn.LambdaWrap(publishLog))
+	//disable publish logs configuration
+	err = esApi.deletePublishLog(ctx, config)
+	if err != nil {
+		return "", nil, err
+	}
+	return "", nil, nil
 }
@@ -0,0 +1,13 @@
+package main
+
+import (
+	"github.com/aws/aws-lambda-go/cfn"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/krunal4amity/cfn-infra/custom_resources/msk/consumergroup"
+	"github.com/krunal4amity/cfn-infra/custom_resources/resource"
+)
+
+//lambda function serving only the KafkaConsumerGroup custom resource. Packaged as consumergroup.zip
+func main() {
+	lambda.Start(cfn.LambdaWrap(resource.Serve(consumergroup.Handler{})))
+}
@@ -0,0 +1,129 @@
+package consumergroup
+
+import (
+	"context"
+	"fmt"
+	"github.com/Shopify/sarama"
+	"github.com/aws/aws-lambda-go/cfn"
+	"github.com/krunal4amity/cfn-infra/custom_resources/msk/postprocesskafka"
+	"github.com/krunal4amity/cfn-infra/custom_resources/resource"
+	"log"
+	"strconv"
+)
+
+//removing the members of a group by their member id needs kafka 2.4 or newer.
+var forceProtocolVersion = sarama.V2_4_0_0
+
+//creates a client for the cluster speaking the given kafka protocol version, finding its brokers and authenticating to
+//them as the Authentication given under properties says, the same way as the KafkaPostProcessor.
+func newClient(ctx context.Context, properties map[string]interface{}, clusterArn string, version sarama.KafkaVersion) (sarama.Client, error) {
+
+	brokers, config, err := postprocesskafka.DiscoverBrokers(ctx, properties, clusterArn, version)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := sarama.NewClient(brokers, config)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to the kafka cluster : %v", err)
+	}
+	return client, nil
+}
+
+//this handler accepts inputs under ResourceProperties as shown below.
+//{
+// "ClusterArn":"arn:aws:kafka:us-west-2:508718283261:cluster/mm/f68810de-4c55-44ad-929c-1fe3b91e4f6b-3",
+// "Authentication":"optional. TLS (default), MTLS, SCRAM-SHA-512 or PLAINTEXT, along with ClientCertificateSecretArn,
+//					CertificateAuthorityArn, ClientCommonName or ScramSecretArn as for the KafkaPostProcessor"
+// "GroupId":"dev-sparkjobs",
+// "Topics":["dev-dataplatformSparkJobRequest"] (every partition of the topics is reset. Not needed with Offsets)
+// "Strategy":"Earliest" (Earliest, Latest, Timestamp or Offsets)
+// "Timestamp":"2021-03-01T00:00:00Z" (Timestamp. RFC3339 or milliseconds since the epoch. Partitions without a message
+//				since then are reset to their latest offset)
+// "Offsets":[ (Offsets. clamped to the earliest and latest offsets of each partition)
+//				{"Topic":"dev-dataplatformSparkJobRequest","Partition":"0","Offset":"1200"},
+//				...
+//			]
+// "Force":"optional. kafka 2.4 or newer. true to remove the active members of the group before resetting its offsets"
+//}
+//The offsets are reset on Create and again on every Update, so changing any property, e.g. the Timestamp, resets them
+//again. Delete leaves the offsets alone. Groups with active members are refused unless Force is set, in which case their
+//members, e.g. consumers of a previous deployment that did not time out yet, are removed and the group is waited on to
+//be empty. Consumers that keep running rejoin the group and make the reset fail.
+//
+//The handler returns the following response (sample output)
+// {
+//		"OldOffsets": "dev-dataplatformSparkJobRequest/0=1530,dev-dataplatformSparkJobRequest/1=1498" (-1 where none was committed)
+//		"NewOffsets": "dev-dataplatformSparkJobRequest/0=0,dev-dataplatformSparkJobRequest/1=0"
+//		"ResetPartitions": "2"
+//}
+type Handler struct{}
+
+var _ resource.Handler = Handler{}
+
+//resets the offsets of the group as the properties say.
+func reset(ctx context.Context, event cfn.Event) (physicalResourceId string, data map[string]interface{}, err error) {
+
+	clusterArn, _ := event.ResourceProperties["ClusterArn"].(string)
+	settings, err := resetProperties(event.ResourceProperties)
+	if err != nil {
+		return "", nil, err
+	}
+
+	version := postprocesskafka.KafkaProtocolVersion
+	if settings.Force {
+		version = forceProtocolVersion
+	}
+	client, err := newClient(ctx, event.ResourceProperties, clusterArn, version)
+	if err != nil {
+		return "", nil, err
+	}
+	admin, err := sarama.NewClusterAdminFromClient(client)
+	if err != nil {
+		client.Close()
+		return "", nil, fmt.Errorf("unable to connect to the kafka cluster : %v", err)
+	}
+	defer admin.Close() //closes the client as well
+
+	offsets, err := resetOffsets(ctx, client, admin, settings)
+	if err != nil {
+		return "", nil, err
+	}
+
+	data = map[string]interface{}{
+		"OldOffsets":      offsets.String(func(o partitionOffset) int64 { return o.Old }),
+		"NewOffsets":      offsets.String(func(o partitionOffset) int64 { return o.New }),
+		"ResetPartitions": strconv.Itoa(len(offsets)),
+	}
+	log.Printf("data to be returned is :%v\n", data)
+	return physicalId(clusterArn, settings.GroupId), data, nil
+}
+
+//Create resets the offsets of the group.
+func (h Handler) Create(ctx context.Context, event cfn.Event) (physicalResourceId string, data map[string]interface{}, err error) {
+
+	log.Println("CREATE: resetting consumer group offsets.")
+	log.Printf("event data :%+v\n", event)
+	return reset(ctx, event)
+}
+
+//Update resets the offsets of the group again, with the new properties.
+func (h Handler) Update(ctx context.Context, event cfn.Event) (physicalResourceId string, data map[string]interface{}, err error) {
+
+	log.Println("UPDATE: resetting consumer group offsets.")
+	log.Printf("event data :%+v\n", event)
+	return reset(ctx, event)
+}
+
+//Delete leaves the offsets of the group alone.
+func (h Handler) Delete(ctx context.Context, event cfn.Event) (physicalResourceId string, data map[string]interface{}, err error) {
+
+	log.Printf("event data :%+v\n", event)
+	log.Println("DELETE: leaving the consumer group offsets alone.")
+	return event.PhysicalResourceID, nil, nil
+}
+
+//the physical resource id of the consumer group of the cluster.
+func physicalId(clusterArn, groupId string) string {
+	return fmt.Sprintf("%s/consumergroups/%s", clusterArn, groupId)
+}
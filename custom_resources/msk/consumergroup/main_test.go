@@ -0,0 +1,254 @@
+package consumergroup
+
+import (
+	"context"
+	"github.com/Shopify/sarama"
+	"github.com/krunal4amity/cfn-infra/custom_resources/msk/postprocesskafka"
+	"github.com/krunal4amity/cfn-infra/custom_resources/resource"
+	"github.com/tj/assert"
+	"testing"
+	"time"
+)
+
+func Test_ResetProperties(t *testing.T) {
+	cases := []struct {
+		Name       string
+		Properties map[string]interface{}
+		Expected   resetSettings
+		Err        string
+	}{
+		{
+			Name:       "earliest",
+			Properties: map[string]interface{}{"GroupId": "jobs", "Topics": []interface{}{"b", "a"}, "Strategy": "Earliest", "Force": "true"},
+			Expected:   resetSettings{GroupId: "jobs", Topics: []string{"a", "b"}, Strategy: strategyEarliest, Force: true},
+		},
+		{
+			Name:       "timestamp",
+			Properties: map[string]interface{}{"GroupId": "jobs", "Topics": []interface{}{"a"}, "Strategy": "Timestamp", "Timestamp": "2021-03-01T00:00:00Z"},
+			Expected:   resetSettings{GroupId: "jobs", Topics: []string{"a"}, Strategy: strategyTimestamp, Timestamp: 1614556800000},
+		},
+		{
+			Name:       "timestamp in milliseconds",
+			Properties: map[string]interface{}{"GroupId": "jobs", "Topics": []interface{}{"a"}, "Strategy": "Timestamp", "Timestamp": "1614556800000"},
+			Expected:   resetSettings{GroupId: "jobs", Topics: []string{"a"}, Strategy: strategyTimestamp, Timestamp: 1614556800000},
+		},
+		{
+			Name: "offsets",
+			Properties: map[string]interface{}{"GroupId": "jobs", "Strategy": "Offsets", "Offsets": []interface{}{
+				map[string]interface{}{"Topic": "a", "Partition": "0", "Offset": "12"},
+				map[string]interface{}{"Topic": "a", "Partition": "2", "Offset": "7"},
+			}},
+			Expected: resetSettings{GroupId: "jobs", Topics: []string{"a"}, Strategy: strategyOffsets, Offsets: map[string]map[int32]int64{"a": {0: 12, 2: 7}}},
+		},
+		{
+			Name:       "all invalid properties are reported",
+			Properties: map[string]interface{}{"Strategy": "Shift"},
+			Err:        `invalid consumer group reset: GroupId is required; Strategy "Shift" is not one of Earliest,Latest,Timestamp,Offsets; Topics are required`,
+		},
+		{
+			Name:       "bad timestamp",
+			Properties: map[string]interface{}{"GroupId": "jobs", "Topics": []interface{}{"a"}, "Strategy": "Timestamp", "Timestamp": "yesterday"},
+			Err:        "Timestamp must be RFC3339 or milliseconds since the epoch, got yesterday",
+		},
+		{
+			Name: "bad offset",
+			Properties: map[string]interface{}{"GroupId": "jobs", "Strategy": "Offsets", "Offsets": []interface{}{
+				map[string]interface{}{"Topic": "a", "Partition": "0", "Offset": "-2"},
+			}},
+			Err: "offset 1: a Topic, a non negative Partition and a non negative Offset are required",
+		},
+	}
+
+	for _, c := range cases {
+		settings, err := resetProperties(c.Properties)
+		if c.Err != "" {
+			assert.NotNil(t, err, c.Name)
+			assert.Contains(t, err.Error(), c.Err, c.Name)
+			continue
+		}
+		assert.Nil(t, err, c.Name)
+		assert.Equal(t, c.Expected, settings, c.Name)
+	}
+}
+
+//describes the group as it is, with the given members, at the given protocol version.
+func groupDescription(version int16, state string, members ...string) *sarama.DescribeGroupsResponse {
+	group := &sarama.GroupDescription{Version: version, GroupId: "jobs", State: state, Members: map[string]*sarama.GroupMemberDescription{}}
+	for _, m := range members {
+		group.Members[m] = &sarama.GroupMemberDescription{Version: version, MemberId: m, ClientId: m, ClientHost: "/10.0.0.1"}
+	}
+	return &sarama.DescribeGroupsResponse{Version: version, Groups: []*sarama.GroupDescription{group}}
+}
+
+//a mock kafka cluster of a single broker coordinating the jobs group, which consumes the 3 partitions of topic a.
+//Partition 2 of topic a has no committed offset.
+func mockGroupBroker(t *testing.T, describe ...interface{}) *sarama.MockBroker {
+	broker := sarama.NewMockBroker(t, 1)
+	offsets := sarama.NewMockOffsetResponse(t)
+	for p := int32(0); p < 3; p++ {
+		offsets.SetOffset("a", p, sarama.OffsetOldest, 10).
+			SetOffset("a", p, sarama.OffsetNewest, 100).
+			SetOffset("a", p, 1614556800000, 40+int64(p))
+	}
+	//no message of partition 2 since the timestamp.
+	offsets.SetOffset("a", 2, 1614556800000, -1)
+
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"ApiVersionsRequest": sarama.NewMockApiVersionsResponse(t),
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetController(broker.BrokerID()).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetLeader("a", 0, broker.BrokerID()).
+			SetLeader("a", 1, broker.BrokerID()).
+			SetLeader("a", 2, broker.BrokerID()),
+		"FindCoordinatorRequest": sarama.NewMockFindCoordinatorResponse(t).SetCoordinator(sarama.CoordinatorGroup, "jobs", broker),
+		"DescribeGroupsRequest":  sarama.NewMockSequence(describe...),
+		"LeaveGroupRequest":      sarama.NewMockWrapper(&sarama.LeaveGroupResponse{Version: 3}),
+		"OffsetRequest":          offsets,
+		"OffsetFetchRequest": sarama.NewMockOffsetFetchResponse(t).
+			SetOffset("jobs", "a", 0, 55, "", sarama.ErrNoError).
+			SetOffset("jobs", "a", 1, 60, "", sarama.ErrNoError),
+		"OffsetCommitRequest": sarama.NewMockOffsetCommitResponse(t),
+	})
+	return broker
+}
+
+//the offsets committed on the mock broker by partition of topic a.
+func committedOffsets(broker *sarama.MockBroker) map[int32]int64 {
+	committed := make(map[int32]int64)
+	for _, rr := range broker.History() {
+		req, ok := rr.Request.(*sarama.OffsetCommitRequest)
+		if !ok {
+			continue
+		}
+		for p := int32(0); p < 3; p++ {
+			if offset, _, err := req.Offset("a", p); err == nil {
+				committed[p] = offset
+			}
+		}
+	}
+	return committed
+}
+
+func Test_MockResetOffsets(t *testing.T) {
+	emptyPollInterval = 10 * time.Millisecond
+	outOfTime, cancel := context.WithTimeout(context.Background(), resource.ResponseMargin)
+	defer cancel()
+
+	cases := []struct {
+		Name      string
+		Ctx       context.Context
+		Settings  resetSettings
+		Version   sarama.KafkaVersion
+		Describe  []interface{}
+		Committed map[int32]int64
+		Old       string
+		New       string
+		Err       string
+	}{
+		{
+			Name:      "earliest",
+			Settings:  resetSettings{GroupId: "jobs", Topics: []string{"a"}, Strategy: strategyEarliest},
+			Describe:  []interface{}{groupDescription(0, groupStateEmpty)},
+			Committed: map[int32]int64{0: 10, 1: 10, 2: 10},
+			Old:       "a/0=55,a/1=60,a/2=-1",
+			New:       "a/0=10,a/1=10,a/2=10",
+		},
+		{
+			Name:      "latest of a group that does not exist yet",
+			Settings:  resetSettings{GroupId: "jobs", Topics: []string{"a"}, Strategy: strategyLatest},
+			Describe:  []interface{}{groupDescription(0, groupStateDead)},
+			Committed: map[int32]int64{0: 100, 1: 100, 2: 100},
+			Old:       "a/0=55,a/1=60,a/2=-1",
+			New:       "a/0=100,a/1=100,a/2=100",
+		},
+		{
+			Name:      "timestamp",
+			Settings:  resetSettings{GroupId: "jobs", Topics: []string{"a"}, Strategy: strategyTimestamp, Timestamp: 1614556800000},
+			Describe:  []interface{}{groupDescription(0, groupStateEmpty)},
+			Committed: map[int32]int64{0: 40, 1: 41, 2: 100},
+			Old:       "a/0=55,a/1=60,a/2=-1",
+			New:       "a/0=40,a/1=41,a/2=100",
+		},
+		{
+			Name:      "offsets are clamped",
+			Settings:  resetSettings{GroupId: "jobs", Topics: []string{"a"}, Strategy: strategyOffsets, Offsets: map[string]map[int32]int64{"a": {0: 5, 1: 500}}},
+			Describe:  []interface{}{groupDescription(0, groupStateEmpty)},
+			Committed: map[int32]int64{0: 10, 1: 100},
+			Old:       "a/0=55,a/1=60",
+			New:       "a/0=10,a/1=100",
+		},
+		{
+			Name:     "unknown partition",
+			Settings: resetSettings{GroupId: "jobs", Topics: []string{"a"}, Strategy: strategyOffsets, Offsets: map[string]map[int32]int64{"a": {7: 5}}},
+			Describe: []interface{}{groupDescription(0, groupStateEmpty)},
+			Err:      "topic a has no partition 7",
+		},
+		{
+			Name:     "active group is refused",
+			Settings: resetSettings{GroupId: "jobs", Topics: []string{"a"}, Strategy: strategyEarliest},
+			Describe: []interface{}{groupDescription(0, "Stable", "consumer-1", "consumer-2")},
+			Err:      "consumer group jobs is Stable with 2 active members (consumer-1@/10.0.0.1, consumer-2@/10.0.0.1). Stop its consumers or set Force",
+		},
+		{
+			Name:     "active group is forced",
+			Settings: resetSettings{GroupId: "jobs", Topics: []string{"a"}, Strategy: strategyEarliest, Force: true},
+			Version:  forceProtocolVersion,
+			Describe: []interface{}{
+				groupDescription(4, "Stable", "consumer-1"),
+				groupDescription(4, "PreparingRebalance"),
+				groupDescription(4, groupStateEmpty),
+			},
+			Committed: map[int32]int64{0: 10, 1: 10, 2: 10},
+			Old:       "a/0=55,a/1=60,a/2=-1",
+			New:       "a/0=10,a/1=10,a/2=10",
+		},
+		{
+			Name:     "forced group does not empty in time",
+			Ctx:      outOfTime,
+			Settings: resetSettings{GroupId: "jobs", Topics: []string{"a"}, Strategy: strategyEarliest, Force: true},
+			Version:  forceProtocolVersion,
+			Describe: []interface{}{
+				groupDescription(4, "Stable", "consumer-1"),
+				groupDescription(4, "PreparingRebalance", "consumer-1"),
+			},
+			Err: "gave up waiting for consumer group jobs to be empty (lambda function is about to time out), it is PreparingRebalance with 1 members",
+		},
+	}
+
+	for _, c := range cases {
+		broker := mockGroupBroker(t, c.Describe...)
+		config := sarama.NewConfig()
+		config.Version = postprocesskafka.KafkaProtocolVersion
+		if c.Version != (sarama.KafkaVersion{}) {
+			config.Version = c.Version
+		}
+		client, err := sarama.NewClient([]string{broker.Addr()}, config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		admin, err := sarama.NewClusterAdminFromClient(client)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ctx := c.Ctx
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		offsets, err := resetOffsets(ctx, client, admin, c.Settings)
+		admin.Close()
+		if c.Err != "" {
+			assert.NotNil(t, err, c.Name)
+			assert.Contains(t, err.Error(), c.Err, c.Name)
+			assert.Empty(t, committedOffsets(broker), c.Name)
+			broker.Close()
+			continue
+		}
+		assert.Nil(t, err, c.Name)
+		assert.Equal(t, c.Old, offsets.String(func(o partitionOffset) int64 { return o.Old }), c.Name)
+		assert.Equal(t, c.New, offsets.String(func(o partitionOffset) int64 { return o.New }), c.Name)
+		assert.Equal(t, c.Committed, committedOffsets(broker), c.Name)
+		broker.Close()
+	}
+}
@@ -0,0 +1,384 @@
+package consumergroup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Shopify/sarama"
+	"github.com/krunal4amity/cfn-infra/custom_resources/resource"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//reset strategies.
+const (
+	strategyEarliest  = "Earliest"
+	strategyLatest    = "Latest"
+	strategyTimestamp = "Timestamp"
+	strategyOffsets   = "Offsets"
+)
+
+//states of a group without members, Dead if the group does not exist.
+const (
+	groupStateEmpty = "Empty"
+	groupStateDead  = "Dead"
+)
+
+//interval between two checks of whether the group is empty.
+var emptyPollInterval = 5 * time.Second
+
+//what to reset, read from the properties.
+type resetSettings struct {
+	GroupId   string
+	Topics    []string                   //sorted, every partition of them is reset
+	Strategy  string                     //Earliest, Latest, Timestamp or Offsets
+	Timestamp int64                      //Timestamp. milliseconds since the epoch
+	Offsets   map[string]map[int32]int64 //Offsets. offset by partition by topic
+	Force     bool                       //removes the active members of the group
+}
+
+//the committed offset of a partition of the group before and after the reset.
+type partitionOffset struct {
+	Topic     string
+	Partition int32
+	Old       int64 //-1 if none was committed
+	New       int64
+}
+
+//the offsets reset, sorted by topic and partition.
+type partitionOffsets []partitionOffset
+
+//lists the offsets as topic/partition=offset, the offset picked by the given function.
+func (p partitionOffsets) String(offset func(partitionOffset) int64) string {
+	var parts []string
+	for _, o := range p {
+		parts = append(parts, fmt.Sprintf("%s/%d=%d", o.Topic, o.Partition, offset(o)))
+	}
+	return strings.Join(parts, ",")
+}
+
+//reads the group, topics and reset strategy. Every invalid property is reported.
+func resetProperties(properties map[string]interface{}) (resetSettings, error) {
+
+	var errs []string
+	settings := resetSettings{}
+	settings.GroupId, _ = properties["GroupId"].(string)
+	if settings.GroupId == "" {
+		errs = append(errs, "GroupId is required")
+	}
+	settings.Force, _ = strconv.ParseBool(fmt.Sprint(properties["Force"]))
+
+	topics := make(map[string]bool)
+	if list, ok := properties["Topics"].([]interface{}); ok {
+		for _, topic := range list {
+			name, _ := topic.(string)
+			if name == "" {
+				errs = append(errs, fmt.Sprintf("topic names must be non empty strings, got %v", topic))
+				continue
+			}
+			topics[name] = true
+		}
+	}
+
+	settings.Strategy, _ = properties["Strategy"].(string)
+	switch settings.Strategy {
+	case strategyEarliest, strategyLatest:
+	case strategyTimestamp:
+		timestamp := fmt.Sprint(properties["Timestamp"])
+		if t, err := time.Parse(time.RFC3339, timestamp); err == nil {
+			settings.Timestamp = t.UnixNano() / int64(time.Millisecond)
+		} else if ms, err := strconv.ParseInt(timestamp, 10, 64); err == nil && ms >= 0 {
+			settings.Timestamp = ms
+		} else {
+			errs = append(errs, fmt.Sprintf("Timestamp must be RFC3339 or milliseconds since the epoch, got %v", properties["Timestamp"]))
+		}
+	case strategyOffsets:
+		list, _ := properties["Offsets"].([]interface{})
+		if len(list) == 0 {
+			errs = append(errs, "Offsets are required with the Offsets strategy")
+		}
+		settings.Offsets = make(map[string]map[int32]int64)
+		for i, item := range list {
+			entry, _ := item.(map[string]interface{})
+			topic, _ := entry["Topic"].(string)
+			partition, perr := strconv.ParseInt(fmt.Sprint(entry["Partition"]), 10, 32)
+			offset, oerr := strconv.ParseInt(fmt.Sprint(entry["Offset"]), 10, 64)
+			if topic == "" || perr != nil || partition < 0 || oerr != nil || offset < 0 {
+				errs = append(errs, fmt.Sprintf("offset %d: a Topic, a non negative Partition and a non negative Offset are required", i+1))
+				continue
+			}
+			if settings.Offsets[topic] == nil {
+				settings.Offsets[topic] = make(map[int32]int64)
+			}
+			settings.Offsets[topic][int32(partition)] = offset
+			topics[topic] = true
+		}
+	default:
+		errs = append(errs, fmt.Sprintf("Strategy %q is not one of %s,%s,%s,%s", settings.Strategy, strategyEarliest, strategyLatest, strategyTimestamp, strategyOffsets))
+	}
+
+	for topic := range topics {
+		settings.Topics = append(settings.Topics, topic)
+	}
+	sort.Strings(settings.Topics)
+	if len(settings.Topics) == 0 && settings.Strategy != strategyOffsets {
+		errs = append(errs, "Topics are required")
+	}
+
+	if len(errs) > 0 {
+		return resetSettings{}, fmt.Errorf("invalid consumer group reset: %s", strings.Join(errs, "; "))
+	}
+	return settings, nil
+}
+
+//resets the committed offsets of the group as the settings say and returns them along with the previous ones. A group
+//with active members is refused unless forced.
+func resetOffsets(ctx context.Context, client sarama.Client, admin sarama.ClusterAdmin, settings resetSettings) (partitionOffsets, error) {
+
+	group, err := describeGroup(admin, settings.GroupId)
+	if err != nil {
+		return nil, err
+	}
+	if len(group.Members) > 0 {
+		if !settings.Force {
+			return nil, fmt.Errorf("consumer group %s is %s with %d active members (%s). Stop its consumers or set Force",
+				settings.GroupId, group.State, len(group.Members), members(group))
+		}
+		err = removeMembers(client, group)
+		if err != nil {
+			return nil, err
+		}
+		err = waitUntilEmpty(ctx, admin, settings.GroupId)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	offsets, err := targetOffsets(client, settings)
+	if err != nil {
+		return nil, err
+	}
+
+	partitions := make(map[string][]int32)
+	for _, o := range offsets {
+		partitions[o.Topic] = append(partitions[o.Topic], o.Partition)
+	}
+	committed, err := admin.ListConsumerGroupOffsets(settings.GroupId, partitions)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get the offsets of consumer group %s : %v", settings.GroupId, err)
+	}
+	for i, o := range offsets {
+		offsets[i].Old = -1
+		if block := committed.GetBlock(o.Topic, o.Partition); block != nil && block.Err == sarama.ErrNoError {
+			offsets[i].Old = block.Offset
+		}
+	}
+
+	err = commitOffsets(client, settings.GroupId, offsets)
+	if err != nil {
+		return nil, err
+	}
+	for _, o := range offsets {
+		log.Printf("reset the offset of consumer group %s on %s/%d from %d to %d", settings.GroupId, o.Topic, o.Partition, o.Old, o.New)
+	}
+	return offsets, nil
+}
+
+//describes the group. A group that does not exist is described as Dead without members.
+func describeGroup(admin sarama.ClusterAdmin, groupId string) (*sarama.GroupDescription, error) {
+
+	groups, err := admin.DescribeConsumerGroups([]string{groupId})
+	if err != nil {
+		return nil, fmt.Errorf("unable to describe consumer group %s : %v", groupId, err)
+	}
+	if len(groups) != 1 {
+		return nil, fmt.Errorf("unable to describe consumer group %s : got %d groups", groupId, len(groups))
+	}
+	if groups[0].Err != sarama.ErrNoError {
+		return nil, fmt.Errorf("unable to describe consumer group %s : %v", groupId, groups[0].Err)
+	}
+	return groups[0], nil
+}
+
+//lists the members of the group as client id@host, sorted.
+func members(group *sarama.GroupDescription) string {
+	var list []string
+	for _, m := range group.Members {
+		list = append(list, m.ClientId+"@"+m.ClientHost)
+	}
+	sort.Strings(list)
+	return strings.Join(list, ", ")
+}
+
+//removes the members of the group through its coordinator.
+func removeMembers(client sarama.Client, group *sarama.GroupDescription) error {
+
+	coordinator, err := client.Coordinator(group.GroupId)
+	if err != nil {
+		return fmt.Errorf("unable to find the coordinator of consumer group %s : %v", group.GroupId, err)
+	}
+
+	request := &sarama.LeaveGroupRequest{Version: 3, GroupId: group.GroupId}
+	for id, m := range group.Members {
+		request.Members = append(request.Members, sarama.MemberIdentity{MemberId: id, GroupInstanceId: m.GroupInstanceId})
+	}
+	response, err := coordinator.LeaveGroup(request)
+	if err != nil {
+		return fmt.Errorf("unable to remove the members of consumer group %s : %v", group.GroupId, err)
+	}
+	if response.Err != sarama.ErrNoError {
+		return fmt.Errorf("unable to remove the members of consumer group %s : %v", group.GroupId, response.Err)
+	}
+	for _, m := range response.Members {
+		if m.Err != sarama.ErrNoError && m.Err != sarama.ErrUnknownMemberId {
+			return fmt.Errorf("unable to remove member %s of consumer group %s : %v", m.MemberId, group.GroupId, m.Err)
+		}
+	}
+	log.Printf("removed the %d members (%s) of consumer group %s", len(group.Members), members(group), group.GroupId)
+	return nil
+}
+
+//waits for the group to be left without members. Gives up when the lambda function is about to time out.
+func waitUntilEmpty(ctx context.Context, admin sarama.ClusterAdmin, groupId string) error {
+	for {
+		group, err := describeGroup(admin, groupId)
+		if err != nil {
+			return err
+		}
+		if group.State == groupStateEmpty || group.State == groupStateDead {
+			return nil
+		}
+		log.Printf("consumer group %s is %s with %d members (%s)", groupId, group.State, len(group.Members), members(group))
+
+		if err := resource.Sleep(ctx, emptyPollInterval); err != nil {
+			return fmt.Errorf("gave up waiting for consumer group %s to be empty (%v), it is %s with %d members (%s). Stop its consumers",
+				groupId, err, group.State, len(group.Members), members(group))
+		}
+	}
+}
+
+//works out the offset each partition of the topics is to be reset to.
+func targetOffsets(client sarama.Client, settings resetSettings) (partitionOffsets, error) {
+
+	var offsets partitionOffsets
+	for _, topic := range settings.Topics {
+		partitions, err := client.Partitions(topic)
+		if err != nil {
+			return nil, fmt.Errorf("unable to get the partitions of topic %s : %v", topic, err)
+		}
+		sort.Slice(partitions, func(i, j int) bool { return partitions[i] < partitions[j] })
+
+		known := make(map[int32]bool)
+		for _, partition := range partitions {
+			known[partition] = true
+		}
+		for partition := range settings.Offsets[topic] {
+			if !known[partition] {
+				return nil, fmt.Errorf("topic %s has no partition %d", topic, partition)
+			}
+		}
+
+		for _, partition := range partitions {
+			given, ok := settings.Offsets[topic][partition]
+			if settings.Strategy == strategyOffsets && !ok {
+				continue
+			}
+			offset, err := targetOffset(client, settings, topic, partition, given)
+			if err != nil {
+				return nil, err
+			}
+			offsets = append(offsets, partitionOffset{Topic: topic, Partition: partition, New: offset})
+		}
+	}
+	return offsets, nil
+}
+
+//the offset a partition is to be reset to. Offsets outside of the partition are clamped to its earliest or latest offset.
+func targetOffset(client sarama.Client, settings resetSettings, topic string, partition int32, given int64) (int64, error) {
+
+	offset := func(time int64) (int64, error) {
+		o, err := client.GetOffset(topic, partition, time)
+		if err != nil {
+			return 0, fmt.Errorf("unable to get the offsets of %s/%d : %v", topic, partition, err)
+		}
+		return o, nil
+	}
+
+	earliest, err := offset(sarama.OffsetOldest)
+	if err != nil {
+		return 0, err
+	}
+	latest, err := offset(sarama.OffsetNewest)
+	if err != nil {
+		return 0, err
+	}
+
+	switch settings.Strategy {
+	case strategyEarliest:
+		return earliest, nil
+	case strategyLatest:
+		return latest, nil
+	case strategyTimestamp:
+		o, err := offset(settings.Timestamp)
+		if err != nil {
+			return 0, err
+		}
+		//no message since the timestamp.
+		if o < 0 {
+			return latest, nil
+		}
+		return o, nil
+	}
+
+	switch {
+	case given < earliest:
+		log.Printf("offset %d of %s/%d is before its earliest offset %d, using the earliest", given, topic, partition, earliest)
+		return earliest, nil
+	case given > latest:
+		log.Printf("offset %d of %s/%d is after its latest offset %d, using the latest", given, topic, partition, latest)
+		return latest, nil
+	}
+	return given, nil
+}
+
+//commits the new offsets for the group through its coordinator, as a client outside of the group does.
+func commitOffsets(client sarama.Client, groupId string, offsets partitionOffsets) error {
+
+	coordinator, err := client.Coordinator(groupId)
+	if err != nil {
+		return fmt.Errorf("unable to find the coordinator of consumer group %s : %v", groupId, err)
+	}
+
+	request := &sarama.OffsetCommitRequest{
+		Version:                 2,
+		ConsumerGroup:           groupId,
+		ConsumerGroupGeneration: sarama.GroupGenerationUndefined,
+		RetentionTime:           -1,
+	}
+	for _, o := range offsets {
+		request.AddBlock(o.Topic, o.Partition, o.New, 0, 0, "")
+	}
+	response, err := coordinator.CommitOffset(request)
+	if err != nil {
+		return fmt.Errorf("unable to commit the offsets of consumer group %s : %v", groupId, err)
+	}
+
+	var errs []string
+	for _, o := range offsets {
+		kerr := response.Errors[o.Topic][o.Partition]
+		if kerr == sarama.ErrNoError {
+			continue
+		}
+		if errors.Is(kerr, sarama.ErrUnknownMemberId) || errors.Is(kerr, sarama.ErrRebalanceInProgress) {
+			return fmt.Errorf("unable to commit the offsets of consumer group %s, it has active members again. Stop its consumers : %v", groupId, kerr)
+		}
+		errs = append(errs, fmt.Sprintf("%s/%d: %v", o.Topic, o.Partition, kerr))
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("unable to commit the offsets of consumer group %s : %s", groupId, strings.Join(errs, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,251 @@
+package kafkaacls
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/Shopify/sarama"
+	"log"
+	"sort"
+	"strings"
+)
+
+//an acl binding i.e. a principal being allowed or denied an operation on a resource from a host.
+type aclBinding struct {
+	Principal    string                        //e.g. User:CN=myclient
+	Host         string                        //* for any host
+	ResourceType sarama.AclResourceType        //Topic, Group, Cluster, TransactionalId or DelegationToken
+	ResourceName string                        //name or prefix of the resource, kafka-cluster for the cluster
+	PatternType  sarama.AclResourcePatternType //Literal or Prefixed
+	Operation    sarama.AclOperation           //e.g. Read, Write, Describe, All
+	Permission   sarama.AclPermissionType      //Allow or Deny
+}
+
+func (b aclBinding) String() string {
+	return fmt.Sprintf("%s %s %s from %s on %s %s:%s", b.Permission.String(), b.Principal, b.Operation.String(), b.Host,
+		b.PatternType.String(), b.ResourceType.String(), b.ResourceName)
+}
+
+//reads the acl bindings given under Acls. Host defaults to *, PatternType to Literal and Permission to Allow.
+//Every invalid binding is reported.
+func aclBindings(properties map[string]interface{}) ([]aclBinding, error) {
+
+	acls, ok := properties["Acls"].([]interface{})
+	if !ok {
+		return nil, nil
+	}
+
+	var bindings []aclBinding
+	var errs []string
+	for i, acl := range acls {
+		fields, ok := acl.(map[string]interface{})
+		if !ok {
+			errs = append(errs, fmt.Sprintf("acl %d: expected an object", i+1))
+			continue
+		}
+		field := func(name, def string) string {
+			if value, ok := fields[name].(string); ok && value != "" {
+				return value
+			}
+			return def
+		}
+
+		binding := aclBinding{
+			Principal:    field("Principal", ""),
+			Host:         field("Host", "*"),
+			ResourceName: field("ResourceName", ""),
+		}
+		var bad []string
+		if !strings.HasPrefix(binding.Principal, "User:") {
+			bad = append(bad, fmt.Sprintf("principal %q must be of the form User:<name>", binding.Principal))
+		}
+		if binding.ResourceName == "" {
+			bad = append(bad, "missing ResourceName")
+		}
+		if err := binding.ResourceType.UnmarshalText([]byte(field("ResourceType", ""))); err != nil || binding.ResourceType <= sarama.AclResourceAny {
+			bad = append(bad, fmt.Sprintf("ResourceType %q is not one of Topic,Group,Cluster,TransactionalId,DelegationToken", field("ResourceType", "")))
+		}
+		if err := binding.PatternType.UnmarshalText([]byte(field("PatternType", "Literal"))); err != nil || (binding.PatternType != sarama.AclPatternLiteral && binding.PatternType != sarama.AclPatternPrefixed) {
+			bad = append(bad, fmt.Sprintf("PatternType %q is not one of Literal,Prefixed", field("PatternType", "")))
+		}
+		if err := binding.Operation.UnmarshalText([]byte(field("Operation", ""))); err != nil || binding.Operation <= sarama.AclOperationAny {
+			bad = append(bad, fmt.Sprintf("Operation %q is not a valid acl operation", field("Operation", "")))
+		}
+		if err := binding.Permission.UnmarshalText([]byte(field("Permission", "Allow"))); err != nil || binding.Permission <= sarama.AclPermissionAny {
+			bad = append(bad, fmt.Sprintf("Permission %q is not one of Allow,Deny", field("Permission", "")))
+		}
+
+		if len(bad) > 0 {
+			errs = append(errs, fmt.Sprintf("acl %d: %s", i+1, strings.Join(bad, ", ")))
+			continue
+		}
+		bindings = append(bindings, binding)
+	}
+
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("invalid Acls: %s", strings.Join(errs, "; "))
+	}
+	return bindings, nil
+}
+
+//lists every acl binding on the cluster.
+func currentBindings(admin sarama.ClusterAdmin) (map[aclBinding]bool, error) {
+
+	filter := sarama.AclFilter{
+		ResourceType:              sarama.AclResourceAny,
+		ResourcePatternTypeFilter: sarama.AclPatternAny,
+		Operation:                 sarama.AclOperationAny,
+		PermissionType:            sarama.AclPermissionAny,
+	}
+	resourceAcls, err := admin.ListAcls(filter)
+	if err != nil {
+		return nil, fmt.Errorf("unable to describe acls : %v", err)
+	}
+
+	current := make(map[aclBinding]bool)
+	for _, resourceAcl := range resourceAcls {
+		for _, acl := range resourceAcl.Acls {
+			current[aclBinding{
+				Principal:    acl.Principal,
+				Host:         acl.Host,
+				ResourceType: resourceAcl.ResourceType,
+				ResourceName: resourceAcl.ResourceName,
+				PatternType:  resourceAcl.ResourcePatternType,
+				Operation:    acl.Operation,
+				Permission:   acl.PermissionType,
+			}] = true
+		}
+	}
+	return current, nil
+}
+
+//a short key of the acl binding, by which the physical resource id records the bindings that existed before the
+//resource listed them.
+func (b aclBinding) key() string {
+	sum := sha256.Sum256([]byte(b.String()))
+	return hex.EncodeToString(sum[:4])
+}
+
+//reconciles the acl bindings of the cluster with the desired ones. Missing bindings are created and the previous
+//bindings which are no longer desired are deleted, unless they are adopted i.e. existed before the resource listed them.
+//A desired binding that already exists without having been listed before is adopted when adopt is set, as on Create,
+//and refused otherwise, since only Create can record it. The keys of the newly adopted bindings are returned.
+func reconcileAcls(admin sarama.ClusterAdmin, desired []aclBinding, previous []aclBinding, adopted map[string]bool, adopt bool) ([]string, error) {
+
+	current, err := currentBindings(admin)
+	if err != nil {
+		return nil, err
+	}
+
+	listed := make(map[aclBinding]bool)
+	for _, binding := range previous {
+		listed[binding] = true
+	}
+
+	wanted := make(map[aclBinding]bool)
+	var create []aclBinding
+	var adopting, refused []string
+	for _, binding := range desired {
+		wanted[binding] = true
+		switch {
+		case !current[binding]:
+			create = append(create, binding)
+		case listed[binding] || adopted[binding.key()]:
+		case adopt:
+			log.Printf("acl %s exists already. It is adopted and will not be deleted by the resource.", binding)
+			adopting = append(adopting, binding.key())
+		default:
+			refused = append(refused, binding.String())
+		}
+	}
+	if len(refused) > 0 {
+		sort.Strings(refused)
+		return nil, fmt.Errorf("acls exist already and were not created by this resource, remove them from Acls or from the cluster first : %s", strings.Join(refused, "; "))
+	}
+
+	var remove []aclBinding
+	for _, binding := range previous {
+		if !wanted[binding] && current[binding] && !adopted[binding.key()] {
+			remove = append(remove, binding)
+		}
+	}
+
+	err = createAcls(admin, create)
+	if err != nil {
+		return nil, err
+	}
+	return adopting, deleteAcls(admin, remove)
+}
+
+//creates the acl bindings and makes sure the cluster holds them afterwards, since the brokers report failures per
+//binding which the cluster admin does not pass on.
+func createAcls(admin sarama.ClusterAdmin, bindings []aclBinding) error {
+	if len(bindings) == 0 {
+		return nil
+	}
+
+	byResource := make(map[sarama.Resource]*sarama.ResourceAcls)
+	var resourceAcls []*sarama.ResourceAcls
+	for _, binding := range bindings {
+		log.Printf("creating acl : %s", binding)
+		resource := sarama.Resource{ResourceType: binding.ResourceType, ResourceName: binding.ResourceName, ResourcePatternType: binding.PatternType}
+		if _, ok := byResource[resource]; !ok {
+			byResource[resource] = &sarama.ResourceAcls{Resource: resource}
+			resourceAcls = append(resourceAcls, byResource[resource])
+		}
+		byResource[resource].Acls = append(byResource[resource].Acls, &sarama.Acl{
+			Principal:      binding.Principal,
+			Host:           binding.Host,
+			Operation:      binding.Operation,
+			PermissionType: binding.Permission,
+		})
+	}
+
+	err := admin.CreateACLs(resourceAcls)
+	if err != nil {
+		return fmt.Errorf("unable to create acls : %v", err)
+	}
+
+	current, err := currentBindings(admin)
+	if err != nil {
+		return err
+	}
+	var missing []string
+	for _, binding := range bindings {
+		if !current[binding] {
+			missing = append(missing, binding.String())
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return fmt.Errorf("acls could not be created, make sure the cluster has an authorizer enabled : %s", strings.Join(missing, "; "))
+	}
+	return nil
+}
+
+//deletes exactly the given acl bindings.
+func deleteAcls(admin sarama.ClusterAdmin, bindings []aclBinding) error {
+	for _, binding := range bindings {
+		log.Printf("deleting acl : %s", binding)
+		binding := binding
+		filter := sarama.AclFilter{
+			ResourceType:              binding.ResourceType,
+			ResourceName:              &binding.ResourceName,
+			ResourcePatternTypeFilter: binding.PatternType,
+			Principal:                 &binding.Principal,
+			Host:                      &binding.Host,
+			Operation:                 binding.Operation,
+			PermissionType:            binding.Permission,
+		}
+		matching, err := admin.DeleteACL(filter, false)
+		if err != nil {
+			return fmt.Errorf("unable to delete acl %s : %v", binding, err)
+		}
+		for _, m := range matching {
+			if m.Err != sarama.ErrNoError {
+				return fmt.Errorf("unable to delete acl %s : %v", binding, m.Err)
+			}
+		}
+	}
+	return nil
+}
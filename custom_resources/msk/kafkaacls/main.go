@@ -0,0 +1,182 @@
+package kafkaacls
+
+import (
+	"context"
+	"fmt"
+	"github.com/Shopify/sarama"
+	"github.com/aws/aws-lambda-go/cfn"
+	"github.com/krunal4amity/cfn-infra/custom_resources/msk/postprocesskafka"
+	"github.com/krunal4amity/cfn-infra/custom_resources/resource"
+	"log"
+	"sort"
+	"strings"
+)
+
+//creates a cluster admin for the cluster, finding its brokers and authenticating to them as the Authentication given
+//under properties says, the same way as the KafkaPostProcessor.
+func newClusterAdmin(ctx context.Context, properties map[string]interface{}, clusterArn string) (sarama.ClusterAdmin, error) {
+
+	brokers, config, err := postprocesskafka.DiscoverBrokers(ctx, properties, clusterArn, postprocesskafka.KafkaProtocolVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	admin, err := sarama.NewClusterAdmin(brokers, config)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to the kafka cluster : %v", err)
+	}
+	return admin, nil
+}
+
+//this handler accepts inputs under ResourceProperties as shown below.
+//{
+// "ClusterArn":"arn:aws:kafka:us-west-2:508718283261:cluster/mm/f68810de-4c55-44ad-929c-1fe3b91e4f6b-3",
+// "Authentication":"optional. TLS (default), MTLS, SCRAM-SHA-512 or PLAINTEXT, along with ClientCertificateSecretArn,
+//					CertificateAuthorityArn, ClientCommonName or ScramSecretArn as for the KafkaPostProcessor"
+// "Acls":[
+//			{
+//				"Principal":"User:CN=sparkjobs.example.com"
+//				"Host":"*" (optional. defaults to *)
+//				"ResourceType":"Topic" (Topic, Group, Cluster, TransactionalId or DelegationToken)
+//				"ResourceName":"dev-dataplatformSparkJobRequest" (kafka-cluster for the Cluster resource type)
+//				"PatternType":"Literal" (optional. Literal or Prefixed, defaults to Literal)
+//				"Operation":"Read" (e.g. Read, Write, Describe, Create, All)
+//				"Permission":"Allow" (optional. Allow or Deny, defaults to Allow)
+//			},
+//			...
+//		]
+//}
+//The acl bindings are created on Create, reconciled with the ones on the cluster on Update and deleted on Delete.
+//Only the bindings given under Acls are ever deleted, and of those only the ones the resource created: bindings which
+//already exist on Create are adopted, recorded in the physical resource id and left on the cluster. Since an Update
+//cannot record them, it refuses to list bindings which already exist. The lambda function needs to run in the private
+//subnets of the cluster, e.g. the PrivateSubnets returned by the KafkaPreProcessor.
+type Handler struct{}
+
+var _ resource.Handler = Handler{}
+
+//Create creates the acl bindings on the cluster.
+func (h Handler) Create(ctx context.Context, event cfn.Event) (physicalResourceId string, data map[string]interface{}, err error) {
+
+	log.Println("CREATE: creating kafka acls.")
+	log.Printf("event data :%+v\n", event)
+
+	clusterArn := event.ResourceProperties["ClusterArn"].(string)
+	bindings, err := aclBindings(event.ResourceProperties)
+	if err != nil {
+		return "", nil, err
+	}
+
+	admin, err := newClusterAdmin(ctx, event.ResourceProperties, clusterArn)
+	if err != nil {
+		return "", nil, err
+	}
+	defer admin.Close()
+
+	adopted, err := reconcileAcls(admin, bindings, nil, nil, true)
+	if err != nil {
+		return "", nil, err
+	}
+	return aclsResourceId(physicalId(clusterArn, event.LogicalResourceID), adopted), nil, nil
+}
+
+//Update creates the new acl bindings and deletes the ones removed from Acls, except the adopted ones. A change of cluster creates the bindings
+//on the new cluster, the ones on the old cluster are deleted by the Delete that follows.
+func (h Handler) Update(ctx context.Context, event cfn.Event) (physicalResourceId string, data map[string]interface{}, err error) {
+
+	log.Println("UPDATE: updating kafka acls.")
+	log.Printf("event data :%+v\n", event)
+
+	clusterArn := event.ResourceProperties["ClusterArn"].(string)
+	if oldClusterArn, _ := event.OldResourceProperties["ClusterArn"].(string); oldClusterArn != clusterArn {
+		log.Printf("cluster changed from %s to %s. Creating the acls on the new cluster.", oldClusterArn, clusterArn)
+		return h.Create(ctx, event)
+	}
+
+	bindings, err := aclBindings(event.ResourceProperties)
+	if err != nil {
+		return "", nil, err
+	}
+	//the previous bindings were valid when they were created.
+	previous, _ := aclBindings(event.OldResourceProperties)
+
+	admin, err := newClusterAdmin(ctx, event.ResourceProperties, clusterArn)
+	if err != nil {
+		return "", nil, err
+	}
+	defer admin.Close()
+
+	_, adopted := parseAclsResourceId(event.PhysicalResourceID)
+	_, err = reconcileAcls(admin, bindings, previous, adopted, false)
+	if err != nil {
+		return "", nil, err
+	}
+	return event.PhysicalResourceID, nil, nil
+}
+
+//Delete deletes the acl bindings given under Acls which the resource created, leaving any other binding on the cluster
+//alone.
+func (h Handler) Delete(ctx context.Context, event cfn.Event) (physicalResourceId string, data map[string]interface{}, err error) {
+
+	log.Println("DELETE: deleting kafka acls.")
+	log.Printf("event data :%+v\n", event)
+
+	clusterArn, _ := event.ResourceProperties["ClusterArn"].(string)
+	//a failed create leaves no acls behind.
+	id, adopted := parseAclsResourceId(event.PhysicalResourceID)
+	if id != physicalId(clusterArn, event.LogicalResourceID) {
+		log.Printf("DELETE: %s was not created by this resource. Taking a clean exit...", event.PhysicalResourceID)
+		return event.PhysicalResourceID, nil, nil
+	}
+
+	bindings, err := aclBindings(event.ResourceProperties)
+	if err != nil {
+		return "", nil, err
+	}
+
+	admin, err := newClusterAdmin(ctx, event.ResourceProperties, clusterArn)
+	if err == postprocesskafka.ErrClusterNotFound {
+		log.Printf("DELETE: cluster %s no longer exists and neither do its acls.", clusterArn)
+		return event.PhysicalResourceID, nil, nil
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	defer admin.Close()
+
+	_, err = reconcileAcls(admin, nil, bindings, adopted, false)
+	if err != nil {
+		return "", nil, err
+	}
+	return event.PhysicalResourceID, nil, nil
+}
+
+//the physical resource id of the acls of the cluster.
+func physicalId(clusterArn, logicalId string) string {
+	return fmt.Sprintf("%s/acls/%s", clusterArn, logicalId)
+}
+
+//suffix of the physical resource id followed by the keys of the acl bindings which existed before the resource listed
+//them on Create. Such bindings are never deleted by the resource.
+const adoptedSuffix = "#adopted="
+
+//the physical resource id of the acls, recording the adopted bindings.
+func aclsResourceId(id string, adopted []string) string {
+	if len(adopted) == 0 {
+		return id
+	}
+	sort.Strings(adopted)
+	return id + adoptedSuffix + strings.Join(adopted, ",")
+}
+
+//the physical resource id of the acls of the cluster and the keys of the adopted bindings.
+func parseAclsResourceId(physicalResourceId string) (id string, adopted map[string]bool) {
+	adopted = make(map[string]bool)
+	parts := strings.SplitN(physicalResourceId, adoptedSuffix, 2)
+	if len(parts) == 2 {
+		for _, key := range strings.Split(parts[1], ",") {
+			adopted[key] = true
+		}
+	}
+	return parts[0], adopted
+}
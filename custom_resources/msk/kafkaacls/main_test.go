@@ -0,0 +1,214 @@
+package kafkaacls
+
+import (
+	"github.com/Shopify/sarama"
+	"github.com/krunal4amity/cfn-infra/custom_resources/msk/postprocesskafka"
+	"github.com/tj/assert"
+	"testing"
+)
+
+func Test_AclBindings(t *testing.T) {
+	cases := []struct {
+		Acls     []interface{}
+		Expected []aclBinding
+		Err      []string
+	}{
+		{
+			Acls: []interface{}{
+				map[string]interface{}{"Principal": "User:CN=jobs", "ResourceType": "Topic", "ResourceName": "requests", "Operation": "Read"},
+				map[string]interface{}{"Principal": "User:CN=jobs", "Host": "10.0.0.1", "ResourceType": "Group", "ResourceName": "jobs-", "PatternType": "Prefixed", "Operation": "All", "Permission": "Deny"},
+			},
+			Expected: []aclBinding{
+				{Principal: "User:CN=jobs", Host: "*", ResourceType: sarama.AclResourceTopic, ResourceName: "requests", PatternType: sarama.AclPatternLiteral, Operation: sarama.AclOperationRead, Permission: sarama.AclPermissionAllow},
+				{Principal: "User:CN=jobs", Host: "10.0.0.1", ResourceType: sarama.AclResourceGroup, ResourceName: "jobs-", PatternType: sarama.AclPatternPrefixed, Operation: sarama.AclOperationAll, Permission: sarama.AclPermissionDeny},
+			},
+		},
+		{
+			Acls: []interface{}{
+				map[string]interface{}{"Principal": "CN=jobs", "ResourceType": "Table", "ResourceName": "requests", "Operation": "Read"},
+				map[string]interface{}{"Principal": "User:CN=jobs", "ResourceType": "Topic", "Operation": "Any", "PatternType": "Match"},
+				"User:CN=jobs",
+			},
+			Err: []string{
+				"acl 1: principal \"CN=jobs\" must be of the form User:<name>, ResourceType \"Table\" is not one of",
+				"acl 2: missing ResourceName, PatternType \"Match\" is not one of Literal,Prefixed, Operation \"Any\" is not a valid acl operation",
+				"acl 3: expected an object",
+			},
+		},
+	}
+
+	for _, c := range cases {
+		bindings, err := aclBindings(map[string]interface{}{"Acls": c.Acls})
+		if len(c.Err) > 0 {
+			assert.NotNil(t, err)
+			for _, e := range c.Err {
+				assert.Contains(t, err.Error(), e)
+			}
+			continue
+		}
+		assert.Nil(t, err)
+		assert.Equal(t, c.Expected, bindings)
+	}
+}
+
+//a response describing the given acl bindings.
+func describeAcls(bindings ...aclBinding) *sarama.DescribeAclsResponse {
+	res := &sarama.DescribeAclsResponse{Version: 1, Err: sarama.ErrNoError}
+	for _, b := range bindings {
+		res.ResourceAcls = append(res.ResourceAcls, &sarama.ResourceAcls{
+			Resource: sarama.Resource{ResourceType: b.ResourceType, ResourceName: b.ResourceName, ResourcePatternType: b.PatternType},
+			Acls:     []*sarama.Acl{{Principal: b.Principal, Host: b.Host, Operation: b.Operation, PermissionType: b.Permission}},
+		})
+	}
+	return res
+}
+
+func Test_MockReconcileAcls(t *testing.T) {
+	read := aclBinding{Principal: "User:CN=jobs", Host: "*", ResourceType: sarama.AclResourceTopic, ResourceName: "requests", PatternType: sarama.AclPatternLiteral, Operation: sarama.AclOperationRead, Permission: sarama.AclPermissionAllow}
+	write := read
+	write.Operation = sarama.AclOperationWrite
+	describe := read
+	describe.Operation = sarama.AclOperationDescribe
+	foreign := read
+	foreign.Principal = "User:CN=someone-else"
+
+	cases := []struct {
+		Name     string
+		Desired  []aclBinding
+		Previous []aclBinding
+		Adopted  map[string]bool
+		Adopt    bool
+		Describe []interface{} //successive DescribeAcls responses
+		Created  []aclBinding
+		Deleted  []aclBinding
+		Adopting []string
+		Err      string
+	}{
+		{
+			//write is added, describe is no longer wanted and the foreign binding is left alone
+			Name:     "update",
+			Desired:  []aclBinding{read, write},
+			Previous: []aclBinding{read, describe},
+			Describe: []interface{}{describeAcls(read, describe, foreign), describeAcls(read, write, describe, foreign)},
+			Created:  []aclBinding{write},
+			Deleted:  []aclBinding{describe},
+		},
+		{
+			Name:     "delete",
+			Previous: []aclBinding{read, write},
+			Describe: []interface{}{describeAcls(read, foreign)},
+			Deleted:  []aclBinding{read},
+		},
+		{
+			//read existed before the resource
+			Name:     "create adopting",
+			Desired:  []aclBinding{read, write},
+			Adopt:    true,
+			Describe: []interface{}{describeAcls(read), describeAcls(read, write)},
+			Created:  []aclBinding{write},
+			Adopting: []string{read.key()},
+		},
+		{
+			Name:     "update listing an existing binding",
+			Desired:  []aclBinding{read, foreign},
+			Previous: []aclBinding{read},
+			Describe: []interface{}{describeAcls(read, foreign)},
+			Err:      "acls exist already and were not created by this resource",
+		},
+		{
+			Name:     "update dropping an adopted binding",
+			Desired:  []aclBinding{write},
+			Previous: []aclBinding{read, write},
+			Adopted:  map[string]bool{read.key(): true},
+			Describe: []interface{}{describeAcls(read, write)},
+		},
+		{
+			Name:     "delete with an adopted binding",
+			Previous: []aclBinding{read, write},
+			Adopted:  map[string]bool{read.key(): true},
+			Describe: []interface{}{describeAcls(read, write)},
+			Deleted:  []aclBinding{write},
+		},
+		{
+			Name:     "unchanged",
+			Desired:  []aclBinding{read},
+			Previous: []aclBinding{read},
+			Describe: []interface{}{describeAcls(read)},
+		},
+		{
+			Name:     "not created",
+			Desired:  []aclBinding{read},
+			Describe: []interface{}{describeAcls()},
+			Created:  []aclBinding{read},
+			Err:      "acls could not be created",
+		},
+	}
+
+	for _, c := range cases {
+		broker := sarama.NewMockBroker(t, 1)
+		broker.SetHandlerByMap(map[string]sarama.MockResponse{
+			"ApiVersionsRequest": sarama.NewMockApiVersionsResponse(t),
+			"MetadataRequest": sarama.NewMockMetadataResponse(t).
+				SetController(broker.BrokerID()).
+				SetBroker(broker.Addr(), broker.BrokerID()),
+			"DescribeAclsRequest": sarama.NewMockSequence(c.Describe...),
+			"CreateAclsRequest":   sarama.NewMockCreateAclsResponse(t),
+			"DeleteAclsRequest":   sarama.NewMockDeleteAclsResponse(t),
+		})
+		config := sarama.NewConfig()
+		config.Version = postprocesskafka.KafkaProtocolVersion
+		admin, err := sarama.NewClusterAdmin([]string{broker.Addr()}, config)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		adopting, err := reconcileAcls(admin, c.Desired, c.Previous, c.Adopted, c.Adopt)
+		assert.Equal(t, c.Adopting, adopting, c.Name)
+		if c.Err != "" {
+			assert.NotNil(t, err, c.Name)
+			assert.Contains(t, err.Error(), c.Err, c.Name)
+		} else {
+			assert.Nil(t, err, c.Name)
+		}
+
+		var created, deleted []aclBinding
+		for _, rr := range broker.History() {
+			switch req := rr.Request.(type) {
+			case *sarama.CreateAclsRequest:
+				for _, acl := range req.AclCreations {
+					created = append(created, aclBinding{
+						Principal: acl.Principal, Host: acl.Host, ResourceType: acl.ResourceType, ResourceName: acl.ResourceName,
+						PatternType: acl.ResourcePatternType, Operation: acl.Operation, Permission: acl.PermissionType,
+					})
+				}
+			case *sarama.DeleteAclsRequest:
+				for _, f := range req.Filters {
+					deleted = append(deleted, aclBinding{
+						Principal: *f.Principal, Host: *f.Host, ResourceType: f.ResourceType, ResourceName: *f.ResourceName,
+						PatternType: f.ResourcePatternTypeFilter, Operation: f.Operation, Permission: f.PermissionType,
+					})
+				}
+			}
+		}
+		assert.Equal(t, c.Created, created, c.Name)
+		assert.Equal(t, c.Deleted, deleted, c.Name)
+
+		admin.Close()
+		broker.Close()
+	}
+}
+
+func Test_AclsResourceId(t *testing.T) {
+	id := physicalId("arn:aws:kafka:us-west-2:508718283261:cluster/mm/f68810de", "KafkaAcls")
+	assert.Equal(t, id, aclsResourceId(id, nil))
+
+	physicalResourceId := aclsResourceId(id, []string{"ffff0000", "0a1b2c3d"})
+	assert.Equal(t, id+"#adopted=0a1b2c3d,ffff0000", physicalResourceId)
+	parsed, adopted := parseAclsResourceId(physicalResourceId)
+	assert.Equal(t, id, parsed)
+	assert.Equal(t, map[string]bool{"0a1b2c3d": true, "ffff0000": true}, adopted)
+
+	parsed, adopted = parseAclsResourceId(id)
+	assert.Equal(t, id, parsed)
+	assert.Empty(t, adopted)
+}
@@ -0,0 +1,13 @@
+package main
+
+import (
+	"github.com/aws/aws-lambda-go/cfn"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/krunal4amity/cfn-infra/custom_resources/msk/kafkascram"
+	"github.com/krunal4amity/cfn-infra/custom_resources/resource"
+)
+
+//lambda function serving only the KafkaScramSecrets custom resource. Packaged as kafkascram.zip
+func main() {
+	lambda.Start(cfn.LambdaWrap(resource.Serve(kafkascram.Handler{})))
+}
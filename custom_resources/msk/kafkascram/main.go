@@ -0,0 +1,300 @@
+package kafkascram
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-lambda-go/cfn"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/kafka"
+	"github.com/aws/aws-sdk-go/service/kafka/kafkaiface"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/secretsmanager/secretsmanageriface"
+	"github.com/krunal4amity/cfn-infra/custom_resources/resource"
+	"log"
+	"strings"
+)
+
+//MSK client
+type MSKclient struct {
+	Client kafkaiface.KafkaAPI
+}
+
+//Secrets Manager client
+type SMclient struct {
+	Client secretsmanageriface.SecretsManagerAPI
+}
+
+//the scram users of a cluster as given under ResourceProperties.
+type scramSettings struct {
+	ClusterArn string
+	KmsKeyId   string //customer managed key, MSK does not accept secrets encrypted with the default key
+	Prefix     string //part of the secret names between AmazonMSK_ and the username
+	Users      []scramUser
+}
+
+//reads and validates the resource properties.
+func settings(properties map[string]interface{}) (scramSettings, error) {
+	s := scramSettings{}
+	s.ClusterArn, _ = properties["ClusterArn"].(string)
+	s.KmsKeyId, _ = properties["KmsKeyId"].(string)
+	s.Prefix, _ = properties["SecretNamePrefix"].(string)
+
+	var errs []string
+	if s.ClusterArn == "" {
+		errs = append(errs, "missing ClusterArn")
+	}
+	if s.KmsKeyId == "" {
+		errs = append(errs, "missing KmsKeyId, MSK only accepts secrets encrypted with a customer managed kms key")
+	}
+	if s.Prefix != "" && !secretNameChars.MatchString(s.Prefix) {
+		errs = append(errs, fmt.Sprintf("SecretNamePrefix %q may only hold letters, digits and /_+=.@-", s.Prefix))
+	}
+	users, err := scramUsers(properties)
+	if err != nil {
+		errs = append(errs, err.Error())
+	}
+	if len(errs) > 0 {
+		return scramSettings{}, fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	s.Users = users
+	return s, nil
+}
+
+//makes the secrets of the desired users exist and be associated with the cluster. previous holds the settings before
+//an update, zero on create. Secrets of users no longer desired are disassociated and deleted, and on a change of
+//cluster the secrets are moved from the previous cluster. Returns the secret arns in the order of the users.
+func reconcile(ctx context.Context, sm SMclient, m MSKclient, desired, previous scramSettings) ([]string, error) {
+
+	previousUsers := make(map[string]scramUser)
+	for _, user := range previous.Users {
+		previousUsers[user.Username] = user
+	}
+
+	var arns []string
+	desiredUsers := make(map[string]bool)
+	for _, user := range desired.Users {
+		desiredUsers[user.Username] = true
+		//imported passwords are written when they change, generated ones are kept.
+		prev, existed := previousUsers[user.Username]
+		writePassword := user.Password != "" && (!existed || prev.Password != user.Password)
+
+		arn, err := sm.ensureSecret(ctx, secretName(desired.Prefix, user.Username), desired.KmsKeyId, user, writePassword)
+		if err != nil {
+			return nil, err
+		}
+		arns = append(arns, arn)
+	}
+
+	if previous.ClusterArn != "" && previous.ClusterArn != desired.ClusterArn {
+		log.Printf("cluster changed from %s to %s.", previous.ClusterArn, desired.ClusterArn)
+		err := removeSecrets(ctx, sm, m, previous.ClusterArn, previous.Prefix, previous.Users, false)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	associated, err := m.scramSecrets(ctx, desired.ClusterArn)
+	if err != nil {
+		return nil, err
+	}
+	var missing []string
+	for _, arn := range arns {
+		if !associated[arn] {
+			missing = append(missing, arn)
+		}
+	}
+	err = m.associate(ctx, desired.ClusterArn, missing)
+	if err != nil {
+		return nil, err
+	}
+
+	var removed []scramUser
+	for _, user := range previous.Users {
+		if !desiredUsers[user.Username] {
+			removed = append(removed, user)
+		}
+	}
+	err = removeSecrets(ctx, sm, m, desired.ClusterArn, previous.Prefix, removed, true)
+	if err != nil {
+		return nil, err
+	}
+	return arns, nil
+}
+
+//disassociates the secrets of the users from the cluster, deleting them as well if deleteSecrets is set.
+func removeSecrets(ctx context.Context, sm SMclient, m MSKclient, clusterArn, prefix string, users []scramUser, deleteSecrets bool) error {
+	if len(users) == 0 {
+		return nil
+	}
+
+	associated, err := m.scramSecrets(ctx, clusterArn)
+	if err != nil {
+		return err
+	}
+	var arns []string
+	for _, user := range users {
+		arn, err := sm.secretArn(ctx, secretName(prefix, user.Username))
+		if err != nil {
+			return err
+		}
+		if associated[arn] {
+			arns = append(arns, arn)
+		}
+	}
+	err = m.disassociate(ctx, clusterArn, arns)
+	if err != nil {
+		return err
+	}
+
+	if !deleteSecrets {
+		return nil
+	}
+	for _, user := range users {
+		err = sm.deleteSecret(ctx, secretName(prefix, user.Username))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//this handler accepts inputs under ResourceProperties as shown below.
+//{
+// "ClusterArn":"arn:aws:kafka:us-west-2:508718283261:cluster/mm/f68810de-4c55-44ad-929c-1fe3b91e4f6b-3",
+// "KmsKeyId":"arn:aws:kms:us-west-2:508718283261:key/1234abcd-12ab-34cd-56ef-1234567890ab",
+// "SecretNamePrefix":"dev_" (optional. the secrets are named AmazonMSK_<SecretNamePrefix><Username>)
+// "Users":[
+//			"sparkjobs", (a password is generated)
+//			{"Username":"schemaregistry","Password":"imported password"},
+//			...
+//		]
+//}
+//The handler returns the following response (sample output)
+//{
+//		"SecretArns":"arn:aws:secretsmanager:us-west-2:508718283261:secret:AmazonMSK_dev_sparkjobs-a1B2c3,..." (in the order of Users)
+//}
+//Secrets of removed users are disassociated and deleted on Update, and all of them on Delete. Deleted secrets can be
+//recovered within the recovery window of Secrets Manager and are restored if the user is added back.
+type Handler struct{}
+
+var _ resource.Handler = Handler{}
+
+//Create creates the secrets of the users and associates them with the cluster.
+func (h Handler) Create(ctx context.Context, event cfn.Event) (physicalResourceId string, data map[string]interface{}, err error) {
+
+	log.Println("CREATE: creating scram secrets.")
+	log.Printf("event data :%+v\n", redacted(event))
+
+	desired, err := settings(event.ResourceProperties)
+	if err != nil {
+		return "", nil, err
+	}
+
+	sm, m := clients()
+	arns, err := reconcile(ctx, sm, m, desired, scramSettings{})
+	if err != nil {
+		return "", nil, err
+	}
+	return physicalId(desired.Prefix, event.LogicalResourceID), map[string]interface{}{"SecretArns": strings.Join(arns, ",")}, nil
+}
+
+//Update creates the secrets of new users, deletes the ones of removed users and moves the secrets to the new cluster if
+//ClusterArn changes. A change of SecretNamePrefix creates new secrets, the old ones are deleted by the Delete that follows.
+func (h Handler) Update(ctx context.Context, event cfn.Event) (physicalResourceId string, data map[string]interface{}, err error) {
+
+	log.Println("UPDATE: updating scram secrets.")
+	log.Printf("event data :%+v\n", redacted(event))
+
+	desired, err := settings(event.ResourceProperties)
+	if err != nil {
+		return "", nil, err
+	}
+	//the previous settings were valid when they were applied.
+	previous, _ := settings(event.OldResourceProperties)
+	if previous.Prefix != desired.Prefix {
+		log.Printf("SecretNamePrefix changed from %q to %q. Creating new secrets.", previous.Prefix, desired.Prefix)
+		return h.Create(ctx, event)
+	}
+
+	sm, m := clients()
+	arns, err := reconcile(ctx, sm, m, desired, previous)
+	if err != nil {
+		return "", nil, err
+	}
+	return event.PhysicalResourceID, map[string]interface{}{"SecretArns": strings.Join(arns, ",")}, nil
+}
+
+//Delete disassociates the secrets of the users from the cluster and deletes them.
+func (h Handler) Delete(ctx context.Context, event cfn.Event) (physicalResourceId string, data map[string]interface{}, err error) {
+
+	log.Println("DELETE: deleting scram secrets.")
+	log.Printf("event data :%+v\n", redacted(event))
+
+	prefix, _ := event.ResourceProperties["SecretNamePrefix"].(string)
+	//a failed create leaves no secrets behind.
+	if event.PhysicalResourceID != physicalId(prefix, event.LogicalResourceID) {
+		log.Printf("DELETE: %s was not created by this resource. Taking a clean exit...", event.PhysicalResourceID)
+		return event.PhysicalResourceID, nil, nil
+	}
+
+	current, err := settings(event.ResourceProperties)
+	if err != nil {
+		return "", nil, err
+	}
+
+	sm, m := clients()
+	err = removeSecrets(ctx, sm, m, current.ClusterArn, current.Prefix, current.Users, true)
+	if err != nil {
+		return "", nil, err
+	}
+	return event.PhysicalResourceID, nil, nil
+}
+
+//clients of a new aws session.
+func clients() (SMclient, MSKclient) {
+	sess := session.Must(session.NewSession())
+	return SMclient{Client: secretsmanager.New(sess)}, MSKclient{Client: kafka.New(sess)}
+}
+
+//the physical resource id of the secrets, which changes along with their names.
+func physicalId(prefix, logicalId string) string {
+	return fmt.Sprintf("%s/%s", logicalId, secretName(prefix, "*"))
+}
+
+//a copy of the event to log, with the imported passwords of the users masked.
+func redacted(event cfn.Event) cfn.Event {
+	event.ResourceProperties = redactedProperties(event.ResourceProperties)
+	event.OldResourceProperties = redactedProperties(event.OldResourceProperties)
+	return event
+}
+
+//a copy of the properties with the Password of the Users masked, the properties are left untouched.
+func redactedProperties(properties map[string]interface{}) map[string]interface{} {
+	users, ok := properties["Users"].([]interface{})
+	if !ok {
+		return properties
+	}
+	copied := make(map[string]interface{}, len(properties))
+	for k, v := range properties {
+		copied[k] = v
+	}
+	redactedUsers := make([]interface{}, len(users))
+	for i, u := range users {
+		redactedUsers[i] = u
+		user, ok := u.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if _, ok := user["Password"]; !ok {
+			continue
+		}
+		redactedUser := make(map[string]interface{}, len(user))
+		for k, v := range user {
+			redactedUser[k] = v
+		}
+		redactedUser["Password"] = "****"
+		redactedUsers[i] = redactedUser
+	}
+	copied["Users"] = redactedUsers
+	return copied
+}
@@ -0,0 +1,269 @@
+package kafkascram
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-lambda-go/cfn"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/kafka"
+	"github.com/aws/aws-sdk-go/service/kafka/kafkaiface"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/secretsmanager/secretsmanageriface"
+	"github.com/tj/assert"
+	"testing"
+	"time"
+)
+
+type mockSecret struct {
+	arn     string
+	kmsKey  string
+	value   string
+	deleted bool
+}
+
+//an in memory secrets manager.
+type mockSecretsManager struct {
+	secretsmanageriface.SecretsManagerAPI
+	secrets map[string]*mockSecret
+	updates int
+}
+
+func (m *mockSecretsManager) GetRandomPasswordWithContext(aws.Context, *secretsmanager.GetRandomPasswordInput, ...request.Option) (*secretsmanager.GetRandomPasswordOutput, error) {
+	return &secretsmanager.GetRandomPasswordOutput{RandomPassword: aws.String("generated")}, nil
+}
+
+func (m *mockSecretsManager) DescribeSecretWithContext(_ aws.Context, in *secretsmanager.DescribeSecretInput, _ ...request.Option) (*secretsmanager.DescribeSecretOutput, error) {
+	s, ok := m.secrets[aws.StringValue(in.SecretId)]
+	if !ok {
+		return nil, awserr.New(secretsmanager.ErrCodeResourceNotFoundException, "secret not found", nil)
+	}
+	out := &secretsmanager.DescribeSecretOutput{ARN: aws.String(s.arn), KmsKeyId: aws.String(s.kmsKey)}
+	if s.deleted {
+		out.DeletedDate = aws.Time(time.Now())
+	}
+	return out, nil
+}
+
+func (m *mockSecretsManager) CreateSecretWithContext(_ aws.Context, in *secretsmanager.CreateSecretInput, _ ...request.Option) (*secretsmanager.CreateSecretOutput, error) {
+	name := aws.StringValue(in.Name)
+	m.secrets[name] = &mockSecret{arn: "arn:" + name, kmsKey: aws.StringValue(in.KmsKeyId), value: aws.StringValue(in.SecretString)}
+	return &secretsmanager.CreateSecretOutput{ARN: aws.String("arn:" + name)}, nil
+}
+
+//secrets are looked up by name or arn.
+func (m *mockSecretsManager) secret(id string) *mockSecret {
+	for name, s := range m.secrets {
+		if name == id || s.arn == id {
+			return s
+		}
+	}
+	return nil
+}
+
+func (m *mockSecretsManager) UpdateSecretWithContext(_ aws.Context, in *secretsmanager.UpdateSecretInput, _ ...request.Option) (*secretsmanager.UpdateSecretOutput, error) {
+	m.updates++
+	s := m.secret(aws.StringValue(in.SecretId))
+	if in.KmsKeyId != nil {
+		s.kmsKey = aws.StringValue(in.KmsKeyId)
+	}
+	if in.SecretString != nil {
+		s.value = aws.StringValue(in.SecretString)
+	}
+	return &secretsmanager.UpdateSecretOutput{}, nil
+}
+
+func (m *mockSecretsManager) RestoreSecretWithContext(_ aws.Context, in *secretsmanager.RestoreSecretInput, _ ...request.Option) (*secretsmanager.RestoreSecretOutput, error) {
+	m.secret(aws.StringValue(in.SecretId)).deleted = false
+	return &secretsmanager.RestoreSecretOutput{}, nil
+}
+
+func (m *mockSecretsManager) DeleteSecretWithContext(_ aws.Context, in *secretsmanager.DeleteSecretInput, _ ...request.Option) (*secretsmanager.DeleteSecretOutput, error) {
+	s := m.secret(aws.StringValue(in.SecretId))
+	if s == nil {
+		return nil, awserr.New(secretsmanager.ErrCodeResourceNotFoundException, "secret not found", nil)
+	}
+	s.deleted = true
+	return &secretsmanager.DeleteSecretOutput{}, nil
+}
+
+//MSK keeping track of the secrets associated with each cluster.
+type mockMSK struct {
+	kafkaiface.KafkaAPI
+	associated  map[string]map[string]bool
+	batchSizes  []int
+	unprocessed []*kafka.UnprocessedScramSecret
+}
+
+func (m *mockMSK) ListScramSecretsPagesWithContext(_ aws.Context, in *kafka.ListScramSecretsInput, fn func(*kafka.ListScramSecretsOutput, bool) bool, _ ...request.Option) error {
+	secrets, ok := m.associated[aws.StringValue(in.ClusterArn)]
+	if !ok {
+		return awserr.New(kafka.ErrCodeNotFoundException, "cluster not found", nil)
+	}
+	out := &kafka.ListScramSecretsOutput{}
+	for arn := range secrets {
+		out.SecretArnList = append(out.SecretArnList, aws.String(arn))
+	}
+	fn(out, true)
+	return nil
+}
+
+func (m *mockMSK) BatchAssociateScramSecretWithContext(_ aws.Context, in *kafka.BatchAssociateScramSecretInput, _ ...request.Option) (*kafka.BatchAssociateScramSecretOutput, error) {
+	m.batchSizes = append(m.batchSizes, len(in.SecretArnList))
+	if len(m.unprocessed) > 0 {
+		return &kafka.BatchAssociateScramSecretOutput{UnprocessedScramSecrets: m.unprocessed}, nil
+	}
+	for _, arn := range in.SecretArnList {
+		m.associated[aws.StringValue(in.ClusterArn)][aws.StringValue(arn)] = true
+	}
+	return &kafka.BatchAssociateScramSecretOutput{}, nil
+}
+
+func (m *mockMSK) BatchDisassociateScramSecretWithContext(_ aws.Context, in *kafka.BatchDisassociateScramSecretInput, _ ...request.Option) (*kafka.BatchDisassociateScramSecretOutput, error) {
+	for _, arn := range in.SecretArnList {
+		delete(m.associated[aws.StringValue(in.ClusterArn)], aws.StringValue(arn))
+	}
+	return &kafka.BatchDisassociateScramSecretOutput{}, nil
+}
+
+func newMocks() (*mockSecretsManager, *mockMSK) {
+	return &mockSecretsManager{secrets: map[string]*mockSecret{}},
+		&mockMSK{associated: map[string]map[string]bool{"cluster1": {}, "cluster2": {}}}
+}
+
+func Test_Settings(t *testing.T) {
+	s, err := settings(map[string]interface{}{
+		"ClusterArn":       "cluster1",
+		"KmsKeyId":         "key1",
+		"SecretNamePrefix": "dev_",
+		"Users":            []interface{}{"jobs", map[string]interface{}{"Username": "schemareg", "Password": "imported"}},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, scramSettings{
+		ClusterArn: "cluster1",
+		KmsKeyId:   "key1",
+		Prefix:     "dev_",
+		Users:      []scramUser{{Username: "jobs"}, {Username: "schemareg", Password: "imported"}},
+	}, s)
+	assert.Equal(t, "AmazonMSK_dev_jobs", secretName(s.Prefix, "jobs"))
+
+	_, err = settings(map[string]interface{}{
+		"SecretNamePrefix": "dev prefix",
+		"Users":            []interface{}{"jobs", "jobs", map[string]interface{}{"Password": "imported"}, "bad name", 42},
+	})
+	assert.NotNil(t, err)
+	for _, e := range []string{
+		"missing ClusterArn",
+		"missing KmsKeyId",
+		"SecretNamePrefix \"dev prefix\" may only hold",
+		"user 2: username jobs is given more than once",
+		"user 3: missing Username",
+		"user 4: username \"bad name\" may only hold",
+		"user 5: expected a username or an object",
+	} {
+		assert.Contains(t, err.Error(), e)
+	}
+}
+
+func Test_MockReconcile(t *testing.T) {
+	smMock, mskMock := newMocks()
+	sm, m := SMclient{Client: smMock}, MSKclient{Client: mskMock}
+	ctx := context.Background()
+
+	//create
+	created := scramSettings{ClusterArn: "cluster1", KmsKeyId: "key1", Prefix: "dev_", Users: []scramUser{
+		{Username: "jobs"},
+		{Username: "schemareg", Password: "imported"},
+	}}
+	arns, err := reconcile(ctx, sm, m, created, scramSettings{})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"arn:AmazonMSK_dev_jobs", "arn:AmazonMSK_dev_schemareg"}, arns)
+	assert.Equal(t, `{"password":"generated","username":"jobs"}`, smMock.secrets["AmazonMSK_dev_jobs"].value)
+	assert.Equal(t, `{"password":"imported","username":"schemareg"}`, smMock.secrets["AmazonMSK_dev_schemareg"].value)
+	assert.Equal(t, "key1", smMock.secrets["AmazonMSK_dev_jobs"].kmsKey)
+	assert.Equal(t, map[string]bool{"arn:AmazonMSK_dev_jobs": true, "arn:AmazonMSK_dev_schemareg": true}, mskMock.associated["cluster1"])
+
+	//an unchanged update touches nothing
+	arns, err = reconcile(ctx, sm, m, created, created)
+	assert.Nil(t, err)
+	assert.Len(t, arns, 2)
+	assert.Equal(t, 0, smMock.updates)
+
+	//schemareg gets a new password, jobs is removed and connect is added
+	updated := scramSettings{ClusterArn: "cluster1", KmsKeyId: "key1", Prefix: "dev_", Users: []scramUser{
+		{Username: "schemareg", Password: "rotated"},
+		{Username: "connect"},
+	}}
+	arns, err = reconcile(ctx, sm, m, updated, created)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"arn:AmazonMSK_dev_schemareg", "arn:AmazonMSK_dev_connect"}, arns)
+	assert.Equal(t, `{"password":"rotated","username":"schemareg"}`, smMock.secrets["AmazonMSK_dev_schemareg"].value)
+	assert.True(t, smMock.secrets["AmazonMSK_dev_jobs"].deleted)
+	assert.Equal(t, map[string]bool{"arn:AmazonMSK_dev_schemareg": true, "arn:AmazonMSK_dev_connect": true}, mskMock.associated["cluster1"])
+
+	//jobs is added back, restoring its secret and its password, and the secrets move to cluster2 with a new kms key
+	moved := scramSettings{ClusterArn: "cluster2", KmsKeyId: "key2", Prefix: "dev_", Users: []scramUser{
+		{Username: "schemareg", Password: "rotated"},
+		{Username: "connect"},
+		{Username: "jobs"},
+	}}
+	_, err = reconcile(ctx, sm, m, moved, updated)
+	assert.Nil(t, err)
+	assert.False(t, smMock.secrets["AmazonMSK_dev_jobs"].deleted)
+	assert.Equal(t, `{"password":"generated","username":"jobs"}`, smMock.secrets["AmazonMSK_dev_jobs"].value)
+	assert.Equal(t, "key2", smMock.secrets["AmazonMSK_dev_schemareg"].kmsKey)
+	assert.Len(t, mskMock.associated["cluster1"], 0)
+	assert.Len(t, mskMock.associated["cluster2"], 3)
+
+	//delete, the cluster1 is gone by now
+	delete(mskMock.associated, "cluster1")
+	err = removeSecrets(ctx, sm, m, "cluster2", "dev_", moved.Users, true)
+	assert.Nil(t, err)
+	assert.Len(t, mskMock.associated["cluster2"], 0)
+	for name, s := range smMock.secrets {
+		assert.True(t, s.deleted, name)
+	}
+	err = removeSecrets(ctx, sm, m, "cluster1", "dev_", moved.Users, true)
+	assert.Nil(t, err)
+}
+
+func Test_MockAssociateBatches(t *testing.T) {
+	smMock, mskMock := newMocks()
+	sm, m := SMclient{Client: smMock}, MSKclient{Client: mskMock}
+
+	many := scramSettings{ClusterArn: "cluster1", KmsKeyId: "key1"}
+	for i := 0; i < 23; i++ {
+		many.Users = append(many.Users, scramUser{Username: fmt.Sprintf("user%d", i)})
+	}
+	_, err := reconcile(context.Background(), sm, m, many, scramSettings{})
+	assert.Nil(t, err)
+	assert.Equal(t, []int{10, 10, 3}, mskMock.batchSizes)
+	assert.Len(t, mskMock.associated["cluster1"], 23)
+
+	//secrets MSK refuses are reported
+	mskMock.unprocessed = []*kafka.UnprocessedScramSecret{{
+		SecretArn:    aws.String("arn:AmazonMSK_new"),
+		ErrorCode:    aws.String("InvalidSecretKmsKey"),
+		ErrorMessage: aws.String("secrets encrypted with the default key are not supported"),
+	}}
+	_, err = reconcile(context.Background(), sm, m, scramSettings{ClusterArn: "cluster1", KmsKeyId: "key1", Users: []scramUser{{Username: "new"}}}, scramSettings{})
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "arn:AmazonMSK_new: InvalidSecretKmsKey-secrets encrypted with the default key are not supported")
+}
+
+func Test_Redacted(t *testing.T) {
+	imported := map[string]interface{}{"Username": "schemareg", "Password": "imported"}
+	event := cfn.Event{
+		ResourceProperties:    map[string]interface{}{"ClusterArn": "cluster1", "Users": []interface{}{"jobs", imported}},
+		OldResourceProperties: map[string]interface{}{"ClusterArn": "cluster1"},
+	}
+	logged := fmt.Sprintf("%+v", redacted(event))
+	assert.NotContains(t, logged, "imported")
+	assert.Contains(t, logged, "Password:****")
+	assert.Contains(t, logged, "jobs")
+
+	//the event itself keeps the password
+	assert.Equal(t, "imported", imported["Password"])
+	assert.Equal(t, imported, event.ResourceProperties["Users"].([]interface{})[1])
+}
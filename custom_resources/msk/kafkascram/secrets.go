@@ -0,0 +1,292 @@
+package kafkascram
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/kafka"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"log"
+	"regexp"
+	"strings"
+)
+
+//MSK only accepts secrets whose name starts with this prefix.
+const secretNamePrefix = "AmazonMSK_"
+
+//the number of secrets MSK (dis)associates in a single call.
+const scramBatchSize = 10
+
+//length of the generated passwords.
+const passwordLength = 32
+
+//characters allowed in a secret name besides the prefix.
+var secretNameChars = regexp.MustCompile(`^[A-Za-z0-9/_+=.@-]+$`)
+
+//a SASL/SCRAM user of the cluster.
+type scramUser struct {
+	Username string
+	Password string //imported password, a password is generated when empty
+}
+
+//reads the users given under Users. A user is either a username or an object with a Username and an optional Password.
+//Every invalid user is reported.
+func scramUsers(properties map[string]interface{}) ([]scramUser, error) {
+	users, ok := properties["Users"].([]interface{})
+	if !ok {
+		return nil, nil
+	}
+
+	var list []scramUser
+	var errs []string
+	seen := make(map[string]bool)
+	for i, u := range users {
+		var user scramUser
+		switch v := u.(type) {
+		case string:
+			user.Username = v
+		case map[string]interface{}:
+			user.Username, _ = v["Username"].(string)
+			user.Password, _ = v["Password"].(string)
+		default:
+			errs = append(errs, fmt.Sprintf("user %d: expected a username or an object", i+1))
+			continue
+		}
+
+		switch {
+		case user.Username == "":
+			errs = append(errs, fmt.Sprintf("user %d: missing Username", i+1))
+		case !secretNameChars.MatchString(user.Username):
+			errs = append(errs, fmt.Sprintf("user %d: username %q may only hold letters, digits and /_+=.@-", i+1, user.Username))
+		case seen[user.Username]:
+			errs = append(errs, fmt.Sprintf("user %d: username %s is given more than once", i+1, user.Username))
+		default:
+			seen[user.Username] = true
+			list = append(list, user)
+		}
+	}
+
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("invalid Users: %s", strings.Join(errs, "; "))
+	}
+	return list, nil
+}
+
+//name of the secret holding the credentials of the user.
+func secretName(prefix, username string) string {
+	return secretNamePrefix + prefix + username
+}
+
+//the credentials in the form MSK expects them.
+func secretString(username, password string) string {
+	b, _ := json.Marshal(map[string]string{"username": username, "password": password})
+	return string(b)
+}
+
+//generates a password for a new user.
+func (s *SMclient) randomPassword(ctx context.Context) (string, error) {
+
+	out, err := s.Client.GetRandomPasswordWithContext(ctx, &secretsmanager.GetRandomPasswordInput{
+		PasswordLength: aws.Int64(passwordLength),
+		//kept out of the passwords since clients put them into JAAS configs.
+		ExcludePunctuation: aws.Bool(true),
+	})
+	if err != nil {
+		return "", fmt.Errorf("unable to generate a password : %v", err)
+	}
+	return aws.StringValue(out.RandomPassword), nil
+}
+
+//makes sure the secret of the user exists, is encrypted with the kms key and not scheduled for deletion. The password is
+//written if the secret is new or writePassword is set, otherwise the secret keeps its password. Returns the secret arn.
+func (s *SMclient) ensureSecret(ctx context.Context, name, kmsKeyId string, user scramUser, writePassword bool) (string, error) {
+
+	desc, err := s.Client.DescribeSecretWithContext(ctx, &secretsmanager.DescribeSecretInput{SecretId: aws.String(name)})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == secretsmanager.ErrCodeResourceNotFoundException {
+			return s.createSecret(ctx, name, kmsKeyId, user)
+		}
+		return "", fmt.Errorf("unable to describe secret %s : %v", name, err)
+	}
+
+	if desc.DeletedDate != nil {
+		log.Printf("secret %s is scheduled for deletion, restoring it.", name)
+		_, err = s.Client.RestoreSecretWithContext(ctx, &secretsmanager.RestoreSecretInput{SecretId: desc.ARN})
+		if err != nil {
+			return "", fmt.Errorf("unable to restore secret %s : %v", name, err)
+		}
+	}
+
+	param := secretsmanager.UpdateSecretInput{SecretId: desc.ARN}
+	if aws.StringValue(desc.KmsKeyId) != kmsKeyId {
+		param.KmsKeyId = aws.String(kmsKeyId)
+	}
+	if writePassword {
+		password := user.Password
+		if password == "" {
+			password, err = s.randomPassword(ctx)
+			if err != nil {
+				return "", err
+			}
+		}
+		param.SecretString = aws.String(secretString(user.Username, password))
+	}
+	if param.KmsKeyId != nil || param.SecretString != nil {
+		log.Printf("updating secret %s", name)
+		_, err = s.Client.UpdateSecretWithContext(ctx, &param)
+		if err != nil {
+			return "", fmt.Errorf("unable to update secret %s : %v", name, err)
+		}
+	}
+	return aws.StringValue(desc.ARN), nil
+}
+
+//creates the secret of the user, generating a password unless one is imported.
+func (s *SMclient) createSecret(ctx context.Context, name, kmsKeyId string, user scramUser) (string, error) {
+
+	password := user.Password
+	if password == "" {
+		var err error
+		password, err = s.randomPassword(ctx)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	log.Printf("creating secret %s", name)
+	out, err := s.Client.CreateSecretWithContext(ctx, &secretsmanager.CreateSecretInput{
+		Name:         aws.String(name),
+		Description:  aws.String(fmt.Sprintf("SASL/SCRAM credentials of the kafka user %s", user.Username)),
+		KmsKeyId:     aws.String(kmsKeyId),
+		SecretString: aws.String(secretString(user.Username, password)),
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			return "", fmt.Errorf("unable to create secret %s: %s-%v", name, aerr.Code(), aerr.Message())
+		}
+		return "", fmt.Errorf("unable to create secret %s : %v", name, err)
+	}
+	return aws.StringValue(out.ARN), nil
+}
+
+//finds out the arn of the secret, empty if there is no such secret.
+func (s *SMclient) secretArn(ctx context.Context, name string) (string, error) {
+
+	desc, err := s.Client.DescribeSecretWithContext(ctx, &secretsmanager.DescribeSecretInput{SecretId: aws.String(name)})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == secretsmanager.ErrCodeResourceNotFoundException {
+			return "", nil
+		}
+		return "", fmt.Errorf("unable to describe secret %s : %v", name, err)
+	}
+	return aws.StringValue(desc.ARN), nil
+}
+
+//schedules the secret for deletion, keeping it recoverable for the default recovery window. A secret that is gone already
+//is fine.
+func (s *SMclient) deleteSecret(ctx context.Context, name string) error {
+
+	log.Printf("deleting secret %s", name)
+	_, err := s.Client.DeleteSecretWithContext(ctx, &secretsmanager.DeleteSecretInput{SecretId: aws.String(name)})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == secretsmanager.ErrCodeResourceNotFoundException {
+			return nil
+		}
+		return fmt.Errorf("unable to delete secret %s : %v", name, err)
+	}
+	return nil
+}
+
+//lists the secrets associated with the cluster. A cluster that no longer exists has none.
+func (m *MSKclient) scramSecrets(ctx context.Context, clusterArn string) (map[string]bool, error) {
+
+	secrets := make(map[string]bool)
+	param := kafka.ListScramSecretsInput{ClusterArn: aws.String(clusterArn)}
+	err := m.Client.ListScramSecretsPagesWithContext(ctx, &param, func(out *kafka.ListScramSecretsOutput, lastPage bool) bool {
+		for _, arn := range out.SecretArnList {
+			secrets[aws.StringValue(arn)] = true
+		}
+		return true
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			if aerr.Code() == kafka.ErrCodeNotFoundException {
+				log.Printf("cluster %s not found.", clusterArn)
+				return secrets, nil
+			}
+			return nil, fmt.Errorf("unable to list scram secrets of %s: %s-%v", clusterArn, aerr.Code(), aerr.Message())
+		}
+		return nil, fmt.Errorf("unable to list scram secrets of %s : %v", clusterArn, err)
+	}
+	return secrets, nil
+}
+
+//associates the secrets with the cluster, in batches as MSK wants them.
+func (m *MSKclient) associate(ctx context.Context, clusterArn string, secretArns []string) error {
+	for _, batch := range batches(secretArns) {
+		log.Printf("associating secrets %v with %s", batch, clusterArn)
+		out, err := m.Client.BatchAssociateScramSecretWithContext(ctx, &kafka.BatchAssociateScramSecretInput{
+			ClusterArn:    aws.String(clusterArn),
+			SecretArnList: aws.StringSlice(batch),
+		})
+		if err != nil {
+			if aerr, ok := err.(awserr.Error); ok {
+				return fmt.Errorf("unable to associate scram secrets: %s-%v", aerr.Code(), aerr.Message())
+			}
+			return fmt.Errorf("unable to associate scram secrets : %v", err)
+		}
+		if err := unprocessed("associate", out.UnprocessedScramSecrets); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//disassociates the secrets from the cluster, in batches as MSK wants them.
+func (m *MSKclient) disassociate(ctx context.Context, clusterArn string, secretArns []string) error {
+	for _, batch := range batches(secretArns) {
+		log.Printf("disassociating secrets %v from %s", batch, clusterArn)
+		out, err := m.Client.BatchDisassociateScramSecretWithContext(ctx, &kafka.BatchDisassociateScramSecretInput{
+			ClusterArn:    aws.String(clusterArn),
+			SecretArnList: aws.StringSlice(batch),
+		})
+		if err != nil {
+			if aerr, ok := err.(awserr.Error); ok {
+				return fmt.Errorf("unable to disassociate scram secrets: %s-%v", aerr.Code(), aerr.Message())
+			}
+			return fmt.Errorf("unable to disassociate scram secrets : %v", err)
+		}
+		if err := unprocessed("disassociate", out.UnprocessedScramSecrets); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//splits the secret arns into batches of scramBatchSize.
+func batches(secretArns []string) [][]string {
+	var list [][]string
+	for len(secretArns) > 0 {
+		n := scramBatchSize
+		if len(secretArns) < n {
+			n = len(secretArns)
+		}
+		list = append(list, secretArns[:n])
+		secretArns = secretArns[n:]
+	}
+	return list
+}
+
+//reports the secrets MSK did not process.
+func unprocessed(action string, secrets []*kafka.UnprocessedScramSecret) error {
+	if len(secrets) == 0 {
+		return nil
+	}
+	var errs []string
+	for _, s := range secrets {
+		errs = append(errs, fmt.Sprintf("%s: %s-%s", aws.StringValue(s.SecretArn), aws.StringValue(s.ErrorCode), aws.StringValue(s.ErrorMessage)))
+	}
+	return fmt.Errorf("unable to %s scram secrets : %s", action, strings.Join(errs, "; "))
+}
@@ -0,0 +1,308 @@
+package monitorkafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-lambda-go/cfn"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/kafka"
+	"github.com/aws/aws-sdk-go/service/kafka/kafkaiface"
+	"github.com/krunal4amity/cfn-infra/custom_resources/msk/mskcluster"
+	"github.com/krunal4amity/cfn-infra/custom_resources/resource"
+	"log"
+	"strconv"
+	"strings"
+)
+
+//MSK client
+type MSKclient struct {
+	Client kafkaiface.KafkaAPI
+}
+
+//the monitoring of a cluster, as desired or as it is.
+type monitoring struct {
+	EnhancedMonitoring string //DEFAULT, PER_BROKER, PER_TOPIC_PER_BROKER or PER_TOPIC_PER_PARTITION
+	JmxExporter        bool   //prometheus JMX exporter on the brokers
+	NodeExporter       bool   //prometheus node exporter on the brokers
+	LogGroup           string //CloudWatch Logs log group the broker logs are delivered to, none when empty
+	LogBucket          string //S3 bucket the broker logs are delivered to, none when empty
+	LogPrefix          string //prefix of the broker logs in the LogBucket
+	DeliveryStream     string //Kinesis Data Firehose delivery stream the broker logs are delivered to, none when empty
+}
+
+//the current state of the cluster.
+type clusterState struct {
+	CurrentVersion string
+	State          string
+	Monitoring     monitoring
+}
+
+//reads and validates the desired monitoring given under ResourceProperties. EnhancedMonitoring defaults to DEFAULT, the
+//exporters and the broker log deliveries to off.
+func desiredMonitoring(properties map[string]interface{}) (monitoring, error) {
+	var errs []string
+	flag := func(name string) bool {
+		value, ok := properties[name]
+		if !ok {
+			return false
+		}
+		b, err := strconv.ParseBool(fmt.Sprint(value))
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s must be true or false, got %v", name, value))
+		}
+		return b
+	}
+
+	m := monitoring{
+		EnhancedMonitoring: kafka.EnhancedMonitoringDefault,
+		JmxExporter:        flag("JmxExporter"),
+		NodeExporter:       flag("NodeExporter"),
+	}
+	if level, ok := properties["EnhancedMonitoring"].(string); ok && level != "" {
+		m.EnhancedMonitoring = level
+	}
+	var valid bool
+	for _, level := range kafka.EnhancedMonitoring_Values() {
+		valid = valid || level == m.EnhancedMonitoring
+	}
+	if !valid {
+		errs = append(errs, fmt.Sprintf("EnhancedMonitoring %q is not one of %s", m.EnhancedMonitoring, strings.Join(kafka.EnhancedMonitoring_Values(), ",")))
+	}
+
+	logs, _ := properties["BrokerLogs"].(map[string]interface{})
+	target := func(name string) map[string]interface{} {
+		t, _ := logs[name].(map[string]interface{})
+		return t
+	}
+	m.LogGroup, _ = target("CloudWatchLogs")["LogGroup"].(string)
+	m.LogBucket, _ = target("S3")["Bucket"].(string)
+	m.LogPrefix, _ = target("S3")["Prefix"].(string)
+	m.DeliveryStream, _ = target("Firehose")["DeliveryStream"].(string)
+	if target("CloudWatchLogs") != nil && m.LogGroup == "" {
+		errs = append(errs, "BrokerLogs CloudWatchLogs needs a LogGroup")
+	}
+	if target("S3") != nil && m.LogBucket == "" {
+		errs = append(errs, "BrokerLogs S3 needs a Bucket")
+	}
+	if target("Firehose") != nil && m.DeliveryStream == "" {
+		errs = append(errs, "BrokerLogs Firehose needs a DeliveryStream")
+	}
+
+	if _, ok := properties["ClusterArn"].(string); !ok {
+		errs = append(errs, "missing ClusterArn")
+	}
+	if len(errs) > 0 {
+		return monitoring{}, fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return m, nil
+}
+
+//finds out the current state of the cluster.
+func (m *MSKclient) clusterState(ctx context.Context, clusterArn string) (clusterState, error) {
+
+	info, err := mskcluster.Describe(ctx, m.Client, clusterArn)
+	if err != nil {
+		return clusterState{}, err
+	}
+	return newClusterState(info), nil
+}
+
+//the state of the cluster as described.
+func newClusterState(info *kafka.ClusterInfo) clusterState {
+	state := clusterState{
+		CurrentVersion: aws.StringValue(info.CurrentVersion),
+		State:          aws.StringValue(info.State),
+		Monitoring:     monitoring{EnhancedMonitoring: aws.StringValue(info.EnhancedMonitoring)},
+	}
+	if info.OpenMonitoring != nil && info.OpenMonitoring.Prometheus != nil {
+		prometheus := info.OpenMonitoring.Prometheus
+		if prometheus.JmxExporter != nil {
+			state.Monitoring.JmxExporter = aws.BoolValue(prometheus.JmxExporter.EnabledInBroker)
+		}
+		if prometheus.NodeExporter != nil {
+			state.Monitoring.NodeExporter = aws.BoolValue(prometheus.NodeExporter.EnabledInBroker)
+		}
+	}
+	if info.LoggingInfo != nil && info.LoggingInfo.BrokerLogs != nil {
+		logs := info.LoggingInfo.BrokerLogs
+		if logs.CloudWatchLogs != nil && aws.BoolValue(logs.CloudWatchLogs.Enabled) {
+			state.Monitoring.LogGroup = aws.StringValue(logs.CloudWatchLogs.LogGroup)
+		}
+		if logs.S3 != nil && aws.BoolValue(logs.S3.Enabled) {
+			state.Monitoring.LogBucket = aws.StringValue(logs.S3.Bucket)
+			state.Monitoring.LogPrefix = aws.StringValue(logs.S3.Prefix)
+		}
+		if logs.Firehose != nil && aws.BoolValue(logs.Firehose.Enabled) {
+			state.Monitoring.DeliveryStream = aws.StringValue(logs.Firehose.DeliveryStream)
+		}
+	}
+	return state
+}
+
+//the monitoring as UpdateMonitoring takes it. Every setting is given so that the ones left out are turned off.
+func updateMonitoringInput(clusterArn, currentVersion string, m monitoring) *kafka.UpdateMonitoringInput {
+	optional := func(s string) *string {
+		if s == "" {
+			return nil
+		}
+		return aws.String(s)
+	}
+	return &kafka.UpdateMonitoringInput{
+		ClusterArn:         aws.String(clusterArn),
+		CurrentVersion:     aws.String(currentVersion),
+		EnhancedMonitoring: aws.String(m.EnhancedMonitoring),
+		OpenMonitoring: &kafka.OpenMonitoringInfo{Prometheus: &kafka.PrometheusInfo{
+			JmxExporter:  &kafka.JmxExporterInfo{EnabledInBroker: aws.Bool(m.JmxExporter)},
+			NodeExporter: &kafka.NodeExporterInfo{EnabledInBroker: aws.Bool(m.NodeExporter)},
+		}},
+		LoggingInfo: &kafka.LoggingInfo{BrokerLogs: &kafka.BrokerLogs{
+			CloudWatchLogs: &kafka.CloudWatchLogs{Enabled: aws.Bool(m.LogGroup != ""), LogGroup: optional(m.LogGroup)},
+			S3:             &kafka.S3{Enabled: aws.Bool(m.LogBucket != ""), Bucket: optional(m.LogBucket), Prefix: optional(m.LogPrefix)},
+			Firehose:       &kafka.Firehose{Enabled: aws.Bool(m.DeliveryStream != ""), DeliveryStream: optional(m.DeliveryStream)},
+		}},
+	}
+}
+
+//brings the monitoring of the cluster to the desired one and waits for the update to complete. A cluster already
+//monitored as desired is left as is. Returns the final state of the cluster and whether it was updated, or an error
+//wrapping resource.ErrOutOfTime while an operation is still in progress.
+//An operation in progress, e.g. the update started by a previous invocation, is waited upon first.
+func (m *MSKclient) monitor(ctx context.Context, clusterArn string, desired monitoring) (clusterState, bool, error) {
+
+	state, err := m.clusterState(ctx, clusterArn)
+	if err != nil {
+		return clusterState{}, false, err
+	}
+	if state.Monitoring == desired {
+		log.Printf("cluster %s is monitored as desired already", clusterArn)
+		return state, false, nil
+	}
+	if state.State == kafka.ClusterStateUpdating {
+		info, err := mskcluster.WaitForOperationInProgress(ctx, m.Client, clusterArn)
+		if err != nil {
+			return clusterState{}, false, err
+		}
+		state = newClusterState(info)
+		if state.Monitoring == desired {
+			log.Printf("cluster %s is monitored as desired once the operation in progress completed", clusterArn)
+			return state, true, nil
+		}
+	}
+	if state.State != kafka.ClusterStateActive {
+		return clusterState{}, false, fmt.Errorf("cluster %s is %s, its monitoring can only be updated when %s", clusterArn, state.State, kafka.ClusterStateActive)
+	}
+
+	log.Printf("updating the monitoring of cluster %s from %+v to %+v", clusterArn, state.Monitoring, desired)
+	out, err := m.Client.UpdateMonitoringWithContext(ctx, updateMonitoringInput(clusterArn, state.CurrentVersion, desired))
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			return clusterState{}, false, fmt.Errorf("unable to update the monitoring of cluster %s: %s-%v", clusterArn, aerr.Code(), aerr.Message())
+		}
+		return clusterState{}, false, fmt.Errorf("unable to update the monitoring of cluster %s : %v", clusterArn, err)
+	}
+	err = mskcluster.WaitForOperation(ctx, m.Client, aws.StringValue(out.ClusterOperationArn))
+	if err != nil {
+		return clusterState{}, false, err
+	}
+	//every operation moves the cluster to a new version.
+	state, err = m.clusterState(ctx, clusterArn)
+	if err != nil {
+		return clusterState{}, false, err
+	}
+	return state, true, nil
+}
+
+//this handler accepts inputs under ResourceProperties as shown below.
+//{
+// "ClusterArn":"arn:aws:kafka:us-west-2:508718283261:cluster/mm/f68810de-4c55-44ad-929c-1fe3b91e4f6b-3",
+// "EnhancedMonitoring":"PER_TOPIC_PER_BROKER" (optional. DEFAULT, PER_BROKER, PER_TOPIC_PER_BROKER or PER_TOPIC_PER_PARTITION)
+// "JmxExporter":"true" (optional. prometheus open monitoring with the JMX exporter, on port 11001 of the brokers)
+// "NodeExporter":"true" (optional. prometheus open monitoring with the node exporter, on port 11002 of the brokers)
+// "BrokerLogs":{ (optional. where the broker logs are delivered to, any of)
+//				"CloudWatchLogs":{"LogGroup":"/msk/dev"},
+//				"S3":{"Bucket":"my-logs","Prefix":"msk/dev"}, (Prefix is optional)
+//				"Firehose":{"DeliveryStream":"msk-dev"}
+//				}
+//}
+//Settings left out are turned off, or DEFAULT for EnhancedMonitoring.
+//The handler returns the following response (sample output), the state of the cluster once monitored.
+//{
+//		"EnhancedMonitoring":"PER_TOPIC_PER_BROKER",
+//		"JmxExporter":"true",
+//		"NodeExporter":"true",
+//		"Updated":"false", (true if the monitoring had to be updated)
+//		"State":"ACTIVE",
+//		"CurrentVersion":"K3AEGXETSR30VB"
+//}
+//A cluster operation may outlast the lambda function. When the function is about to time out, the request is continued
+//in a new invocation which waits for the operation in progress, so the function has to be served with
+//resource.LambdaWrap and be allowed to invoke itself.
+type Handler struct{}
+
+var _ resource.Handler = Handler{}
+
+//Create brings the monitoring of the cluster to the desired one.
+func (h Handler) Create(ctx context.Context, event cfn.Event) (physicalResourceId string, data map[string]interface{}, err error) {
+
+	log.Println("CREATE: updating kafka cluster monitoring.")
+	log.Printf("event data :%+v\n", event)
+
+	return monitor(ctx, event)
+}
+
+//Update brings the monitoring of the cluster to the new desired one.
+func (h Handler) Update(ctx context.Context, event cfn.Event) (physicalResourceId string, data map[string]interface{}, err error) {
+
+	log.Println("UPDATE: updating kafka cluster monitoring.")
+	log.Printf("event data :%+v\n", event)
+
+	return monitor(ctx, event)
+}
+
+//Delete leaves the monitoring of the cluster as it is.
+func (h Handler) Delete(ctx context.Context, event cfn.Event) (physicalResourceId string, data map[string]interface{}, err error) {
+
+	log.Printf("event data :%+v\n", event)
+	log.Println("DELETE: leaving the monitoring of the cluster as it is.")
+
+	return event.PhysicalResourceID, nil, nil
+}
+
+//updates the monitoring of the cluster of the event, returning its final state as response data.
+func monitor(ctx context.Context, event cfn.Event) (physicalResourceId string, data map[string]interface{}, err error) {
+
+	desired, err := desiredMonitoring(event.ResourceProperties)
+	if err != nil {
+		return "", nil, err
+	}
+	clusterArn := event.ResourceProperties["ClusterArn"].(string)
+
+	sess, err := session.NewSession()
+	if err != nil {
+		return "", nil, fmt.Errorf("unable to create a new session: %v", err)
+	}
+	mskapi := MSKclient{Client: kafka.New(sess)}
+
+	state, updated, err := mskapi.monitor(ctx, clusterArn, desired)
+	if errors.Is(err, resource.ErrOutOfTime) {
+		log.Printf("the monitoring of cluster %s is still being updated.", clusterArn)
+		return "", nil, resource.Continue(ctx, event)
+	}
+	if err != nil {
+		return "", nil, err
+	}
+
+	data = map[string]interface{}{
+		"EnhancedMonitoring": state.Monitoring.EnhancedMonitoring,
+		"JmxExporter":        strconv.FormatBool(state.Monitoring.JmxExporter),
+		"NodeExporter":       strconv.FormatBool(state.Monitoring.NodeExporter),
+		"Updated":            strconv.FormatBool(updated),
+		"State":              state.State,
+		"CurrentVersion":     state.CurrentVersion,
+	}
+	log.Printf("data to be returned is :%v\n", data)
+	return clusterArn + "/monitoring", data, nil
+}
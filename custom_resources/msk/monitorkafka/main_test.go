@@ -0,0 +1,257 @@
+package monitorkafka
+
+import (
+	"context"
+	"errors"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/kafka"
+	"github.com/aws/aws-sdk-go/service/kafka/kafkaiface"
+	"github.com/krunal4amity/cfn-infra/custom_resources/msk/mskcluster"
+	"github.com/krunal4amity/cfn-infra/custom_resources/resource"
+	"github.com/tj/assert"
+	"testing"
+	"time"
+)
+
+// a cluster changing its monitoring once the operation updating it completes.
+type mockMSK struct {
+	kafkaiface.KafkaAPI
+	state      string
+	monitoring monitoring
+	version    int
+	pending    func() //the change of the operation in progress
+	failOp     bool
+	inProgress bool   //the operation never completes
+	running    string //arn of the operation in progress when the cluster is UPDATING
+	calls      []string
+	versions   []string //CurrentVersion given with each update
+}
+
+func (m *mockMSK) DescribeClusterWithContext(aws.Context, *kafka.DescribeClusterInput, ...request.Option) (*kafka.DescribeClusterOutput, error) {
+	mon := m.monitoring
+	//MSK keeps the log group of a disabled delivery.
+	logGroup := mon.LogGroup
+	if logGroup == "" {
+		logGroup = "/msk/old"
+	}
+	return &kafka.DescribeClusterOutput{ClusterInfo: &kafka.ClusterInfo{
+		CurrentVersion:     aws.String(string(rune('A' + m.version))),
+		State:              aws.String(m.state),
+		EnhancedMonitoring: aws.String(mon.EnhancedMonitoring),
+		OpenMonitoring: &kafka.OpenMonitoring{Prometheus: &kafka.Prometheus{
+			JmxExporter:  &kafka.JmxExporter{EnabledInBroker: aws.Bool(mon.JmxExporter)},
+			NodeExporter: &kafka.NodeExporter{EnabledInBroker: aws.Bool(mon.NodeExporter)},
+		}},
+		LoggingInfo: &kafka.LoggingInfo{BrokerLogs: &kafka.BrokerLogs{
+			CloudWatchLogs: &kafka.CloudWatchLogs{Enabled: aws.Bool(mon.LogGroup != ""), LogGroup: aws.String(logGroup)},
+			S3:             &kafka.S3{Enabled: aws.Bool(mon.LogBucket != ""), Bucket: aws.String(mon.LogBucket), Prefix: aws.String(mon.LogPrefix)},
+			Firehose:       &kafka.Firehose{Enabled: aws.Bool(mon.DeliveryStream != ""), DeliveryStream: aws.String(mon.DeliveryStream)},
+		}},
+	}}, nil
+}
+
+func (m *mockMSK) UpdateMonitoringWithContext(_ aws.Context, in *kafka.UpdateMonitoringInput, _ ...request.Option) (*kafka.UpdateMonitoringOutput, error) {
+	m.calls = append(m.calls, "UpdateMonitoring")
+	m.versions = append(m.versions, aws.StringValue(in.CurrentVersion))
+	logs := in.LoggingInfo.BrokerLogs
+	updated := monitoring{
+		EnhancedMonitoring: aws.StringValue(in.EnhancedMonitoring),
+		JmxExporter:        aws.BoolValue(in.OpenMonitoring.Prometheus.JmxExporter.EnabledInBroker),
+		NodeExporter:       aws.BoolValue(in.OpenMonitoring.Prometheus.NodeExporter.EnabledInBroker),
+	}
+	if aws.BoolValue(logs.CloudWatchLogs.Enabled) {
+		updated.LogGroup = aws.StringValue(logs.CloudWatchLogs.LogGroup)
+	}
+	if aws.BoolValue(logs.S3.Enabled) {
+		updated.LogBucket = aws.StringValue(logs.S3.Bucket)
+		updated.LogPrefix = aws.StringValue(logs.S3.Prefix)
+	}
+	if aws.BoolValue(logs.Firehose.Enabled) {
+		updated.DeliveryStream = aws.StringValue(logs.Firehose.DeliveryStream)
+	}
+	m.pending = func() { m.monitoring = updated }
+	return &kafka.UpdateMonitoringOutput{ClusterOperationArn: aws.String("monitoringOp")}, nil
+}
+
+func (m *mockMSK) DescribeClusterOperationWithContext(_ aws.Context, in *kafka.DescribeClusterOperationInput, _ ...request.Option) (*kafka.DescribeClusterOperationOutput, error) {
+	m.calls = append(m.calls, "DescribeClusterOperation")
+	info := &kafka.ClusterOperationInfo{OperationState: aws.String("UPDATE_COMPLETE")}
+	if m.failOp {
+		info.OperationState = aws.String("UPDATE_FAILED")
+		info.ErrorInfo = &kafka.ErrorInfo{ErrorCode: aws.String("Failed"), ErrorString: aws.String("mocked failure")}
+	} else if m.inProgress {
+		info.OperationState = aws.String("UPDATE_IN_PROGRESS")
+	} else {
+		m.pending()
+		m.version++
+		m.state = kafka.ClusterStateActive
+		m.running = ""
+	}
+	return &kafka.DescribeClusterOperationOutput{ClusterOperationInfo: info}, nil
+}
+
+func (m *mockMSK) ListClusterOperationsWithContext(aws.Context, *kafka.ListClusterOperationsInput, ...request.Option) (*kafka.ListClusterOperationsOutput, error) {
+	m.calls = append(m.calls, "ListClusterOperations")
+	out := &kafka.ListClusterOperationsOutput{}
+	if m.running != "" {
+		out.ClusterOperationInfoList = []*kafka.ClusterOperationInfo{
+			{OperationArn: aws.String(m.running), OperationState: aws.String("UPDATE_IN_PROGRESS")},
+		}
+	}
+	return out, nil
+}
+
+func Test_DesiredMonitoring(t *testing.T) {
+	m, err := desiredMonitoring(map[string]interface{}{"ClusterArn": "dummyArn"})
+	assert.Nil(t, err)
+	assert.Equal(t, monitoring{EnhancedMonitoring: kafka.EnhancedMonitoringDefault}, m)
+
+	m, err = desiredMonitoring(map[string]interface{}{
+		"ClusterArn":         "dummyArn",
+		"EnhancedMonitoring": "PER_TOPIC_PER_BROKER",
+		"JmxExporter":        "true",
+		"NodeExporter":       "false",
+		"BrokerLogs": map[string]interface{}{
+			"CloudWatchLogs": map[string]interface{}{"LogGroup": "/msk/dev"},
+			"S3":             map[string]interface{}{"Bucket": "logs", "Prefix": "msk/dev"},
+			"Firehose":       map[string]interface{}{"DeliveryStream": "msk-dev"},
+		},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, monitoring{EnhancedMonitoring: kafka.EnhancedMonitoringPerTopicPerBroker, JmxExporter: true, LogGroup: "/msk/dev",
+		LogBucket: "logs", LogPrefix: "msk/dev", DeliveryStream: "msk-dev"}, m)
+
+	_, err = desiredMonitoring(map[string]interface{}{
+		"EnhancedMonitoring": "ALL",
+		"JmxExporter":        "yes please",
+		"BrokerLogs":         map[string]interface{}{"S3": map[string]interface{}{"Prefix": "msk/dev"}},
+	})
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "JmxExporter must be true or false, got yes please")
+	assert.Contains(t, err.Error(), `EnhancedMonitoring "ALL" is not one of DEFAULT,PER_BROKER,PER_TOPIC_PER_BROKER,PER_TOPIC_PER_PARTITION`)
+	assert.Contains(t, err.Error(), "BrokerLogs S3 needs a Bucket")
+	assert.Contains(t, err.Error(), "missing ClusterArn")
+}
+
+func Test_MockMonitor(t *testing.T) {
+	mskcluster.PollInterval = time.Millisecond
+	outOfTime, cancel := context.WithTimeout(context.Background(), resource.ResponseMargin)
+	defer cancel()
+	defaults := monitoring{EnhancedMonitoring: kafka.EnhancedMonitoringDefault}
+	full := monitoring{EnhancedMonitoring: kafka.EnhancedMonitoringPerTopicPerBroker, JmxExporter: true, NodeExporter: true, LogGroup: "/msk/dev"}
+	resumed := &mockMSK{state: kafka.ClusterStateUpdating, monitoring: defaults, running: "monitoringOp"}
+	resumed.pending = func() { resumed.monitoring = full }
+	scaling := &mockMSK{state: kafka.ClusterStateUpdating, monitoring: defaults, running: "countOp"}
+	scaling.pending = func() {}
+
+	cases := []struct {
+		Name      string
+		Ctx       context.Context
+		Mock      *mockMSK
+		Desired   monitoring
+		Calls     []string
+		Versions  []string
+		Updated   bool
+		Err       string
+		OutOfTime bool
+	}{
+		{
+			Name:     "turned on",
+			Mock:     &mockMSK{state: kafka.ClusterStateActive, monitoring: defaults},
+			Desired:  full,
+			Calls:    []string{"UpdateMonitoring", "DescribeClusterOperation"},
+			Versions: []string{"A"},
+			Updated:  true,
+		},
+		{
+			Name:     "turned off",
+			Mock:     &mockMSK{state: kafka.ClusterStateActive, monitoring: full},
+			Desired:  defaults,
+			Calls:    []string{"UpdateMonitoring", "DescribeClusterOperation"},
+			Versions: []string{"A"},
+			Updated:  true,
+		},
+		{
+			Name:    "unchanged",
+			Mock:    &mockMSK{state: kafka.ClusterStateActive, monitoring: full},
+			Desired: full,
+		},
+		{
+			Name:    "unchanged while the cluster is updating",
+			Mock:    &mockMSK{state: kafka.ClusterStateUpdating, monitoring: full},
+			Desired: full,
+		},
+		{
+			Name:    "not active",
+			Mock:    &mockMSK{state: kafka.ClusterStateUpdating, monitoring: defaults},
+			Desired: full,
+			Calls:   []string{"ListClusterOperations"},
+			Err:     "cluster dummyArn is UPDATING",
+		},
+		{
+			Name:    "resumed",
+			Mock:    resumed,
+			Desired: full,
+			Calls:   []string{"ListClusterOperations", "DescribeClusterOperation"},
+			Updated: true,
+		},
+		{
+			Name:     "after the operation in progress",
+			Mock:     scaling,
+			Desired:  full,
+			Calls:    []string{"ListClusterOperations", "DescribeClusterOperation", "UpdateMonitoring", "DescribeClusterOperation"},
+			Versions: []string{"B"},
+			Updated:  true,
+		},
+		{
+			Name:    "operation fails",
+			Mock:    &mockMSK{state: kafka.ClusterStateActive, monitoring: defaults, failOp: true},
+			Desired: full,
+			Calls:   []string{"UpdateMonitoring", "DescribeClusterOperation"},
+			Err:     "cluster operation monitoringOp failed: Failed-mocked failure",
+		},
+		{
+			Name:      "out of time",
+			Ctx:       outOfTime,
+			Mock:      &mockMSK{state: kafka.ClusterStateActive, monitoring: defaults, inProgress: true},
+			Desired:   full,
+			Calls:     []string{"UpdateMonitoring", "DescribeClusterOperation"},
+			Err:       "gave up waiting for cluster operation monitoringOp in state UPDATE_IN_PROGRESS: lambda function is about to time out",
+			OutOfTime: true,
+		},
+	}
+
+	for _, c := range cases {
+		ctx := c.Ctx
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		mskApi := MSKclient{Client: c.Mock}
+		state, updated, err := mskApi.monitor(ctx, "dummyArn", c.Desired)
+		assert.Equal(t, c.Calls, c.Mock.calls, c.Name)
+		if c.Err != "" {
+			assert.NotNil(t, err, c.Name)
+			assert.Contains(t, err.Error(), c.Err, c.Name)
+			assert.Equal(t, c.OutOfTime, errors.Is(err, resource.ErrOutOfTime), c.Name)
+			continue
+		}
+		assert.Nil(t, err, c.Name)
+		assert.Equal(t, c.Updated, updated, c.Name)
+		assert.Equal(t, c.Versions, c.Mock.versions, c.Name)
+		assert.Equal(t, c.Desired, state.Monitoring, c.Name)
+	}
+}
+
+func Test_UpdateMonitoringInput(t *testing.T) {
+	in := updateMonitoringInput("dummyArn", "A", monitoring{EnhancedMonitoring: kafka.EnhancedMonitoringDefault, LogBucket: "logs"})
+	logs := in.LoggingInfo.BrokerLogs
+	assert.False(t, aws.BoolValue(logs.CloudWatchLogs.Enabled))
+	assert.Nil(t, logs.CloudWatchLogs.LogGroup)
+	assert.True(t, aws.BoolValue(logs.S3.Enabled))
+	assert.Equal(t, "logs", aws.StringValue(logs.S3.Bucket))
+	assert.Nil(t, logs.S3.Prefix)
+	assert.False(t, aws.BoolValue(logs.Firehose.Enabled))
+	assert.False(t, aws.BoolValue(in.OpenMonitoring.Prometheus.JmxExporter.EnabledInBroker))
+	assert.Equal(t, "A", aws.StringValue(in.CurrentVersion))
+}
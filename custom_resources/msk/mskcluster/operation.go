@@ -0,0 +1,107 @@
+package mskcluster
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/kafka"
+	"github.com/aws/aws-sdk-go/service/kafka/kafkaiface"
+	"github.com/krunal4amity/cfn-infra/custom_resources/resource"
+	"log"
+	"time"
+)
+
+//PollInterval is the interval between two checks of a cluster operation. MSK cluster operations take minutes rather
+//than seconds.
+var PollInterval = 30 * time.Second
+
+//states of a cluster operation which has not completed yet.
+var pendingOperationStates = map[string]bool{"PENDING": true, "UPDATE_IN_PROGRESS": true}
+
+//Describe finds out the current state of the cluster.
+func Describe(ctx context.Context, client kafkaiface.KafkaAPI, clusterArn string) (*kafka.ClusterInfo, error) {
+
+	out, err := client.DescribeClusterWithContext(ctx, &kafka.DescribeClusterInput{ClusterArn: aws.String(clusterArn)})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			return nil, fmt.Errorf("unable to describe cluster %s: %s-%v", clusterArn, aerr.Code(), aerr.Message())
+		}
+		return nil, fmt.Errorf("unable to describe cluster %s : %v", clusterArn, err)
+	}
+	return out.ClusterInfo, nil
+}
+
+//WaitForOperation waits for the given cluster operation to complete. When the lambda function is about to time out
+//first, the error returned wraps resource.ErrOutOfTime, for the request to be continued in a new invocation.
+func WaitForOperation(ctx context.Context, client kafkaiface.KafkaAPI, operationArn string) error {
+
+	param := kafka.DescribeClusterOperationInput{ClusterOperationArn: aws.String(operationArn)}
+	for {
+		out, err := client.DescribeClusterOperationWithContext(ctx, &param)
+		if err != nil {
+			return fmt.Errorf("unable to describe cluster operation %s: %v", operationArn, err)
+		}
+
+		info := out.ClusterOperationInfo
+		state := aws.StringValue(info.OperationState)
+		log.Printf("cluster operation %s is in state %s", operationArn, state)
+		switch state {
+		case "UPDATE_COMPLETE":
+			return nil
+		case "UPDATE_FAILED":
+			if info.ErrorInfo != nil {
+				return fmt.Errorf("cluster operation %s failed: %s-%s", operationArn, aws.StringValue(info.ErrorInfo.ErrorCode), aws.StringValue(info.ErrorInfo.ErrorString))
+			}
+			return fmt.Errorf("cluster operation %s failed", operationArn)
+		}
+
+		if err := resource.Sleep(ctx, PollInterval); err != nil {
+			return fmt.Errorf("gave up waiting for cluster operation %s in state %s: %w", operationArn, state, err)
+		}
+	}
+}
+
+//OperationInProgress finds the operation in progress on the cluster, empty if there is none.
+func OperationInProgress(ctx context.Context, client kafkaiface.KafkaAPI, clusterArn string) (string, error) {
+
+	param := kafka.ListClusterOperationsInput{ClusterArn: aws.String(clusterArn)}
+	for {
+		out, err := client.ListClusterOperationsWithContext(ctx, &param)
+		if err != nil {
+			if aerr, ok := err.(awserr.Error); ok {
+				return "", fmt.Errorf("unable to list the operations of cluster %s: %s-%v", clusterArn, aerr.Code(), aerr.Message())
+			}
+			return "", fmt.Errorf("unable to list the operations of cluster %s : %v", clusterArn, err)
+		}
+		for _, op := range out.ClusterOperationInfoList {
+			if pendingOperationStates[aws.StringValue(op.OperationState)] {
+				return aws.StringValue(op.OperationArn), nil
+			}
+		}
+		if out.NextToken == nil {
+			return "", nil
+		}
+		param.NextToken = out.NextToken
+	}
+}
+
+//WaitForOperationInProgress waits for the operation in progress on an UPDATING cluster, e.g. one started by a previous invocation
+//of the lambda function, and returns the state of the cluster afterwards. Any other cluster is returned as described.
+func WaitForOperationInProgress(ctx context.Context, client kafkaiface.KafkaAPI, clusterArn string) (*kafka.ClusterInfo, error) {
+
+	info, err := Describe(ctx, client, clusterArn)
+	if err != nil || aws.StringValue(info.State) != kafka.ClusterStateUpdating {
+		return info, err
+	}
+	operationArn, err := OperationInProgress(ctx, client, clusterArn)
+	if err != nil || operationArn == "" {
+		return info, err
+	}
+	log.Printf("cluster %s is %s, waiting for operation %s first", clusterArn, kafka.ClusterStateUpdating, operationArn)
+	err = WaitForOperation(ctx, client, operationArn)
+	if err != nil {
+		return nil, err
+	}
+	return Describe(ctx, client, clusterArn)
+}
@@ -0,0 +1,136 @@
+package mskcluster
+
+import (
+	"context"
+	"errors"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/kafka"
+	"github.com/aws/aws-sdk-go/service/kafka/kafkaiface"
+	"github.com/krunal4amity/cfn-infra/custom_resources/resource"
+	"github.com/tj/assert"
+	"testing"
+	"time"
+)
+
+//a cluster running an operation which completes after a number of polls.
+type mockMSK struct {
+	kafkaiface.KafkaAPI
+	state   string
+	left    int      //polls left of the operation in progress
+	failOp  bool
+	pages   [][]string //states of the listed operations, page by page
+	calls   []string
+	missing bool
+}
+
+func (m *mockMSK) DescribeClusterWithContext(aws.Context, *kafka.DescribeClusterInput, ...request.Option) (*kafka.DescribeClusterOutput, error) {
+	m.calls = append(m.calls, "DescribeCluster")
+	if m.missing {
+		return nil, awserr.New(kafka.ErrCodeNotFoundException, "cluster not found", nil)
+	}
+	return &kafka.DescribeClusterOutput{ClusterInfo: &kafka.ClusterInfo{State: aws.String(m.state)}}, nil
+}
+
+func (m *mockMSK) DescribeClusterOperationWithContext(aws.Context, *kafka.DescribeClusterOperationInput, ...request.Option) (*kafka.DescribeClusterOperationOutput, error) {
+	m.calls = append(m.calls, "DescribeClusterOperation")
+	info := &kafka.ClusterOperationInfo{OperationState: aws.String("UPDATE_IN_PROGRESS")}
+	m.left--
+	switch {
+	case m.failOp:
+		info.OperationState = aws.String("UPDATE_FAILED")
+		info.ErrorInfo = &kafka.ErrorInfo{ErrorCode: aws.String("Failed"), ErrorString: aws.String("mocked failure")}
+	case m.left < 0:
+		info.OperationState = aws.String("UPDATE_COMPLETE")
+		m.state = kafka.ClusterStateActive
+	}
+	return &kafka.DescribeClusterOperationOutput{ClusterOperationInfo: info}, nil
+}
+
+func (m *mockMSK) ListClusterOperationsWithContext(_ aws.Context, in *kafka.ListClusterOperationsInput, _ ...request.Option) (*kafka.ListClusterOperationsOutput, error) {
+	m.calls = append(m.calls, "ListClusterOperations")
+	page := 0
+	if in.NextToken != nil {
+		page = int(aws.StringValue(in.NextToken)[0] - '0')
+	}
+	out := &kafka.ListClusterOperationsOutput{}
+	for i, state := range m.pages[page] {
+		out.ClusterOperationInfoList = append(out.ClusterOperationInfoList, &kafka.ClusterOperationInfo{
+			OperationArn:   aws.String(string(rune('a'+page)) + string(rune('0'+i))),
+			OperationState: aws.String(state),
+		})
+	}
+	if page+1 < len(m.pages) {
+		out.NextToken = aws.String(string(rune('0' + page + 1)))
+	}
+	return out, nil
+}
+
+func Test_MockWaitForOperation(t *testing.T) {
+	PollInterval = time.Millisecond
+	outOfTime, cancel := context.WithTimeout(context.Background(), resource.ResponseMargin)
+	defer cancel()
+
+	cases := []struct {
+		Name      string
+		Ctx       context.Context
+		Mock      *mockMSK
+		Polls     int
+		Err       string
+		OutOfTime bool
+	}{
+		{Name: "completes", Mock: &mockMSK{left: 2}, Polls: 3},
+		{Name: "fails", Mock: &mockMSK{failOp: true}, Polls: 1, Err: "cluster operation op failed: Failed-mocked failure"},
+		{Name: "out of time", Ctx: outOfTime, Mock: &mockMSK{left: 2}, Polls: 1, Err: "gave up waiting for cluster operation op in state UPDATE_IN_PROGRESS", OutOfTime: true},
+	}
+	for _, c := range cases {
+		ctx := context.Background()
+		if c.Ctx != nil {
+			ctx = c.Ctx
+		}
+		err := WaitForOperation(ctx, c.Mock, "op")
+		assert.Equal(t, c.Polls, len(c.Mock.calls), c.Name)
+		if c.Err == "" {
+			assert.Nil(t, err, c.Name)
+			continue
+		}
+		assert.NotNil(t, err, c.Name)
+		assert.Contains(t, err.Error(), c.Err, c.Name)
+		assert.Equal(t, c.OutOfTime, errors.Is(err, resource.ErrOutOfTime), c.Name)
+	}
+}
+
+func Test_MockOperationInProgress(t *testing.T) {
+	mock := &mockMSK{pages: [][]string{{"UPDATE_COMPLETE", "UPDATE_FAILED"}, {"UPDATE_COMPLETE", "PENDING"}}}
+	arn, err := OperationInProgress(context.Background(), mock, "cluster")
+	assert.Nil(t, err)
+	assert.Equal(t, "b1", arn)
+
+	mock = &mockMSK{pages: [][]string{{"UPDATE_COMPLETE"}}}
+	arn, err = OperationInProgress(context.Background(), mock, "cluster")
+	assert.Nil(t, err)
+	assert.Equal(t, "", arn)
+}
+
+func Test_MockWaitForOperationInProgress(t *testing.T) {
+	PollInterval = time.Millisecond
+
+	//an updating cluster is described again once its operation completes.
+	mock := &mockMSK{state: kafka.ClusterStateUpdating, left: 1, pages: [][]string{{"UPDATE_IN_PROGRESS"}}}
+	info, err := WaitForOperationInProgress(context.Background(), mock, "cluster")
+	assert.Nil(t, err)
+	assert.Equal(t, kafka.ClusterStateActive, aws.StringValue(info.State))
+	assert.Equal(t, []string{"DescribeCluster", "ListClusterOperations", "DescribeClusterOperation", "DescribeClusterOperation", "DescribeCluster"}, mock.calls)
+
+	//any other cluster is returned as is.
+	mock = &mockMSK{state: kafka.ClusterStateActive}
+	info, err = WaitForOperationInProgress(context.Background(), mock, "cluster")
+	assert.Nil(t, err)
+	assert.Equal(t, kafka.ClusterStateActive, aws.StringValue(info.State))
+	assert.Equal(t, []string{"DescribeCluster"}, mock.calls)
+
+	_, err = WaitForOperationInProgress(context.Background(), &mockMSK{missing: true}, "cluster")
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "unable to describe cluster cluster: NotFoundException-cluster not found")
+}
@@ -0,0 +1,300 @@
+package postprocesskafka
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha512"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"github.com/Shopify/sarama"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/acmpca"
+	"github.com/aws/aws-sdk-go/service/acmpca/acmpcaiface"
+	msk "github.com/aws/aws-sdk-go/service/kafka"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/secretsmanager/secretsmanageriface"
+	"github.com/xdg-go/scram"
+	"log"
+	"strings"
+)
+
+//client authentication modes of the Authentication property.
+const (
+	authTLS       = "TLS"           //one way TLS, the client verifies the brokers
+	authMTLS      = "MTLS"          //mutual TLS, the brokers verify the client certificate as well
+	authScram     = "SCRAM-SHA-512" //SASL/SCRAM over TLS
+	authPlaintext = "PLAINTEXT"     //no encryption, meant for dev clusters only
+)
+
+//common name of the client certificates issued through ACM PCA unless ClientCommonName is given.
+const defaultClientCommonName = "kafka-postprocessor"
+
+//how the post processor authenticates itself to the kafka cluster.
+type kafkaAuth struct {
+	Mode                       string //one of TLS, MTLS, SCRAM-SHA-512, PLAINTEXT
+	ClientCertificateSecretArn string //MTLS: secret holding the client certificate and private key
+	CertificateAuthorityArn    string //MTLS: ACM PCA issuing a client certificate
+	ClientCommonName           string //MTLS: common name of the certificate issued by ACM PCA
+	ScramSecretArn             string //SCRAM-SHA-512: secret holding the username and password
+}
+
+//Secrets Manager client
+type SMclient struct {
+	Client secretsmanageriface.SecretsManagerAPI
+}
+
+//ACM Private CA client
+type PCAclient struct {
+	Client acmpcaiface.ACMPCAAPI
+}
+
+//reads the Authentication property along with the properties the chosen mode needs. Authentication defaults to TLS.
+func authentication(properties map[string]interface{}) (kafkaAuth, error) {
+	property := func(name string) string {
+		value, _ := properties[name].(string)
+		return value
+	}
+
+	auth := kafkaAuth{
+		Mode:                       strings.ToUpper(property("Authentication")),
+		ClientCertificateSecretArn: property("ClientCertificateSecretArn"),
+		CertificateAuthorityArn:    property("CertificateAuthorityArn"),
+		ClientCommonName:           property("ClientCommonName"),
+		ScramSecretArn:             property("ScramSecretArn"),
+	}
+	if auth.Mode == "" {
+		auth.Mode = authTLS
+	}
+	if auth.ClientCommonName == "" {
+		auth.ClientCommonName = defaultClientCommonName
+	}
+
+	switch auth.Mode {
+	case authTLS, authPlaintext:
+	case authMTLS:
+		if (auth.ClientCertificateSecretArn == "") == (auth.CertificateAuthorityArn == "") {
+			return kafkaAuth{}, fmt.Errorf("authentication %s needs exactly one of ClientCertificateSecretArn or CertificateAuthorityArn", authMTLS)
+		}
+	case authScram:
+		if auth.ScramSecretArn == "" {
+			return kafkaAuth{}, fmt.Errorf("authentication %s needs a ScramSecretArn", authScram)
+		}
+	default:
+		return kafkaAuth{}, fmt.Errorf("unknown authentication %q, expected one of %s", auth.Mode,
+			strings.Join([]string{authTLS, authMTLS, authScram, authPlaintext}, ","))
+	}
+	return auth, nil
+}
+
+//picks the bootstrap broker connection string matching the authentication mode.
+func (a kafkaAuth) bootstrapBrokers(out *msk.GetBootstrapBrokersOutput) (string, error) {
+	var brokers *string
+	switch a.Mode {
+	case authTLS, authMTLS:
+		brokers = out.BootstrapBrokerStringTls
+	case authScram:
+		brokers = out.BootstrapBrokerStringSaslScram
+	case authPlaintext:
+		brokers = out.BootstrapBrokerString
+	}
+	if aws.StringValue(brokers) == "" {
+		return "", fmt.Errorf("the cluster has no bootstrap brokers for authentication %s, check its client authentication and encryption in transit settings", a.Mode)
+	}
+	return aws.StringValue(brokers), nil
+}
+
+//the listener protocol of the brokers for the authentication mode.
+func (a kafkaAuth) protocol() string {
+	switch a.Mode {
+	case authScram:
+		return protocolSaslSsl
+	case authPlaintext:
+		return protocolPlaintext
+	}
+	return protocolSsl
+}
+
+//builds the sarama config for the authentication mode, fetching the client credentials it needs.
+func (a kafkaAuth) saramaConfig(ctx context.Context, sm SMclient, pca PCAclient) (*sarama.Config, error) {
+	config := sarama.NewConfig()
+	config.Version = KafkaProtocolVersion
+
+	switch a.Mode {
+	case authTLS:
+		config.Net.TLS.Enable = true
+	case authMTLS:
+		var cert tls.Certificate
+		var err error
+		if a.ClientCertificateSecretArn != "" {
+			cert, err = sm.clientCertificate(ctx, a.ClientCertificateSecretArn)
+		} else {
+			cert, err = pca.issueClientCertificate(ctx, a.CertificateAuthorityArn, a.ClientCommonName)
+		}
+		if err != nil {
+			return nil, err
+		}
+		config.Net.TLS.Enable = true
+		config.Net.TLS.Config = &tls.Config{Certificates: []tls.Certificate{cert}}
+	case authScram:
+		username, password, err := sm.scramCredentials(ctx, a.ScramSecretArn)
+		if err != nil {
+			return nil, err
+		}
+		//MSK only accepts SASL/SCRAM over TLS.
+		config.Net.TLS.Enable = true
+		config.Net.SASL.Enable = true
+		config.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA512
+		config.Net.SASL.User = username
+		config.Net.SASL.Password = password
+		config.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+			return &scramClient{HashGeneratorFcn: sha512.New}
+		}
+	case authPlaintext:
+		log.Println("connecting to the kafka cluster in plaintext, which is only meant for dev clusters.")
+	}
+	return config, nil
+}
+
+//reads the string value of the secret.
+func (s *SMclient) secretString(ctx context.Context, secretArn string) (string, error) {
+
+	out, err := s.Client.GetSecretValueWithContext(ctx, &secretsmanager.GetSecretValueInput{SecretId: aws.String(secretArn)})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			return "", fmt.Errorf("unable to get secret %s: %s-%v", secretArn, aerr.Code(), aerr.Message())
+		}
+		return "", fmt.Errorf("unable to get secret %s : %v", secretArn, err)
+	}
+	if aws.StringValue(out.SecretString) == "" {
+		return "", fmt.Errorf("secret %s has no secret string", secretArn)
+	}
+	return aws.StringValue(out.SecretString), nil
+}
+
+//reads the username and password of a SCRAM secret, stored the way MSK expects them i.e. {"username":"...","password":"..."}
+func (s *SMclient) scramCredentials(ctx context.Context, secretArn string) (username, password string, err error) {
+
+	secret, err := s.secretString(ctx, secretArn)
+	if err != nil {
+		return "", "", err
+	}
+
+	var credentials struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+	if err := json.Unmarshal([]byte(secret), &credentials); err != nil {
+		return "", "", fmt.Errorf("secret %s is not of the form {\"username\":\"...\",\"password\":\"...\"} : %v", secretArn, err)
+	}
+	if credentials.Username == "" || credentials.Password == "" {
+		return "", "", fmt.Errorf("secret %s is missing the username or the password", secretArn)
+	}
+	return credentials.Username, credentials.Password, nil
+}
+
+//reads a client certificate and its private key stored in PEM form as {"certificate":"...","privateKey":"..."}. The
+//certificate may be followed by its chain.
+func (s *SMclient) clientCertificate(ctx context.Context, secretArn string) (tls.Certificate, error) {
+
+	secret, err := s.secretString(ctx, secretArn)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	var pair struct {
+		Certificate      string `json:"certificate"`
+		CertificateChain string `json:"certificateChain"`
+		PrivateKey       string `json:"privateKey"`
+	}
+	if err := json.Unmarshal([]byte(secret), &pair); err != nil {
+		return tls.Certificate{}, fmt.Errorf("secret %s is not of the form {\"certificate\":\"...\",\"privateKey\":\"...\"} : %v", secretArn, err)
+	}
+
+	cert, err := tls.X509KeyPair([]byte(pair.Certificate+"\n"+pair.CertificateChain), []byte(pair.PrivateKey))
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("secret %s does not hold a valid client certificate and private key : %v", secretArn, err)
+	}
+	return cert, nil
+}
+
+//issues a short lived client certificate from the private certificate authority for a newly generated key.
+func (p *PCAclient) issueClientCertificate(ctx context.Context, caArn, commonName string) (tls.Certificate, error) {
+
+	ca, err := p.Client.DescribeCertificateAuthorityWithContext(ctx, &acmpca.DescribeCertificateAuthorityInput{
+		CertificateAuthorityArn: aws.String(caArn),
+	})
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("unable to describe certificate authority %s : %v", caArn, err)
+	}
+	if status := aws.StringValue(ca.CertificateAuthority.Status); status != acmpca.CertificateAuthorityStatusActive {
+		return tls.Certificate{}, fmt.Errorf("certificate authority %s is %s and cannot issue certificates", caArn, status)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("unable to generate a private key : %v", err)
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{Subject: pkix.Name{CommonName: commonName}}, key)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("unable to create a certificate signing request : %v", err)
+	}
+
+	issued, err := p.Client.IssueCertificateWithContext(ctx, &acmpca.IssueCertificateInput{
+		CertificateAuthorityArn: aws.String(caArn),
+		Csr:                     pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr}),
+		//the certificate authority signs with its own algorithm.
+		SigningAlgorithm: ca.CertificateAuthority.CertificateAuthorityConfiguration.SigningAlgorithm,
+		Validity:         &acmpca.Validity{Type: aws.String(acmpca.ValidityPeriodTypeDays), Value: aws.Int64(1)},
+	})
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("unable to issue a client certificate from %s : %v", caArn, err)
+	}
+
+	getParam := acmpca.GetCertificateInput{CertificateAuthorityArn: aws.String(caArn), CertificateArn: issued.CertificateArn}
+	err = p.Client.WaitUntilCertificateIssuedWithContext(ctx, &getParam)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("client certificate %s was not issued : %v", aws.StringValue(issued.CertificateArn), err)
+	}
+	out, err := p.Client.GetCertificateWithContext(ctx, &getParam)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("unable to get client certificate %s : %v", aws.StringValue(issued.CertificateArn), err)
+	}
+	log.Printf("issued client certificate %s for %s", aws.StringValue(issued.CertificateArn), commonName)
+
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("unable to encode the private key : %v", err)
+	}
+	return tls.X509KeyPair([]byte(aws.StringValue(out.Certificate)+"\n"+aws.StringValue(out.CertificateChain)),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}))
+}
+
+//a sarama.SCRAMClient running the SCRAM conversation with the brokers.
+type scramClient struct {
+	*scram.ClientConversation
+	scram.HashGeneratorFcn
+}
+
+func (c *scramClient) Begin(userName, password, authzID string) error {
+	client, err := c.HashGeneratorFcn.NewClient(userName, password, authzID)
+	if err != nil {
+		return err
+	}
+	c.ClientConversation = client.NewConversation()
+	return nil
+}
+
+func (c *scramClient) Step(challenge string) (string, error) {
+	return c.ClientConversation.Step(challenge)
+}
+
+func (c *scramClient) Done() bool {
+	return c.ClientConversation.Done()
+}
@@ -0,0 +1,225 @@
+package postprocesskafka
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"github.com/Shopify/sarama"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/acmpca"
+	"github.com/aws/aws-sdk-go/service/acmpca/acmpcaiface"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/secretsmanager/secretsmanageriface"
+	"github.com/tj/assert"
+	"math/big"
+	"testing"
+	"time"
+)
+
+type mockSecretsManager struct {
+	secretsmanageriface.SecretsManagerAPI
+	secrets map[string]string
+}
+
+func (m *mockSecretsManager) GetSecretValueWithContext(_ aws.Context, in *secretsmanager.GetSecretValueInput, _ ...request.Option) (*secretsmanager.GetSecretValueOutput, error) {
+	secret, ok := m.secrets[aws.StringValue(in.SecretId)]
+	if !ok {
+		return nil, awserr.New(secretsmanager.ErrCodeResourceNotFoundException, "secret not found", nil)
+	}
+	return &secretsmanager.GetSecretValueOutput{SecretString: aws.String(secret)}, nil
+}
+
+//a private certificate authority signing the certificate signing requests it is given.
+type mockPCA struct {
+	acmpcaiface.ACMPCAAPI
+	caCert *x509.Certificate
+	caKey  *ecdsa.PrivateKey
+	issued []byte
+}
+
+func (m *mockPCA) DescribeCertificateAuthorityWithContext(aws.Context, *acmpca.DescribeCertificateAuthorityInput, ...request.Option) (*acmpca.DescribeCertificateAuthorityOutput, error) {
+	return &acmpca.DescribeCertificateAuthorityOutput{CertificateAuthority: &acmpca.CertificateAuthority{
+		Status:                            aws.String(acmpca.CertificateAuthorityStatusActive),
+		CertificateAuthorityConfiguration: &acmpca.CertificateAuthorityConfiguration{SigningAlgorithm: aws.String(acmpca.SigningAlgorithmSha256withecdsa)},
+	}}, nil
+}
+
+func (m *mockPCA) IssueCertificateWithContext(_ aws.Context, in *acmpca.IssueCertificateInput, _ ...request.Option) (*acmpca.IssueCertificateOutput, error) {
+	block, _ := pem.Decode(in.Csr)
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      csr.Subject,
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	m.issued, err = x509.CreateCertificate(rand.Reader, template, m.caCert, csr.PublicKey, m.caKey)
+	if err != nil {
+		return nil, err
+	}
+	return &acmpca.IssueCertificateOutput{CertificateArn: aws.String("dummyCertArn")}, nil
+}
+
+func (m *mockPCA) WaitUntilCertificateIssuedWithContext(aws.Context, *acmpca.GetCertificateInput, ...request.WaiterOption) error {
+	return nil
+}
+
+func (m *mockPCA) GetCertificateWithContext(aws.Context, *acmpca.GetCertificateInput, ...request.Option) (*acmpca.GetCertificateOutput, error) {
+	return &acmpca.GetCertificateOutput{
+		Certificate:      aws.String(string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: m.issued}))),
+		CertificateChain: aws.String(string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: m.caCert.Raw}))),
+	}, nil
+}
+
+//a self signed certificate with its key.
+func selfSigned(t *testing.T, commonName string) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func Test_Authentication(t *testing.T) {
+	cases := []struct {
+		Properties map[string]interface{}
+		Expected   kafkaAuth
+		Err        string
+	}{
+		{
+			Properties: map[string]interface{}{},
+			Expected:   kafkaAuth{Mode: authTLS, ClientCommonName: defaultClientCommonName},
+		},
+		{
+			Properties: map[string]interface{}{"Authentication": "scram-sha-512", "ScramSecretArn": "AmazonMSK_dev"},
+			Expected:   kafkaAuth{Mode: authScram, ScramSecretArn: "AmazonMSK_dev", ClientCommonName: defaultClientCommonName},
+		},
+		{
+			Properties: map[string]interface{}{"Authentication": "MTLS", "CertificateAuthorityArn": "dummyCaArn", "ClientCommonName": "jobs"},
+			Expected:   kafkaAuth{Mode: authMTLS, CertificateAuthorityArn: "dummyCaArn", ClientCommonName: "jobs"},
+		},
+		{
+			Properties: map[string]interface{}{"Authentication": "MTLS"},
+			Err:        "needs exactly one of ClientCertificateSecretArn or CertificateAuthorityArn",
+		},
+		{
+			Properties: map[string]interface{}{"Authentication": "MTLS", "CertificateAuthorityArn": "dummyCaArn", "ClientCertificateSecretArn": "dummySecret"},
+			Err:        "needs exactly one of ClientCertificateSecretArn or CertificateAuthorityArn",
+		},
+		{
+			Properties: map[string]interface{}{"Authentication": "SCRAM-SHA-512"},
+			Err:        "needs a ScramSecretArn",
+		},
+		{
+			Properties: map[string]interface{}{"Authentication": "IAM"},
+			Err:        "unknown authentication \"IAM\", expected one of TLS,MTLS,SCRAM-SHA-512,PLAINTEXT",
+		},
+	}
+
+	for _, c := range cases {
+		auth, err := authentication(c.Properties)
+		if c.Err != "" {
+			assert.NotNil(t, err)
+			assert.Contains(t, err.Error(), c.Err)
+			continue
+		}
+		assert.Nil(t, err)
+		assert.Equal(t, c.Expected, auth)
+	}
+}
+
+func Test_MockSaramaConfig(t *testing.T) {
+	caCert, caKey := selfSigned(t, "ca")
+	clientCert, clientKey := selfSigned(t, "client")
+	keyDer, err := x509.MarshalECPrivateKey(clientKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certSecret, _ := json.Marshal(map[string]string{
+		"certificate": string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: clientCert.Raw})),
+		"privateKey":  string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})),
+	})
+
+	sm := SMclient{Client: &mockSecretsManager{secrets: map[string]string{
+		"scram":       `{"username":"jobs","password":"secret"}`,
+		"scramNoPass": `{"username":"jobs"}`,
+		"clientCert":  string(certSecret),
+		"notJson":     "jobs:secret",
+	}}}
+	pca := &mockPCA{caCert: caCert, caKey: caKey}
+	ctx := context.Background()
+
+	config, err := kafkaAuth{Mode: authTLS}.saramaConfig(ctx, sm, PCAclient{Client: pca})
+	assert.Nil(t, err)
+	assert.True(t, config.Net.TLS.Enable)
+	assert.False(t, config.Net.SASL.Enable)
+	assert.Equal(t, KafkaProtocolVersion, config.Version)
+
+	config, err = kafkaAuth{Mode: authPlaintext}.saramaConfig(ctx, sm, PCAclient{Client: pca})
+	assert.Nil(t, err)
+	assert.False(t, config.Net.TLS.Enable)
+
+	config, err = kafkaAuth{Mode: authScram, ScramSecretArn: "scram"}.saramaConfig(ctx, sm, PCAclient{Client: pca})
+	assert.Nil(t, err)
+	assert.True(t, config.Net.TLS.Enable)
+	assert.True(t, config.Net.SASL.Enable)
+	assert.Equal(t, sarama.SASLMechanism(sarama.SASLTypeSCRAMSHA512), config.Net.SASL.Mechanism)
+	assert.Equal(t, "jobs", config.Net.SASL.User)
+	assert.Equal(t, "secret", config.Net.SASL.Password)
+	assert.Nil(t, config.Net.SASL.SCRAMClientGeneratorFunc().Begin("jobs", "secret", ""))
+	assert.Nil(t, config.Validate())
+
+	for _, secret := range []string{"scramNoPass", "notJson", "missing"} {
+		_, err = kafkaAuth{Mode: authScram, ScramSecretArn: secret}.saramaConfig(ctx, sm, PCAclient{Client: pca})
+		assert.NotNil(t, err, secret)
+	}
+
+	config, err = kafkaAuth{Mode: authMTLS, ClientCertificateSecretArn: "clientCert"}.saramaConfig(ctx, sm, PCAclient{Client: pca})
+	assert.Nil(t, err)
+	assert.True(t, config.Net.TLS.Enable)
+	assert.Len(t, config.Net.TLS.Config.Certificates, 1)
+
+	_, err = kafkaAuth{Mode: authMTLS, ClientCertificateSecretArn: "scram"}.saramaConfig(ctx, sm, PCAclient{Client: pca})
+	assert.NotNil(t, err)
+
+	//the certificate issued by the private CA is for the generated key and carries the CA in its chain
+	config, err = kafkaAuth{Mode: authMTLS, CertificateAuthorityArn: "dummyCaArn", ClientCommonName: "jobs"}.saramaConfig(ctx, sm, PCAclient{Client: pca})
+	assert.Nil(t, err)
+	assert.Len(t, config.Net.TLS.Config.Certificates, 1)
+	if len(config.Net.TLS.Config.Certificates) == 1 {
+		chain := config.Net.TLS.Config.Certificates[0].Certificate
+		assert.Len(t, chain, 2)
+		issued, err := x509.ParseCertificate(chain[0])
+		assert.Nil(t, err)
+		assert.Equal(t, "jobs", issued.Subject.CommonName)
+		assert.Nil(t, issued.CheckSignatureFrom(caCert))
+	}
+}
@@ -0,0 +1,69 @@
+package postprocesskafka
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	msk "github.com/aws/aws-sdk-go/service/kafka"
+	"sort"
+	"strings"
+)
+
+//listener protocols of the brokers as kafka clients name them in their bootstrap.servers URLs.
+const (
+	protocolSsl       = "SSL"
+	protocolSaslSsl   = "SASL_SSL"
+	protocolPlaintext = "PLAINTEXT"
+)
+
+//the bootstrap broker strings of a cluster returned as attributes, along with the listener protocol of each. MSK only
+//returns the strings of the client authentication and encryption in transit settings of the cluster.
+var brokerStrings = []struct {
+	Attribute string
+	Protocol  string
+	Brokers   func(out *msk.GetBootstrapBrokersOutput) *string
+}{
+	{Attribute: "BrokersTls", Protocol: protocolSsl, Brokers: func(out *msk.GetBootstrapBrokersOutput) *string { return out.BootstrapBrokerStringTls }},
+	{Attribute: "BrokersPlaintext", Protocol: protocolPlaintext, Brokers: func(out *msk.GetBootstrapBrokersOutput) *string { return out.BootstrapBrokerString }},
+	{Attribute: "BrokersSaslScram", Protocol: protocolSaslSsl, Brokers: func(out *msk.GetBootstrapBrokersOutput) *string { return out.BootstrapBrokerStringSaslScram }},
+	{Attribute: "BrokersIam", Protocol: protocolSaslSsl, Brokers: func(out *msk.GetBootstrapBrokersOutput) *string { return out.BootstrapBrokerStringSaslIam }},
+}
+
+//splits a broker connection string such as b-2.kafka.example.com:9094,b-1.kafka.example.com:9094 into its brokers,
+//sorted and without blanks or duplicates.
+func sortedBrokers(conString string) []string {
+	seen := make(map[string]bool)
+	var brokers []string
+	for _, broker := range strings.Split(conString, ",") {
+		broker = strings.TrimSpace(broker)
+		if broker == "" || seen[broker] {
+			continue
+		}
+		seen[broker] = true
+		brokers = append(brokers, broker)
+	}
+	sort.Strings(brokers)
+	return brokers
+}
+
+//lists the brokers as URLs of the listener protocol, e.g. SSL://b-1.kafka.example.com:9094,SSL://b-2.kafka.example.com:9094
+func brokerUrls(brokers []string, protocol string) string {
+	urls := make([]string, len(brokers))
+	for i, broker := range brokers {
+		urls[i] = protocol + "://" + broker
+	}
+	return strings.Join(urls, ",")
+}
+
+//the bootstrap broker strings the cluster has, each as the sorted host:port list under its attribute, e.g. BrokersTls,
+//and as the sorted URL list under the attribute suffixed with Urls, e.g. BrokersTlsUrls.
+func brokerAttributes(out *msk.GetBootstrapBrokersOutput) map[string]interface{} {
+	attributes := make(map[string]interface{})
+	for _, s := range brokerStrings {
+		brokers := sortedBrokers(aws.StringValue(s.Brokers(out)))
+		if len(brokers) == 0 {
+			continue
+		}
+		attributes[s.Attribute] = strings.Join(brokers, ",")
+		attributes[s.Attribute+"Urls"] = brokerUrls(brokers, s.Protocol)
+	}
+	return attributes
+}
@@ -0,0 +1,65 @@
+package postprocesskafka
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kafka"
+	"github.com/tj/assert"
+	"testing"
+)
+
+func Test_SortedBrokers(t *testing.T) {
+	assert.Equal(t, []string{"b-1.example.com:9094", "b-2.example.com:9094", "b-3.example.com:9094"},
+		sortedBrokers("b-3.example.com:9094,b-1.example.com:9094, b-2.example.com:9094,,b-1.example.com:9094"))
+	assert.Nil(t, sortedBrokers(""))
+}
+
+func Test_BrokerAttributes(t *testing.T) {
+	cases := []struct {
+		Name     string
+		Resp     kafka.GetBootstrapBrokersOutput
+		Expected map[string]interface{}
+	}{
+		{
+			Name: "tls and plaintext",
+			Resp: kafka.GetBootstrapBrokersOutput{
+				BootstrapBrokerString:    aws.String("b-2.example.com:9092,b-3.example.com:9092,b-1.example.com:9092"),
+				BootstrapBrokerStringTls: aws.String("b-2.example.com:9094,b-3.example.com:9094,b-1.example.com:9094"),
+			},
+			Expected: map[string]interface{}{
+				"BrokersTls":           "b-1.example.com:9094,b-2.example.com:9094,b-3.example.com:9094",
+				"BrokersTlsUrls":       "SSL://b-1.example.com:9094,SSL://b-2.example.com:9094,SSL://b-3.example.com:9094",
+				"BrokersPlaintext":     "b-1.example.com:9092,b-2.example.com:9092,b-3.example.com:9092",
+				"BrokersPlaintextUrls": "PLAINTEXT://b-1.example.com:9092,PLAINTEXT://b-2.example.com:9092,PLAINTEXT://b-3.example.com:9092",
+			},
+		},
+		{
+			Name: "sasl",
+			Resp: kafka.GetBootstrapBrokersOutput{
+				BootstrapBrokerStringSaslScram: aws.String("b-2.example.com:9096,b-1.example.com:9096"),
+				BootstrapBrokerStringSaslIam:   aws.String("b-2.example.com:9098,b-1.example.com:9098"),
+				BootstrapBrokerStringTls:       aws.String(""),
+			},
+			Expected: map[string]interface{}{
+				"BrokersSaslScram":     "b-1.example.com:9096,b-2.example.com:9096",
+				"BrokersSaslScramUrls": "SASL_SSL://b-1.example.com:9096,SASL_SSL://b-2.example.com:9096",
+				"BrokersIam":           "b-1.example.com:9098,b-2.example.com:9098",
+				"BrokersIamUrls":       "SASL_SSL://b-1.example.com:9098,SASL_SSL://b-2.example.com:9098",
+			},
+		},
+		{
+			Name:     "none",
+			Expected: map[string]interface{}{},
+		},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.Expected, brokerAttributes(&c.Resp), c.Name)
+	}
+}
+
+func Test_AuthProtocol(t *testing.T) {
+	brokers := sortedBrokers("b-2.example.com:9096,b-1.example.com:9096")
+	assert.Equal(t, "SASL_SSL://b-1.example.com:9096,SASL_SSL://b-2.example.com:9096", brokerUrls(brokers, kafkaAuth{Mode: authScram}.protocol()))
+	assert.Equal(t, "SSL://b-1.example.com:9096,SSL://b-2.example.com:9096", brokerUrls(brokers, kafkaAuth{Mode: authMTLS}.protocol()))
+	assert.Equal(t, "PLAINTEXT://b-1.example.com:9096,PLAINTEXT://b-2.example.com:9096", brokerUrls(brokers, kafkaAuth{Mode: authPlaintext}.protocol()))
+}
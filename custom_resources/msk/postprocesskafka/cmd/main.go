@@ -0,0 +1,13 @@
+package main
+
+import (
+	"github.com/aws/aws-lambda-go/cfn"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/krunal4amity/cfn-infra/custom_resources/msk/postprocesskafka"
+	"github.com/krunal4amity/cfn-infra/custom_resources/resource"
+)
+
+//lambda function serving only the KafkaPostProcessor custom resource. Packaged as postprocesskafka.zip
+func main() {
+	lambda.Start(cfn.LambdaWrap(resource.Serve(postprocesskafka.Handler{})))
+}
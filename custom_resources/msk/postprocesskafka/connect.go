@@ -0,0 +1,38 @@
+package postprocesskafka
+
+import (
+	"context"
+	"fmt"
+	"github.com/Shopify/sarama"
+	"github.com/aws/aws-sdk-go/aws/session"
+	msk "github.com/aws/aws-sdk-go/service/kafka"
+)
+
+//DiscoverBrokers finds out the bootstrap brokers of the cluster matching the Authentication given under properties,
+//sorted, along with the sarama config authenticating to them in the given kafka protocol version. The other custom
+//resources talking to the cluster use it so that they reach the brokers the same way the KafkaPostProcessor does, and
+//accept the same Authentication, ClientCertificateSecretArn, CertificateAuthorityArn, ClientCommonName and
+//ScramSecretArn properties. ErrClusterNotFound is returned when the cluster does not exist.
+func DiscoverBrokers(ctx context.Context, properties map[string]interface{}, clusterArn string, version sarama.KafkaVersion) ([]string, *sarama.Config, error) {
+
+	auth, err := authentication(properties)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sess, err := session.NewSession()
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to create a new session: %v", err)
+	}
+	mskapi := MSKclient{Client: msk.New(sess)}
+	brokers, err := mskapi.brokerConString(ctx, clusterArn, auth)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	config, err := clientConfig(ctx, auth, version)
+	if err != nil {
+		return nil, nil, err
+	}
+	return sortedBrokers(brokers), config, nil
+}
@@ -1,11 +1,11 @@
-package main
+package postprocesskafka
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/Shopify/sarama"
 	"github.com/aws/aws-lambda-go/cfn"
-	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/session"
@@ -13,18 +13,18 @@ import (
 	"github.com/aws/aws-sdk-go/service/kafka/kafkaiface"
 	r53 "github.com/aws/aws-sdk-go/service/route53"
 	"github.com/aws/aws-sdk-go/service/route53/route53iface"
+	"github.com/krunal4amity/cfn-infra/custom_resources/resource"
 	"log"
-	"net"
 	"strconv"
 	"strings"
-	"time"
 )
 
 //kafka topic configuration struct
 type kafkaTopicConfig struct {
-	Name              string //name of the topic
-	ReplicationFactor int    //replication factor
-	NumOfPartitions   int    //number of partitions
+	Name              string            //name of the topic
+	ReplicationFactor int               //replication factor
+	NumOfPartitions   int               //number of partitions
+	Config            map[string]string //topic level configs e.g. retention.ms
 }
 
 //Route 53 client
@@ -37,19 +37,11 @@ type MSKclient struct {
 	Client kafkaiface.KafkaAPI
 }
 
-//Apache Kafka client. However it doesn't include any 'sarama' elements
-type Kafka struct {
-	BrokerConn       string
-	Topics           []kafkaTopicConfig
-	BrokerController string
-}
+//returned when the hosted zone does not exist.
+var errZoneNotFound = errors.New("hosted zone not found")
 
-//some error codes that we care about. Available at https://kafka.apache.org/protocol#protocol_error_codes
-const (
-	NOT_CONTROLLER       = 41 //if the broker being used is not a controller
-	TOPIC_ALREADY_EXISTS = 36 //if the topic already exists
-	SUCCESS              = 0  //if create topic operation executes fine and doesn't result in any error code.
-)
+//ErrClusterNotFound is returned when the msk cluster does not exist.
+var ErrClusterNotFound = errors.New("msk cluster not found")
 
 //fetches the hosted zone name based on the hosted zone ID supplied. To be used in case it is required to create a route53 record set.
 func (r *R53client) recordSet(ctx context.Context, zoneId string) (string, error) {
@@ -58,6 +50,9 @@ func (r *R53client) recordSet(ctx context.Context, zoneId string) (string, error
 
 	out, err := r.Client.GetHostedZoneWithContext(ctx, &param)
 	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == r53.ErrCodeNoSuchHostedZone {
+			return "", errZoneNotFound
+		}
 		return "", fmt.Errorf("unable to get hosted zone : %v", err)
 	}
 
@@ -65,8 +60,8 @@ func (r *R53client) recordSet(ctx context.Context, zoneId string) (string, error
 	return aws.StringValue(out.HostedZone.Name), nil
 }
 
-//finds out existing bootstrap broker connection string from describeCluster operation on MSK
-func (m *MSKclient) brokerConString(ctx context.Context, clusterArn string) (string, error) {
+//finds out the bootstrap broker connection strings of the cluster from MSK.
+func (m *MSKclient) bootstrapBrokers(ctx context.Context, clusterArn string) (*msk.GetBootstrapBrokersOutput, error) {
 
 	param := msk.GetBootstrapBrokersInput{
 		ClusterArn: aws.String(clusterArn),
@@ -76,19 +71,37 @@ func (m *MSKclient) brokerConString(ctx context.Context, clusterArn string) (str
 	if err != nil {
 		if aerr, ok := err.(awserr.Error); ok {
 			switch aerr.Code() {
+			case msk.ErrCodeNotFoundException:
+				return nil, ErrClusterNotFound
 			default:
-				return "", fmt.Errorf("unable to describe brokerlist: %s-%v", aerr.Code(), aerr.Message())
+				return nil, fmt.Errorf("unable to describe brokerlist: %s-%v", aerr.Code(), aerr.Message())
 			}
 		} else {
-			return "", fmt.Errorf("unable to describe brokerlist : %v", err)
+			return nil, fmt.Errorf("unable to describe brokerlist : %v", err)
 		}
 	}
+	return out, nil
+}
+
+//finds out existing bootstrap broker connection string matching the authentication mode from MSK, its brokers sorted.
+func (m *MSKclient) brokerConString(ctx context.Context, clusterArn string, auth kafkaAuth) (string, error) {
+
+	out, err := m.bootstrapBrokers(ctx, clusterArn)
+	if err != nil {
+		return "", err
+	}
 	//Note that SSL encryption, technically speaking, already enables 1-way authentication in which the client authenticates
 	// the server certificate. So when referring to SSL authentication, it is really referring to 2-way authentication in
 	// which the broker also authenticates the client certificate.
-	//BootstrapBrokerString = PLAINTEXT (9092 port). BootstrapBrokerStringTls = TLS (9094 port)
-	log.Printf("Bootstrap broker connection string is : %s", aws.StringValue(out.BootstrapBrokerStringTls))
-	return aws.StringValue(out.BootstrapBrokerStringTls), nil
+	//BootstrapBrokerString = PLAINTEXT (9092 port). BootstrapBrokerStringTls = TLS (9094 port).
+	//BootstrapBrokerStringSaslScram = SASL/SCRAM (9096 port)
+	brokers, err := auth.bootstrapBrokers(out)
+	if err != nil {
+		return "", err
+	}
+	brokers = strings.Join(sortedBrokers(brokers), ",")
+	log.Printf("Bootstrap broker connection string is : %s", brokers)
+	return brokers, nil
 }
 
 func (m *MSKclient) zookeeperConString(ctx context.Context, clusterArn string) (string, error) {
@@ -111,238 +124,413 @@ func (m *MSKclient) zookeeperConString(ctx context.Context, clusterArn string) (
 	return aws.StringValue(out.ClusterInfo.ZookeeperConnectString), nil
 }
 
-//loops through the list of available broker nodes and topic list to create topic. One of them is a controller and topic can be created over
-//controller's broker connection only. Use kafka returned error codes to check whether the broker is a controller or not.
-func (k *Kafka) createTopic(ctx context.Context) error {
-
-	brokerConArray := strings.Split(k.BrokerConn, ",")
-
-	for _, brokerCon := range brokerConArray {
-
-		if k.BrokerController != "" && brokerCon != k.BrokerController { //let's move to next iterator if controller has been found
-			continue
-		} else { //let's find who of the brokers is a controller and create topic
-			for _, topicConfig := range k.Topics {
-				if k.BrokerController != "" && brokerCon == k.BrokerController {
-					err := k.createKafkaTopic(ctx, k.BrokerController, topicConfig) //use controller that has been found
-					if err != nil {
-						return err
-					}
-				} else {
-					err := k.createKafkaTopic(ctx, brokerCon, topicConfig) //keep on finding one
-					if err != nil {
-						return err
-					}
+//finds out the number of broker nodes of the cluster from MSK.
+func (m *MSKclient) brokerCount(ctx context.Context, clusterArn string) (int, error) {
+
+	param := msk.DescribeClusterInput{ClusterArn: aws.String(clusterArn)}
+
+	out, err := m.Client.DescribeClusterWithContext(ctx, &param)
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			switch aerr.Code() {
+			case msk.ErrCodeNotFoundException:
+				return 0, ErrClusterNotFound
+			default:
+				return 0, fmt.Errorf("unable to describe cluster: %s-%v", aerr.Code(), aerr.Message())
+			}
+		} else {
+			return 0, fmt.Errorf("unable to describe cluster : %v", err)
+		}
+	}
+
+	log.Printf("Number of broker nodes is : %d", aws.Int64Value(out.ClusterInfo.NumberOfBrokerNodes))
+	return int(aws.Int64Value(out.ClusterInfo.NumberOfBrokerNodes)), nil
+}
+
+//validates the topics against the TopicNamePolicy and the broker count of the cluster, before connecting to it.
+func validateTopicList(ctx context.Context, event cfn.Event, topics []kafkaTopicConfig) error {
+
+	policy, err := namePolicy(event.ResourceProperties)
+	if err != nil {
+		return err
+	}
+
+	return validateTopics(topics, policy, func() (int, error) {
+		sess, err := session.NewSession()
+		if err != nil {
+			return 0, fmt.Errorf("unable to create a new session: %v", err)
+		}
+		mskapi := MSKclient{Client: msk.New(sess)}
+		return mskapi.brokerCount(ctx, event.ResourceProperties["ClusterArn"].(string))
+	})
+}
+
+//reads the topics given under TopicList.
+func topicList(properties map[string]interface{}) []kafkaTopicConfig {
+	var listOfTopics []kafkaTopicConfig
+
+	if topics, ok := properties["TopicList"].([]interface{}); ok {
+		for _, topic := range topics {
+			var thisTopic kafkaTopicConfig
+			thisTopic.Name = topic.(map[string]interface{})["Name"].(string)
+			replicationFactorStr := topic.(map[string]interface{})["ReplicationFactor"].(string)
+			thisTopic.ReplicationFactor, _ = strconv.Atoi(replicationFactorStr)
+			numOfPartitionsStr := topic.(map[string]interface{})["NumOfPartitions"].(string)
+			thisTopic.NumOfPartitions, _ = strconv.Atoi(numOfPartitionsStr)
+			if config, ok := topic.(map[string]interface{})["Config"].(map[string]interface{}); ok {
+				thisTopic.Config = make(map[string]string)
+				for key, value := range config {
+					thisTopic.Config[key] = fmt.Sprint(value)
 				}
 			}
+			listOfTopics = append(listOfTopics, thisTopic)
 		}
 	}
-	return nil
+	return listOfTopics
 }
 
-func (k *Kafka) createKafkaTopic(ctx context.Context, brokerConn string, conf kafkaTopicConfig) (err error) {
-	//Set Logging by uncommenting the following during testing
-	//sarama.Logger = log.New(os.Stdout, "[zaplabs-msk]", log.LstdFlags)
+//reads an optional boolean property such as AllowTopicDeletion, false unless set to true.
+func flagProperty(properties map[string]interface{}, name string) bool {
+	b, _ := strconv.ParseBool(fmt.Sprint(properties[name]))
+	return b
+}
 
-	//Set broker config
-	broker := sarama.NewBroker(brokerConn)
+//this handler accepts inputs under ResourceProperties as shown below.
+//{
+// "ClusterArn":"arn:aws:kafka:us-west-2:508718283261:configuration/krunal1/25815693-f755-47f3-873b-aaeb92dc25d8-3",
+// "HostedZone":"ADf2er223324"
+// "TopicList":[
+//				{
+//					"Name":"MySampleTopic"
+//					"ReplicationFactor":"3"
+//					"NumOfPartitions":"1"
+//					"Config":{"retention.ms":"604800000","cleanup.policy":"compact"} (optional. topic level configs)
+//				},
+//				...
+//				]
+// "AllowTopicDeletion":"optional. true to delete the topics removed from TopicList on update"
+// "TopicNamePolicy":{ (optional. every topic of the TopicList is validated against it before the cluster is contacted)
+//				"Prefix":"dev-" (optional. prefix every topic name must start with)
+//				"AllowedCharacters":"a-zA-Z0-9._-" (optional. character set of the names, what kafka accepts by default)
+//				"MaxLength":"249" (optional. longest name, what kafka accepts by default)
+//				} (NumOfPartitions and ReplicationFactor are limited to the broker count regardless)
+// "Authentication":"optional. TLS (default), MTLS, SCRAM-SHA-512 or PLAINTEXT"
+// "ClientCertificateSecretArn":"MTLS. secret holding {"certificate":"PEM","privateKey":"PEM"}"
+// "CertificateAuthorityArn":"MTLS. ACM PCA issuing a client certificate, instead of ClientCertificateSecretArn"
+// "ClientCommonName":"optional. MTLS. common name of the certificate issued by ACM PCA"
+// "ScramSecretArn":"SCRAM-SHA-512. secret holding {"username":"...","password":"..."}"
+// "StageName":"optional. dev. manages the brokers-dev and zookeepers-dev A records in the HostedZone"
+// "Rebalance":{ (optional. kafka 2.4 or newer. balances the replicas of the topics over all brokers and their racks)
+//				"Topics":["MySampleTopic"] (optional. the topics of the TopicList by default)
+//				"ThrottleBytesPerSec":"52428800" (optional. replication throttle of each broker while reassigning)
+//				}
+// "SmokeTest":{ (optional. produces a message and consumes it back, failing with a network, TLS or auth diagnosis)
+//				"Topic":"dev-smoketest" (optional. kafka-postprocessor-smoketest by default, created if missing)
+//				"TimeoutSeconds":"30" (optional. time the message has to make it back)
+//				}
+// "ClientQuotas":[ (optional. kafka 2.6 or newer. removed from the cluster on delete)
+//				{
+//					"User":"app" (SCRAM username or client certificate distinguished name, e.g. CN=app. User and/or ClientId)
+//					"ClientId":"app-producer" (the client.id of the clients)
+//					"ProducerByteRate":"1048576" (optional. bytes/sec per broker)
+//					"ConsumerByteRate":"2097152" (optional. bytes/sec per broker)
+//					"RequestPercentage":"200" (optional. percentage of a broker thread's time)
+//				},
+//				...
+//				]
+//}
+
+//The handler returns the following response (sample output). Broker lists are sorted.
+// {
+//		"Brokers":"SSL://b-1.mm.rora1l.c3.kafka.us-west-2.amazonaws.com:9094,SSL://b-2.mm.rora1l.c3.kafka.us-west-2.amazonaws.com:9094,SSL://b-3.mm.rora1l.c3.kafka.us-west-2.amazonaws.com:9094" (for the Authentication)
+//		"BrokersTls": "b-1.mm.rora1l.c3.kafka.us-west-2.amazonaws.com:9094,b-2.mm.rora1l.c3.kafka.us-west-2.amazonaws.com:9094,..."
+//		"BrokersTlsUrls": "SSL://b-1.mm.rora1l.c3.kafka.us-west-2.amazonaws.com:9094,SSL://b-2.mm.rora1l.c3.kafka.us-west-2.amazonaws.com:9094,..."
+//		"BrokersPlaintext", "BrokersPlaintextUrls": PLAINTEXT://..., "BrokersSaslScram", "BrokersSaslScramUrls": SASL_SSL://...,
+//		"BrokersIam", "BrokersIamUrls": SASL_SSL://... (the ones the cluster has)
+//      "Zookeepers":"10.133.33.244,10.133.34.68,10.133.32.160",
+//		"ZoneName": "mydomain.example.com",
+//		"BrokersDns": "brokers-dev.mydomain.example.com." (with a StageName)
+//		"ZookeepersDns": "zookeepers-dev.mydomain.example.com." (with a StageName)
+//		"ReassignedPartitions": "12" (with a Rebalance)
+//		"SmokeTestLatencyMs": "35" (with a SmokeTest, from producing the message until it is consumed back)
+//		"SmokeTestTopic": "dev-smoketest" (with a SmokeTest)
+//		"AlteredClientQuotas": "3" (with ClientQuotas, the quota configs set or removed)
+//}
+type Handler struct{}
 
-	// Additional configurations. Check sarama doc for more info
-	config := sarama.NewConfig()
-	config.Net.TLS.Enable = true
+var _ resource.Handler = Handler{}
 
-	//choosing the latest version.
-	config.Version = sarama.MaxVersion
+//looks up the broker connection string of the cluster for the authentication mode along with the broker and zookeeper
+//connection details and the hosted zone name to be returned as response data.
+func clusterDetails(ctx context.Context, event cfn.Event, auth kafkaAuth) (brokers string, data map[string]interface{}, err error) {
 
-	// Open broker connection with configs defined above
-	err = broker.Open(config)
+	log.Println("Lambda function should be in private subnet with NAT translation to access AWS private resources or else the lambda will fail.")
+	sess := session.Must(session.NewSession()) //aws session
+	r53api := R53client{Client: r53.New(sess)} //r53 client
+	mskapi := MSKclient{Client: msk.New(sess)} //msk client
+
+	clusterArn := event.ResourceProperties["ClusterArn"].(string)
+	zoneId := event.ResourceProperties["HostedZone"].(string)
+	log.Printf("MSKClusterArn: %s. Route53HostedZone : %s", clusterArn, zoneId)
+
+	zoneName, err := r53api.recordSet(ctx, zoneId)
 	if err != nil {
-		return fmt.Errorf("error occurred while opening broker connection : %v", err)
+		return "", nil, err
 	}
 
-	// check if the connection was OK
-	_, err = broker.Connected()
+	out, err := mskapi.bootstrapBrokers(ctx, clusterArn)
 	if err != nil {
-		return fmt.Errorf("error occurred while connecting to the broker : %v", err)
+		return "", nil, err
 	}
-
-	// Setup the Topic details in CreateTopicRequest struct
-	if conf.Name == "" {
-		return fmt.Errorf("An empty string was provided for topic name. Exiting.")
+	brokers, err = auth.bootstrapBrokers(out)
+	if err != nil {
+		return "", nil, err
 	}
-	topic := conf.Name
-	topicDetail := &sarama.TopicDetail{}
-	topicDetail.NumPartitions = int32(conf.NumOfPartitions)
-	topicDetail.ReplicationFactor = int16(conf.ReplicationFactor)
-	topicDetail.ConfigEntries = make(map[string]*string)
+	brokerList := sortedBrokers(brokers)
+	brokers = strings.Join(brokerList, ",")
+	log.Printf("Bootstrap broker connection string is : %s", brokers)
 
-	topicDetails := make(map[string]*sarama.TopicDetail)
-	topicDetails[topic] = topicDetail
-
-	request := sarama.CreateTopicsRequest{
-		Timeout:      time.Second * 15,
-		TopicDetails: topicDetails,
+	zookeepers, err := mskapi.zookeeperConString(ctx, clusterArn)
+	if err != nil {
+		return "", nil, err
 	}
 
-	// Send request to Broker
-	response, err := broker.CreateTopics(&request)
-	// handle errors if any
+	zookeeperList, err := resolveHosts(zookeepers)
 	if err != nil {
-		return fmt.Errorf("error occurred while creating the topic %s: %v", conf.Name, err)
+		return "", nil, err
 	}
+	zk := strings.Join(zookeeperList, ",")
 
-	//some specific errors are captured as TopicErrors object even though CreateTopics operation didn't result in an error.
-	t := response.TopicErrors
-	for _, val := range t {
-		if val.Err == NOT_CONTROLLER {
-			return nil
-		} else if val.Err == TOPIC_ALREADY_EXISTS {
-			log.Printf("Topic %s already exits.\n", conf.Name)
-			k.BrokerController = brokerConn
-			return nil
-		} else if val.Err == SUCCESS {
-			log.Printf("Topic %s created successfully.\n", conf.Name)
-			k.BrokerController = brokerConn
-			return nil
-		} else {
-			return fmt.Errorf("Error occurred while creating the topic. Error code : %v. Check kafka error codes at https://kafka.apache.org/protocol#protocol_error_codes", val.Err)
-		}
-	}
+	data = brokerAttributes(out)
+	data["Brokers"] = brokerUrls(brokerList, auth.protocol())
+	data["Zookeepers"] = zk //  resolves a list of names such as z-3.kafka-stg.e30w3f.c3.kafka.us-west-2.amazonaws.com:2181 to a list of IPs without ports
+	data["ZoneName"] = zoneName
+	return brokers, data, nil
+}
+
+//smoke tests the cluster with a message keyed after the request, adding the outcome to the response data.
+func runSmokeTest(ctx context.Context, admin sarama.ClusterAdmin, brokers string, config *sarama.Config, settings smokeTestSettings, requestId string, data map[string]interface{}) error {
 
-	// close connection to broker
-	defer broker.Close()
+	err := createSmokeTestTopic(admin, settings.Topic)
+	if err != nil {
+		return err
+	}
+	result, err := smokeTest(ctx, strings.Split(brokers, ","), config, settings.Topic, "smoketest-"+requestId, settings.Timeout)
+	if err != nil {
+		return err
+	}
+	data["SmokeTestLatencyMs"] = strconv.FormatInt(result.Latency.Milliseconds(), 10)
+	data["SmokeTestTopic"] = settings.Topic
 	return nil
 }
 
-func createTopics(ctx context.Context, event cfn.Event) (physicalResourceId string, data map[string]interface{}, err error) {
+//Create creates the topics on the kafka cluster and returns the broker and zookeeper connection details.
+func (h Handler) Create(ctx context.Context, event cfn.Event) (physicalResourceId string, data map[string]interface{}, err error) {
 
 	log.Println("Initializing...")
-	log.Println("Lambda function should be in private subnet with NAT translation to access AWS private resources or else the lambda will fail.")
-	sess := session.Must(session.NewSession()) //aws session
-	r53api := R53client{Client: r53.New(sess)} //r53 client
-	mskapi := MSKclient{Client: msk.New(sess)} //msk client
-	var kafkaApi Kafka                         //apache kafka client
-	var listOfTopics []kafkaTopicConfig
+	log.Println("CREATE: starting the create operation for topics")
+	log.Printf("event data :%+v\n", event)
 
-	clusterArn := event.ResourceProperties["ClusterArn"].(string)
-	zoneId := event.ResourceProperties["HostedZone"].(string)
+	listOfTopics := topicList(event.ResourceProperties)
+	log.Printf("list of topics : %+v", listOfTopics)
+	err = validateTopicList(ctx, event, listOfTopics)
+	if err != nil {
+		return "", nil, err
+	}
+	settings, rebalancing, err := rebalance(event.ResourceProperties, listOfTopics)
+	if err != nil {
+		return "", nil, err
+	}
+	smoke, smokeTesting, err := smokeTestProperty(event.ResourceProperties)
+	if err != nil {
+		return "", nil, err
+	}
+	quotas, err := clientQuotas(event.ResourceProperties)
+	if err != nil {
+		return "", nil, err
+	}
+	auth, err := authentication(event.ResourceProperties)
+	if err != nil {
+		return "", nil, err
+	}
 
-	switch event.RequestType {
+	brokers, data, err := clusterDetails(ctx, event, auth)
+	if err != nil {
+		return "", nil, err
+	}
 
-	case cfn.RequestCreate:
+	version := KafkaProtocolVersion
+	if rebalancing {
+		version = rebalanceProtocolVersion
+	}
+	if quotas != nil {
+		version = quotaProtocolVersion
+	}
+	config, err := clientConfig(ctx, auth, version)
+	if err != nil {
+		return "", nil, err
+	}
+	admin, err := newClusterAdmin(brokers, config)
+	if err != nil {
+		return "", nil, err
+	}
+	defer admin.Close()
 
-		log.Println("CREATE: starting the create operation for topics")
-		log.Printf("event data :%+v\n", event)
-		log.Printf("MSKClusterArn: %s. Route53HostedZone : %s", clusterArn, zoneId)
+	err = createTopics(admin, listOfTopics) //create topics here
+	if err != nil {
+		return "", nil, err
+	}
 
-		zoneName, err := r53api.recordSet(ctx, zoneId)
+	if rebalancing {
+		reassigned, err := rebalanceTopics(ctx, admin, settings)
 		if err != nil {
 			return "", nil, err
 		}
+		data["ReassignedPartitions"] = strconv.Itoa(reassigned)
+	}
 
-		brokers, err := mskapi.brokerConString(ctx, clusterArn)
+	if quotas != nil {
+		altered, err := reconcileQuotas(admin, quotas, nil)
 		if err != nil {
 			return "", nil, err
 		}
+		data["AlteredClientQuotas"] = strconv.Itoa(altered)
+	}
 
-		var brokerListSsl string
-		brokerList := strings.Split(brokers, ",")
-		for i, broker := range brokerList {
-			if i == len(brokerList)-1 {
-				brokerListSsl = brokerListSsl + "SSL://" + broker
-			} else {
-				brokerListSsl = "SSL://" + broker + "," + brokerListSsl
-			}
-		}
-
-		zookeepers, err := mskapi.zookeeperConString(ctx, clusterArn)
+	if smokeTesting {
+		err = runSmokeTest(ctx, admin, brokers, config, smoke, event.RequestID, data)
 		if err != nil {
 			return "", nil, err
 		}
+	}
 
-		var zookeeperList []string
-		zkList := strings.Split(strings.ReplaceAll(zookeepers, ":2181", ""), ",")
-		for _, zk := range zkList {
-			ips, _ := net.LookupIP(zk)
-			for _, ip := range ips {
-				zookeeperList = append(zookeeperList, ip.String())
-			}
-		}
-		zk := strings.Join(zookeeperList, ",")
-
-		if topics, ok := event.ResourceProperties["TopicList"].([]interface{}); ok {
-			for _, topic := range topics {
-				var thisTopic kafkaTopicConfig
-				thisTopic.Name = topic.(map[string]interface{})["Name"].(string)
-				replicationFactorStr := topic.(map[string]interface{})["ReplicationFactor"].(string)
-				thisTopic.ReplicationFactor, _ = strconv.Atoi(replicationFactorStr)
-				numOfPartitionsStr := topic.(map[string]interface{})["NumOfPartitions"].(string)
-				thisTopic.NumOfPartitions, _ = strconv.Atoi(numOfPartitionsStr)
-				listOfTopics = append(listOfTopics, thisTopic)
-			}
-		}
+	err = updateRecords(ctx, event, brokers, data)
+	if err != nil {
+		return "", nil, err
+	}
+
+	log.Printf("data to be returned is :%v\n", data)
+	return "", data, nil
+}
+
+//Update reconciles the topics on the kafka cluster with the TopicList. New topics are created, partitions are added,
+//topic configs are altered and topics removed from the TopicList are deleted if AllowTopicDeletion is set.
+//Partitions cannot be decreased and changing the replication factor is not supported. Client quotas are set as the
+//ClientQuotas say, removing the ones dropped from them.
+func (h Handler) Update(ctx context.Context, event cfn.Event) (physicalResourceId string, data map[string]interface{}, err error) {
 
-		log.Printf("list of topics : %+v", listOfTopics)
-		kafkaApi.Topics = listOfTopics
-		kafkaApi.BrokerConn = brokers
+	log.Println("UPDATE: starting the update operation on topics now.")
+	log.Printf("event data : %+v\n", event)
 
-		err = kafkaApi.createTopic(ctx) //create topics here
+	topics := topicList(event.ResourceProperties)
+	err = validateTopicList(ctx, event, topics)
+	if err != nil {
+		return "", nil, err
+	}
+	settings, rebalancing, err := rebalance(event.ResourceProperties, topics)
+	if err != nil {
+		return "", nil, err
+	}
+	smoke, smokeTesting, err := smokeTestProperty(event.ResourceProperties)
+	if err != nil {
+		return "", nil, err
+	}
+	quotas, err := clientQuotas(event.ResourceProperties)
+	if err != nil {
+		return "", nil, err
+	}
+	previousQuotas, _ := clientQuotas(event.OldResourceProperties)
+	auth, err := authentication(event.ResourceProperties)
+	if err != nil {
+		return "", nil, err
+	}
+
+	brokers, data, err := clusterDetails(ctx, event, auth)
+	if err != nil {
+		return "", nil, err
+	}
+
+	version := KafkaProtocolVersion
+	if managesTopicConfigs(topics, topicList(event.OldResourceProperties)) {
+		version = topicConfigProtocolVersion
+	}
+	if rebalancing {
+		version = rebalanceProtocolVersion
+	}
+	if quotas != nil || previousQuotas != nil {
+		version = quotaProtocolVersion
+	}
+	config, err := clientConfig(ctx, auth, version)
+	if err != nil {
+		return "", nil, err
+	}
+	admin, err := newClusterAdmin(brokers, config)
+	if err != nil {
+		return "", nil, err
+	}
+	defer admin.Close()
+
+	err = reconcileTopics(admin, topics, topicList(event.OldResourceProperties), flagProperty(event.ResourceProperties, "AllowTopicDeletion"))
+	if err != nil {
+		return "", nil, err
+	}
+
+	if rebalancing {
+		reassigned, err := rebalanceTopics(ctx, admin, settings)
 		if err != nil {
 			return "", nil, err
 		}
+		data["ReassignedPartitions"] = strconv.Itoa(reassigned)
+	}
 
-		data = map[string]interface{}{
-			"Brokers":    brokerListSsl,
-			"Zookeepers": zk, //  resolves a list of names such as z-3.kafka-stg.e30w3f.c3.kafka.us-west-2.amazonaws.com:2181 to a list of IPs without ports
-			"ZoneName":   zoneName,
+	if quotas != nil || previousQuotas != nil {
+		altered, err := reconcileQuotas(admin, quotas, previousQuotas)
+		if err != nil {
+			return "", nil, err
 		}
+		data["AlteredClientQuotas"] = strconv.Itoa(altered)
+	}
 
-		log.Printf("data to be returned is :%v\n", data)
-		return "", data, nil
-
-	case cfn.RequestUpdate:
+	if smokeTesting {
+		err = runSmokeTest(ctx, admin, brokers, config, smoke, event.RequestID, data)
+		if err != nil {
+			return "", nil, err
+		}
+	}
 
-		log.Println("UPDATE: starting the update operation on topics now.")
-		log.Printf("event data : %+v\n", event)
+	err = updateRecords(ctx, event, brokers, data)
+	if err != nil {
+		return "", nil, err
+	}
 
-		//update operation doesn't cater to changing the replication factor or num of partitions, since sarama doesn't support it.
-		event.RequestType = cfn.RequestCreate
-		return createTopics(ctx, event) //calling create operation again. 'sarama' create operations are idempotent it seems.
+	log.Printf("data to be returned is :%v\n", data)
+	return event.PhysicalResourceID, data, nil
+}
 
-	case cfn.RequestDelete:
+//Delete removes the broker and zookeeper records along with the client quotas and leaves the topics alone.
+func (h Handler) Delete(ctx context.Context, event cfn.Event) (physicalResourceId string, data map[string]interface{}, err error) {
 
-		log.Printf("event data :%+v\n", event)
+	log.Printf("event data :%+v\n", event)
 
-		//Delete operation of the stack would delete the cluster itself including all the topics in it. Hence this operation
-		//doesn't make sense.
-		log.Println("DELETE: skipping deleting the topics. Delete a cfn stack would delete the msk cluster anyway including all topics in it")
+	//Delete operation of the stack would delete the cluster itself including all the topics in it. Hence this operation
+	//doesn't make sense.
+	log.Println("DELETE: skipping deleting the topics. Delete a cfn stack would delete the msk cluster anyway including all topics in it")
 
-		return
+	err = removeRecords(ctx, event.ResourceProperties)
+	if err != nil {
+		return "", nil, err
 	}
 
+	quotas, err := clientQuotas(event.ResourceProperties)
+	if err != nil {
+		log.Printf("skipping removing the client quotas : %v", err)
+		return "", nil, nil
+	}
+	if quotas != nil {
+		err = removeQuotas(ctx, event.ResourceProperties, quotas)
+		if err != nil {
+			return "", nil, err
+		}
+	}
 	return
 }
-
-//this lambda function accepts inputs under ResourceProperties as shown below.
-//{
-// "ClusterArn":"arn:aws:kafka:us-west-2:508718283261:configuration/krunal1/25815693-f755-47f3-873b-aaeb92dc25d8-3",
-// "HostedZone":"ADf2er223324"
-// "TopicList":[
-//				{
-//					"Name":"MySampleTopic"
-//					"ReplicationFactor":"3"
-//					"NumOfPartitions":"1"
-//				},
-//				...
-//				]
-//}
-
-//The lambda returns the following response (sample output)
-// {
-//		"Brokers":"SSL://b-2.mm.rora1l.c3.kafka.us-west-2.amazonaws.com:9094,SSL://b-3.mm.rora1l.c3.kafka.us-west-2.amazonaws.com:9094,SSL://b-1.mm.rora1l.c3.kafka.us-west-2.amazonaws.com:9094",
-//      "Zookeepers":"10.133.33.244,10.133.34.68,10.133.32.160",
-//		"ZoneName": "mydomain.example.com"
-//}
-func main() {
-	lambda.Start(cfn.LambdaWrap(createTopics))
-}
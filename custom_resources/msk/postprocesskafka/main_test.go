@@ -1,8 +1,9 @@
-package main
+package postprocesskafka
 
 import (
 	"context"
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/kafka"
@@ -23,20 +24,31 @@ var (
 
 type mockRoute53 struct {
 	route53iface.Route53API
-	hzResp route53.GetHostedZoneOutput
+	hzResp  route53.GetHostedZoneOutput
+	hzErr   error
+	records []*route53.ResourceRecordSet //record sets of the zone, sorted by name
+	changes []*route53.ChangeBatch
+	waited  []string
 }
 
 type mockMSK struct {
 	kafkaiface.KafkaAPI
 	bsResp kafka.GetBootstrapBrokersOutput
+	bsErr  error
 	clResp kafka.DescribeClusterOutput
 }
 
 func (m *mockRoute53) GetHostedZoneWithContext(aws.Context, *route53.GetHostedZoneInput, ...request.Option) (*route53.GetHostedZoneOutput, error) {
+	if m.hzErr != nil {
+		return nil, m.hzErr
+	}
 	return &m.hzResp, nil
 }
 
 func (m *mockMSK) GetBootstrapBrokersWithContext(aws.Context, *kafka.GetBootstrapBrokersInput, ...request.Option) (*kafka.GetBootstrapBrokersOutput, error) {
+	if m.bsErr != nil {
+		return nil, m.bsErr
+	}
 	return &m.bsResp, nil
 }
 
@@ -73,25 +85,40 @@ func Test_MockGetRecodSet(t *testing.T) {
 }
 
 func Test_MockBrokerConString(t *testing.T) {
+	resp := kafka.GetBootstrapBrokersOutput{
+		BootstrapBrokerString:          aws.String("10.1.1.1:9092"),
+		BootstrapBrokerStringTls:       aws.String("10.1.1.1:9094"),
+		BootstrapBrokerStringSaslScram: aws.String("10.1.1.1:9096"),
+	}
 	cases := []struct {
 		Resp     kafka.GetBootstrapBrokersOutput
+		Auth     string
 		Expected string
+		Err      bool
 	}{
-		{
-			Resp: kafka.GetBootstrapBrokersOutput{
-				BootstrapBrokerStringTls: aws.String("10.1.1.1:9094"),
-			},
-			Expected: "10.1.1.1:9094",
-		},
+		{Resp: resp, Auth: authTLS, Expected: "10.1.1.1:9094"},
+		{Resp: resp, Auth: authMTLS, Expected: "10.1.1.1:9094"},
+		{Resp: resp, Auth: authScram, Expected: "10.1.1.1:9096"},
+		{Resp: resp, Auth: authPlaintext, Expected: "10.1.1.1:9092"},
+		//a cluster without SASL/SCRAM client authentication
+		{Resp: kafka.GetBootstrapBrokersOutput{BootstrapBrokerStringTls: aws.String("10.1.1.1:9094")}, Auth: authScram, Err: true},
 	}
 
 	for _, c := range cases {
 		mskApi := MSKclient{Client: &mockMSK{bsResp: c.Resp}}
-		out, err := mskApi.brokerConString(context.Background(), "dummyArn")
+		out, err := mskApi.brokerConString(context.Background(), "dummyArn", kafkaAuth{Mode: c.Auth})
+		if c.Err {
+			assert.NotNil(t, err, c.Auth)
+			continue
+		}
 		assert.Nil(t, err)
 		t.Log("MockBrokerConString: ", out)
 		assert.Equal(t, c.Expected, out)
 	}
+
+	mskApi := MSKclient{Client: &mockMSK{bsErr: awserr.New(kafka.ErrCodeNotFoundException, "cluster not found", nil)}}
+	_, err := mskApi.brokerConString(context.Background(), "dummyArn", kafkaAuth{Mode: authTLS})
+	assert.Equal(t, ErrClusterNotFound, err)
 }
 
 func Test_MockZookeerConString(t *testing.T) {
@@ -127,7 +154,7 @@ func TestAwsMethods(t *testing.T) {
 	mskapi := MSKclient{Client: kafka.New(sess)}
 	ctx := context.Background()
 	t.Run("brokerlist", func(t *testing.T) {
-		brokers, err := mskapi.brokerConString(ctx, clusterArn)
+		brokers, err := mskapi.brokerConString(ctx, clusterArn, kafkaAuth{Mode: authTLS})
 		t.Log(brokers)
 		assert.Nil(t, err)
 		assert.NotZero(t, brokers)
@@ -0,0 +1,259 @@
+package postprocesskafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Shopify/sarama"
+	"github.com/aws/aws-sdk-go/aws/session"
+	msk "github.com/aws/aws-sdk-go/service/kafka"
+	"log"
+	"strconv"
+	"strings"
+)
+
+//describing and altering client quotas needs kafka 2.6 or newer.
+var quotaProtocolVersion = sarama.V2_6_0_0
+
+//client quota configs of kafka managed by the handler.
+const (
+	quotaProducerByteRate  = "producer_byte_rate"
+	quotaConsumerByteRate  = "consumer_byte_rate"
+	quotaRequestPercentage = "request_percentage"
+)
+
+//the properties of a ClientQuotas entry along with the quota config each of them sets.
+var quotaProperties = []struct {
+	Name   string
+	Config string
+}{
+	{Name: "ProducerByteRate", Config: quotaProducerByteRate},
+	{Name: "ConsumerByteRate", Config: quotaConsumerByteRate},
+	{Name: "RequestPercentage", Config: quotaRequestPercentage},
+}
+
+//the quotas of a user, a client id or a client id of a user, read from the ClientQuotas property.
+type clientQuota struct {
+	User     string             //the principal name e.g. the SCRAM username or the distinguished name of a client certificate
+	ClientId string             //the client.id of the clients
+	Values   map[string]float64 //quota config e.g. producer_byte_rate to its value
+}
+
+//identifies the entity of the quota, e.g. user=app,client-id=producer.
+func (q clientQuota) key() string {
+	var parts []string
+	if q.User != "" {
+		parts = append(parts, string(sarama.QuotaEntityUser)+"="+q.User)
+	}
+	if q.ClientId != "" {
+		parts = append(parts, string(sarama.QuotaEntityClientID)+"="+q.ClientId)
+	}
+	return strings.Join(parts, ",")
+}
+
+//the entity of the quota as kafka knows it.
+func (q clientQuota) entity() []sarama.QuotaEntityComponent {
+	var entity []sarama.QuotaEntityComponent
+	if q.User != "" {
+		entity = append(entity, sarama.QuotaEntityComponent{EntityType: sarama.QuotaEntityUser, MatchType: sarama.QuotaMatchExact, Name: q.User})
+	}
+	if q.ClientId != "" {
+		entity = append(entity, sarama.QuotaEntityComponent{EntityType: sarama.QuotaEntityClientID, MatchType: sarama.QuotaMatchExact, Name: q.ClientId})
+	}
+	return entity
+}
+
+//a quota config to set or remove on an entity.
+type quotaChange struct {
+	Quota clientQuota
+	Op    sarama.ClientQuotasOp
+}
+
+//reads the ClientQuotas property. Every entry names a User, a ClientId or both and sets at least one of the
+//ProducerByteRate, ConsumerByteRate (bytes/sec) and RequestPercentage quotas. All invalid entries are reported at once.
+func clientQuotas(properties map[string]interface{}) ([]clientQuota, error) {
+	list, ok := properties["ClientQuotas"].([]interface{})
+	if !ok {
+		return nil, nil
+	}
+
+	var quotas []clientQuota
+	var errs []string
+	seen := make(map[string]bool)
+	for i, item := range list {
+		entry, _ := item.(map[string]interface{})
+		q := clientQuota{Values: make(map[string]float64)}
+		q.User, _ = entry["User"].(string)
+		q.ClientId, _ = entry["ClientId"].(string)
+		if q.key() == "" {
+			errs = append(errs, fmt.Sprintf("entry %d: a User or a ClientId is required", i))
+			continue
+		}
+		if seen[q.key()] {
+			errs = append(errs, fmt.Sprintf("%s: declared more than once", q.key()))
+			continue
+		}
+		seen[q.key()] = true
+
+		for _, p := range quotaProperties {
+			value, ok := entry[p.Name]
+			if !ok {
+				continue
+			}
+			f, err := strconv.ParseFloat(fmt.Sprint(value), 64)
+			if err != nil || f <= 0 {
+				errs = append(errs, fmt.Sprintf("%s: %s must be a positive number, got %v", q.key(), p.Name, value))
+				continue
+			}
+			q.Values[p.Config] = f
+		}
+		if len(q.Values) == 0 {
+			errs = append(errs, fmt.Sprintf("%s: at least one of ProducerByteRate, ConsumerByteRate and RequestPercentage is required", q.key()))
+			continue
+		}
+		quotas = append(quotas, q)
+	}
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("invalid ClientQuotas: %s", strings.Join(errs, "; "))
+	}
+	return quotas, nil
+}
+
+//describes the quotas of all the users and client ids on the cluster by entity key. Default quotas are left out.
+func existingQuotas(admin sarama.ClusterAdmin) (map[string]map[string]float64, error) {
+
+	entries, err := admin.DescribeClientQuotas(nil, false)
+	if err != nil {
+		return nil, fmt.Errorf("unable to describe the client quotas : %v", err)
+	}
+
+	existing := make(map[string]map[string]float64)
+	for _, entry := range entries {
+		var q clientQuota
+		exact := true
+		for _, c := range entry.Entity {
+			if c.MatchType != sarama.QuotaMatchExact {
+				exact = false
+			}
+			switch c.EntityType {
+			case sarama.QuotaEntityUser:
+				q.User = c.Name
+			case sarama.QuotaEntityClientID:
+				q.ClientId = c.Name
+			default:
+				exact = false
+			}
+		}
+		if exact && q.key() != "" {
+			existing[q.key()] = entry.Values
+		}
+	}
+	return existing, nil
+}
+
+//works out the quota configs to set for the desired quotas to hold. The quota configs a desired entity does not set
+//any more, and those of the previous entities that are not desired any more, are removed. Quota configs nobody declared
+//are left alone.
+func planQuotas(existing map[string]map[string]float64, desired []clientQuota, previous []clientQuota) []quotaChange {
+
+	var changes []quotaChange
+	wanted := make(map[string]bool)
+	for _, q := range desired {
+		wanted[q.key()] = true
+		current := existing[q.key()]
+		for _, p := range quotaProperties {
+			value, set := q.Values[p.Config]
+			old, exists := current[p.Config]
+			switch {
+			case set && (!exists || old != value):
+				changes = append(changes, quotaChange{Quota: q, Op: sarama.ClientQuotasOp{Key: p.Config, Value: value}})
+			case !set && exists:
+				changes = append(changes, quotaChange{Quota: q, Op: sarama.ClientQuotasOp{Key: p.Config, Remove: true}})
+			}
+		}
+	}
+
+	for _, q := range previous {
+		if wanted[q.key()] {
+			continue
+		}
+		current := existing[q.key()]
+		for _, p := range quotaProperties {
+			if _, declared := q.Values[p.Config]; !declared {
+				continue
+			}
+			if _, exists := current[p.Config]; exists {
+				changes = append(changes, quotaChange{Quota: q, Op: sarama.ClientQuotasOp{Key: p.Config, Remove: true}})
+			}
+		}
+	}
+	return changes
+}
+
+//reconciles the client quotas on the cluster with the desired ones, removing the previous ones that are not desired any
+//more. Returns the number of quota configs set or removed.
+func reconcileQuotas(admin sarama.ClusterAdmin, desired []clientQuota, previous []clientQuota) (int, error) {
+
+	existing, err := existingQuotas(admin)
+	if err != nil {
+		return 0, err
+	}
+
+	changes := planQuotas(existing, desired, previous)
+	for _, c := range changes {
+		err = admin.AlterClientQuotas(c.Quota.entity(), c.Op, false)
+		if err != nil {
+			if c.Op.Remove {
+				return 0, fmt.Errorf("unable to remove the %s quota of %s : %v", c.Op.Key, c.Quota.key(), err)
+			}
+			return 0, fmt.Errorf("unable to set the %s quota of %s to %v : %v", c.Op.Key, c.Quota.key(), c.Op.Value, err)
+		}
+		if c.Op.Remove {
+			log.Printf("removed the %s quota of %s", c.Op.Key, c.Quota.key())
+		} else {
+			log.Printf("set the %s quota of %s to %v", c.Op.Key, c.Quota.key(), c.Op.Value)
+		}
+	}
+	return len(changes), nil
+}
+
+//removes the declared client quotas from the cluster. There is nothing to remove once the cluster is gone.
+func removeQuotas(ctx context.Context, properties map[string]interface{}, quotas []clientQuota) error {
+
+	auth, err := authentication(properties)
+	if err != nil {
+		return err
+	}
+	sess, err := session.NewSession()
+	if err != nil {
+		return fmt.Errorf("unable to create a new session: %v", err)
+	}
+	mskapi := MSKclient{Client: msk.New(sess)}
+
+	clusterArn, _ := properties["ClusterArn"].(string)
+	brokers, err := mskapi.brokerConString(ctx, clusterArn, auth)
+	if err != nil {
+		if errors.Is(err, ErrClusterNotFound) {
+			log.Printf("cluster %s not found, no client quotas to remove", clusterArn)
+			return nil
+		}
+		return err
+	}
+
+	config, err := clientConfig(ctx, auth, quotaProtocolVersion)
+	if err != nil {
+		return err
+	}
+	admin, err := newClusterAdmin(brokers, config)
+	if err != nil {
+		return err
+	}
+	defer admin.Close()
+
+	removed, err := reconcileQuotas(admin, nil, quotas)
+	if err != nil {
+		return err
+	}
+	log.Printf("removed %d client quota configs", removed)
+	return nil
+}
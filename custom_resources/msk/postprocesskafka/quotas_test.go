@@ -0,0 +1,203 @@
+package postprocesskafka
+
+import (
+	"github.com/Shopify/sarama"
+	"github.com/tj/assert"
+	"testing"
+)
+
+func Test_ClientQuotas(t *testing.T) {
+	quotas, err := clientQuotas(map[string]interface{}{})
+	assert.Nil(t, err)
+	assert.Nil(t, quotas)
+
+	quotas, err = clientQuotas(map[string]interface{}{"ClientQuotas": []interface{}{
+		map[string]interface{}{"User": "app", "ProducerByteRate": "1048576", "RequestPercentage": "150.5"},
+		map[string]interface{}{"User": "app", "ClientId": "app-consumer", "ConsumerByteRate": "2097152"},
+	}})
+	assert.Nil(t, err)
+	assert.Equal(t, []clientQuota{
+		{User: "app", Values: map[string]float64{quotaProducerByteRate: 1048576, quotaRequestPercentage: 150.5}},
+		{User: "app", ClientId: "app-consumer", Values: map[string]float64{quotaConsumerByteRate: 2097152}},
+	}, quotas)
+	assert.Equal(t, "user=app,client-id=app-consumer", quotas[1].key())
+
+	_, err = clientQuotas(map[string]interface{}{"ClientQuotas": []interface{}{
+		map[string]interface{}{"ProducerByteRate": "1048576"},
+		map[string]interface{}{"ClientId": "app", "ProducerByteRate": "-1"},
+		map[string]interface{}{"ClientId": "other"},
+		map[string]interface{}{"ClientId": "dup", "ProducerByteRate": "1"},
+		map[string]interface{}{"ClientId": "dup", "ProducerByteRate": "2"},
+	}})
+	assert.NotNil(t, err)
+	assert.Equal(t, "invalid ClientQuotas: entry 0: a User or a ClientId is required; "+
+		"client-id=app: ProducerByteRate must be a positive number, got -1; "+
+		"client-id=app: at least one of ProducerByteRate, ConsumerByteRate and RequestPercentage is required; "+
+		"client-id=other: at least one of ProducerByteRate, ConsumerByteRate and RequestPercentage is required; "+
+		"client-id=dup: declared more than once", err.Error())
+}
+
+func Test_PlanQuotas(t *testing.T) {
+	app := clientQuota{User: "app", Values: map[string]float64{quotaProducerByteRate: 100}}
+	appBoth := clientQuota{User: "app", Values: map[string]float64{quotaProducerByteRate: 100, quotaConsumerByteRate: 200}}
+	other := clientQuota{ClientId: "other", Values: map[string]float64{quotaRequestPercentage: 50}}
+
+	cases := []struct {
+		Name     string
+		Existing map[string]map[string]float64
+		Desired  []clientQuota
+		Previous []clientQuota
+		Changes  []quotaChange
+	}{
+		{
+			Name:    "new quota",
+			Desired: []clientQuota{app},
+			Changes: []quotaChange{{Quota: app, Op: sarama.ClientQuotasOp{Key: quotaProducerByteRate, Value: 100}}},
+		},
+		{
+			Name:     "unchanged",
+			Existing: map[string]map[string]float64{"user=app": {quotaProducerByteRate: 100}},
+			Desired:  []clientQuota{app},
+			Previous: []clientQuota{app},
+		},
+		{
+			Name:     "value changed",
+			Existing: map[string]map[string]float64{"user=app": {quotaProducerByteRate: 50}},
+			Desired:  []clientQuota{app},
+			Previous: []clientQuota{app},
+			Changes:  []quotaChange{{Quota: app, Op: sarama.ClientQuotasOp{Key: quotaProducerByteRate, Value: 100}}},
+		},
+		{
+			Name:     "quota config dropped",
+			Existing: map[string]map[string]float64{"user=app": {quotaProducerByteRate: 100, quotaConsumerByteRate: 200}},
+			Desired:  []clientQuota{app},
+			Previous: []clientQuota{appBoth},
+			Changes:  []quotaChange{{Quota: app, Op: sarama.ClientQuotasOp{Key: quotaConsumerByteRate, Remove: true}}},
+		},
+		{
+			Name:     "entity dropped",
+			Existing: map[string]map[string]float64{"user=app": {quotaProducerByteRate: 100}, "client-id=other": {quotaRequestPercentage: 50}},
+			Desired:  []clientQuota{app},
+			Previous: []clientQuota{app, other},
+			Changes:  []quotaChange{{Quota: other, Op: sarama.ClientQuotasOp{Key: quotaRequestPercentage, Remove: true}}},
+		},
+		{
+			Name:     "quotas nobody declared are left alone",
+			Existing: map[string]map[string]float64{"client-id=other": {quotaRequestPercentage: 50}},
+			Desired:  []clientQuota{app},
+			Changes:  []quotaChange{{Quota: app, Op: sarama.ClientQuotasOp{Key: quotaProducerByteRate, Value: 100}}},
+		},
+		{
+			Name:     "delete removes what exists",
+			Existing: map[string]map[string]float64{"user=app": {quotaProducerByteRate: 100}},
+			Previous: []clientQuota{appBoth},
+			Changes:  []quotaChange{{Quota: appBoth, Op: sarama.ClientQuotasOp{Key: quotaProducerByteRate, Remove: true}}},
+		},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.Changes, planQuotas(c.Existing, c.Desired, c.Previous), c.Name)
+	}
+}
+
+//a mock kafka cluster of a single broker holding the given client quotas and altering them as told.
+func mockQuotaBroker(t *testing.T, entries []sarama.DescribeClientQuotasEntry, alterErr sarama.KError) *sarama.MockBroker {
+	broker := sarama.NewMockBroker(t, 1)
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"ApiVersionsRequest": sarama.NewMockApiVersionsResponse(t),
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetController(broker.BrokerID()).
+			SetBroker(broker.Addr(), broker.BrokerID()),
+		"DescribeClientQuotasRequest": sarama.NewMockWrapper(&sarama.DescribeClientQuotasResponse{Entries: entries}),
+		"AlterClientQuotasRequest": sarama.NewMockWrapper(&sarama.AlterClientQuotasResponse{
+			Entries: []sarama.AlterClientQuotasEntryResponse{{ErrorCode: alterErr}},
+		}),
+	})
+	return broker
+}
+
+//the quota configs altered on the mock broker.
+func alteredQuotas(broker *sarama.MockBroker) []sarama.AlterClientQuotasEntry {
+	var altered []sarama.AlterClientQuotasEntry
+	for _, rr := range broker.History() {
+		if req, ok := rr.Request.(*sarama.AlterClientQuotasRequest); ok {
+			altered = append(altered, req.Entries...)
+		}
+	}
+	return altered
+}
+
+func Test_MockReconcileQuotas(t *testing.T) {
+	existing := []sarama.DescribeClientQuotasEntry{
+		{
+			Entity: []sarama.QuotaEntityComponent{{EntityType: sarama.QuotaEntityUser, MatchType: sarama.QuotaMatchExact, Name: "app"}},
+			Values: map[string]float64{quotaProducerByteRate: 50},
+		},
+		{
+			Entity: []sarama.QuotaEntityComponent{{EntityType: sarama.QuotaEntityClientID, MatchType: sarama.QuotaMatchExact, Name: "old"}},
+			Values: map[string]float64{quotaConsumerByteRate: 10},
+		},
+		{
+			//the default quota of all users, which is not managed.
+			Entity: []sarama.QuotaEntityComponent{{EntityType: sarama.QuotaEntityUser, MatchType: sarama.QuotaMatchDefault}},
+			Values: map[string]float64{quotaProducerByteRate: 1},
+		},
+	}
+	app := clientQuota{User: "app", Values: map[string]float64{quotaProducerByteRate: 100}}
+	old := clientQuota{ClientId: "old", Values: map[string]float64{quotaConsumerByteRate: 10}}
+
+	cases := []struct {
+		Name     string
+		Desired  []clientQuota
+		Previous []clientQuota
+		AlterErr sarama.KError
+		Altered  []sarama.AlterClientQuotasEntry
+		Err      string
+	}{
+		{
+			Name:     "update",
+			Desired:  []clientQuota{app},
+			Previous: []clientQuota{app, old},
+			Altered: []sarama.AlterClientQuotasEntry{
+				{Entity: app.entity(), Ops: []sarama.ClientQuotasOp{{Key: quotaProducerByteRate, Value: 100}}},
+				{Entity: old.entity(), Ops: []sarama.ClientQuotasOp{{Key: quotaConsumerByteRate, Remove: true}}},
+			},
+		},
+		{
+			Name:     "delete",
+			Previous: []clientQuota{old},
+			Altered: []sarama.AlterClientQuotasEntry{
+				{Entity: old.entity(), Ops: []sarama.ClientQuotasOp{{Key: quotaConsumerByteRate, Remove: true}}},
+			},
+		},
+		{
+			Name:     "not authorized",
+			Desired:  []clientQuota{app},
+			AlterErr: sarama.ErrClusterAuthorizationFailed,
+			Err:      "unable to set the producer_byte_rate quota of user=app to 100",
+		},
+	}
+
+	for _, c := range cases {
+		broker := mockQuotaBroker(t, existing, c.AlterErr)
+		config := sarama.NewConfig()
+		config.Version = quotaProtocolVersion
+		admin, err := sarama.NewClusterAdmin([]string{broker.Addr()}, config)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		altered, err := reconcileQuotas(admin, c.Desired, c.Previous)
+		admin.Close()
+		if c.Err != "" {
+			assert.NotNil(t, err, c.Name)
+			assert.Contains(t, err.Error(), c.Err, c.Name)
+			broker.Close()
+			continue
+		}
+		assert.Nil(t, err, c.Name)
+		assert.Equal(t, len(c.Altered), altered, c.Name)
+		assert.Equal(t, c.Altered, alteredQuotas(broker), c.Name)
+		broker.Close()
+	}
+}
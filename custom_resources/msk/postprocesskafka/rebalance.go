@@ -0,0 +1,471 @@
+package postprocesskafka
+
+import (
+	"context"
+	"fmt"
+	"github.com/Shopify/sarama"
+	"github.com/krunal4amity/cfn-infra/custom_resources/resource"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//partition reassignments need kafka 2.4 or newer, throttling them 2.3 or newer.
+var rebalanceProtocolVersion = sarama.V2_4_0_0
+
+//interval between two checks of the partition reassignments in progress.
+var reassignmentPollInterval = 10 * time.Second
+
+//broker and topic configs throttling the replication of reassigned partitions.
+const (
+	leaderThrottledRate       = "leader.replication.throttled.rate"
+	followerThrottledRate     = "follower.replication.throttled.rate"
+	leaderThrottledReplicas   = "leader.replication.throttled.replicas"
+	followerThrottledReplicas = "follower.replication.throttled.replicas"
+)
+
+//a broker of the cluster along with its rack, the availability zone of the broker on MSK.
+type brokerRack struct {
+	ID   int32
+	Rack string
+}
+
+//the replica assignment of a topic, the replicas of partition i at index i. The first replica of a partition is its
+//preferred leader.
+type assignment [][]int32
+
+//what to rebalance, read from the Rebalance property.
+type rebalanceSettings struct {
+	Topics   []string //sorted names of the topics to rebalance
+	Throttle int64    //replication throttle of each broker in bytes/sec while reassigning, none when 0
+}
+
+//reads the Rebalance property. The topics default to the ones of the TopicList. ok is false without a Rebalance property.
+func rebalance(properties map[string]interface{}, topics []kafkaTopicConfig) (settings rebalanceSettings, ok bool, err error) {
+	r, ok := properties["Rebalance"].(map[string]interface{})
+	if !ok {
+		return rebalanceSettings{}, false, nil
+	}
+
+	if list, ok := r["Topics"].([]interface{}); ok {
+		for _, topic := range list {
+			name, _ := topic.(string)
+			if name == "" {
+				return rebalanceSettings{}, true, fmt.Errorf("invalid Rebalance: topic names must be non empty strings, got %v", topic)
+			}
+			settings.Topics = append(settings.Topics, name)
+		}
+	} else {
+		for _, topic := range topics {
+			settings.Topics = append(settings.Topics, topic.Name)
+		}
+	}
+	if len(settings.Topics) == 0 {
+		return rebalanceSettings{}, true, fmt.Errorf("invalid Rebalance: no topics to rebalance")
+	}
+	sort.Strings(settings.Topics)
+
+	if throttle, ok := r["ThrottleBytesPerSec"]; ok {
+		settings.Throttle, err = strconv.ParseInt(fmt.Sprint(throttle), 10, 64)
+		if err != nil || settings.Throttle < 0 {
+			return rebalanceSettings{}, true, fmt.Errorf("invalid Rebalance: ThrottleBytesPerSec must be a non negative number, got %v", throttle)
+		}
+	}
+	return settings, true, nil
+}
+
+//spreads count items over the brokers, each broker taking count/brokers of them and count%brokers of the brokers one more.
+type quota struct {
+	base  int
+	extra int //brokers that may still take one more than base
+	taken map[int32]int
+}
+
+func newQuota(count, brokers int) *quota {
+	return &quota{base: count / brokers, extra: count % brokers, taken: make(map[int32]int)}
+}
+
+//whether the broker may take one more item.
+func (q *quota) allows(id int32) bool {
+	return q.taken[id] < q.base || (q.taken[id] == q.base && q.extra > 0)
+}
+
+func (q *quota) take(id int32) {
+	if q.taken[id] == q.base {
+		q.extra--
+	}
+	q.taken[id]++
+}
+
+//balances the replicas of a topic over the brokers while moving as few of them as possible. Every broker ends up with
+//an even share of the replicas and of the preferred leaders, and the replicas of a partition are spread over as many racks
+//as possible. Replicas are kept on their broker as long as that holds, so a balanced assignment comes back unchanged.
+//load holds the replicas of each broker over the topics balanced before, so that the uneven leftovers of the topics do not
+//pile up on the same brokers. It is updated with the returned assignment.
+func balancedAssignment(brokers []brokerRack, current assignment, load map[int32]int) (assignment, error) {
+	if len(brokers) == 0 {
+		return nil, fmt.Errorf("no brokers to assign replicas to")
+	}
+
+	//brokers without a rack count as racks of their own.
+	rack := make(map[int32]string)
+	racks := make(map[string]bool)
+	ids := make([]int32, 0, len(brokers))
+	for _, b := range brokers {
+		r := b.Rack
+		if r == "" {
+			r = fmt.Sprintf("broker-%d", b.ID)
+		}
+		rack[b.ID] = r
+		racks[r] = true
+		ids = append(ids, b.ID)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	var total int
+	for p, replicas := range current {
+		if len(replicas) > len(brokers) {
+			return nil, fmt.Errorf("partition %d has %d replicas but the cluster only has %d brokers", p, len(replicas), len(brokers))
+		}
+		total += len(replicas)
+	}
+	//the most replicas of a partition a rack may hold for them to be spread over as many racks as possible.
+	perRack := func(replicas int) int {
+		return (replicas + len(racks) - 1) / len(racks)
+	}
+	contains := func(list []int32, id int32) bool {
+		for _, i := range list {
+			if i == id {
+				return true
+			}
+		}
+		return false
+	}
+
+	//keep the replicas that are on a broker still there, within its share and not crowding a rack.
+	replicaQuota := newQuota(total, len(brokers))
+	target := make(assignment, len(current))
+	for p, replicas := range current {
+		used := make(map[string]int)
+		for _, id := range replicas {
+			r, ok := rack[id]
+			if !ok || contains(target[p], id) || used[r] >= perRack(len(replicas)) || !replicaQuota.allows(id) {
+				continue
+			}
+			replicaQuota.take(id)
+			used[r]++
+			target[p] = append(target[p], id)
+		}
+	}
+
+	//fill the partitions up from the least loaded brokers, preferring the racks the partition has the fewest replicas on.
+	//The rack spread and the shares only give way when nothing else is left.
+	for p, replicas := range current {
+		used := make(map[string]int)
+		for _, id := range target[p] {
+			used[rack[id]]++
+		}
+		for len(target[p]) < len(replicas) {
+			var candidates []int32
+			for _, id := range ids {
+				if !contains(target[p], id) {
+					candidates = append(candidates, id)
+				}
+			}
+			key := func(id int32) []int {
+				return []int{
+					boolInt(used[rack[id]] >= perRack(len(replicas))),
+					boolInt(!replicaQuota.allows(id)),
+					used[rack[id]],
+					replicaQuota.taken[id],
+					load[id],
+				}
+			}
+			sort.SliceStable(candidates, func(i, j int) bool { return lessInts(key(candidates[i]), key(candidates[j])) })
+
+			id := candidates[0]
+			replicaQuota.take(id)
+			used[rack[id]]++
+			target[p] = append(target[p], id)
+		}
+	}
+
+	//keep the preferred leaders within their share, then hand out the rest to the replicas with the fewest leaderships.
+	leaderQuota := newQuota(len(current), len(brokers))
+	led := make([]bool, len(current))
+	for p := range target {
+		if len(target[p]) > 0 && len(current[p]) > 0 && target[p][0] == current[p][0] && leaderQuota.allows(target[p][0]) {
+			leaderQuota.take(target[p][0])
+			led[p] = true
+		}
+	}
+	for p, replicas := range target {
+		if led[p] || len(replicas) == 0 {
+			continue
+		}
+		best := 0
+		for i, id := range replicas {
+			if lessInts([]int{boolInt(!leaderQuota.allows(id)), leaderQuota.taken[id]},
+				[]int{boolInt(!leaderQuota.allows(replicas[best])), leaderQuota.taken[replicas[best]]}) {
+				best = i
+			}
+		}
+		leader := replicas[best]
+		leaderQuota.take(leader)
+		target[p] = append([]int32{leader}, append(append([]int32{}, replicas[:best]...), replicas[best+1:]...)...)
+	}
+
+	for _, replicas := range target {
+		for _, id := range replicas {
+			load[id]++
+		}
+	}
+	return target, nil
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+//compares two keys of the same length element by element.
+func lessInts(a, b []int) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return a[i] < b[i]
+		}
+	}
+	return false
+}
+
+//the partitions whose replicas, or their order, differ between the two assignments.
+func changedPartitions(current, target assignment) []int32 {
+	var changed []int32
+	for p := range target {
+		if len(current[p]) != len(target[p]) {
+			changed = append(changed, int32(p))
+			continue
+		}
+		for i := range target[p] {
+			if current[p][i] != target[p][i] {
+				changed = append(changed, int32(p))
+				break
+			}
+		}
+	}
+	return changed
+}
+
+//describes the replica assignment of the topics.
+func currentAssignments(admin sarama.ClusterAdmin, topics []string) (map[string]assignment, error) {
+
+	metadata, err := admin.DescribeTopics(topics)
+	if err != nil {
+		return nil, fmt.Errorf("unable to describe topics %v : %v", topics, err)
+	}
+
+	assignments := make(map[string]assignment)
+	for _, topic := range metadata {
+		if topic.Err != sarama.ErrNoError {
+			return nil, fmt.Errorf("unable to describe topic %s : %v", topic.Name, topic.Err)
+		}
+		current := make(assignment, len(topic.Partitions))
+		for _, partition := range topic.Partitions {
+			if partition.ID < 0 || int(partition.ID) >= len(current) {
+				return nil, fmt.Errorf("topic %s has an unexpected partition %d", topic.Name, partition.ID)
+			}
+			current[partition.ID] = partition.Replicas
+		}
+		assignments[topic.Name] = current
+	}
+	return assignments, nil
+}
+
+//lists the topics with partition reassignments still in progress, along with their number of partitions being reassigned.
+func pendingReassignments(admin sarama.ClusterAdmin, assignments map[string]assignment) ([]string, error) {
+	var pending []string
+	for topic, partitions := range assignments {
+		ids := make([]int32, len(partitions))
+		for p := range partitions {
+			ids[p] = int32(p)
+		}
+		status, err := admin.ListPartitionReassignments(topic, ids)
+		if err != nil {
+			return nil, fmt.Errorf("unable to list partition reassignments of topic %s : %v", topic, err)
+		}
+		if n := len(status[topic]); n > 0 {
+			pending = append(pending, fmt.Sprintf("%s (%d partitions)", topic, n))
+		}
+	}
+	sort.Strings(pending)
+	return pending, nil
+}
+
+//waits for the partition reassignments of the topics to complete. Gives up when the lambda function is about to time
+//out. The reassignments carry on in the cluster regardless.
+func waitForReassignments(ctx context.Context, admin sarama.ClusterAdmin, assignments map[string]assignment) error {
+	for {
+		pending, err := pendingReassignments(admin, assignments)
+		if err != nil {
+			return err
+		}
+		if len(pending) == 0 {
+			return nil
+		}
+		log.Printf("partitions still being reassigned : %s", strings.Join(pending, ", "))
+
+		if err := resource.Sleep(ctx, reassignmentPollInterval); err != nil {
+			return fmt.Errorf("gave up waiting for the partition reassignments of %s, they carry on in the cluster: %v", strings.Join(pending, ", "), err)
+		}
+	}
+}
+
+//removes the replication throttle of the brokers and topics, provided no reassignment of the topics is pending any more:
+//a throttle removed too early lets the reassignments flood the brokers. cause is the error the rebalance ended with if
+//any, which is returned along with the reason a throttle is left in place.
+func removeThrottle(admin sarama.ClusterAdmin, brokers []brokerRack, topics []string, assignments map[string]assignment, cause error) error {
+	withCause := func(msg string) error {
+		if cause == nil {
+			return fmt.Errorf("%s", msg)
+		}
+		return fmt.Errorf("%v. %s", cause, msg)
+	}
+
+	pending, err := pendingReassignments(admin, assignments)
+	if err != nil {
+		return withCause(fmt.Sprintf("the replication throttle is left in place as the reassignments could not be checked: %v", err))
+	}
+	if len(pending) > 0 {
+		return withCause(fmt.Sprintf("the replication throttle is left in place while %s are being reassigned, the next rebalance removes it", strings.Join(pending, ", ")))
+	}
+
+	err = throttleReplication(admin, brokers, topics, "")
+	if err != nil {
+		return withCause(fmt.Sprintf("the replication throttle could not be removed: %v", err))
+	}
+	log.Printf("removed the replication throttle of topics %v", topics)
+	return cause
+}
+
+//sets (or deletes, when rate is empty) the replication throttle of the brokers and of all replicas of the topics.
+func throttleReplication(admin sarama.ClusterAdmin, brokers []brokerRack, topics []string, rate string) error {
+	op := sarama.IncrementalAlterConfigsOperationSet
+	value, all := &rate, "*"
+	replicas := &all
+	if rate == "" {
+		op = sarama.IncrementalAlterConfigsOperationDelete
+		value, replicas = nil, nil
+	}
+
+	for _, b := range brokers {
+		err := admin.IncrementalAlterConfig(sarama.BrokerResource, strconv.Itoa(int(b.ID)), map[string]sarama.IncrementalAlterConfigsEntry{
+			leaderThrottledRate:   {Operation: op, Value: value},
+			followerThrottledRate: {Operation: op, Value: value},
+		}, false)
+		if err != nil {
+			return fmt.Errorf("unable to alter the replication throttle of broker %d : %v", b.ID, err)
+		}
+	}
+	for _, topic := range topics {
+		err := admin.IncrementalAlterConfig(sarama.TopicResource, topic, map[string]sarama.IncrementalAlterConfigsEntry{
+			leaderThrottledReplicas:   {Operation: op, Value: replicas},
+			followerThrottledReplicas: {Operation: op, Value: replicas},
+		}, false)
+		if err != nil {
+			return fmt.Errorf("unable to alter the throttled replicas of topic %s : %v", topic, err)
+		}
+	}
+	return nil
+}
+
+//rebalances the replicas of the topics over all brokers of the cluster, taking their racks into account. Reassignments
+//still in progress are waited for first, then the partitions that are not balanced are reassigned, throttled if asked
+//for, and waited for. The throttle is removed again once no reassignment is pending, including a throttle left in place
+//by a previous rebalance which gave up waiting. Returns the number of partitions reassigned.
+func rebalanceTopics(ctx context.Context, admin sarama.ClusterAdmin, settings rebalanceSettings) (int, error) {
+
+	cluster, _, err := admin.DescribeCluster()
+	if err != nil {
+		return 0, fmt.Errorf("unable to describe the kafka cluster : %v", err)
+	}
+	var brokers []brokerRack
+	for _, b := range cluster {
+		brokers = append(brokers, brokerRack{ID: b.ID(), Rack: b.Rack()})
+	}
+	sort.Slice(brokers, func(i, j int) bool { return brokers[i].ID < brokers[j].ID })
+	log.Printf("rebalancing topics %v over brokers %+v", settings.Topics, brokers)
+
+	current, err := currentAssignments(admin, settings.Topics)
+	if err != nil {
+		return 0, err
+	}
+	//the replicas of a partition being reassigned are in flux.
+	err = waitForReassignments(ctx, admin, current)
+	if err != nil {
+		return 0, err
+	}
+	current, err = currentAssignments(admin, settings.Topics)
+	if err != nil {
+		return 0, err
+	}
+	if settings.Throttle > 0 {
+		err = throttleReplication(admin, brokers, settings.Topics, "")
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	var reassigned int
+	var topics []string
+	targets := make(map[string]assignment)
+	load := make(map[int32]int)
+	for _, topic := range settings.Topics {
+		target, err := balancedAssignment(brokers, current[topic], load)
+		if err != nil {
+			return 0, fmt.Errorf("unable to balance topic %s : %v", topic, err)
+		}
+		if changed := changedPartitions(current[topic], target); len(changed) > 0 {
+			log.Printf("reassigning partitions %v of topic %s from %v to %v", changed, topic, current[topic], target)
+			reassigned += len(changed)
+			topics = append(topics, topic)
+			targets[topic] = target
+		}
+	}
+	if reassigned == 0 {
+		log.Printf("topics %v are balanced already.", settings.Topics)
+		return 0, nil
+	}
+
+	if settings.Throttle > 0 {
+		log.Printf("throttling replication to %d bytes/sec per broker", settings.Throttle)
+		err = throttleReplication(admin, brokers, topics, strconv.FormatInt(settings.Throttle, 10))
+		if err != nil {
+			return 0, removeThrottle(admin, brokers, topics, targets, err)
+		}
+	}
+
+	err = reassignPartitions(ctx, admin, topics, targets)
+	if settings.Throttle > 0 {
+		err = removeThrottle(admin, brokers, topics, targets, err)
+	}
+	if err != nil {
+		return 0, err
+	}
+	log.Printf("reassigned %d partitions of topics %v", reassigned, topics)
+	return reassigned, nil
+}
+
+//reassigns the partitions of the topics to their targets and waits for the reassignments to complete.
+func reassignPartitions(ctx context.Context, admin sarama.ClusterAdmin, topics []string, targets map[string]assignment) error {
+	for _, topic := range topics {
+		err := admin.AlterPartitionReassignments(topic, targets[topic])
+		if err != nil {
+			return fmt.Errorf("unable to reassign partitions of topic %s : %v", topic, err)
+		}
+	}
+	return waitForReassignments(ctx, admin, targets)
+}
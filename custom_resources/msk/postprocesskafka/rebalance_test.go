@@ -0,0 +1,247 @@
+package postprocesskafka
+
+import (
+	"errors"
+	"github.com/Shopify/sarama"
+	"github.com/tj/assert"
+	"testing"
+)
+
+// brokers 1 to n spread over the racks in turn.
+func brokersInRacks(n int, racks ...string) []brokerRack {
+	var brokers []brokerRack
+	for i := 0; i < n; i++ {
+		b := brokerRack{ID: int32(i + 1)}
+		if len(racks) > 0 {
+			b.Rack = racks[i%len(racks)]
+		}
+		brokers = append(brokers, b)
+	}
+	return brokers
+}
+
+// partitions of the given replicas each.
+func partitionsOn(n int, replicas ...int32) assignment {
+	current := make(assignment, n)
+	for p := range current {
+		current[p] = append([]int32{}, replicas...)
+	}
+	return current
+}
+
+func Test_BalancedAssignment(t *testing.T) {
+	cases := []struct {
+		Name     string
+		Brokers  []brokerRack
+		Current  assignment
+		Replicas []int //replicas each broker is to end up with, by broker
+		Leaders  []int //preferred leaderships each broker is to end up with, by broker
+		Kept     int   //replicas expected to stay on their broker
+		Err      string
+	}{
+		{
+			Name:     "brokers added to each rack",
+			Brokers:  brokersInRacks(6, "az1", "az2", "az3"),
+			Current:  partitionsOn(6, 1, 2, 3),
+			Replicas: []int{3, 3, 3, 3, 3, 3},
+			Leaders:  []int{1, 1, 1, 1, 1, 1},
+			Kept:     9,
+		},
+		{
+			Name:     "balanced already",
+			Brokers:  brokersInRacks(3, "az1", "az2", "az3"),
+			Current:  assignment{{1, 2, 3}, {2, 3, 1}, {3, 1, 2}},
+			Replicas: []int{3, 3, 3},
+			Leaders:  []int{1, 1, 1},
+			Kept:     9,
+		},
+		{
+			Name:     "uneven share",
+			Brokers:  brokersInRacks(4, "az1", "az2"),
+			Current:  partitionsOn(3, 1, 2),
+			Replicas: []int{2, 2, 1, 1},
+			Leaders:  []int{1, 1, 1, 0},
+			Kept:     4,
+		},
+		{
+			Name:     "broker gone",
+			Brokers:  brokersInRacks(3),
+			Current:  assignment{{1, 4}, {2, 1}, {3, 2}},
+			Replicas: []int{2, 2, 2},
+			Leaders:  []int{1, 1, 1},
+			Kept:     5,
+		},
+		{
+			Name:    "too few brokers",
+			Brokers: brokersInRacks(2),
+			Current: partitionsOn(1, 1, 2, 3),
+			Err:     "partition 0 has 3 replicas but the cluster only has 2 brokers",
+		},
+	}
+
+	for _, c := range cases {
+		load := make(map[int32]int)
+		target, err := balancedAssignment(c.Brokers, c.Current, load)
+		if c.Err != "" {
+			assert.NotNil(t, err, c.Name)
+			assert.Contains(t, err.Error(), c.Err, c.Name)
+			continue
+		}
+		assert.Nil(t, err, c.Name)
+		if len(target) != len(c.Current) {
+			t.Fatalf("%s: expected %d partitions, got %d", c.Name, len(c.Current), len(target))
+		}
+
+		rack := make(map[int32]string)
+		for _, b := range c.Brokers {
+			rack[b.ID] = b.Rack
+		}
+		replicas := make([]int, len(c.Brokers))
+		leaders := make([]int, len(c.Brokers))
+		var kept int
+		for p := range target {
+			assert.Equal(t, len(c.Current[p]), len(target[p]), c.Name)
+			seen := make(map[int32]bool)
+			racks := make(map[string]bool)
+			for i, id := range target[p] {
+				assert.False(t, seen[id], "%s: broker %d twice in partition %d", c.Name, id, p)
+				seen[id] = true
+				racks[rack[id]] = true
+				replicas[id-1]++
+				if i == 0 {
+					leaders[id-1]++
+				}
+			}
+			for _, id := range c.Current[p] {
+				if seen[id] {
+					kept++
+				}
+			}
+			if c.Brokers[0].Rack != "" {
+				assert.Equal(t, len(target[p]), len(racks), "%s: partition %d not spread over the racks: %v", c.Name, p, target[p])
+			}
+		}
+		assert.Equal(t, c.Replicas, replicas, c.Name)
+		assert.Equal(t, c.Leaders, leaders, c.Name)
+		assert.Equal(t, c.Kept, kept, c.Name)
+		for id, n := range replicas {
+			assert.Equal(t, n, load[int32(id+1)], c.Name)
+		}
+
+		//balancing the result again changes nothing.
+		again, err := balancedAssignment(c.Brokers, target, make(map[int32]int))
+		assert.Nil(t, err, c.Name)
+		assert.Empty(t, changedPartitions(target, again), c.Name)
+	}
+}
+
+func Test_BalancedAssignmentSpreadsLeftovers(t *testing.T) {
+	//a single partition of a single replica per topic lands on another broker for each topic.
+	brokers := brokersInRacks(3)
+	load := make(map[int32]int)
+	var ids []int32
+	for i := 0; i < 3; i++ {
+		target, err := balancedAssignment(brokers, assignment{{4}}, load)
+		assert.Nil(t, err)
+		ids = append(ids, target[0][0])
+	}
+	assert.Equal(t, []int32{1, 2, 3}, ids)
+}
+
+func Test_Rebalance(t *testing.T) {
+	topics := []kafkaTopicConfig{{Name: "b"}, {Name: "a"}}
+
+	_, ok, err := rebalance(map[string]interface{}{}, topics)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	settings, ok, err := rebalance(map[string]interface{}{"Rebalance": map[string]interface{}{}}, topics)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, rebalanceSettings{Topics: []string{"a", "b"}}, settings)
+
+	settings, _, err = rebalance(map[string]interface{}{"Rebalance": map[string]interface{}{
+		"Topics":              []interface{}{"c"},
+		"ThrottleBytesPerSec": "52428800",
+	}}, topics)
+	assert.Nil(t, err)
+	assert.Equal(t, rebalanceSettings{Topics: []string{"c"}, Throttle: 52428800}, settings)
+
+	_, _, err = rebalance(map[string]interface{}{"Rebalance": map[string]interface{}{"ThrottleBytesPerSec": "-1"}}, topics)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "ThrottleBytesPerSec must be a non negative number, got -1")
+
+	_, _, err = rebalance(map[string]interface{}{"Rebalance": map[string]interface{}{"Topics": []interface{}{""}}}, topics)
+	assert.NotNil(t, err)
+
+	_, _, err = rebalance(map[string]interface{}{"Rebalance": map[string]interface{}{}}, nil)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "no topics to rebalance")
+}
+
+func Test_MockRemoveThrottle(t *testing.T) {
+	targets := map[string]assignment{"orders": partitionsOn(2, 1, 2)}
+	cases := []struct {
+		Name    string
+		Pending sarama.MockResponse
+		Cause   error
+		Removed bool
+		Err     string
+	}{
+		{
+			Name:    "done",
+			Pending: sarama.NewMockWrapper(&sarama.ListPartitionReassignmentsResponse{}),
+			Removed: true,
+		},
+		{
+			Name:    "failed without pending reassignments",
+			Pending: sarama.NewMockWrapper(&sarama.ListPartitionReassignmentsResponse{}),
+			Cause:   errors.New("unable to reassign partitions of topic orders"),
+			Removed: true,
+			Err:     "unable to reassign partitions of topic orders",
+		},
+		{
+			Name:    "gave up waiting",
+			Pending: sarama.NewMockListPartitionReassignmentsResponse(t),
+			Cause:   errors.New("gave up waiting for the partition reassignments of orders (2 partitions)"),
+			Err:     "gave up waiting for the partition reassignments of orders (2 partitions). the replication throttle is left in place while orders (2 partitions) are being reassigned",
+		},
+	}
+
+	for _, c := range cases {
+		broker := sarama.NewMockBroker(t, 1)
+		broker.SetHandlerByMap(map[string]sarama.MockResponse{
+			"ApiVersionsRequest": sarama.NewMockApiVersionsResponse(t),
+			"MetadataRequest": sarama.NewMockMetadataResponse(t).
+				SetController(broker.BrokerID()).
+				SetBroker(broker.Addr(), broker.BrokerID()),
+			"ListPartitionReassignmentsRequest": c.Pending,
+			"IncrementalAlterConfigsRequest":    sarama.NewMockIncrementalAlterConfigsResponse(t),
+		})
+		config := sarama.NewConfig()
+		config.Version = rebalanceProtocolVersion
+		admin, err := sarama.NewClusterAdmin([]string{broker.Addr()}, config)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = removeThrottle(admin, []brokerRack{{ID: broker.BrokerID()}}, []string{"orders"}, targets, c.Cause)
+		if c.Err != "" {
+			assert.NotNil(t, err, c.Name)
+			assert.Contains(t, err.Error(), c.Err, c.Name)
+		} else {
+			assert.Nil(t, err, c.Name)
+		}
+
+		var removed bool
+		for _, rr := range broker.History() {
+			if _, ok := rr.Request.(*sarama.IncrementalAlterConfigsRequest); ok {
+				removed = true
+			}
+		}
+		assert.Equal(t, c.Removed, removed, c.Name)
+
+		admin.Close()
+		broker.Close()
+	}
+}
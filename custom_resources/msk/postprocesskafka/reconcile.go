@@ -0,0 +1,271 @@
+package postprocesskafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Shopify/sarama"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/acmpca"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"log"
+	"sort"
+	"strings"
+)
+
+//KafkaProtocolVersion is the kafka protocol version spoken to the cluster. MSK brokers of any newer version understand its
+//requests.
+var KafkaProtocolVersion = sarama.V2_2_0_0
+
+//altering topic configs incrementally needs kafka 2.3 or newer.
+var topicConfigProtocolVersion = sarama.V2_3_0_0
+
+//the client config authenticating as the authentication mode says and speaking the given kafka protocol version.
+func clientConfig(ctx context.Context, auth kafkaAuth, version sarama.KafkaVersion) (*sarama.Config, error) {
+	sess, err := session.NewSession()
+	if err != nil {
+		return nil, fmt.Errorf("unable to create a new session: %v", err)
+	}
+	config, err := auth.saramaConfig(ctx, SMclient{Client: secretsmanager.New(sess)}, PCAclient{Client: acmpca.New(sess)})
+	if err != nil {
+		return nil, err
+	}
+	config.Version = version
+	return config, nil
+}
+
+//creates a cluster admin for the given broker connection string.
+func newClusterAdmin(brokers string, config *sarama.Config) (sarama.ClusterAdmin, error) {
+	admin, err := sarama.NewClusterAdmin(strings.Split(brokers, ","), config)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to the kafka cluster : %v", err)
+	}
+	return admin, nil
+}
+
+//creates the topics on the cluster. The cluster admin sends the requests to the controller it finds through a metadata
+//request, keeping a single connection per broker. A topic that already exists is left as is, any other error fails.
+func createTopics(admin sarama.ClusterAdmin, topics []kafkaTopicConfig) error {
+	for _, topic := range topics {
+		if topic.Name == "" {
+			return fmt.Errorf("An empty string was provided for topic name. Exiting.")
+		}
+
+		err := admin.CreateTopic(topic.Name, topicDetail(topic), false)
+		switch {
+		case err == nil:
+			log.Printf("Topic %s created successfully.\n", topic.Name)
+		case errors.Is(err, sarama.ErrTopicAlreadyExists):
+			log.Printf("Topic %s already exits.\n", topic.Name)
+		default:
+			return fmt.Errorf("error occurred while creating the topic %s: %v", topic.Name, err)
+		}
+	}
+	return nil
+}
+
+//changes to be made to the topics of the cluster so that they match the TopicList.
+type topicPlan struct {
+	create     []kafkaTopicConfig
+	partitions []kafkaTopicConfig //topics to get more partitions
+	configs    []topicConfigChange
+	delete     []string
+}
+
+//the config entries of a topic to be set or deleted.
+type topicConfigChange struct {
+	name    string
+	entries map[string]sarama.IncrementalAlterConfigsEntry
+}
+
+//reconciles the topics of the cluster with the desired ones. Missing topics are created, partitions are added and
+//changed topic configs are applied. Topics in the previous TopicList but not in the desired one are deleted if
+//allowDeletion is set. Nothing is changed if any of the topics would lose partitions, since kafka cannot do that.
+func reconcileTopics(admin sarama.ClusterAdmin, desired []kafkaTopicConfig, previous []kafkaTopicConfig, allowDeletion bool) error {
+
+	existing, err := admin.ListTopics()
+	if err != nil {
+		return fmt.Errorf("unable to list topics : %v", err)
+	}
+
+	plan, err := planTopics(admin, existing, desired, previous, allowDeletion)
+	if err != nil {
+		return err
+	}
+
+	err = createTopics(admin, plan.create)
+	if err != nil {
+		return err
+	}
+
+	for _, topic := range plan.partitions {
+		log.Printf("increasing partitions of topic %s from %d to %d", topic.Name, existing[topic.Name].NumPartitions, topic.NumOfPartitions)
+		err := admin.CreatePartitions(topic.Name, int32(topic.NumOfPartitions), nil, false)
+		if err != nil {
+			return fmt.Errorf("unable to increase partitions of topic %s : %v", topic.Name, err)
+		}
+	}
+
+	for _, change := range plan.configs {
+		log.Printf("altering configs %v of topic %s", configKeys(change.entries), change.name)
+		err := admin.IncrementalAlterConfig(sarama.TopicResource, change.name, change.entries, false)
+		if err != nil {
+			return fmt.Errorf("unable to alter configs of topic %s : %v", change.name, err)
+		}
+	}
+
+	for _, name := range plan.delete {
+		log.Printf("deleting topic %s removed from the TopicList", name)
+		err := admin.DeleteTopic(name)
+		if err != nil && err != sarama.ErrUnknownTopicOrPartition {
+			return fmt.Errorf("unable to delete topic %s : %v", name, err)
+		}
+	}
+	return nil
+}
+
+//works out the changes needed to reconcile the existing topics with the desired ones.
+func planTopics(admin sarama.ClusterAdmin, existing map[string]sarama.TopicDetail, desired []kafkaTopicConfig, previous []kafkaTopicConfig, allowDeletion bool) (topicPlan, error) {
+
+	var plan topicPlan
+
+	previousConfig := make(map[string]map[string]string)
+	for _, topic := range previous {
+		previousConfig[topic.Name] = topic.Config
+	}
+
+	var refused []string
+	wanted := make(map[string]bool)
+	for _, topic := range desired {
+		wanted[topic.Name] = true
+		current, ok := existing[topic.Name]
+		if !ok {
+			plan.create = append(plan.create, topic)
+			continue
+		}
+
+		switch partitions := int32(topic.NumOfPartitions); {
+		case partitions < current.NumPartitions:
+			refused = append(refused, fmt.Sprintf("topic %s has %d partitions and cannot be decreased to %d", topic.Name, current.NumPartitions, partitions))
+		case partitions > current.NumPartitions:
+			plan.partitions = append(plan.partitions, topic)
+		}
+		if int16(topic.ReplicationFactor) != current.ReplicationFactor {
+			log.Printf("topic %s has a replication factor of %d. Changing it to %d is not supported, leaving it as is.", topic.Name, current.ReplicationFactor, topic.ReplicationFactor)
+		}
+
+		//topic configs are only managed for topics that have, or had, a Config in the TopicList.
+		if len(topic.Config) == 0 && len(previousConfig[topic.Name]) == 0 {
+			continue
+		}
+		overrides, err := topicOverrides(admin, topic.Name)
+		if err != nil {
+			return plan, err
+		}
+		if entries := configChanges(overrides, topic.Config); len(entries) > 0 {
+			plan.configs = append(plan.configs, topicConfigChange{name: topic.Name, entries: entries})
+		}
+	}
+
+	if len(refused) > 0 {
+		return plan, fmt.Errorf("refusing to update topics, partitions can only be increased: %s", strings.Join(refused, "; "))
+	}
+
+	for _, topic := range previous {
+		if wanted[topic.Name] {
+			continue
+		}
+		if _, ok := existing[topic.Name]; !ok {
+			continue
+		}
+		if allowDeletion {
+			plan.delete = append(plan.delete, topic.Name)
+		} else {
+			log.Printf("topic %s was removed from the TopicList. Set AllowTopicDeletion to delete it.", topic.Name)
+		}
+	}
+	return plan, nil
+}
+
+//whether topic configs are managed, which they are for topics that have, or had, a Config in the TopicList.
+func managesTopicConfigs(desired []kafkaTopicConfig, previous []kafkaTopicConfig) bool {
+	for _, topics := range [][]kafkaTopicConfig{desired, previous} {
+		for _, topic := range topics {
+			if len(topic.Config) > 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+//describes the configs set on the topic itself, leaving out the broker level and default ones, and the throttled replicas
+//a rebalance sets and removes.
+func topicOverrides(admin sarama.ClusterAdmin, name string) (map[string]string, error) {
+
+	entries, err := admin.DescribeConfig(sarama.ConfigResource{Type: sarama.TopicResource, Name: name})
+	if err != nil {
+		return nil, fmt.Errorf("unable to describe configs of topic %s : %v", name, err)
+	}
+
+	overrides := make(map[string]string)
+	for _, entry := range entries {
+		if throttledReplicas(entry.Name) {
+			continue
+		}
+		if entry.Source == sarama.SourceTopic || (entry.Source == sarama.SourceUnknown && !entry.Default) {
+			overrides[entry.Name] = entry.Value
+		}
+	}
+	return overrides, nil
+}
+
+//the details of a topic to be created.
+func topicDetail(topic kafkaTopicConfig) *sarama.TopicDetail {
+	detail := &sarama.TopicDetail{
+		NumPartitions:     int32(topic.NumOfPartitions),
+		ReplicationFactor: int16(topic.ReplicationFactor),
+		ConfigEntries:     make(map[string]*string),
+	}
+	for key, value := range topic.Config {
+		value := value
+		detail.ConfigEntries[key] = &value
+	}
+	return detail
+}
+
+//the entries altering the topic overrides into the desired config: changed or new keys are set, keys no longer desired
+//are deleted and the rest is left alone. The throttled replicas are left to the rebalance.
+func configChanges(overrides, desired map[string]string) map[string]sarama.IncrementalAlterConfigsEntry {
+	entries := make(map[string]sarama.IncrementalAlterConfigsEntry)
+	for key, value := range desired {
+		if throttledReplicas(key) {
+			continue
+		}
+		if v, ok := overrides[key]; !ok || v != value {
+			value := value
+			entries[key] = sarama.IncrementalAlterConfigsEntry{Operation: sarama.IncrementalAlterConfigsOperationSet, Value: &value}
+		}
+	}
+	for key := range overrides {
+		if _, ok := desired[key]; !ok {
+			entries[key] = sarama.IncrementalAlterConfigsEntry{Operation: sarama.IncrementalAlterConfigsOperationDelete}
+		}
+	}
+	return entries
+}
+
+//whether the topic config is one of the throttled replicas.
+func throttledReplicas(key string) bool {
+	return key == leaderThrottledReplicas || key == followerThrottledReplicas
+}
+
+//the sorted keys of the config entries, for logging.
+func configKeys(entries map[string]sarama.IncrementalAlterConfigsEntry) []string {
+	keys := make([]string, 0, len(entries))
+	for key := range entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
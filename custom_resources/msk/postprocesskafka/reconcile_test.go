@@ -0,0 +1,272 @@
+package postprocesskafka
+
+import (
+	"github.com/Shopify/sarama"
+	"github.com/tj/assert"
+	"testing"
+)
+
+// a mock kafka cluster of a single broker holding the given topics with their partition counts and topic configs.
+func mockCluster(t *testing.T, partitions map[string]int32, configs map[string]map[string]string) (*sarama.MockBroker, sarama.ClusterAdmin) {
+	broker := sarama.NewMockBroker(t, 1)
+
+	metadata := sarama.NewMockMetadataResponse(t).
+		SetController(broker.BrokerID()).
+		SetBroker(broker.Addr(), broker.BrokerID())
+	for topic, count := range partitions {
+		for p := int32(0); p < count; p++ {
+			metadata.SetLeader(topic, p, broker.BrokerID())
+		}
+	}
+
+	describeConfigs := &sarama.DescribeConfigsResponse{Version: 2}
+	for topic := range partitions {
+		resource := &sarama.ResourceResponse{Name: topic, Type: sarama.TopicResource}
+		resource.Configs = append(resource.Configs, &sarama.ConfigEntry{Name: "min.insync.replicas", Value: "2", Source: sarama.SourceStaticBroker})
+		for key, value := range configs[topic] {
+			resource.Configs = append(resource.Configs, &sarama.ConfigEntry{Name: key, Value: value, Source: sarama.SourceTopic})
+		}
+		describeConfigs.Resources = append(describeConfigs.Resources, resource)
+	}
+
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"ApiVersionsRequest":             sarama.NewMockApiVersionsResponse(t),
+		"MetadataRequest":                metadata,
+		"DescribeConfigsRequest":         sarama.NewMockWrapper(describeConfigs),
+		"CreateTopicsRequest":            sarama.NewMockCreateTopicsResponse(t),
+		"CreatePartitionsRequest":        sarama.NewMockCreatePartitionsResponse(t),
+		"IncrementalAlterConfigsRequest": sarama.NewMockIncrementalAlterConfigsResponse(t),
+		"DeleteTopicsRequest":            sarama.NewMockDeleteTopicsResponse(t),
+	})
+
+	config := sarama.NewConfig()
+	config.Version = topicConfigProtocolVersion
+	admin, err := sarama.NewClusterAdmin([]string{broker.Addr()}, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return broker, admin
+}
+
+// the requests of the given kind received by the broker.
+func requests(broker *sarama.MockBroker) (creates []*sarama.CreateTopicsRequest, partitions []*sarama.CreatePartitionsRequest, alters []*sarama.IncrementalAlterConfigsRequest, deletes []*sarama.DeleteTopicsRequest) {
+	for _, rr := range broker.History() {
+		switch req := rr.Request.(type) {
+		case *sarama.CreateTopicsRequest:
+			creates = append(creates, req)
+		case *sarama.CreatePartitionsRequest:
+			partitions = append(partitions, req)
+		case *sarama.IncrementalAlterConfigsRequest:
+			alters = append(alters, req)
+		case *sarama.DeleteTopicsRequest:
+			deletes = append(deletes, req)
+		}
+	}
+	return
+}
+
+func Test_MockReconcileTopics(t *testing.T) {
+	broker, admin := mockCluster(t,
+		map[string]int32{"orders": 3, "payments": 2, "audit": 1, "legacy": 1},
+		map[string]map[string]string{"payments": {"retention.ms": "86400000", "cleanup.policy": "compact", "segment.ms": "3600000", leaderThrottledReplicas: "*"}},
+	)
+	defer broker.Close()
+	defer admin.Close()
+
+	previous := []kafkaTopicConfig{
+		{Name: "orders", ReplicationFactor: 1, NumOfPartitions: 3},
+		{Name: "payments", ReplicationFactor: 1, NumOfPartitions: 2, Config: map[string]string{"retention.ms": "86400000", "cleanup.policy": "compact", "segment.ms": "3600000"}},
+		{Name: "audit", ReplicationFactor: 1, NumOfPartitions: 1},
+		{Name: "legacy", ReplicationFactor: 1, NumOfPartitions: 1},
+	}
+	desired := []kafkaTopicConfig{
+		{Name: "orders", ReplicationFactor: 1, NumOfPartitions: 6},
+		{Name: "payments", ReplicationFactor: 1, NumOfPartitions: 2, Config: map[string]string{"retention.ms": "604800000", "segment.ms": "3600000"}},
+		{Name: "audit", ReplicationFactor: 1, NumOfPartitions: 1},
+		{Name: "shipments", ReplicationFactor: 1, NumOfPartitions: 4},
+	}
+
+	err := reconcileTopics(admin, desired, previous, true)
+	assert.Nil(t, err)
+
+	creates, partitions, alters, deletes := requests(broker)
+	assert.Len(t, creates, 1)
+	if len(creates) == 1 {
+		assert.Equal(t, int32(4), creates[0].TopicDetails["shipments"].NumPartitions)
+	}
+	assert.Len(t, partitions, 1)
+	if len(partitions) == 1 {
+		assert.Equal(t, int32(6), partitions[0].TopicPartitions["orders"].Count)
+	}
+	assert.Len(t, alters, 1)
+	if len(alters) == 1 {
+		//only the changed keys are altered, the throttled replicas of a rebalance are left alone
+		assert.Equal(t, "payments", alters[0].Resources[0].Name)
+		entries := alters[0].Resources[0].ConfigEntries
+		assert.Len(t, entries, 2)
+		assert.Equal(t, sarama.IncrementalAlterConfigsOperationSet, entries["retention.ms"].Operation)
+		assert.Equal(t, "604800000", *entries["retention.ms"].Value)
+		assert.Equal(t, sarama.IncrementalAlterConfigsOperationDelete, entries["cleanup.policy"].Operation)
+	}
+	assert.Len(t, deletes, 1)
+	if len(deletes) == 1 {
+		assert.Equal(t, []string{"legacy"}, deletes[0].Topics)
+	}
+}
+
+func Test_MockReconcileTopicsUnchanged(t *testing.T) {
+	broker, admin := mockCluster(t,
+		map[string]int32{"orders": 3, "legacy": 1},
+		map[string]map[string]string{"orders": {"cleanup.policy": "compact", followerThrottledReplicas: "*"}},
+	)
+	defer broker.Close()
+	defer admin.Close()
+
+	previous := []kafkaTopicConfig{
+		{Name: "orders", ReplicationFactor: 1, NumOfPartitions: 3, Config: map[string]string{"cleanup.policy": "compact"}},
+		{Name: "legacy", ReplicationFactor: 1, NumOfPartitions: 1},
+	}
+	desired := previous[:1]
+
+	//the removed topic is kept without AllowTopicDeletion
+	err := reconcileTopics(admin, desired, previous, false)
+	assert.Nil(t, err)
+
+	creates, partitions, alters, deletes := requests(broker)
+	assert.Len(t, creates, 0)
+	assert.Len(t, partitions, 0)
+	assert.Len(t, alters, 0)
+	assert.Len(t, deletes, 0)
+}
+
+func Test_MockReconcileTopicsPartitionDecrease(t *testing.T) {
+	broker, admin := mockCluster(t, map[string]int32{"orders": 3, "payments": 2}, nil)
+	defer broker.Close()
+	defer admin.Close()
+
+	desired := []kafkaTopicConfig{
+		{Name: "orders", ReplicationFactor: 1, NumOfPartitions: 1},
+		{Name: "payments", ReplicationFactor: 1, NumOfPartitions: 4},
+		{Name: "shipments", ReplicationFactor: 1, NumOfPartitions: 4},
+	}
+
+	err := reconcileTopics(admin, desired, nil, false)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "topic orders has 3 partitions and cannot be decreased to 1")
+
+	//nothing is changed when any of the topics is refused
+	creates, partitions, alters, deletes := requests(broker)
+	assert.Len(t, creates, 0)
+	assert.Len(t, partitions, 0)
+	assert.Len(t, alters, 0)
+	assert.Len(t, deletes, 0)
+}
+
+func Test_TopicList(t *testing.T) {
+	properties := map[string]interface{}{
+		"TopicList": []interface{}{
+			map[string]interface{}{"Name": "orders", "ReplicationFactor": "3", "NumOfPartitions": "6"},
+			map[string]interface{}{"Name": "payments", "ReplicationFactor": "3", "NumOfPartitions": "2", "Config": map[string]interface{}{"retention.ms": "604800000"}},
+		},
+	}
+	expected := []kafkaTopicConfig{
+		{Name: "orders", ReplicationFactor: 3, NumOfPartitions: 6},
+		{Name: "payments", ReplicationFactor: 3, NumOfPartitions: 2, Config: map[string]string{"retention.ms": "604800000"}},
+	}
+	assert.Equal(t, expected, topicList(properties))
+	assert.Nil(t, topicList(map[string]interface{}{}))
+}
+
+func Test_TopicConfigViolations(t *testing.T) {
+	cases := []struct {
+		Topics []kafkaTopicConfig
+		Err    []string
+	}{
+		{Topics: []kafkaTopicConfig{{Name: "orders"}}},
+		{Topics: []kafkaTopicConfig{{Name: "requests", Config: map[string]string{"retention.ms": "604800000", "cleanup.policy": "compact", "min.insync.replicas": "2"}}}},
+		{
+			Topics: []kafkaTopicConfig{
+				{Name: "requests", Config: map[string]string{"log.retention.ms": "604800000", "retention.ms": ""}},
+				{Name: "orders", Config: map[string]string{"auto.create.topics.enable": "true"}},
+			},
+			Err: []string{
+				"topic requests: log.retention.ms is not a topic level config, did you mean retention.ms?",
+				"topic requests: retention.ms: missing value",
+				"topic orders: auto.create.topics.enable is not a topic level config",
+			},
+		},
+	}
+
+	for _, c := range cases {
+		errs := topicConfigViolations(c.Topics)
+		assert.Equal(t, len(c.Err), len(errs))
+		for _, e := range c.Err {
+			assert.Contains(t, errs, e)
+		}
+	}
+}
+
+func Test_MockCreateTopics(t *testing.T) {
+	seed := sarama.NewMockBroker(t, 1)
+	controller := sarama.NewMockBroker(t, 2)
+	defer seed.Close()
+	defer controller.Close()
+
+	metadata := sarama.NewMockMetadataResponse(t).
+		SetController(controller.BrokerID()).
+		SetBroker(seed.Addr(), seed.BrokerID()).
+		SetBroker(controller.Addr(), controller.BrokerID()).
+		SetLeader("orders", 0, controller.BrokerID())
+
+	notController := &sarama.CreateTopicsResponse{Version: 2, TopicErrors: map[string]*sarama.TopicError{}}
+	created := &sarama.CreateTopicsResponse{Version: 2, TopicErrors: map[string]*sarama.TopicError{}}
+	for _, topic := range []string{"orders", "payments", "audit"} {
+		notController.TopicErrors[topic] = &sarama.TopicError{Err: sarama.ErrNotController}
+	}
+	created.TopicErrors["orders"] = &sarama.TopicError{Err: sarama.ErrTopicAlreadyExists}
+	created.TopicErrors["payments"] = &sarama.TopicError{Err: sarama.ErrNoError}
+	created.TopicErrors["audit"] = &sarama.TopicError{Err: sarama.ErrInvalidReplicationFactor}
+
+	//a broker other than the controller refuses to create topics
+	seed.SetHandlerByMap(map[string]sarama.MockResponse{
+		"ApiVersionsRequest":  sarama.NewMockApiVersionsResponse(t),
+		"MetadataRequest":     metadata,
+		"CreateTopicsRequest": sarama.NewMockWrapper(notController),
+	})
+	controller.SetHandlerByMap(map[string]sarama.MockResponse{
+		"ApiVersionsRequest":  sarama.NewMockApiVersionsResponse(t),
+		"MetadataRequest":     metadata,
+		"CreateTopicsRequest": sarama.NewMockWrapper(created),
+	})
+
+	config := sarama.NewConfig()
+	config.Version = KafkaProtocolVersion
+	admin, err := sarama.NewClusterAdmin([]string{seed.Addr()}, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer admin.Close()
+
+	//a topic that already exists is fine, as is a new one
+	err = createTopics(admin, []kafkaTopicConfig{
+		{Name: "orders", ReplicationFactor: 3, NumOfPartitions: 1},
+		{Name: "payments", ReplicationFactor: 3, NumOfPartitions: 1, Config: map[string]string{"retention.ms": "604800000"}},
+	})
+	assert.Nil(t, err)
+
+	creates, _, _, _ := requests(controller)
+	assert.Len(t, creates, 2)
+	if len(creates) == 2 {
+		assert.Equal(t, "604800000", *creates[1].TopicDetails["payments"].ConfigEntries["retention.ms"])
+	}
+	creates, _, _, _ = requests(seed)
+	assert.Len(t, creates, 0)
+
+	//any other error fails
+	err = createTopics(admin, []kafkaTopicConfig{{Name: "audit", ReplicationFactor: 5, NumOfPartitions: 1}})
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "error occurred while creating the topic audit")
+
+	err = createTopics(admin, []kafkaTopicConfig{{Name: ""}})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,241 @@
+package postprocesskafka
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-lambda-go/cfn"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	r53 "github.com/aws/aws-sdk-go/service/route53"
+	"log"
+	"net"
+	"sort"
+	"strings"
+)
+
+//ttl of the broker and zookeeper records.
+const recordTTL = 300
+
+//resolves host names, overridden in tests.
+var lookupIP = net.LookupIP
+
+//a multi-value A record of the brokers or zookeepers.
+type dnsRecord struct {
+	Name   string   //fully qualified name e.g. brokers-dev.example.com.
+	Values []string //ipv4 addresses
+}
+
+//resolves the hosts of a connection string such as b-1.kafka.example.com:9094,b-2.kafka.example.com:9094 to their
+//sorted ipv4 addresses. Every host that cannot be resolved is reported.
+func resolveHosts(conString string) ([]string, error) {
+	seen := make(map[string]bool)
+	var ips []string
+	var errs []string
+	for _, hostPort := range strings.Split(conString, ",") {
+		host := strings.TrimSpace(hostPort)
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if host == "" {
+			continue
+		}
+
+		resolved, err := lookupIP(host)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", host, err))
+			continue
+		}
+		var found bool
+		for _, ip := range resolved {
+			if ip.To4() == nil {
+				continue
+			}
+			found = true
+			if !seen[ip.String()] {
+				seen[ip.String()] = true
+				ips = append(ips, ip.String())
+			}
+		}
+		if !found {
+			errs = append(errs, fmt.Sprintf("%s: no ipv4 address", host))
+		}
+	}
+
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("unable to resolve %s", strings.Join(errs, "; "))
+	}
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("no hosts to resolve in %q", conString)
+	}
+	sort.Strings(ips)
+	return ips, nil
+}
+
+//names of the broker and zookeeper records of the stage in the zone.
+func recordNames(stage, zoneName string) (brokers string, zookeepers string) {
+	zone := strings.TrimSuffix(zoneName, ".") + "."
+	return fmt.Sprintf("brokers-%s.%s", stage, zone), fmt.Sprintf("zookeepers-%s.%s", stage, zone)
+}
+
+//creates or updates the records in the zone and waits for the change to be INSYNC.
+func (r *R53client) upsertRecords(ctx context.Context, zoneId string, records []dnsRecord) error {
+
+	var changes []*r53.Change
+	for _, record := range records {
+		log.Printf("upserting record %s : %v", record.Name, record.Values)
+		changes = append(changes, &r53.Change{
+			Action:            aws.String(r53.ChangeActionUpsert),
+			ResourceRecordSet: aRecordSet(record.Name, record.Values),
+		})
+	}
+	return r.changeRecords(ctx, zoneId, changes)
+}
+
+//deletes the A records of the given names from the zone and waits for the change to be INSYNC. Records that do not
+//exist, or a zone that does not, are fine.
+func (r *R53client) deleteRecords(ctx context.Context, zoneId string, names []string) error {
+
+	var changes []*r53.Change
+	for _, name := range names {
+		out, err := r.Client.ListResourceRecordSetsWithContext(ctx, &r53.ListResourceRecordSetsInput{
+			HostedZoneId:    aws.String(zoneId),
+			StartRecordName: aws.String(name),
+			StartRecordType: aws.String(r53.RRTypeA),
+			MaxItems:        aws.String("1"),
+		})
+		if err != nil {
+			if aerr, ok := err.(awserr.Error); ok && aerr.Code() == r53.ErrCodeNoSuchHostedZone {
+				log.Printf("hosted zone %s not found, nothing to delete.", zoneId)
+				return nil
+			}
+			return fmt.Errorf("unable to list record sets of %s : %v", zoneId, err)
+		}
+		//the listing starts at the name, whatever record comes first when it does not exist.
+		if len(out.ResourceRecordSets) == 0 || aws.StringValue(out.ResourceRecordSets[0].Name) != name ||
+			aws.StringValue(out.ResourceRecordSets[0].Type) != r53.RRTypeA {
+			log.Printf("record %s not found, nothing to delete.", name)
+			continue
+		}
+		log.Printf("deleting record %s", name)
+		changes = append(changes, &r53.Change{
+			Action:            aws.String(r53.ChangeActionDelete),
+			ResourceRecordSet: out.ResourceRecordSets[0],
+		})
+	}
+	return r.changeRecords(ctx, zoneId, changes)
+}
+
+//applies the changes to the zone in a single batch and waits for them to be INSYNC.
+func (r *R53client) changeRecords(ctx context.Context, zoneId string, changes []*r53.Change) error {
+	if len(changes) == 0 {
+		return nil
+	}
+
+	out, err := r.Client.ChangeResourceRecordSetsWithContext(ctx, &r53.ChangeResourceRecordSetsInput{
+		HostedZoneId: aws.String(zoneId),
+		ChangeBatch: &r53.ChangeBatch{
+			Comment: aws.String("kafka broker and zookeeper records"),
+			Changes: changes,
+		},
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			return fmt.Errorf("unable to change record sets of %s: %s-%v", zoneId, aerr.Code(), aerr.Message())
+		}
+		return fmt.Errorf("unable to change record sets of %s : %v", zoneId, err)
+	}
+
+	log.Printf("waiting for change %s to be INSYNC", aws.StringValue(out.ChangeInfo.Id))
+	err = r.Client.WaitUntilResourceRecordSetsChangedWithContext(ctx, &r53.GetChangeInput{Id: out.ChangeInfo.Id})
+	if err != nil {
+		return fmt.Errorf("change %s of %s did not get INSYNC : %v", aws.StringValue(out.ChangeInfo.Id), zoneId, err)
+	}
+	return nil
+}
+
+//a multi-value A record set.
+func aRecordSet(name string, values []string) *r53.ResourceRecordSet {
+	set := &r53.ResourceRecordSet{
+		Name: aws.String(name),
+		Type: aws.String(r53.RRTypeA),
+		TTL:  aws.Int64(recordTTL),
+	}
+	for _, value := range values {
+		set.ResourceRecords = append(set.ResourceRecords, &r53.ResourceRecord{Value: aws.String(value)})
+	}
+	return set
+}
+
+//the hosted zone and the names of the records managed for the properties, none without a StageName.
+func (r *R53client) managedRecords(ctx context.Context, properties map[string]interface{}) (zoneId string, names []string, err error) {
+	stage, _ := properties["StageName"].(string)
+	zoneId, _ = properties["HostedZone"].(string)
+	if stage == "" || zoneId == "" {
+		return zoneId, nil, nil
+	}
+
+	zoneName, err := r.recordSet(ctx, zoneId)
+	if err == errZoneNotFound {
+		log.Printf("hosted zone %s not found.", zoneId)
+		return zoneId, nil, nil
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	brokers, zookeepers := recordNames(stage, zoneName)
+	return zoneId, []string{brokers, zookeepers}, nil
+}
+
+//upserts the broker and zookeeper records of the StageName in the HostedZone and removes the ones of the previous
+//StageName or HostedZone. The record names are added to the response data.
+func updateRecords(ctx context.Context, event cfn.Event, brokers string, data map[string]interface{}) error {
+	sess := session.Must(session.NewSession())
+	r53api := R53client{Client: r53.New(sess)}
+
+	zoneId, names, err := r53api.managedRecords(ctx, event.ResourceProperties)
+	if err != nil {
+		return err
+	}
+	if len(names) == 0 {
+		log.Println("no StageName given, the broker and zookeeper records are not managed.")
+	} else {
+		brokerIps, err := resolveHosts(brokers)
+		if err != nil {
+			return err
+		}
+		err = r53api.upsertRecords(ctx, zoneId, []dnsRecord{
+			{Name: names[0], Values: brokerIps},
+			{Name: names[1], Values: strings.Split(data["Zookeepers"].(string), ",")},
+		})
+		if err != nil {
+			return err
+		}
+		data["BrokersDns"] = names[0]
+		data["ZookeepersDns"] = names[1]
+	}
+
+	if event.RequestType != cfn.RequestUpdate {
+		return nil
+	}
+	oldZoneId, oldNames, err := r53api.managedRecords(ctx, event.OldResourceProperties)
+	if err != nil {
+		return err
+	}
+	if oldZoneId == zoneId && strings.Join(oldNames, ",") == strings.Join(names, ",") {
+		return nil
+	}
+	return r53api.deleteRecords(ctx, oldZoneId, oldNames)
+}
+
+//removes the broker and zookeeper records.
+func removeRecords(ctx context.Context, properties map[string]interface{}) error {
+	sess := session.Must(session.NewSession())
+	r53api := R53client{Client: r53.New(sess)}
+
+	zoneId, names, err := r53api.managedRecords(ctx, properties)
+	if err != nil {
+		return err
+	}
+	return r53api.deleteRecords(ctx, zoneId, names)
+}
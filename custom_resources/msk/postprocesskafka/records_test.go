@@ -0,0 +1,122 @@
+package postprocesskafka
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/route53"
+	"github.com/tj/assert"
+	"net"
+	"testing"
+)
+
+//lists the record sets starting at the given name, as route53 does.
+func (m *mockRoute53) ListResourceRecordSetsWithContext(_ aws.Context, in *route53.ListResourceRecordSetsInput, _ ...request.Option) (*route53.ListResourceRecordSetsOutput, error) {
+	out := &route53.ListResourceRecordSetsOutput{}
+	for _, set := range m.records {
+		if aws.StringValue(set.Name) >= aws.StringValue(in.StartRecordName) {
+			out.ResourceRecordSets = append(out.ResourceRecordSets, set)
+			break
+		}
+	}
+	return out, nil
+}
+
+func (m *mockRoute53) ChangeResourceRecordSetsWithContext(_ aws.Context, in *route53.ChangeResourceRecordSetsInput, _ ...request.Option) (*route53.ChangeResourceRecordSetsOutput, error) {
+	m.changes = append(m.changes, in.ChangeBatch)
+	id := fmt.Sprintf("change%d", len(m.changes))
+	return &route53.ChangeResourceRecordSetsOutput{ChangeInfo: &route53.ChangeInfo{Id: aws.String(id), Status: aws.String(route53.ChangeStatusPending)}}, nil
+}
+
+func (m *mockRoute53) WaitUntilResourceRecordSetsChangedWithContext(_ aws.Context, in *route53.GetChangeInput, _ ...request.WaiterOption) error {
+	m.waited = append(m.waited, aws.StringValue(in.Id))
+	return nil
+}
+
+func Test_ResolveHosts(t *testing.T) {
+	defer func() { lookupIP = net.LookupIP }()
+	lookupIP = func(host string) ([]net.IP, error) {
+		switch host {
+		case "b-1.kafka.example.com":
+			return []net.IP{net.ParseIP("10.0.0.2"), net.ParseIP("fd00::2")}, nil
+		case "b-2.kafka.example.com":
+			return []net.IP{net.ParseIP("10.0.0.1")}, nil
+		case "b-3.kafka.example.com":
+			return []net.IP{net.ParseIP("10.0.0.1")}, nil
+		case "v6.kafka.example.com":
+			return []net.IP{net.ParseIP("fd00::3")}, nil
+		}
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+
+	ips, err := resolveHosts("b-1.kafka.example.com:9094,b-2.kafka.example.com:9094,b-3.kafka.example.com")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"10.0.0.1", "10.0.0.2"}, ips)
+
+	_, err = resolveHosts("b-1.kafka.example.com:9094,z-1.kafka.example.com:2181,v6.kafka.example.com:2181")
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "z-1.kafka.example.com: lookup z-1.kafka.example.com: no such host")
+	assert.Contains(t, err.Error(), "v6.kafka.example.com: no ipv4 address")
+
+	_, err = resolveHosts("")
+	assert.NotNil(t, err)
+}
+
+func Test_MockRecords(t *testing.T) {
+	r53Mock := &mockRoute53{hzResp: route53.GetHostedZoneOutput{HostedZone: &route53.HostedZone{Name: aws.String("example.com.")}}}
+	r53Api := R53client{Client: r53Mock}
+	ctx := context.Background()
+
+	zoneId, names, err := r53Api.managedRecords(ctx, map[string]interface{}{"HostedZone": zoneID, "StageName": "dev"})
+	assert.Nil(t, err)
+	assert.Equal(t, zoneID, zoneId)
+	assert.Equal(t, []string{"brokers-dev.example.com.", "zookeepers-dev.example.com."}, names)
+
+	//nothing is managed without a StageName
+	_, names, err = r53Api.managedRecords(ctx, map[string]interface{}{"HostedZone": zoneID})
+	assert.Nil(t, err)
+	assert.Len(t, names, 0)
+
+	err = r53Api.upsertRecords(ctx, zoneID, []dnsRecord{
+		{Name: "brokers-dev.example.com.", Values: []string{"10.0.0.1", "10.0.0.2"}},
+		{Name: "zookeepers-dev.example.com.", Values: []string{"10.0.1.1"}},
+	})
+	assert.Nil(t, err)
+	assert.Len(t, r53Mock.changes, 1)
+	if len(r53Mock.changes) == 1 {
+		changes := r53Mock.changes[0].Changes
+		assert.Len(t, changes, 2)
+		assert.Equal(t, route53.ChangeActionUpsert, aws.StringValue(changes[0].Action))
+		assert.Equal(t, aRecordSet("brokers-dev.example.com.", []string{"10.0.0.1", "10.0.0.2"}), changes[0].ResourceRecordSet)
+	}
+	assert.Equal(t, []string{"change1"}, r53Mock.waited)
+
+	//only the existing A records are deleted, with their current values
+	brokers := aRecordSet("brokers-dev.example.com.", []string{"10.0.0.1"})
+	r53Mock.records = []*route53.ResourceRecordSet{
+		brokers,
+		{Name: aws.String("schemareg-dev.example.com."), Type: aws.String(route53.RRTypeCname)},
+	}
+	err = r53Api.deleteRecords(ctx, zoneID, []string{"brokers-dev.example.com.", "zookeepers-dev.example.com."})
+	assert.Nil(t, err)
+	assert.Len(t, r53Mock.changes, 2)
+	if len(r53Mock.changes) == 2 {
+		changes := r53Mock.changes[1].Changes
+		assert.Len(t, changes, 1)
+		assert.Equal(t, route53.ChangeActionDelete, aws.StringValue(changes[0].Action))
+		assert.Equal(t, brokers, changes[0].ResourceRecordSet)
+	}
+
+	//no change is sent when there is nothing to delete
+	err = r53Api.deleteRecords(ctx, zoneID, []string{"zookeepers-dev.example.com."})
+	assert.Nil(t, err)
+	assert.Len(t, r53Mock.changes, 2)
+
+	//a zone that is gone has no records
+	r53Mock.hzErr = awserr.New(route53.ErrCodeNoSuchHostedZone, "no such hosted zone", nil)
+	_, names, err = r53Api.managedRecords(ctx, map[string]interface{}{"HostedZone": zoneID, "StageName": "dev"})
+	assert.Nil(t, err)
+	assert.Len(t, names, 0)
+}
@@ -0,0 +1,260 @@
+package postprocesskafka
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"github.com/Shopify/sarama"
+	"github.com/krunal4amity/cfn-infra/custom_resources/resource"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//topic the smoke test messages go to when no Topic is given.
+const defaultSmokeTestTopic = "kafka-postprocessor-smoketest"
+
+//time the smoke test message has to make it back when no TimeoutSeconds are given.
+const defaultSmokeTestTimeout = 30 * time.Second
+
+//smoke test messages are kept for an hour.
+const smokeTestRetentionMs = "3600000"
+
+//what to smoke test, read from the SmokeTest property.
+type smokeTestSettings struct {
+	Topic   string
+	Timeout time.Duration
+}
+
+//the outcome of a smoke test that passed.
+type smokeTestResult struct {
+	Key       string
+	Partition int32
+	Offset    int64
+	Latency   time.Duration //from producing the message until it is consumed back
+}
+
+//reads the SmokeTest property. ok is false without a SmokeTest property.
+func smokeTestProperty(properties map[string]interface{}) (settings smokeTestSettings, ok bool, err error) {
+	s, ok := properties["SmokeTest"].(map[string]interface{})
+	if !ok {
+		return smokeTestSettings{}, false, nil
+	}
+
+	settings = smokeTestSettings{Topic: defaultSmokeTestTopic, Timeout: defaultSmokeTestTimeout}
+	if topic, ok := s["Topic"]; ok {
+		settings.Topic, _ = topic.(string)
+		if settings.Topic == "" {
+			return smokeTestSettings{}, true, fmt.Errorf("invalid SmokeTest: Topic must be a non empty string, got %v", topic)
+		}
+	}
+	if timeout, ok := s["TimeoutSeconds"]; ok {
+		seconds, err := strconv.Atoi(fmt.Sprint(timeout))
+		if err != nil || seconds < 1 || seconds > 600 {
+			return smokeTestSettings{}, true, fmt.Errorf("invalid SmokeTest: TimeoutSeconds must be a number between 1 and 600, got %v", timeout)
+		}
+		settings.Timeout = time.Duration(seconds) * time.Second
+	}
+	return settings, true, nil
+}
+
+//creates the smoke test topic unless it exists, with a single partition replicated to up to 3 brokers.
+func createSmokeTestTopic(admin sarama.ClusterAdmin, topic string) error {
+
+	brokers, _, err := admin.DescribeCluster()
+	if err != nil {
+		return fmt.Errorf("unable to describe the kafka cluster : %v", err)
+	}
+	replicationFactor := len(brokers)
+	if replicationFactor > 3 {
+		replicationFactor = 3
+	}
+	retention := smokeTestRetentionMs
+
+	err = admin.CreateTopic(topic, &sarama.TopicDetail{
+		NumPartitions:     1,
+		ReplicationFactor: int16(replicationFactor),
+		ConfigEntries:     map[string]*string{"retention.ms": &retention},
+	}, false)
+	if err != nil && !errors.Is(err, sarama.ErrTopicAlreadyExists) {
+		return diagnose(fmt.Sprintf("create the smoke test topic %s", topic), err)
+	}
+	return nil
+}
+
+//produces a message keyed with key to the topic and consumes it back within the timeout. The brokers are probed first so
+//that a failure is diagnosed as a network, TLS or authentication one where possible. The timeout is cut short to leave
+//the lambda function time to respond to cloudformation.
+func smokeTest(ctx context.Context, brokers []string, config *sarama.Config, topic, key string, timeout time.Duration) (smokeTestResult, error) {
+
+	if fnDeadline, ok := ctx.Deadline(); ok && time.Until(fnDeadline)-resource.ResponseMargin < timeout {
+		timeout = time.Until(fnDeadline) - resource.ResponseMargin
+		if timeout <= 0 {
+			return smokeTestResult{}, fmt.Errorf("smoke test failed: %v", resource.ErrOutOfTime)
+		}
+		log.Printf("smoke test timeout cut down to %s", timeout)
+	}
+	deadline := time.Now().Add(timeout)
+	err := probeBrokers(brokers, config, deadline)
+	if err != nil {
+		return smokeTestResult{}, err
+	}
+
+	c := *config
+	c.Producer.Return.Successes = true
+	c.Producer.RequiredAcks = sarama.WaitForAll
+	c.Producer.Retry.Max = 5
+	c.Producer.Retry.Backoff = 250 * time.Millisecond
+	c.Consumer.Return.Errors = true
+	capTimeouts(&c, time.Until(deadline))
+
+	client, err := sarama.NewClient(brokers, &c)
+	if err != nil {
+		return smokeTestResult{}, diagnose("connect to the kafka cluster", err)
+	}
+	defer client.Close()
+
+	producer, err := sarama.NewSyncProducerFromClient(client)
+	if err != nil {
+		return smokeTestResult{}, diagnose("create a producer", err)
+	}
+	defer producer.Close()
+
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+
+	//the producer retries, so the message is raced against the deadline.
+	type produced struct {
+		partition int32
+		offset    int64
+		err       error
+	}
+	sent := make(chan produced, 1)
+	start := time.Now()
+	go func() {
+		partition, offset, err := producer.SendMessage(&sarama.ProducerMessage{
+			Topic: topic,
+			Key:   sarama.StringEncoder(key),
+			Value: sarama.StringEncoder(start.UTC().Format(time.RFC3339Nano)),
+		})
+		sent <- produced{partition, offset, err}
+	}()
+
+	var partition int32
+	var offset int64
+	select {
+	case p := <-sent:
+		if p.err != nil {
+			return smokeTestResult{}, diagnose(fmt.Sprintf("produce to topic %s", topic), p.err)
+		}
+		partition, offset = p.partition, p.offset
+	case <-timer.C:
+		return smokeTestResult{}, fmt.Errorf("smoke test failed: message %s could not be produced to topic %s within %s", key, topic, timeout)
+	case <-ctx.Done():
+		return smokeTestResult{}, fmt.Errorf("smoke test failed: gave up producing message %s : %v", key, ctx.Err())
+	}
+	log.Printf("smoke test message %s produced to topic %s partition %d offset %d", key, topic, partition, offset)
+
+	consumer, err := sarama.NewConsumerFromClient(client)
+	if err != nil {
+		return smokeTestResult{}, diagnose("create a consumer", err)
+	}
+	defer consumer.Close()
+
+	pc, err := consumer.ConsumePartition(topic, partition, offset)
+	if err != nil {
+		return smokeTestResult{}, diagnose(fmt.Sprintf("consume from topic %s", topic), err)
+	}
+	defer pc.Close()
+
+	for {
+		select {
+		case msg := <-pc.Messages():
+			if string(msg.Key) != key {
+				continue
+			}
+			result := smokeTestResult{Key: key, Partition: partition, Offset: offset, Latency: time.Since(start)}
+			log.Printf("smoke test message %s consumed back after %s", key, result.Latency)
+			return result, nil
+		case err := <-pc.Errors():
+			return smokeTestResult{}, diagnose(fmt.Sprintf("consume from topic %s", topic), err)
+		case <-timer.C:
+			return smokeTestResult{}, fmt.Errorf("smoke test failed: message %s produced to topic %s partition %d offset %d was not consumed back within %s", key, topic, partition, offset, timeout)
+		case <-ctx.Done():
+			return smokeTestResult{}, fmt.Errorf("smoke test failed: gave up waiting for message %s : %v", key, ctx.Err())
+		}
+	}
+}
+
+//lowers the network, metadata and producer timeouts of the client config to the time left, so that no request of the
+//smoke test outlasts it.
+func capTimeouts(c *sarama.Config, left time.Duration) {
+	for _, timeout := range []*time.Duration{&c.Net.DialTimeout, &c.Net.ReadTimeout, &c.Net.WriteTimeout, &c.Producer.Timeout} {
+		if *timeout > left {
+			*timeout = left
+		}
+	}
+	if c.Metadata.Timeout == 0 || c.Metadata.Timeout > left {
+		c.Metadata.Timeout = left
+	}
+}
+
+//checks that every broker can be reached and, with TLS, completes a handshake, all before the deadline.
+func probeBrokers(brokers []string, config *sarama.Config, deadline time.Time) error {
+
+	var unreachable, handshakes []string
+	dialer := &net.Dialer{Deadline: deadline}
+	for _, broker := range brokers {
+		conn, err := dialer.Dial("tcp", broker)
+		if err != nil {
+			unreachable = append(unreachable, err.Error())
+			continue
+		}
+		_ = conn.SetDeadline(deadline)
+		if config.Net.TLS.Enable {
+			tlsConfig := &tls.Config{}
+			if config.Net.TLS.Config != nil {
+				tlsConfig = config.Net.TLS.Config.Clone()
+			}
+			if tlsConfig.ServerName == "" {
+				tlsConfig.ServerName, _, _ = net.SplitHostPort(broker)
+			}
+			tlsConn := tls.Client(conn, tlsConfig)
+			if err := tlsConn.Handshake(); err != nil {
+				handshakes = append(handshakes, fmt.Sprintf("%s: %v", broker, err))
+			}
+		}
+		conn.Close()
+	}
+
+	if len(unreachable) > 0 {
+		return fmt.Errorf("smoke test failed, network: unable to reach %s. Check that the function runs in subnets routed to the "+
+			"cluster and that the security group of the cluster allows the broker port from them", strings.Join(unreachable, "; "))
+	}
+	if len(handshakes) > 0 {
+		return fmt.Errorf("smoke test failed, TLS: handshake failed with %s. Check that the Authentication matches the client "+
+			"authentication of the cluster and that the client certificate, if any, is issued by a CA the cluster trusts", strings.Join(handshakes, "; "))
+	}
+	return nil
+}
+
+//explains why speaking kafka to the cluster failed.
+func diagnose(action string, err error) error {
+	switch {
+	case errors.Is(err, sarama.ErrSASLAuthenticationFailed), errors.Is(err, sarama.ErrIllegalSASLState),
+		errors.Is(err, sarama.ErrUnsupportedSASLMechanism):
+		return fmt.Errorf("smoke test failed, auth: unable to %s, SASL authentication failed. Check the credentials and that "+
+			"their secret is associated with the cluster : %v", action, err)
+	case errors.Is(err, sarama.ErrTopicAuthorizationFailed), errors.Is(err, sarama.ErrClusterAuthorizationFailed),
+		errors.Is(err, sarama.ErrGroupAuthorizationFailed):
+		return fmt.Errorf("smoke test failed, auth: not authorized to %s. Check the ACLs of the client : %v", action, err)
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, sarama.ErrOutOfBrokers) {
+		return fmt.Errorf("smoke test failed, network: unable to %s : %v", action, err)
+	}
+	return fmt.Errorf("smoke test failed: unable to %s : %v", action, err)
+}
@@ -0,0 +1,158 @@
+package postprocesskafka
+
+import (
+	"context"
+	"github.com/Shopify/sarama"
+	"github.com/krunal4amity/cfn-infra/custom_resources/resource"
+	"github.com/tj/assert"
+	"net"
+	"testing"
+	"time"
+)
+
+//a mock kafka cluster of a single broker holding the smoke test topic, answering fetches with a message of the given key.
+func mockSmokeTestBroker(t *testing.T, key string, produceErr sarama.KError) *sarama.MockBroker {
+	broker := sarama.NewMockBroker(t, 1)
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"ApiVersionsRequest": sarama.NewMockApiVersionsResponse(t),
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetController(broker.BrokerID()).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetLeader("smoketest", 0, broker.BrokerID()),
+		//the version of the produce requests for KafkaProtocolVersion.
+		"ProduceRequest": sarama.NewMockProduceResponse(t).SetVersion(3).SetError("smoketest", 0, produceErr),
+		"OffsetRequest": sarama.NewMockOffsetResponse(t).
+			SetOffset("smoketest", 0, sarama.OffsetOldest, 0).
+			SetOffset("smoketest", 0, sarama.OffsetNewest, 1),
+		"FetchRequest": sarama.NewMockFetchResponse(t, 1).
+			SetMessageWithKey("smoketest", 0, 0, sarama.StringEncoder(key), sarama.StringEncoder("now")),
+	})
+	return broker
+}
+
+func Test_SmokeTestProperty(t *testing.T) {
+	_, ok, err := smokeTestProperty(map[string]interface{}{})
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	settings, ok, err := smokeTestProperty(map[string]interface{}{"SmokeTest": map[string]interface{}{}})
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, smokeTestSettings{Topic: defaultSmokeTestTopic, Timeout: defaultSmokeTestTimeout}, settings)
+
+	settings, _, err = smokeTestProperty(map[string]interface{}{"SmokeTest": map[string]interface{}{"Topic": "dev-smoketest", "TimeoutSeconds": "10"}})
+	assert.Nil(t, err)
+	assert.Equal(t, smokeTestSettings{Topic: "dev-smoketest", Timeout: 10 * time.Second}, settings)
+
+	_, _, err = smokeTestProperty(map[string]interface{}{"SmokeTest": map[string]interface{}{"TimeoutSeconds": "0"}})
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "TimeoutSeconds must be a number between 1 and 600, got 0")
+}
+
+func Test_MockSmokeTest(t *testing.T) {
+	ctx := context.Background()
+
+	//a port nothing listens on.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed := listener.Addr().String()
+	listener.Close()
+
+	//a port that hangs up on whoever connects, without a TLS handshake.
+	plain, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer plain.Close()
+	go func() {
+		for {
+			conn, err := plain.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	outOfTime, cancel := context.WithTimeout(ctx, resource.ResponseMargin)
+	defer cancel()
+
+	cases := []struct {
+		Name       string
+		Key        string //key of the message the broker hands out
+		ProduceErr sarama.KError
+		TLS        bool
+		Brokers    []string
+		Ctx        context.Context
+		Err        string
+	}{
+		{Name: "passes", Key: "smoketest-1"},
+		{Name: "message not consumed back", Key: "someone-else", Err: "was not consumed back within 1s"},
+		{Name: "not authorized", Key: "smoketest-1", ProduceErr: sarama.ErrTopicAuthorizationFailed, Err: "smoke test failed, auth: not authorized to produce to topic smoketest"},
+		{Name: "tls", Key: "smoketest-1", TLS: true, Brokers: []string{plain.Addr().String()}, Err: "smoke test failed, TLS: handshake failed with"},
+		{Name: "network", Key: "smoketest-1", Brokers: []string{closed}, Err: "smoke test failed, network: unable to reach"},
+		{Name: "out of time", Key: "smoketest-1", Ctx: outOfTime, Err: "smoke test failed: lambda function is about to time out"},
+	}
+
+	for _, c := range cases {
+		broker := mockSmokeTestBroker(t, c.Key, c.ProduceErr)
+		config := sarama.NewConfig()
+		config.Version = KafkaProtocolVersion
+		config.Net.TLS.Enable = c.TLS
+		brokers := c.Brokers
+		if brokers == nil {
+			brokers = []string{broker.Addr()}
+		}
+
+		testCtx := ctx
+		if c.Ctx != nil {
+			testCtx = c.Ctx
+		}
+		result, err := smokeTest(testCtx, brokers, config, "smoketest", "smoketest-1", time.Second)
+		broker.Close()
+		if c.Err != "" {
+			assert.NotNil(t, err, c.Name)
+			assert.Contains(t, err.Error(), c.Err, c.Name)
+			continue
+		}
+		assert.Nil(t, err, c.Name)
+		assert.Equal(t, "smoketest-1", result.Key, c.Name)
+		assert.Equal(t, int32(0), result.Partition, c.Name)
+		assert.True(t, result.Latency > 0, c.Name)
+	}
+}
+
+func Test_MockSmokeTestUnresponsive(t *testing.T) {
+	//a port that accepts connections and never answers.
+	silent, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			conn, err := silent.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				<-done
+				conn.Close()
+			}()
+		}
+	}()
+
+	config := sarama.NewConfig()
+	config.Version = KafkaProtocolVersion
+
+	//the requests of the client give up along with the smoke test instead of after their default timeouts.
+	start := time.Now()
+	_, err = smokeTest(context.Background(), []string{silent.Addr().String()}, config, "smoketest", "smoketest-1", time.Second)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "smoke test failed")
+	assert.True(t, time.Since(start) < 5*time.Second, time.Since(start).String())
+}
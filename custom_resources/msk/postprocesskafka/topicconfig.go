@@ -0,0 +1,69 @@
+package postprocesskafka
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+//topic level configs that can be set on a topic. Available at https://kafka.apache.org/documentation/#topicconfigs
+var topicConfigKeys = map[string]bool{
+	"cleanup.policy":       true,
+	"compression.type":     true,
+	"delete.retention.ms":  true,
+	"file.delete.delay.ms": true,
+	"flush.messages":       true,
+	"flush.ms":             true,
+	"follower.replication.throttled.replicas": true,
+	"index.interval.bytes":                    true,
+	"leader.replication.throttled.replicas":   true,
+	"local.retention.bytes":                   true,
+	"local.retention.ms":                      true,
+	"max.compaction.lag.ms":                   true,
+	"max.message.bytes":                       true,
+	"message.downconversion.enable":           true,
+	"message.format.version":                  true,
+	"message.timestamp.after.max.ms":          true,
+	"message.timestamp.before.max.ms":         true,
+	"message.timestamp.difference.max.ms":     true,
+	"message.timestamp.type":                  true,
+	"min.cleanable.dirty.ratio":               true,
+	"min.compaction.lag.ms":                   true,
+	"min.insync.replicas":                     true,
+	"preallocate":                             true,
+	"remote.storage.enable":                   true,
+	"retention.bytes":                         true,
+	"retention.ms":                            true,
+	"segment.bytes":                           true,
+	"segment.index.bytes":                     true,
+	"segment.jitter.ms":                       true,
+	"segment.ms":                              true,
+	"unclean.leader.election.enable":          true,
+}
+
+//checks the Config of each topic against the known topic level configs. Every offending entry is reported.
+func topicConfigViolations(topics []kafkaTopicConfig) []string {
+	var errs []string
+	for _, topic := range topics {
+		var keys []string
+		for key := range topic.Config {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			switch {
+			case !topicConfigKeys[key]:
+				msg := fmt.Sprintf("topic %s: %s is not a topic level config", topic.Name, key)
+				//broker level configs such as log.retention.ms have their topic level counterparts without the log. prefix
+				if trimmed := strings.TrimPrefix(key, "log."); trimmed != key && topicConfigKeys[trimmed] {
+					msg += fmt.Sprintf(", did you mean %s?", trimmed)
+				}
+				errs = append(errs, msg)
+			case strings.TrimSpace(topic.Config[key]) == "":
+				errs = append(errs, fmt.Sprintf("topic %s: %s: missing value", topic.Name, key))
+			}
+		}
+	}
+	return errs
+}
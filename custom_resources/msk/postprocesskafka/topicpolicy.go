@@ -0,0 +1,125 @@
+package postprocesskafka
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+//the longest topic name kafka accepts, and the characters it accepts in them.
+const (
+	maxTopicNameLength = 249
+	legalTopicChars    = "a-zA-Z0-9._-"
+)
+
+//constraints on the names of the topics of the TopicList, read from the TopicNamePolicy property.
+type topicNamePolicy struct {
+	Prefix            string //prefix every topic name starts with, e.g. the stage name
+	AllowedCharacters string //character set the names are made of, as in a regular expression bracket e.g. a-z0-9.-
+	MaxLength         int    //longest name allowed
+	allowed           *regexp.Regexp
+}
+
+//reads the TopicNamePolicy property. AllowedCharacters and MaxLength default to what kafka accepts. The policy is nil
+//without a TopicNamePolicy property.
+func namePolicy(properties map[string]interface{}) (*topicNamePolicy, error) {
+	p, ok := properties["TopicNamePolicy"].(map[string]interface{})
+	if !ok {
+		return nil, nil
+	}
+
+	policy := topicNamePolicy{AllowedCharacters: legalTopicChars, MaxLength: maxTopicNameLength}
+	if prefix, ok := p["Prefix"]; ok {
+		policy.Prefix = fmt.Sprint(prefix)
+	}
+	if chars, ok := p["AllowedCharacters"]; ok {
+		policy.AllowedCharacters = fmt.Sprint(chars)
+	}
+	var errs []string
+	allowed, err := regexp.Compile("^[" + policy.AllowedCharacters + "]+$")
+	if err != nil || policy.AllowedCharacters == "" {
+		errs = append(errs, fmt.Sprintf("AllowedCharacters must be a character set such as %s, got %q", legalTopicChars, policy.AllowedCharacters))
+	}
+	policy.allowed = allowed
+	if length, ok := p["MaxLength"]; ok {
+		policy.MaxLength, err = strconv.Atoi(fmt.Sprint(length))
+		if err != nil || policy.MaxLength < 1 || policy.MaxLength > maxTopicNameLength {
+			errs = append(errs, fmt.Sprintf("MaxLength must be a number from 1 to %d, got %v", maxTopicNameLength, length))
+		}
+	}
+
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("invalid TopicNamePolicy: %s", strings.Join(errs, "; "))
+	}
+	return &policy, nil
+}
+
+//the ways the name breaks the policy.
+func (p *topicNamePolicy) violations(name string) []string {
+	var errs []string
+	if !strings.HasPrefix(name, p.Prefix) {
+		errs = append(errs, fmt.Sprintf("topic %s: name must start with %s", name, p.Prefix))
+	}
+	if !p.allowed.MatchString(name) {
+		errs = append(errs, fmt.Sprintf("topic %s: name may only contain the characters %s", name, p.AllowedCharacters))
+	}
+	if len(name) > p.MaxLength {
+		errs = append(errs, fmt.Sprintf("topic %s: name is %d characters long, longer than %d", name, len(name), p.MaxLength))
+	}
+	return errs
+}
+
+//validates every topic of the TopicList before the cluster is contacted: its name against the policy if any and its
+//configs first, then its partitions and replication factor against the brokers of the cluster, which brokerCount finds
+//out. Every violation is reported, along with the error finding out the broker count if any. A broker count of 0 leaves
+//the partitions and replication factor unchecked against the brokers.
+func validateTopics(topics []kafkaTopicConfig, policy *topicNamePolicy, brokerCount func() (int, error)) error {
+	var errs []string
+	seen := make(map[string]bool)
+	for i, topic := range topics {
+		if topic.Name == "" {
+			errs = append(errs, fmt.Sprintf("topic #%d: missing Name", i+1))
+			continue
+		}
+		if seen[topic.Name] {
+			errs = append(errs, fmt.Sprintf("topic %s: listed more than once", topic.Name))
+		}
+		seen[topic.Name] = true
+
+		if policy != nil {
+			errs = append(errs, policy.violations(topic.Name)...)
+		}
+		if topic.NumOfPartitions < 1 {
+			errs = append(errs, fmt.Sprintf("topic %s: NumOfPartitions must be a positive number", topic.Name))
+		}
+		if topic.ReplicationFactor < 1 {
+			errs = append(errs, fmt.Sprintf("topic %s: ReplicationFactor must be a positive number", topic.Name))
+		}
+	}
+	errs = append(errs, topicConfigViolations(topics)...)
+
+	brokers, err := brokerCount()
+	if err != nil {
+		if len(errs) == 0 {
+			return err
+		}
+		errs = append(errs, fmt.Sprintf("partitions and replication factors left unchecked: %v", err))
+	}
+	for _, topic := range topics {
+		if topic.Name == "" || brokers == 0 {
+			continue
+		}
+		if topic.NumOfPartitions > brokers {
+			errs = append(errs, fmt.Sprintf("topic %s: NumOfPartitions %d is greater than the %d brokers", topic.Name, topic.NumOfPartitions, brokers))
+		}
+		if topic.ReplicationFactor > brokers {
+			errs = append(errs, fmt.Sprintf("topic %s: ReplicationFactor %d is greater than the %d brokers", topic.Name, topic.ReplicationFactor, brokers))
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid TopicList: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package postprocesskafka
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kafka"
+	"github.com/tj/assert"
+	"testing"
+)
+
+func Test_NamePolicy(t *testing.T) {
+	policy, err := namePolicy(map[string]interface{}{})
+	assert.Nil(t, err)
+	assert.Nil(t, policy)
+
+	policy, err = namePolicy(map[string]interface{}{"TopicNamePolicy": map[string]interface{}{"Prefix": "dev-"}})
+	assert.Nil(t, err)
+	assert.Equal(t, "dev-", policy.Prefix)
+	assert.Equal(t, legalTopicChars, policy.AllowedCharacters)
+	assert.Equal(t, maxTopicNameLength, policy.MaxLength)
+
+	_, err = namePolicy(map[string]interface{}{"TopicNamePolicy": map[string]interface{}{"AllowedCharacters": "z-a", "MaxLength": "300"}})
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), `AllowedCharacters must be a character set such as a-zA-Z0-9._-, got "z-a"`)
+	assert.Contains(t, err.Error(), "MaxLength must be a number from 1 to 249, got 300")
+}
+
+func Test_ValidateTopics(t *testing.T) {
+	policy, err := namePolicy(map[string]interface{}{"TopicNamePolicy": map[string]interface{}{
+		"Prefix":            "stg-",
+		"AllowedCharacters": "a-zA-Z-",
+		"MaxLength":         "20",
+	}})
+	assert.Nil(t, err)
+
+	cases := []struct {
+		Name      string
+		Topics    []kafkaTopicConfig
+		Policy    *topicNamePolicy
+		Brokers   int
+		BrokerErr error
+		Err       []string
+	}{
+		{
+			Name:    "valid",
+			Topics:  []kafkaTopicConfig{{Name: "stg-orders", NumOfPartitions: 3, ReplicationFactor: 3}},
+			Policy:  policy,
+			Brokers: 3,
+		},
+		{
+			Name:    "without a policy",
+			Topics:  []kafkaTopicConfig{{Name: "prd.orders", NumOfPartitions: 3, ReplicationFactor: 3}},
+			Brokers: 3,
+		},
+		{
+			Name:    "without a policy partitions may not outnumber the brokers either",
+			Topics:  []kafkaTopicConfig{{Name: "prd.orders", NumOfPartitions: 12, ReplicationFactor: 3}},
+			Brokers: 3,
+			Err:     []string{"topic prd.orders: NumOfPartitions 12 is greater than the 3 brokers"},
+		},
+		{
+			Name: "unknown broker count",
+			Topics: []kafkaTopicConfig{
+				{Name: "stg-orders", NumOfPartitions: 12, ReplicationFactor: 6},
+			},
+			Policy: policy,
+		},
+		{
+			Name:      "cluster not found",
+			Topics:    []kafkaTopicConfig{{Name: "stg-orders", NumOfPartitions: 3, ReplicationFactor: 3}},
+			Policy:    policy,
+			BrokerErr: ErrClusterNotFound,
+			Err:       []string{"msk cluster not found"},
+		},
+		{
+			Name:      "violations along with the cluster not found",
+			Topics:    []kafkaTopicConfig{{Name: "prd-orders", NumOfPartitions: 3, ReplicationFactor: 3}},
+			Policy:    policy,
+			BrokerErr: ErrClusterNotFound,
+			Err: []string{
+				"invalid TopicList: topic prd-orders: name must start with stg-",
+				"partitions and replication factors left unchecked: msk cluster not found",
+			},
+		},
+		{
+			Name: "every violation",
+			Topics: []kafkaTopicConfig{
+				{Name: "prd-orders", NumOfPartitions: 6, ReplicationFactor: 3},
+				{Name: "stg-orders.v2", NumOfPartitions: 3, ReplicationFactor: 4},
+				{Name: "stg-DataplatformSparkJobRequest", ReplicationFactor: 3},
+				{Name: "stg-orders", NumOfPartitions: 3, ReplicationFactor: 3, Config: map[string]string{"log.retention.ms": "1"}},
+				{NumOfPartitions: 1, ReplicationFactor: 1},
+				{Name: "stg-orders", NumOfPartitions: 1, ReplicationFactor: 1},
+			},
+			Policy:  policy,
+			Brokers: 3,
+			Err: []string{
+				"topic prd-orders: name must start with stg-",
+				"topic prd-orders: NumOfPartitions 6 is greater than the 3 brokers",
+				"topic stg-orders.v2: name may only contain the characters a-zA-Z-",
+				"topic stg-orders.v2: ReplicationFactor 4 is greater than the 3 brokers",
+				"topic stg-DataplatformSparkJobRequest: name is 31 characters long, longer than 20",
+				"topic stg-DataplatformSparkJobRequest: NumOfPartitions must be a positive number",
+				"topic stg-orders: listed more than once",
+				"topic #5: missing Name",
+				"topic stg-orders: log.retention.ms is not a topic level config, did you mean retention.ms?",
+			},
+		},
+	}
+
+	for _, c := range cases {
+		err := validateTopics(c.Topics, c.Policy, func() (int, error) { return c.Brokers, c.BrokerErr })
+		if len(c.Err) == 0 {
+			assert.Nil(t, err, c.Name)
+			continue
+		}
+		assert.NotNil(t, err, c.Name)
+		for _, e := range c.Err {
+			assert.Contains(t, err.Error(), e, c.Name)
+		}
+	}
+}
+
+func Test_MockBrokerCount(t *testing.T) {
+	mskApi := MSKclient{Client: &mockMSK{clResp: kafka.DescribeClusterOutput{
+		ClusterInfo: &kafka.ClusterInfo{NumberOfBrokerNodes: aws.Int64(6)},
+	}}}
+	count, err := mskApi.brokerCount(context.Background(), "dummyArn")
+	assert.Nil(t, err)
+	assert.Equal(t, 6, count)
+}
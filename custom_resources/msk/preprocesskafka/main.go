@@ -1,10 +1,9 @@
-package main
+package preprocesskafka
 
 import (
 	"context"
 	"fmt"
 	"github.com/aws/aws-lambda-go/cfn"
-	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/session"
@@ -12,14 +11,22 @@ import (
 	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
 	"github.com/aws/aws-sdk-go/service/kafka"
 	"github.com/aws/aws-sdk-go/service/kafka/kafkaiface"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/krunal4amity/cfn-infra/custom_resources/msk/mskcluster"
+	"github.com/krunal4amity/cfn-infra/custom_resources/resource"
+	"io/ioutil"
 	"log"
+	"net/url"
+	"reflect"
+	"strconv"
 	"strings"
 )
 
 type ClusterConfig struct {
 	Name           string   `json:"name"`           //name of the cluster configuration
 	Description    string   `json:"description"`    //description of the config
-	Kafka_Versions []string `json:"kafka-versions"` // a list of MSK versions : currently available are 1.1.1 and 2.2.1
+	Kafka_Versions []string `json:"kafka-versions"` // a list of MSK versions as listed by ListKafkaVersions, or latest
 }
 
 //MSK service client
@@ -32,30 +39,147 @@ type Ec2Client struct {
 	Client ec2iface.EC2API
 }
 
-//If the cluster config exists already, returns the arn of the cluster config that matches the name
-// supplied and revision being 1
-func (k *MSKclient) listConfigurations(ctx context.Context, name string) (string, error) {
+//S3 service client
+type S3client struct {
+	Client s3iface.S3API
+}
+
+//If the cluster config exists already, returns the arn and the latest revision of the cluster config that matches
+//the name supplied. Pages through all the configurations of the account.
+func (k *MSKclient) listConfigurations(ctx context.Context, name string) (string, int64, error) {
+
+	param := kafka.ListConfigurationsInput{}
+	for {
+		out, err := k.Client.ListConfigurationsWithContext(ctx, &param)
+		if err != nil {
+			return "", 0, fmt.Errorf("Unable to list MSK cluster configurations : %v", err)
+		}
 
-	out, err := k.Client.ListConfigurationsWithContext(ctx, nil)
+		for _, config := range out.Configurations {
+			if name == aws.StringValue(config.Name) {
+				var revision int64
+				if config.LatestRevision != nil {
+					revision = aws.Int64Value(config.LatestRevision.Revision)
+				}
+				log.Printf("Matching MSK cluster configuration found : %v at revision %d", aws.StringValue(config.Arn), revision)
+				return aws.StringValue(config.Arn), revision, nil
+			}
+		}
+
+		if aws.StringValue(out.NextToken) == "" {
+			break
+		}
+		param.NextToken = out.NextToken
+	}
+
+	return "", 0, fmt.Errorf("Unable to list MSK cluster configuration by name %s", name)
+}
+
+//fetches the server properties stored in the given revision of the cluster configuration.
+func (k *MSKclient) serverProperties(ctx context.Context, configArn string, revision int64) ([]byte, error) {
+
+	param := kafka.DescribeConfigurationRevisionInput{
+		Arn:      aws.String(configArn),
+		Revision: aws.Int64(revision),
+	}
+
+	out, err := k.Client.DescribeConfigurationRevisionWithContext(ctx, &param)
 	if err != nil {
-		return "", fmt.Errorf("Unable to list MSK cluster configurations : %v", err)
+		return nil, fmt.Errorf("unable to describe revision %d of cluster configuration %s: %v", revision, configArn, err)
 	}
+	return out.ServerProperties, nil
+}
 
-	for _, config := range out.Configurations {
+//compares two sets of server properties property by property, ignoring order, blank lines, comments and whitespace.
+//falls back to comparing line by line if either of them cannot be parsed.
+func sameServerProperties(a, b []byte) bool {
+	pa, errA := parseServerProperties(a)
+	pb, errB := parseServerProperties(b)
+	if errA == nil && errB == nil {
+		return string(renderServerProperties(mergeServerProperties(nil, pa))) == string(renderServerProperties(mergeServerProperties(nil, pb)))
+	}
 
-		if name == aws.StringValue(config.Name) && aws.Int64Value(config.LatestRevision.Revision) == 1 {
-			log.Printf("Matching MSK cluster configuration found : %v", aws.StringValue(config.Arn))
-			return aws.StringValue(config.Arn), nil
-		} else {
-			log.Printf("configuration %s is not a match", aws.StringValue(config.Arn))
+	lines := func(props []byte) []string {
+		var r []string
+		for _, line := range strings.Split(string(props), "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				r = append(r, line)
+			}
 		}
+		return r
+	}
+	return reflect.DeepEqual(lines(a), lines(b))
+}
+
+//parses the server properties read from a file, if any, and the ones supplied inline, which take precedence over the
+//file's, and validates them for the kafka versions of the configuration. Returns them as key=value lines sorted by key,
+//so that identical inputs always produce identical configuration revisions and mistakes surface here rather than deep
+//inside CreateConfiguration.
+func checkServerProperties(fileProps []byte, fileSource string, inlineProps []byte, kafkaVersions []string) ([]byte, error) {
+	var errs []string
+
+	fromFile, err := parseServerProperties(fileProps)
+	if err != nil {
+		errs = append(errs, fmt.Sprintf("%s: %v", fileSource, err))
+	}
+	for i := range fromFile {
+		fromFile[i].Source = fileSource
+	}
+
+	inline, err := parseServerProperties(inlineProps)
+	if err != nil {
+		errs = append(errs, fmt.Sprintf("ServerProperties: %v", err))
+	}
+	for i := range inline {
+		inline[i].Source = "ServerProperties"
+	}
+
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("unable to parse server properties: %s", strings.Join(errs, "; "))
 	}
 
-	return "", fmt.Errorf("Unable to list MSK cluster configuration by name %s", name)
+	props := mergeServerProperties(fromFile, inline)
+	if len(props) == 0 {
+		return nil, fmt.Errorf("no server properties supplied. Either ServerProperties or ServerPropertiesS3Uri is required")
+	}
+
+	err = validateServerProperties(props, kafkaVersions)
+	if err != nil {
+		return nil, fmt.Errorf("invalid server properties: %v", err)
+	}
+	return renderServerProperties(props), nil
 }
 
-//create the configuration for MSK cluster to use
-func (k *MSKclient) createConfig(ctx context.Context, conf ClusterConfig, serverProps []byte) (string, error) {
+//reads a server.properties file from the given s3 uri e.g. s3://mybucket/msk/server.properties
+func (c *S3client) serverPropertiesFile(ctx context.Context, uri string) ([]byte, error) {
+
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "s3" || u.Host == "" || strings.TrimPrefix(u.Path, "/") == "" {
+		return nil, fmt.Errorf("%s is not a valid s3 uri of the form s3://bucket/key", uri)
+	}
+
+	param := s3.GetObjectInput{
+		Bucket: aws.String(u.Host),
+		Key:    aws.String(strings.TrimPrefix(u.Path, "/")),
+	}
+
+	out, err := c.Client.GetObjectWithContext(ctx, &param)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get server properties file %s: %v", uri, err)
+	}
+	defer out.Body.Close()
+
+	b, err := ioutil.ReadAll(out.Body)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read server properties file %s: %v", uri, err)
+	}
+	return b, nil
+}
+
+//create the configuration for MSK cluster to use. Returns the arn and the revision of the configuration.
+//If a configuration by the same name exists already, it is adopted: reused, and a new revision of it is created in case
+//its server properties differ from the ones supplied.
+func (k *MSKclient) createConfig(ctx context.Context, conf ClusterConfig, serverProps []byte) (string, int64, bool, error) {
 
 	param := kafka.CreateConfigurationInput{
 		Description:      aws.String(conf.Description),
@@ -70,174 +194,623 @@ func (k *MSKclient) createConfig(ctx context.Context, conf ClusterConfig, server
 		if aerr, ok := err.(awserr.Error); ok {
 			switch aerr.Code() {
 			case kafka.ErrCodeConflictException:
-				return k.listConfigurations(ctx, conf.Name)
+				configArn, revision, err := k.existingConfig(ctx, conf, serverProps)
+				return configArn, revision, true, err
 			default:
-				return "", fmt.Errorf("unable to create cluster configuration: %v", aerr.Message())
+				return "", 0, false, fmt.Errorf("unable to create cluster configuration: %v", aerr.Message())
 			}
 		} else {
-			return "", fmt.Errorf("unable to create cluster configuration :%v", err)
+			return "", 0, false, fmt.Errorf("unable to create cluster configuration :%v", err)
 		}
 	}
 
 	log.Println("kafka cluster  configuration arn is :", aws.StringValue(out.Arn))
-	return aws.StringValue(out.Arn), nil
+	return aws.StringValue(out.Arn), aws.Int64Value(out.LatestRevision.Revision), false, nil
+}
+
+//suffix of the physical resource id of a configuration which existed before the resource adopted it. Such a
+//configuration may be in use by other clusters or stacks, hence it is never deleted along with the resource.
+const adoptedSuffix = "#adopted"
+
+//the physical resource id of the configuration, marking the adopted ones.
+func configResourceId(configArn string, adopted bool) string {
+	if adopted {
+		return configArn + adoptedSuffix
+	}
+	return configArn
+}
+
+//the configuration arn of the physical resource id and whether the configuration was adopted.
+func parseConfigResourceId(physicalResourceId string) (configArn string, adopted bool) {
+	configArn = strings.TrimSuffix(physicalResourceId, adoptedSuffix)
+	return configArn, configArn != physicalResourceId
+}
+
+//the configuration of the physical resource id and whether it was adopted. Earlier versions of the handler returned no
+//physical resource id, leaving cloudformation to use the name of the log stream instead. The configuration of such a
+//resource is looked up by name and treated as adopted, since there is no telling whether the resource created it.
+func (k *MSKclient) resourceConfig(ctx context.Context, physicalResourceId string, name string) (string, bool, error) {
+
+	configArn, adopted := parseConfigResourceId(physicalResourceId)
+	if strings.HasPrefix(configArn, "arn:") {
+		return configArn, adopted, nil
+	}
+
+	configArn, _, err := k.listConfigurations(ctx, name)
+	if err != nil {
+		return "", false, err
+	}
+	log.Printf("physical resource id %s is not a configuration arn. Configuration %s found by name %s is treated as adopted.", physicalResourceId, configArn, name)
+	return configArn, true, nil
+}
+
+//finds the existing configuration by name and makes sure its latest revision holds the server properties supplied.
+func (k *MSKclient) existingConfig(ctx context.Context, conf ClusterConfig, serverProps []byte) (string, int64, error) {
+
+	configArn, revision, err := k.listConfigurations(ctx, conf.Name)
+	if err != nil {
+		return "", 0, err
+	}
+
+	stored, err := k.serverProperties(ctx, configArn, revision)
+	if err != nil {
+		return "", 0, err
+	}
+
+	if sameServerProperties(stored, serverProps) {
+		return configArn, revision, nil
+	}
+
+	log.Printf("server properties of configuration %s at revision %d differ from the ones supplied. Creating a new revision.", configArn, revision)
+	revision, err = k.updateConfig(ctx, configArn, conf, serverProps)
+	if err != nil {
+		return "", 0, err
+	}
+	return configArn, revision, nil
 }
 
-//fetches the cidr of the VPC using the given vpc. So that it could be used in places
+//the cidr blocks of a vpc. Cidr is the primary ipv4 block, Ipv4 holds every associated ipv4 block including
+//secondary ones and Ipv6 the associated ipv6 blocks.
+type vpcCidrs struct {
+	Cidr string
+	Ipv4 []string
+	Ipv6 []string
+}
+
+//fetches the cidr blocks of the VPC in the associated state using the given vpc. So that they could be used in places
 // such as SecurityGroups in cloudformation template etc.
-func (e *Ec2Client) vpcCidr(ctx context.Context, vpcId string) (string, error) {
+func (e *Ec2Client) vpcCidr(ctx context.Context, vpcId string) (vpcCidrs, error) {
 
 	param := ec2.DescribeVpcsInput{VpcIds: aws.StringSlice([]string{vpcId})}
 	out, err := e.Client.DescribeVpcsWithContext(ctx, &param)
 	if err != nil {
-		return "", fmt.Errorf("unable to describe VPC : %v", err)
+		return vpcCidrs{}, fmt.Errorf("unable to describe VPC : %v", err)
 	}
 
 	for _, vpc := range out.Vpcs {
-		if vpcId == aws.StringValue(vpc.VpcId) {
-			return aws.StringValue(vpc.CidrBlock), nil
-		} else {
-			return "", fmt.Errorf("vpc matching the ID %s could not be found", vpcId)
+		if vpcId != aws.StringValue(vpc.VpcId) {
+			continue
+		}
+
+		cidrs := vpcCidrs{Cidr: aws.StringValue(vpc.CidrBlock)}
+		for _, assoc := range vpc.CidrBlockAssociationSet {
+			if assoc.CidrBlockState != nil && aws.StringValue(assoc.CidrBlockState.State) == ec2.VpcCidrBlockStateCodeAssociated {
+				cidrs.Ipv4 = append(cidrs.Ipv4, aws.StringValue(assoc.CidrBlock))
+			}
+		}
+		for _, assoc := range vpc.Ipv6CidrBlockAssociationSet {
+			if assoc.Ipv6CidrBlockState != nil && aws.StringValue(assoc.Ipv6CidrBlockState.State) == ec2.VpcCidrBlockStateCodeAssociated {
+				cidrs.Ipv6 = append(cidrs.Ipv6, aws.StringValue(assoc.Ipv6CidrBlock))
+			}
 		}
+		if len(cidrs.Ipv4) == 0 && cidrs.Cidr != "" {
+			cidrs.Ipv4 = []string{cidrs.Cidr}
+		}
+		return cidrs, nil
 	}
-	return "", nil
+	return vpcCidrs{}, fmt.Errorf("vpc matching the ID %s could not be found", vpcId)
 
 }
 
-//returns a list of private subnets associated with the vpc.
+//returns a list of private subnets of the vpc sorted by availability zone, i.e. the subnets routing their internet
+//bound traffic through a NAT gateway, a NAT instance, a transit gateway or an egress only internet gateway. Subnets with
+//no explicit route table association inherit the main route table of the vpc.
 //the lambda function (custom resource) that will perform post processing task will need to be allocated to
 //the subnets that have NAT translation even though the MSK is being created is in public subnet.
-func (c *Ec2Client) privSubnets(ctx context.Context, vpcId string) ([]string, error) {
-	param := ec2.DescribeRouteTablesInput{
-		Filters: []*ec2.Filter{
-			&ec2.Filter{
-				Name:   aws.String("vpc-id"),
-				Values: []*string{aws.String(vpcId)},
-			},
-			&ec2.Filter{
-				Name:   aws.String("route.nat-gateway-id"),
-				Values: []*string{aws.String("nat*")},
-			},
+//If onePerAz is true, only the first private subnet of each availability zone is returned.
+func (c *Ec2Client) privSubnets(ctx context.Context, vpcId string, onePerAz bool) ([]string, error) {
+	filters := []*ec2.Filter{
+		&ec2.Filter{
+			Name:   aws.String("vpc-id"),
+			Values: []*string{aws.String(vpcId)},
 		},
 	}
 
-	out, err := c.Client.DescribeRouteTablesWithContext(ctx, &param)
-	if err != nil {
-		if aerr, ok := err.(awserr.Error); ok {
-			switch aerr.Code() {
-			default:
+	var routeTables []*ec2.RouteTable
+	rtParam := ec2.DescribeRouteTablesInput{Filters: filters}
+	for {
+		out, err := c.Client.DescribeRouteTablesWithContext(ctx, &rtParam)
+		if err != nil {
+			if aerr, ok := err.(awserr.Error); ok {
 				return nil, fmt.Errorf("unable to list route destinations: %s", aerr.Message())
 			}
-		} else {
 			return nil, fmt.Errorf("unable to list route destinations: %v", err)
 		}
+		routeTables = append(routeTables, out.RouteTables...)
+		if aws.StringValue(out.NextToken) == "" {
+			break
+		}
+		rtParam.NextToken = out.NextToken
 	}
 
-	var privSubnets []string
-	for _, routetable := range out.RouteTables {
-		for _, association := range routetable.Associations {
-			privSubnets = append(privSubnets, aws.StringValue(association.SubnetId))
+	var subnets []*ec2.Subnet
+	subnetParam := ec2.DescribeSubnetsInput{Filters: filters}
+	for {
+		out, err := c.Client.DescribeSubnetsWithContext(ctx, &subnetParam)
+		if err != nil {
+			if aerr, ok := err.(awserr.Error); ok {
+				return nil, fmt.Errorf("unable to list subnets: %s", aerr.Message())
+			}
+			return nil, fmt.Errorf("unable to list subnets: %v", err)
+		}
+		subnets = append(subnets, out.Subnets...)
+		if aws.StringValue(out.NextToken) == "" {
+			break
 		}
+		subnetParam.NextToken = out.NextToken
+	}
+
+	private := privateSubnets(routeTables, subnets)
+	if onePerAz {
+		private = subnetPerAz(private)
+	}
+
+	var privSubnets []string
+	for _, subnet := range private {
+		privSubnets = append(privSubnets, subnet.Id)
 	}
 	log.Printf("List of private subnets is :%v. Length : %v", privSubnets, len(privSubnets))
 	return privSubnets, nil
 }
 
-func configureCluster(ctx context.Context, event cfn.Event) (physicalResourceId string, data map[string]interface{}, err error) {
+//creates a new revision of the given cluster configuration with the server properties supplied.
+//Note that kafka versions of a configuration are set when it is created and cannot be changed afterwards.
+func (k *MSKclient) updateConfig(ctx context.Context, configArn string, conf ClusterConfig, serverProps []byte) (int64, error) {
 
-	log.Println("Initializing....")
-	sess, err := session.NewSession()
+	param := kafka.UpdateConfigurationInput{
+		Arn:              aws.String(configArn),
+		Description:      aws.String(conf.Description),
+		ServerProperties: serverProps,
+	}
+
+	out, err := k.Client.UpdateConfigurationWithContext(ctx, &param)
 	if err != nil {
-		return "", nil, fmt.Errorf("unable to create a new session: %v", err)
+		if aerr, ok := err.(awserr.Error); ok {
+			return 0, fmt.Errorf("unable to update cluster configuration %s: %s-%v", configArn, aerr.Code(), aerr.Message())
+		}
+		return 0, fmt.Errorf("unable to update cluster configuration %s: %v", configArn, err)
 	}
-	mskApi := MSKclient{Client: kafka.New(sess)}
-	ec2Api := Ec2Client{Client: ec2.New(sess)}
-	vpcId := event.ResourceProperties["VpcId"].(string)
-	var config ClusterConfig
-	var serverProps string
 
-	switch event.RequestType {
-	case cfn.RequestCreate:
+	revision := aws.Int64Value(out.LatestRevision.Revision)
+	log.Printf("kafka cluster configuration %s is now at revision %d", configArn, revision)
+	return revision, nil
+}
 
-		log.Println("CREATE: creating MSK cluster configuration.")
-		log.Printf("event is :%+v\n", event)
+//fetches the latest revision of the given cluster configuration along with the kafka versions it was created with.
+func (k *MSKclient) latestRevision(ctx context.Context, configArn string) (int64, []string, error) {
 
-		cidr, err := ec2Api.vpcCidr(ctx, vpcId)
-		if err != nil {
-			return "", nil, err
-		}
-		log.Printf("cidr is :%s", cidr)
+	out, err := k.Client.DescribeConfigurationWithContext(ctx, &kafka.DescribeConfigurationInput{Arn: aws.String(configArn)})
+	if err != nil {
+		return 0, nil, fmt.Errorf("unable to describe cluster configuration %s: %v", configArn, err)
+	}
+	return aws.Int64Value(out.LatestRevision.Revision), aws.StringValueSlice(out.KafkaVersions), nil
+}
 
-		privSubs, err := ec2Api.privSubnets(ctx, vpcId)
-		if err != nil {
-			return "", nil, err
-		}
+//applies the given revision of the cluster configuration to an existing MSK cluster and waits for it to complete. A
+//cluster at that revision already is left as is.
+func (k *MSKclient) applyConfig(ctx context.Context, clusterArn string, configArn string, revision int64) error {
 
-		if conf, ok := event.ResourceProperties["ClusterConfig"].(map[string]interface{}); ok {
-			config.Name = conf["Name"].(string)
-			config.Description = conf["Description"].(string)
-			tempVers := conf["Kafka_Versions"].([]interface{})
-			for _, tempVer := range tempVers {
-				config.Kafka_Versions = append(config.Kafka_Versions, tempVer.(string))
-			}
+	cluster, err := mskcluster.Describe(ctx, k.Client, clusterArn)
+	if err != nil {
+		return err
+	}
+	if current := cluster.CurrentBrokerSoftwareInfo; current != nil &&
+		aws.StringValue(current.ConfigurationArn) == configArn && aws.Int64Value(current.ConfigurationRevision) == revision {
+		log.Printf("cluster %s is at revision %d of configuration %s already", clusterArn, revision, configArn)
+		return nil
+	}
 
-		} else {
-			return "", nil, fmt.Errorf("unable to convert event data to ClusterConfig")
+	param := kafka.UpdateClusterConfigurationInput{
+		ClusterArn:     aws.String(clusterArn),
+		CurrentVersion: cluster.CurrentVersion,
+		ConfigurationInfo: &kafka.ConfigurationInfo{
+			Arn:      aws.String(configArn),
+			Revision: aws.Int64(revision),
+		},
+	}
+
+	out, err := k.Client.UpdateClusterConfigurationWithContext(ctx, &param)
+	if err != nil {
+		return fmt.Errorf("unable to apply revision %d of configuration %s to cluster %s: %v", revision, configArn, clusterArn, err)
+	}
+
+	log.Printf("applying revision %d of configuration %s to cluster %s", revision, configArn, clusterArn)
+	return mskcluster.WaitForOperation(ctx, k.Client, aws.StringValue(out.ClusterOperationArn))
+}
+
+//deletes the cluster configuration. A configuration still in use by a cluster cannot be deleted, hence the deletion
+//is retried until the cluster lets go of it (e.g. is being deleted by the same stack) or the lambda function has to
+//respond to cloudformation.
+//A configuration that no longer exists is considered deleted.
+func (k *MSKclient) deleteConfig(ctx context.Context, configArn string) error {
+
+	param := kafka.DeleteConfigurationInput{Arn: aws.String(configArn)}
+	for {
+		_, err := k.Client.DeleteConfigurationWithContext(ctx, &param)
+		if err == nil {
+			break
 		}
 
-		if props, ok := event.ResourceProperties["ServerProperties"].([]interface{}); ok {
-			for _, prop := range props {
-				serverProps = serverProps + prop.(string) + "\n"
-			}
-		} else {
-			return "", nil, fmt.Errorf("unable to convert server properties to an array of string")
+		aerr, ok := err.(awserr.Error)
+		if !ok {
+			return fmt.Errorf("unable to delete configuration %s: %v", configArn, err)
+		}
+		switch aerr.Code() {
+		case kafka.ErrCodeNotFoundException:
+			log.Printf("configuration %s does not exist", configArn)
+			return nil
+		case kafka.ErrCodeBadRequestException, kafka.ErrCodeConflictException:
+			log.Printf("configuration %s can not be deleted yet, retrying: %s", configArn, aerr.Message())
+		default:
+			return fmt.Errorf("unable to delete configuration %s: %s", configArn, aerr.Message())
 		}
 
-		configArn, err := mskApi.createConfig(ctx, config, []byte(serverProps))
-		if err != nil {
-			return "", nil, fmt.Errorf("Unable to create MSK cluster config : %v", err)
+		if err := resource.Sleep(ctx, mskcluster.PollInterval); err != nil {
+			return fmt.Errorf("gave up deleting configuration %s: %s: %v", configArn, aerr.Message(), err)
 		}
+	}
 
-		var r []string
-		for _, subnet := range privSubs {
-			if subnet != "" {
-				r = append(r, subnet)
+	//deletion is asynchronous, wait for the configuration to go away.
+	for {
+		out, err := k.Client.DescribeConfigurationWithContext(ctx, &kafka.DescribeConfigurationInput{Arn: aws.String(configArn)})
+		if err != nil {
+			if aerr, ok := err.(awserr.Error); ok && aerr.Code() == kafka.ErrCodeNotFoundException {
+				log.Printf("configuration %s is deleted", configArn)
+				return nil
 			}
+			return fmt.Errorf("unable to describe configuration %s: %v", configArn, err)
 		}
 
-		data = map[string]interface{}{
-			"ConfigurationArn": configArn,
-			"VpcCidr":          cidr,
-			"PrivateSubnets":   strings.Join(r, ","),
+		state := aws.StringValue(out.State)
+		log.Printf("configuration %s is in state %s", configArn, state)
+		if state == kafka.ConfigurationStateDeleteFailed {
+			return fmt.Errorf("deletion of configuration %s failed", configArn)
 		}
-		log.Printf("data being returned is :%+v", data)
-		return configArn, data, nil
 
-	case cfn.RequestUpdate:
-		log.Println("UPDATE: update operation is not supported. Taking a clean exit...")
-		return
-	case cfn.RequestDelete:
-		log.Println("DELETE: delete operation is not supported. Taking a clean exit...")
-		return
+		if err := resource.Sleep(ctx, mskcluster.PollInterval); err != nil {
+			return fmt.Errorf("gave up waiting for configuration %s in state %s to be deleted: %v", configArn, state, err)
+		}
 	}
-	return
 }
 
-//The lambda takes the following sample input under ResourceProperties
+//The handler takes the following sample input under ResourceProperties
 //{
 // "VpcId":"vpc-23l4j2l3kj4"
-// "ClusterConfig":{ "Name":"mycustomconfig","Description":"sample desc","Kafka_Versions:["1.1.1","2.2.1"]}
+// "ClusterConfig":{ "Name":"mycustomconfig","Description":"sample desc","Kafka_Versions:["2.2.1","latest"]}
+// "ServerProperties":["auto.create.topics.enable=true","log.retention.hours=168"] or {"auto.create.topics.enable":"true","log.retention.hours":"168"}
+// "ServerPropertiesS3Uri":"optional. s3://mybucket/msk/server.properties, a server.properties file. ServerProperties take precedence over it."
+// "OneSubnetPerAz":"optional. true to return a single private subnet per availability zone e.g. for lambda placement"
+// "ClusterArn":"optional. arn of an existing MSK cluster to apply a new revision of the configuration to on update"
+// "RetainOnDelete":"optional. true to keep the cluster configuration when the resource is deleted"
 // }
 
-//the lambda function intends to return the following sample object as its response once it executes successfully.
+//the handler intends to return the following sample object as its response once it executes successfully.
 // {
 //	 "ConfigurationArn":"arn:aws:kafka:us-west-2:508718283261:configuration/krunal1/25815693-f755-47f3-873b-aaeb92dc25d8-3"
+//	 "Revision":1
+//	 "KafkaVersions":"2.2.1,3.5.1"
+//	 "KafkaVersion":"3.5.1"
 //	 "VpcCidr":"192.168.0.0/24"
-//   "PrivateSubnets":["subnet-031fdba3fb3045c3f","subnet-0b688e70c6c723bf3","subnet-0637a832d0ca08d3f"]
+//	 "VpcCidrs":"192.168.0.0/24,100.64.0.0/16"
+//	 "VpcIpv6Cidrs":"2600:1f14:abc:de00::/56"
+//   "PrivateSubnets":"subnet-031fdba3fb3045c3f,subnet-0b688e70c6c723bf3,subnet-0637a832d0ca08d3f"
 // }
-//ConfigurationArn is to be used while creating an MSK cluster.
+//ConfigurationArn and Revision are to be used while creating an MSK cluster. KafkaVersion is the newest of the resolved
+//KafkaVersions. With latest it follows the releases of MSK whenever a configuration is created, so a cluster using it as
+//its version could be upgraded by a stack update nobody asked for. Such clusters should rather pin their version.
 //VpcCidr is to be used conditionally to create a security group with ingress rules with cidr as the source range.
+//VpcCidrs and VpcIpv6Cidrs hold all the ipv4 (secondary included) and ipv6 cidrs of the vpc for the same purpose.
 //PrivateSubnets is to be used with the Custom Resource lambda function that will need private acccess to MSK cluster.
-func main() {
-	lambda.Start(cfn.LambdaWrap(configureCluster))
+type Handler struct{}
+
+var _ resource.Handler = Handler{}
+
+//aws service clients used by the handler.
+type clients struct {
+	msk MSKclient
+	ec2 Ec2Client
+	s3  S3client
+}
+
+//creates the aws service clients used by the handler.
+func newClients() (clients, error) {
+	sess, err := session.NewSession()
+	if err != nil {
+		return clients{}, fmt.Errorf("unable to create a new session: %v", err)
+	}
+	return clients{
+		msk: MSKclient{Client: kafka.New(sess)},
+		ec2: Ec2Client{Client: ec2.New(sess)},
+		s3:  S3client{Client: s3.New(sess)},
+	}, nil
+}
+
+//reads the cluster configuration from the given resource properties.
+func clusterConfig(properties map[string]interface{}) (ClusterConfig, error) {
+	var config ClusterConfig
+
+	if conf, ok := properties["ClusterConfig"].(map[string]interface{}); ok {
+		config.Name = conf["Name"].(string)
+		config.Description = conf["Description"].(string)
+		tempVers := conf["Kafka_Versions"].([]interface{})
+		for _, tempVer := range tempVers {
+			config.Kafka_Versions = append(config.Kafka_Versions, tempVer.(string))
+		}
+
+	} else {
+		return config, fmt.Errorf("unable to convert event data to ClusterConfig")
+	}
+	return config, nil
+}
+
+//reads the server properties supplied inline under ServerProperties, either as a list of key=value strings or as a
+//map of keys to values e.g. {"log.retention.hours":"168"}. ServerProperties may be left out if a file is supplied.
+func inlineServerProperties(properties map[string]interface{}) ([]byte, error) {
+	var serverProps string
+
+	switch props := properties["ServerProperties"].(type) {
+	case nil:
+		return nil, nil
+	case []interface{}:
+		for _, prop := range props {
+			p, ok := prop.(string)
+			if !ok {
+				return nil, fmt.Errorf("unable to convert server property %v to a string", prop)
+			}
+			serverProps = serverProps + p + "\n"
+		}
+	case map[string]interface{}:
+		return mapServerProperties(props), nil
+	default:
+		return nil, fmt.Errorf("unable to convert server properties to an array of string or a map")
+	}
+	return []byte(serverProps), nil
+}
+
+//reads the cluster configuration with its kafka versions resolved against the ones MSK offers and the normalized
+//server properties, fetching the server properties file given as ServerPropertiesS3Uri if any. Deprecated versions are
+//only accepted if they were among the Kafka_Versions of the previous properties, nil on create.
+func (c *clients) configuration(ctx context.Context, properties map[string]interface{}, previous map[string]interface{}) (ClusterConfig, []byte, error) {
+	config, err := clusterConfig(properties)
+	if err != nil {
+		return config, nil, err
+	}
+	var previousVersions []string
+	if previous != nil {
+		if previousConfig, err := clusterConfig(previous); err == nil {
+			previousVersions = previousConfig.Kafka_Versions
+		}
+	}
+
+	available, err := c.msk.kafkaVersions(ctx)
+	if err != nil {
+		return config, nil, err
+	}
+	config.Kafka_Versions, err = resolveKafkaVersions(config.Kafka_Versions, previousVersions, available)
+	if err != nil {
+		return config, nil, err
+	}
+	log.Printf("kafka versions resolved to %v", config.Kafka_Versions)
+
+	inlineProps, err := inlineServerProperties(properties)
+	if err != nil {
+		return config, nil, err
+	}
+
+	var fileProps []byte
+	uri, _ := properties["ServerPropertiesS3Uri"].(string)
+	if uri != "" {
+		fileProps, err = c.s3.serverPropertiesFile(ctx, uri)
+		if err != nil {
+			return config, nil, err
+		}
+	}
+
+	serverProps, err := checkServerProperties(fileProps, uri, inlineProps, config.Kafka_Versions)
+	if err != nil {
+		return config, nil, err
+	}
+	return config, serverProps, nil
+}
+
+//looks up the vpc cidr and the private subnets of the vpc to be returned as response data.
+func (e *Ec2Client) vpcDetails(ctx context.Context, vpcId string, onePerAz bool) (map[string]interface{}, error) {
+
+	cidrs, err := e.vpcCidr(ctx, vpcId)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("cidr is :%s, all ipv4 cidrs are :%v, ipv6 cidrs are :%v", cidrs.Cidr, cidrs.Ipv4, cidrs.Ipv6)
+
+	privSubs, err := e.privSubnets(ctx, vpcId, onePerAz)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"VpcCidr":        cidrs.Cidr,
+		"VpcCidrs":       strings.Join(cidrs.Ipv4, ","),
+		"VpcIpv6Cidrs":   strings.Join(cidrs.Ipv6, ","),
+		"PrivateSubnets": strings.Join(privSubs, ","),
+	}, nil
+}
+
+//reads an optional boolean property such as OneSubnetPerAz, false unless set to true.
+func flagProperty(properties map[string]interface{}, name string) bool {
+	b, _ := strconv.ParseBool(fmt.Sprint(properties[name]))
+	return b
+}
+
+//Create creates the MSK cluster configuration and looks up the vpc details required by the cluster.
+func (h Handler) Create(ctx context.Context, event cfn.Event) (physicalResourceId string, data map[string]interface{}, err error) {
+
+	log.Println("Initializing....")
+	c, err := newClients()
+	if err != nil {
+		return "", nil, err
+	}
+	vpcId := event.ResourceProperties["VpcId"].(string)
+
+	log.Println("CREATE: creating MSK cluster configuration.")
+	log.Printf("event is :%+v\n", event)
+
+	config, serverProps, err := c.configuration(ctx, event.ResourceProperties, event.OldResourceProperties)
+	if err != nil {
+		return "", nil, err
+	}
+
+	data, err = c.ec2.vpcDetails(ctx, vpcId, flagProperty(event.ResourceProperties, "OneSubnetPerAz"))
+	if err != nil {
+		return "", nil, err
+	}
+
+	configArn, revision, adopted, err := c.msk.createConfig(ctx, config, serverProps)
+	if err != nil {
+		return "", nil, fmt.Errorf("Unable to create MSK cluster config : %v", err)
+	}
+	if adopted {
+		log.Printf("configuration %s existed already. It is adopted and will not be deleted along with the resource.", configArn)
+	}
+
+	data["ConfigurationArn"] = configArn
+	data["Revision"] = revision
+	data["KafkaVersions"] = strings.Join(config.Kafka_Versions, ",")
+	data["KafkaVersion"] = newestKafkaVersion(config.Kafka_Versions)
+	log.Printf("data being returned is :%+v", data)
+	return configResourceId(configArn, adopted), data, nil
+}
+
+//Update creates a new revision of the cluster configuration when the server properties differ from the ones stored in
+//its latest revision, and optionally applies it to the cluster given as ClusterArn, as it does when ClusterArn changes.
+//A change of name creates a new
+//configuration instead. The kafka versions of a configuration cannot be changed by a revision, hence a change of
+//Kafka_Versions alone leaves the configuration as it is and the versions it was created with are returned.
+func (h Handler) Update(ctx context.Context, event cfn.Event) (physicalResourceId string, data map[string]interface{}, err error) {
+
+	log.Println("Initializing....")
+	c, err := newClients()
+	if err != nil {
+		return "", nil, err
+	}
+	vpcId := event.ResourceProperties["VpcId"].(string)
+
+	log.Println("UPDATE: updating MSK cluster configuration.")
+	log.Printf("event is :%+v\n", event)
+
+	config, serverProps, err := c.configuration(ctx, event.ResourceProperties, event.OldResourceProperties)
+	if err != nil {
+		return "", nil, err
+	}
+	oldConfig, err := clusterConfig(event.OldResourceProperties)
+	if err != nil {
+		return "", nil, err
+	}
+	//the versions as written in the template, so that latest resolving to a newer version is not taken as a change.
+	newConfig, err := clusterConfig(event.ResourceProperties)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if config.Name != oldConfig.Name {
+		log.Printf("configuration name changed from %s to %s. Creating a new configuration.", oldConfig.Name, config.Name)
+		return h.Create(ctx, event)
+	}
+
+	data, err = c.ec2.vpcDetails(ctx, vpcId, flagProperty(event.ResourceProperties, "OneSubnetPerAz"))
+	if err != nil {
+		return "", nil, err
+	}
+
+	configArn, adopted, err := c.msk.resourceConfig(ctx, event.PhysicalResourceID, config.Name)
+	if err != nil {
+		return "", nil, err
+	}
+	revision, versions, err := c.msk.latestRevision(ctx, configArn)
+	if err != nil {
+		return "", nil, err
+	}
+	stored, err := c.msk.serverProperties(ctx, configArn, revision)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if !reflect.DeepEqual(newConfig.Kafka_Versions, oldConfig.Kafka_Versions) {
+		log.Printf("kafka versions changed from %v to %v. Configuration %s keeps the versions %v it was created with, change its Name to create a configuration for the new ones.", oldConfig.Kafka_Versions, newConfig.Kafka_Versions, configArn, versions)
+	}
+	changed := !sameServerProperties(serverProps, stored)
+	if changed {
+		revision, err = c.msk.updateConfig(ctx, configArn, config, serverProps)
+		if err != nil {
+			return "", nil, err
+		}
+	} else {
+		log.Printf("server properties are unchanged. Staying at revision %d.", revision)
+	}
+
+	//a new cluster gets the configuration too, even if it did not change.
+	clusterArn, _ := event.ResourceProperties["ClusterArn"].(string)
+	oldClusterArn, _ := event.OldResourceProperties["ClusterArn"].(string)
+	if clusterArn != "" && (changed || clusterArn != oldClusterArn) {
+		err = c.msk.applyConfig(ctx, clusterArn, configArn, revision)
+		if err != nil {
+			return "", nil, err
+		}
+	}
+
+	data["ConfigurationArn"] = configArn
+	data["Revision"] = revision
+	data["KafkaVersions"] = strings.Join(versions, ",")
+	data["KafkaVersion"] = newestKafkaVersion(versions)
+	log.Printf("data being returned is :%+v", data)
+	return configResourceId(configArn, adopted), data, nil
+}
+
+//Delete deletes the cluster configuration which is the physical resource id, waiting for any cluster using it to let
+//go of it first, unless RetainOnDelete is set. A configuration adopted rather than created by the resource is retained.
+func (h Handler) Delete(ctx context.Context, event cfn.Event) (physicalResourceId string, data map[string]interface{}, err error) {
+
+	configArn, adopted := parseConfigResourceId(event.PhysicalResourceID)
+	if flagProperty(event.ResourceProperties, "RetainOnDelete") {
+		log.Printf("DELETE: retaining configuration %s", configArn)
+		return event.PhysicalResourceID, nil, nil
+	}
+	if adopted {
+		log.Printf("DELETE: retaining configuration %s, it existed before the resource adopted it", configArn)
+		return event.PhysicalResourceID, nil, nil
+	}
+	//a failed create leaves no configuration behind, and the configuration of a resource created by an earlier version of
+	//the handler, without a physical resource id, is treated as adopted.
+	if !strings.HasPrefix(configArn, "arn:") {
+		log.Printf("DELETE: %s is not a configuration arn. Retaining any configuration by the name of the resource...", configArn)
+		return configArn, nil, nil
+	}
+
+	c, err := newClients()
+	if err != nil {
+		return "", nil, err
+	}
+	if err := c.msk.deleteConfig(ctx, configArn); err != nil {
+		return "", nil, err
+	}
+	return event.PhysicalResourceID, nil, nil
 }
@@ -1,17 +1,26 @@
-package main
+package preprocesskafka
 
 import (
 	"context"
+	"github.com/aws/aws-lambda-go/cfn"
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
 	"github.com/aws/aws-sdk-go/service/kafka"
 	"github.com/aws/aws-sdk-go/service/kafka/kafkaiface"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/krunal4amity/cfn-infra/custom_resources/msk/mskcluster"
+	"github.com/krunal4amity/cfn-infra/custom_resources/resource"
 	"github.com/tj/assert"
+	"io/ioutil"
 	"os"
+	"strings"
 	"testing"
+	"time"
 )
 
 var (
@@ -28,24 +37,112 @@ log.roll.ms = 604800000
 
 type mockMsk struct {
 	kafkaiface.KafkaAPI
-	listResp   kafka.ListConfigurationsOutput
-	createResp kafka.CreateConfigurationOutput
+	listResp          kafka.ListConfigurationsOutput
+	nextListResp      kafka.ListConfigurationsOutput //returned when a NextToken is supplied
+	createResp        kafka.CreateConfigurationOutput
+	createErr         error
+	descRevResp       kafka.DescribeConfigurationRevisionOutput
+	updateResp        kafka.UpdateConfigurationOutput
+	descConfResp      kafka.DescribeConfigurationOutput
+	descClusterResp   kafka.DescribeClusterOutput
+	updateClusterResp kafka.UpdateClusterConfigurationOutput
+	opStates          []string //states returned by successive DescribeClusterOperation calls
+	updateClusterReq  *kafka.UpdateClusterConfigurationInput
+	deleteErrs        []error //errors returned by successive DeleteConfiguration calls before it succeeds
+	deleteCalls       int
+	descConfErr       error
+	versionsResp      kafka.ListKafkaVersionsOutput
+	nextVersionsResp  kafka.ListKafkaVersionsOutput //returned when a NextToken is supplied
+}
+
+type mockS3 struct {
+	s3iface.S3API
+	objects map[string]string //contents by bucket/key
 }
 
 type mockEc2 struct {
 	ec2iface.EC2API
-	descVpc ec2.DescribeVpcsOutput
-	descRt  ec2.DescribeRouteTablesOutput
+	descVpc     ec2.DescribeVpcsOutput
+	descRt      ec2.DescribeRouteTablesOutput
+	descSubnets ec2.DescribeSubnetsOutput
 }
 
 func (m *mockMsk) ListConfigurationsWithContext(ctx aws.Context, param *kafka.ListConfigurationsInput, opts ...request.Option) (*kafka.ListConfigurationsOutput, error) {
+	if param != nil && param.NextToken != nil {
+		return &m.nextListResp, nil
+	}
 	return &m.listResp, nil
 }
 
 func (m *mockMsk) CreateConfigurationWithContext(ctx aws.Context, param *kafka.CreateConfigurationInput, opts ...request.Option) (*kafka.CreateConfigurationOutput, error) {
+	if m.createErr != nil {
+		return nil, m.createErr
+	}
 	return &m.createResp, nil
 }
 
+func (m *mockMsk) DescribeConfigurationRevisionWithContext(ctx aws.Context, param *kafka.DescribeConfigurationRevisionInput, opts ...request.Option) (*kafka.DescribeConfigurationRevisionOutput, error) {
+	return &m.descRevResp, nil
+}
+
+func (m *mockMsk) UpdateConfigurationWithContext(ctx aws.Context, param *kafka.UpdateConfigurationInput, opts ...request.Option) (*kafka.UpdateConfigurationOutput, error) {
+	return &m.updateResp, nil
+}
+
+func (m *mockMsk) DescribeConfigurationWithContext(ctx aws.Context, param *kafka.DescribeConfigurationInput, opts ...request.Option) (*kafka.DescribeConfigurationOutput, error) {
+	if m.descConfErr != nil {
+		return nil, m.descConfErr
+	}
+	return &m.descConfResp, nil
+}
+
+func (m *mockMsk) ListKafkaVersionsWithContext(ctx aws.Context, param *kafka.ListKafkaVersionsInput, opts ...request.Option) (*kafka.ListKafkaVersionsOutput, error) {
+	if param != nil && param.NextToken != nil {
+		return &m.nextVersionsResp, nil
+	}
+	return &m.versionsResp, nil
+}
+
+func (m *mockMsk) DeleteConfigurationWithContext(ctx aws.Context, param *kafka.DeleteConfigurationInput, opts ...request.Option) (*kafka.DeleteConfigurationOutput, error) {
+	m.deleteCalls++
+	if len(m.deleteErrs) > 0 {
+		err := m.deleteErrs[0]
+		m.deleteErrs = m.deleteErrs[1:]
+		return nil, err
+	}
+	return &kafka.DeleteConfigurationOutput{Arn: param.Arn, State: aws.String(kafka.ConfigurationStateDeleting)}, nil
+}
+
+func (m *mockMsk) DescribeClusterWithContext(ctx aws.Context, param *kafka.DescribeClusterInput, opts ...request.Option) (*kafka.DescribeClusterOutput, error) {
+	return &m.descClusterResp, nil
+}
+
+func (m *mockMsk) UpdateClusterConfigurationWithContext(ctx aws.Context, param *kafka.UpdateClusterConfigurationInput, opts ...request.Option) (*kafka.UpdateClusterConfigurationOutput, error) {
+	m.updateClusterReq = param
+	return &m.updateClusterResp, nil
+}
+
+func (m *mockMsk) DescribeClusterOperationWithContext(ctx aws.Context, param *kafka.DescribeClusterOperationInput, opts ...request.Option) (*kafka.DescribeClusterOperationOutput, error) {
+	state := m.opStates[0]
+	if len(m.opStates) > 1 {
+		m.opStates = m.opStates[1:]
+	}
+	return &kafka.DescribeClusterOperationOutput{
+		ClusterOperationInfo: &kafka.ClusterOperationInfo{
+			OperationState: aws.String(state),
+			ErrorInfo:      &kafka.ErrorInfo{ErrorCode: aws.String("Failed"), ErrorString: aws.String("mocked failure")},
+		},
+	}, nil
+}
+
+func (m *mockS3) GetObjectWithContext(ctx aws.Context, param *s3.GetObjectInput, opts ...request.Option) (*s3.GetObjectOutput, error) {
+	content, ok := m.objects[aws.StringValue(param.Bucket)+"/"+aws.StringValue(param.Key)]
+	if !ok {
+		return nil, awserr.New(s3.ErrCodeNoSuchKey, "The specified key does not exist.", nil)
+	}
+	return &s3.GetObjectOutput{Body: ioutil.NopCloser(strings.NewReader(content))}, nil
+}
+
 func (m *mockEc2) DescribeVpcsWithContext(ctx aws.Context, param *ec2.DescribeVpcsInput, opts ...request.Option) (*ec2.DescribeVpcsOutput, error) {
 	return &m.descVpc, nil
 }
@@ -54,10 +151,17 @@ func (m *mockEc2) DescribeRouteTablesWithContext(ctx aws.Context, param *ec2.Des
 	return &m.descRt, nil
 }
 
+func (m *mockEc2) DescribeSubnetsWithContext(ctx aws.Context, param *ec2.DescribeSubnetsInput, opts ...request.Option) (*ec2.DescribeSubnetsOutput, error) {
+	return &m.descSubnets, nil
+}
+
 func Test_MockListMSKConfig(t *testing.T) {
 	cases := []struct {
-		Resp     kafka.ListConfigurationsOutput
-		Expected string
+		Resp             kafka.ListConfigurationsOutput
+		NextResp         kafka.ListConfigurationsOutput
+		Expected         string
+		ExpectedRevision int64
+		Err              bool
 	}{
 		{
 			Resp: kafka.ListConfigurationsOutput{
@@ -69,15 +173,57 @@ func Test_MockListMSKConfig(t *testing.T) {
 					},
 				},
 			},
-			Expected: "arn:aws:msk:us-west-2:1234567891:mymskconfig",
+			Expected:         "arn:aws:msk:us-west-2:1234567891:mymskconfig",
+			ExpectedRevision: 1,
+		},
+		{
+			//a revised configuration found on the second page
+			Resp: kafka.ListConfigurationsOutput{
+				Configurations: []*kafka.Configuration{
+					{
+						Name:           aws.String("SampleConfig10"),
+						Arn:            aws.String("arn:aws:msk:us-west-2:1234567891:othermskconfig"),
+						LatestRevision: &kafka.ConfigurationRevision{Revision: aws.Int64(1)},
+					},
+				},
+				NextToken: aws.String("page2"),
+			},
+			NextResp: kafka.ListConfigurationsOutput{
+				Configurations: []*kafka.Configuration{
+					{
+						Name:           aws.String("SampleConfig1"),
+						Arn:            aws.String("arn:aws:msk:us-west-2:1234567891:mymskconfig"),
+						LatestRevision: &kafka.ConfigurationRevision{Revision: aws.Int64(4)},
+					},
+				},
+			},
+			Expected:         "arn:aws:msk:us-west-2:1234567891:mymskconfig",
+			ExpectedRevision: 4,
+		},
+		{
+			Resp: kafka.ListConfigurationsOutput{
+				Configurations: []*kafka.Configuration{
+					{
+						Name:           aws.String("SampleConfig10"),
+						Arn:            aws.String("arn:aws:msk:us-west-2:1234567891:othermskconfig"),
+						LatestRevision: &kafka.ConfigurationRevision{Revision: aws.Int64(1)},
+					},
+				},
+			},
+			Err: true,
 		},
 	}
 
 	for _, c := range cases {
-		mskApi := MSKclient{Client: &mockMsk{listResp: c.Resp}}
-		arn, err := mskApi.listConfigurations(context.Background(), "SampleConfig1")
+		mskApi := MSKclient{Client: &mockMsk{listResp: c.Resp, nextListResp: c.NextResp}}
+		arn, revision, err := mskApi.listConfigurations(context.Background(), "SampleConfig1")
+		if c.Err {
+			assert.NotNil(t, err)
+			continue
+		}
 		assert.Nil(t, err)
 		assert.Equal(t, c.Expected, arn)
+		assert.Equal(t, c.ExpectedRevision, revision)
 	}
 }
 
@@ -98,17 +244,239 @@ func Test_MockCreateMSKConfig(t *testing.T) {
 
 	for _, c := range cases {
 		mskApi := MSKclient{Client: &mockMsk{createResp: c.Resp}}
-		arn, err := mskApi.createConfig(context.Background(), ClusterConfig{"SampleConfig1", "mymskconfig", []string{"1.1.1", "2.2.1"}}, serverProp)
+		arn, revision, adopted, err := mskApi.createConfig(context.Background(), ClusterConfig{"SampleConfig1", "mymskconfig", []string{"1.1.1", "2.2.1"}}, serverProp)
 		assert.Nil(t, err)
+		assert.False(t, adopted)
 		t.Log("mockCreateMSKConfig = arn is :", arn)
 		assert.Equal(t, c.Expected, arn)
+		assert.Equal(t, int64(1), revision)
+	}
+}
+
+func Test_MockCreateExistingMSKConfig(t *testing.T) {
+	list := kafka.ListConfigurationsOutput{
+		Configurations: []*kafka.Configuration{
+			{
+				Name:           aws.String("SampleConfig1"),
+				Arn:            aws.String("arn:aws:msk:us-west-2:1234567891:mymskconfig"),
+				LatestRevision: &kafka.ConfigurationRevision{Revision: aws.Int64(2)},
+			},
+		},
+	}
+	cases := []struct {
+		StoredProps      []byte
+		ExpectedRevision int64
+	}{
+		{
+			//same properties, formatted differently. The existing revision is reused.
+			StoredProps:      []byte("auto.create.topics.enable = true\nzookeeper.connection.timeout.ms = 2000\n\nlog.roll.ms = 604800000"),
+			ExpectedRevision: 2,
+		},
+		{
+			//changed properties. A new revision is created.
+			StoredProps:      []byte("auto.create.topics.enable = false"),
+			ExpectedRevision: 3,
+		},
+	}
+
+	for _, c := range cases {
+		mskApi := MSKclient{Client: &mockMsk{
+			createErr:   awserr.New(kafka.ErrCodeConflictException, "configuration exists", nil),
+			listResp:    list,
+			descRevResp: kafka.DescribeConfigurationRevisionOutput{ServerProperties: c.StoredProps},
+			updateResp:  kafka.UpdateConfigurationOutput{LatestRevision: &kafka.ConfigurationRevision{Revision: aws.Int64(3)}},
+		}}
+		arn, revision, adopted, err := mskApi.createConfig(context.Background(), ClusterConfig{"SampleConfig1", "mymskconfig", []string{"2.2.1"}}, serverProp)
+		assert.Nil(t, err)
+		assert.True(t, adopted)
+		assert.Equal(t, "arn:aws:msk:us-west-2:1234567891:mymskconfig", arn)
+		assert.Equal(t, c.ExpectedRevision, revision)
+	}
+}
+
+func Test_ConfigResourceId(t *testing.T) {
+	configArn := "arn:aws:kafka:us-west-2:1234567891:configuration/mymskconfig/abc-1"
+	for _, adopted := range []bool{false, true} {
+		arn, wasAdopted := parseConfigResourceId(configResourceId(configArn, adopted))
+		assert.Equal(t, configArn, arn)
+		assert.Equal(t, adopted, wasAdopted)
+	}
+	assert.Equal(t, configArn, configResourceId(configArn, false))
+}
+
+func Test_MockResourceConfig(t *testing.T) {
+	configArn := "arn:aws:kafka:us-west-2:1234567891:configuration/SampleConfig1/abc-1"
+	mskApi := MSKclient{Client: &mockMsk{listResp: kafka.ListConfigurationsOutput{Configurations: []*kafka.Configuration{
+		{Arn: aws.String(configArn), Name: aws.String("SampleConfig1"), LatestRevision: &kafka.ConfigurationRevision{Revision: aws.Int64(2)}},
+	}}}}
+
+	arn, adopted, err := mskApi.resourceConfig(context.Background(), configResourceId(configArn, false), "SampleConfig1")
+	assert.Nil(t, err)
+	assert.Equal(t, configArn, arn)
+	assert.False(t, adopted)
+
+	//the log stream name cloudformation used when earlier versions of the handler returned no physical resource id.
+	arn, adopted, err = mskApi.resourceConfig(context.Background(), "2021/03/01/[$LATEST]f68810de4c5544ad", "SampleConfig1")
+	assert.Nil(t, err)
+	assert.Equal(t, configArn, arn)
+	assert.True(t, adopted)
+
+	_, _, err = mskApi.resourceConfig(context.Background(), "2021/03/01/[$LATEST]f68810de4c5544ad", "SampleConfig2")
+	assert.NotNil(t, err)
+}
+
+func Test_MockDeleteAdoptedMSKConfig(t *testing.T) {
+	//an adopted configuration is retained without even creating clients.
+	id, _, err := Handler{}.Delete(context.Background(), cfn.Event{PhysicalResourceID: configResourceId("arn:aws:kafka:us-west-2:1234567891:configuration/shared/abc-1", true)})
+	assert.Nil(t, err)
+	assert.Equal(t, "arn:aws:kafka:us-west-2:1234567891:configuration/shared/abc-1#adopted", id)
+}
+
+func Test_MockUpdateMSKConfig(t *testing.T) {
+	cases := []struct {
+		Resp     kafka.UpdateConfigurationOutput
+		Expected int64
+	}{
+		{
+			Resp: kafka.UpdateConfigurationOutput{
+				Arn:            aws.String("arn:aws:msk:us-west-2:1234567891:mymskconfig"),
+				LatestRevision: &kafka.ConfigurationRevision{Revision: aws.Int64(3)},
+			},
+			Expected: 3,
+		},
+	}
+
+	for _, c := range cases {
+		mskApi := MSKclient{Client: &mockMsk{updateResp: c.Resp}}
+		revision, err := mskApi.updateConfig(context.Background(), "arn:aws:msk:us-west-2:1234567891:mymskconfig", ClusterConfig{"SampleConfig1", "mymskconfig", []string{"2.2.1"}}, serverProp)
+		assert.Nil(t, err)
+		assert.Equal(t, c.Expected, revision)
+	}
+}
+
+func Test_MockLatestRevision(t *testing.T) {
+	mskApi := MSKclient{Client: &mockMsk{descConfResp: kafka.DescribeConfigurationOutput{
+		KafkaVersions:  aws.StringSlice([]string{"2.2.1", "2.8.1"}),
+		LatestRevision: &kafka.ConfigurationRevision{Revision: aws.Int64(4)},
+	}}}
+	revision, versions, err := mskApi.latestRevision(context.Background(), "arn:aws:msk:us-west-2:1234567891:mymskconfig")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(4), revision)
+	assert.Equal(t, []string{"2.2.1", "2.8.1"}, versions)
+}
+
+func Test_MockApplyMSKConfig(t *testing.T) {
+	mskcluster.PollInterval = time.Millisecond
+	cases := []struct {
+		OpStates []string
+		Err      bool
+	}{
+		{OpStates: []string{"PENDING", "UPDATE_IN_PROGRESS", "UPDATE_COMPLETE"}},
+		{OpStates: []string{"UPDATE_IN_PROGRESS", "UPDATE_FAILED"}, Err: true},
+	}
+
+	for _, c := range cases {
+		mock := &mockMsk{
+			descClusterResp:   kafka.DescribeClusterOutput{ClusterInfo: &kafka.ClusterInfo{CurrentVersion: aws.String("K3AEGXETSR30VB")}},
+			updateClusterResp: kafka.UpdateClusterConfigurationOutput{ClusterOperationArn: aws.String("arn:aws:kafka:us-west-2:1234567891:cluster-operation/abc")},
+			opStates:          c.OpStates,
+		}
+		mskApi := MSKclient{Client: mock}
+		err := mskApi.applyConfig(context.Background(), "dummyClusterArn", "dummyConfigArn", 2)
+		if c.Err {
+			assert.NotNil(t, err)
+		} else {
+			assert.Nil(t, err)
+		}
+		assert.Equal(t, "K3AEGXETSR30VB", aws.StringValue(mock.updateClusterReq.CurrentVersion))
+		assert.Equal(t, int64(2), aws.Int64Value(mock.updateClusterReq.ConfigurationInfo.Revision))
+	}
+}
+
+func Test_MockApplyCurrentMSKConfig(t *testing.T) {
+	mock := &mockMsk{descClusterResp: kafka.DescribeClusterOutput{ClusterInfo: &kafka.ClusterInfo{
+		CurrentVersion: aws.String("K3AEGXETSR30VB"),
+		CurrentBrokerSoftwareInfo: &kafka.BrokerSoftwareInfo{
+			ConfigurationArn:      aws.String("dummyConfigArn"),
+			ConfigurationRevision: aws.Int64(2),
+		},
+	}}}
+	mskApi := MSKclient{Client: mock}
+	assert.Nil(t, mskApi.applyConfig(context.Background(), "dummyClusterArn", "dummyConfigArn", 2))
+	assert.Nil(t, mock.updateClusterReq)
+}
+
+func Test_MockDeleteMSKConfig(t *testing.T) {
+	mskcluster.PollInterval = time.Millisecond
+	inUse := awserr.New(kafka.ErrCodeBadRequestException, "Configuration is in use by one or more clusters.", nil)
+	notFound := awserr.New(kafka.ErrCodeNotFoundException, "The configuration does not exist.", nil)
+	cases := []struct {
+		DeleteErrs  []error
+		DescConf    kafka.DescribeConfigurationOutput
+		DescConfErr error
+		Calls       int
+		Err         string
+	}{
+		{DescConfErr: notFound, Calls: 1},
+		{DeleteErrs: []error{inUse, inUse}, DescConfErr: notFound, Calls: 3},
+		{DeleteErrs: []error{notFound}, Calls: 1},
+		{DeleteErrs: []error{awserr.New(kafka.ErrCodeForbiddenException, "access denied", nil)}, Calls: 1, Err: "unable to delete configuration"},
+		{DescConf: kafka.DescribeConfigurationOutput{State: aws.String(kafka.ConfigurationStateDeleteFailed)}, Calls: 1, Err: "deletion of configuration dummyConfigArn failed"},
+	}
+
+	for _, c := range cases {
+		mock := &mockMsk{deleteErrs: c.DeleteErrs, descConfResp: c.DescConf, descConfErr: c.DescConfErr}
+		mskApi := MSKclient{Client: mock}
+		err := mskApi.deleteConfig(context.Background(), "dummyConfigArn")
+		if c.Err != "" {
+			assert.NotNil(t, err)
+			assert.Contains(t, err.Error(), c.Err)
+		} else {
+			assert.Nil(t, err)
+		}
+		assert.Equal(t, c.Calls, mock.deleteCalls)
+	}
+
+	//gives up on a configuration that stays in use in time to respond to cloudformation
+	ctx, cancel := context.WithTimeout(context.Background(), resource.ResponseMargin)
+	defer cancel()
+	mock := &mockMsk{deleteErrs: []error{inUse}}
+	err := (&MSKclient{Client: mock}).deleteConfig(ctx, "dummyConfigArn")
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "gave up deleting configuration")
+	assert.Contains(t, err.Error(), resource.ErrOutOfTime.Error())
+}
+
+func Test_MockServerPropertiesFile(t *testing.T) {
+	cases := []struct {
+		Uri      string
+		Expected string
+		Err      bool
+	}{
+		{Uri: "s3://mybucket/msk/server.properties", Expected: "num.partitions=5\n"},
+		{Uri: "s3://mybucket/msk/missing.properties", Err: true},
+		{Uri: "https://mybucket/msk/server.properties", Err: true},
+		{Uri: "s3://mybucket", Err: true},
+	}
+
+	for _, c := range cases {
+		s3Api := S3client{Client: &mockS3{objects: map[string]string{"mybucket/msk/server.properties": "num.partitions=5\n"}}}
+		out, err := s3Api.serverPropertiesFile(context.Background(), c.Uri)
+		if c.Err {
+			assert.NotNil(t, err)
+			continue
+		}
+		assert.Nil(t, err)
+		assert.Equal(t, c.Expected, string(out))
 	}
 }
 
 func Test_MockDescribeVpc(t *testing.T) {
+	associated := &ec2.VpcCidrBlockState{State: aws.String(ec2.VpcCidrBlockStateCodeAssociated)}
 	cases := []struct {
 		Resp     ec2.DescribeVpcsOutput
-		Expected string
+		Expected vpcCidrs
+		Err      bool
 	}{
 		{
 			Resp: ec2.DescribeVpcsOutput{
@@ -119,57 +487,142 @@ func Test_MockDescribeVpc(t *testing.T) {
 					},
 				},
 			},
-			Expected: "10.0.0.0/12",
+			Expected: vpcCidrs{Cidr: "10.0.0.0/12", Ipv4: []string{"10.0.0.0/12"}},
+		},
+		{
+			//secondary and ipv6 cidrs, disassociated blocks are left out
+			Resp: ec2.DescribeVpcsOutput{
+				Vpcs: []*ec2.Vpc{
+					{
+						VpcId:     aws.String("vpc-5678"),
+						CidrBlock: aws.String("172.16.0.0/16"),
+					},
+					{
+						VpcId:     aws.String("vpc-1234"),
+						CidrBlock: aws.String("10.0.0.0/16"),
+						CidrBlockAssociationSet: []*ec2.VpcCidrBlockAssociation{
+							{CidrBlock: aws.String("10.0.0.0/16"), CidrBlockState: associated},
+							{CidrBlock: aws.String("100.64.0.0/16"), CidrBlockState: associated},
+							{CidrBlock: aws.String("100.65.0.0/16"), CidrBlockState: &ec2.VpcCidrBlockState{State: aws.String(ec2.VpcCidrBlockStateCodeDisassociated)}},
+						},
+						Ipv6CidrBlockAssociationSet: []*ec2.VpcIpv6CidrBlockAssociation{
+							{Ipv6CidrBlock: aws.String("2600:1f14:abc:de00::/56"), Ipv6CidrBlockState: associated},
+						},
+					},
+				},
+			},
+			Expected: vpcCidrs{Cidr: "10.0.0.0/16", Ipv4: []string{"10.0.0.0/16", "100.64.0.0/16"}, Ipv6: []string{"2600:1f14:abc:de00::/56"}},
+		},
+		{
+			Resp: ec2.DescribeVpcsOutput{Vpcs: []*ec2.Vpc{{VpcId: aws.String("vpc-5678"), CidrBlock: aws.String("172.16.0.0/16")}}},
+			Err:  true,
 		},
 	}
 
 	for _, c := range cases {
 		ec2Api := Ec2Client{Client: &mockEc2{descVpc: c.Resp}}
-		vpcId, err := ec2Api.vpcCidr(context.Background(), "vpc-1234")
-		t.Log("vpc id: ", vpcId)
-		assert.Nil(t, err)
-		for _, vpc := range c.Resp.Vpcs {
-			assert.Equal(t, c.Expected, aws.StringValue(vpc.CidrBlock))
+		cidrs, err := ec2Api.vpcCidr(context.Background(), "vpc-1234")
+		if c.Err {
+			assert.NotNil(t, err)
+			continue
 		}
+		assert.Nil(t, err)
+		assert.Equal(t, c.Expected, cidrs)
 	}
 }
 
 func Test_MockDescribeRouteTables(t *testing.T) {
 
+	subnets := ec2.DescribeSubnetsOutput{
+		Subnets: []*ec2.Subnet{
+			{SubnetId: aws.String("sub-pub"), AvailabilityZone: aws.String("us-west-2a")},
+			{SubnetId: aws.String("sub-2b"), AvailabilityZone: aws.String("us-west-2b")},
+			{SubnetId: aws.String("sub-2a"), AvailabilityZone: aws.String("us-west-2a")},
+			{SubnetId: aws.String("sub-main"), AvailabilityZone: aws.String("us-west-2a")},
+		},
+	}
+	public := &ec2.RouteTable{
+		RouteTableId: aws.String("rt-pub"),
+		Routes: []*ec2.Route{
+			{DestinationCidrBlock: aws.String("192.168.0.0/16"), GatewayId: aws.String("local")},
+			{DestinationCidrBlock: aws.String("0.0.0.0/0"), GatewayId: aws.String("igw-1234")},
+		},
+		Associations: []*ec2.RouteTableAssociation{{SubnetId: aws.String("sub-pub")}},
+	}
+	//the main route table without egress, and a private one for route under test
+	routeTables := func(main *ec2.Route, route *ec2.Route) ec2.DescribeRouteTablesOutput {
+		return ec2.DescribeRouteTablesOutput{
+			RouteTables: []*ec2.RouteTable{
+				public,
+				{
+					RouteTableId: aws.String("rt-main"),
+					Routes:       []*ec2.Route{main},
+					Associations: []*ec2.RouteTableAssociation{{Main: aws.Bool(true)}},
+				},
+				{
+					RouteTableId: aws.String("rt-priv"),
+					Routes:       []*ec2.Route{route},
+					Associations: []*ec2.RouteTableAssociation{{SubnetId: aws.String("sub-2a")}, {SubnetId: aws.String("sub-2b")}},
+				},
+			},
+		}
+	}
+	local := &ec2.Route{DestinationCidrBlock: aws.String("192.168.0.0/16"), GatewayId: aws.String("local")}
+
 	cases := []struct {
+		Name     string
 		Resp     ec2.DescribeRouteTablesOutput
+		OnePerAz bool
 		Expected []string
 	}{
 		{
-			Resp: ec2.DescribeRouteTablesOutput{
-				RouteTables: []*ec2.RouteTable{
-					{
-						RouteTableId: aws.String("rt-1234"),
-						VpcId:        aws.String("vpc-1234"),
-						Routes: []*ec2.Route{
-							{
-								NatGatewayId: aws.String("nat-1234"),
-							},
-						},
-						Associations: []*ec2.RouteTableAssociation{
-							{
-								SubnetId:     aws.String("sub-1234"),
-								RouteTableId: aws.String("rt-1234"),
-							},
-						},
-					},
-				},
-			},
-			Expected: []string{"sub-1234"},
+			Name:     "nat gateway",
+			Resp:     routeTables(local, &ec2.Route{DestinationCidrBlock: aws.String("0.0.0.0/0"), NatGatewayId: aws.String("nat-1234")}),
+			Expected: []string{"sub-2a", "sub-2b"},
+		},
+		{
+			Name:     "nat instance",
+			Resp:     routeTables(local, &ec2.Route{DestinationCidrBlock: aws.String("0.0.0.0/0"), InstanceId: aws.String("i-1234")}),
+			Expected: []string{"sub-2a", "sub-2b"},
+		},
+		{
+			Name:     "transit gateway",
+			Resp:     routeTables(local, &ec2.Route{DestinationCidrBlock: aws.String("0.0.0.0/0"), TransitGatewayId: aws.String("tgw-1234")}),
+			Expected: []string{"sub-2a", "sub-2b"},
+		},
+		{
+			Name:     "egress only internet gateway",
+			Resp:     routeTables(local, &ec2.Route{DestinationIpv6CidrBlock: aws.String("::/0"), EgressOnlyInternetGatewayId: aws.String("eigw-1234")}),
+			Expected: []string{"sub-2a", "sub-2b"},
+		},
+		{
+			Name:     "blackhole",
+			Resp:     routeTables(local, &ec2.Route{DestinationCidrBlock: aws.String("0.0.0.0/0"), NatGatewayId: aws.String("nat-1234"), State: aws.String(ec2.RouteStateBlackhole)}),
+			Expected: nil,
+		},
+		{
+			Name:     "main table inheritance",
+			Resp:     routeTables(&ec2.Route{DestinationCidrBlock: aws.String("0.0.0.0/0"), NatGatewayId: aws.String("nat-1234")}, local),
+			Expected: []string{"sub-main"},
+		},
+		{
+			Name:     "sorted by az",
+			Resp:     routeTables(&ec2.Route{DestinationCidrBlock: aws.String("0.0.0.0/0"), NatGatewayId: aws.String("nat-1234")}, &ec2.Route{DestinationCidrBlock: aws.String("0.0.0.0/0"), NatGatewayId: aws.String("nat-5678")}),
+			Expected: []string{"sub-2a", "sub-main", "sub-2b"},
+		},
+		{
+			Name:     "one per az",
+			Resp:     routeTables(&ec2.Route{DestinationCidrBlock: aws.String("0.0.0.0/0"), NatGatewayId: aws.String("nat-1234")}, &ec2.Route{DestinationCidrBlock: aws.String("0.0.0.0/0"), NatGatewayId: aws.String("nat-5678")}),
+			OnePerAz: true,
+			Expected: []string{"sub-2a", "sub-2b"},
 		},
 	}
 
 	for _, c := range cases {
-		ec2Api := Ec2Client{Client: &mockEc2{descRt: c.Resp}}
-		rts, err := ec2Api.privSubnets(context.Background(), "vpc-1234")
-		t.Log("subnets: ", rts)
-		assert.Nil(t, err)
-		//assert.Equal(t,c.Expected,rts)
+		ec2Api := Ec2Client{Client: &mockEc2{descRt: c.Resp, descSubnets: subnets}}
+		rts, err := ec2Api.privSubnets(context.Background(), "vpc-1234", c.OnePerAz)
+		assert.Nil(t, err, c.Name)
+		assert.Equal(t, c.Expected, rts, c.Name)
 	}
 }
 
@@ -201,23 +654,23 @@ func TestMSKMethods(t *testing.T) {
 
 	t.Run("checkMSKClusterConfig", func(t *testing.T) {
 		ctx := context.Background()
-		configArn, err := mskapi.createConfig(ctx, config, serverProp)
+		configArn, _, _, err := mskapi.createConfig(ctx, config, serverProp)
 		assert.Nil(t, err)
 		assert.NotZero(t, configArn)
 
-		configArn, err = mskapi.createConfig(ctx, config, serverProp)
+		configArn, _, _, err = mskapi.createConfig(ctx, config, serverProp)
 		assert.Nil(t, err)
 		assert.NotZero(t, configArn)
 	})
 
 	t.Run("getPrivateSubnets", func(t *testing.T) {
 		ctx := context.Background()
-		privSubnets, err := ec2api.privSubnets(ctx, vpcId)
+		privSubnets, err := ec2api.privSubnets(ctx, vpcId, false)
 		assert.Nil(t, err)
 		assert.NotZero(t, privSubnets)
 
 		//second check
-		privSubnets, err = ec2api.privSubnets(ctx, vpcId)
+		privSubnets, err = ec2api.privSubnets(ctx, vpcId, false)
 		assert.Nil(t, err)
 		assert.NotZero(t, privSubnets)
 	})
@@ -0,0 +1,363 @@
+package preprocesskafka
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//a single key=value entry of a server.properties file along with where it was found.
+type serverProperty struct {
+	Key    string
+	Value  string
+	Line   int
+	Source string //where the property came from e.g. ServerProperties or the s3 uri of a file. empty if not known.
+}
+
+//where the property was found, to be used in error messages.
+func (p serverProperty) location() string {
+	if p.Source == "" {
+		return fmt.Sprintf("line %d", p.Line)
+	}
+	return fmt.Sprintf("%s line %d", p.Source, p.Line)
+}
+
+//kind of value a server property takes
+type propertyType int
+
+const (
+	boolProperty   propertyType = iota //true or false
+	intProperty                        //32 bit integer
+	longProperty                       //64 bit integer
+	doubleProperty                     //floating point number
+	enumProperty                       //one of the Values
+	listProperty                       //comma separated list of the Values
+	stringProperty                     //any non empty string e.g. a class name
+)
+
+//describes a server property that MSK allows to be set in a cluster configuration.
+type propertySpec struct {
+	Type        propertyType
+	Min         float64           //inclusive lower bound of numeric properties. numeric values are non-negative by default.
+	Max         float64           //inclusive upper bound of numeric properties. 0 means the upper bound of the type itself.
+	Values      []string          //allowed values of enum and list properties
+	ValuesSince map[string]string //first kafka version supporting a value, for values not available in all versions.
+	Since       string            //first kafka version supporting the property. empty if supported by all MSK versions.
+}
+
+//server properties MSK allows in a custom configuration along with their value types and ranges.
+//Available at https://docs.aws.amazon.com/msk/latest/developerguide/msk-configuration-properties.html
+var serverPropertyCatalog = map[string]propertySpec{
+	"allow.everyone.if.no.acl.found":            {Type: boolProperty},
+	"auto.create.topics.enable":                 {Type: boolProperty},
+	"compression.type":                          {Type: enumProperty, Values: []string{"gzip", "snappy", "lz4", "zstd", "uncompressed", "producer"}, ValuesSince: map[string]string{"zstd": "2.1.0"}},
+	"connections.max.idle.ms":                   {Type: longProperty},
+	"default.replication.factor":                {Type: intProperty, Min: 1},
+	"delete.topic.enable":                       {Type: boolProperty},
+	"group.initial.rebalance.delay.ms":          {Type: intProperty},
+	"group.max.session.timeout.ms":              {Type: intProperty},
+	"group.min.session.timeout.ms":              {Type: intProperty},
+	"leader.imbalance.per.broker.percentage":    {Type: intProperty},
+	"log.cleaner.delete.retention.ms":           {Type: longProperty},
+	"log.cleaner.max.compaction.lag.ms":         {Type: longProperty, Min: 1, Since: "2.3.1"},
+	"log.cleaner.min.cleanable.ratio":           {Type: doubleProperty, Max: 1},
+	"log.cleaner.min.compaction.lag.ms":         {Type: longProperty},
+	"log.cleanup.policy":                        {Type: listProperty, Values: []string{"delete", "compact"}},
+	"log.flush.interval.messages":               {Type: longProperty, Min: 1},
+	"log.flush.interval.ms":                     {Type: longProperty},
+	"log.message.timestamp.difference.max.ms":   {Type: longProperty},
+	"log.message.timestamp.type":                {Type: enumProperty, Values: []string{"CreateTime", "LogAppendTime"}},
+	"log.retention.bytes":                       {Type: longProperty, Min: -1},
+	"log.retention.hours":                       {Type: intProperty},
+	"log.retention.minutes":                     {Type: intProperty},
+	"log.retention.ms":                          {Type: longProperty, Min: -1},
+	"log.roll.ms":                               {Type: longProperty, Min: 1},
+	"log.segment.bytes":                         {Type: intProperty, Min: 14},
+	"max.incremental.fetch.session.cache.slots": {Type: intProperty},
+	"message.max.bytes":                         {Type: intProperty},
+	"min.insync.replicas":                       {Type: intProperty, Min: 1},
+	"num.io.threads":                            {Type: intProperty, Min: 1},
+	"num.network.threads":                       {Type: intProperty, Min: 1},
+	"num.partitions":                            {Type: intProperty, Min: 1},
+	"num.recovery.threads.per.data.dir":         {Type: intProperty, Min: 1},
+	"num.replica.fetchers":                      {Type: intProperty, Min: 1},
+	"offsets.retention.minutes":                 {Type: intProperty, Min: 1},
+	"offsets.topic.replication.factor":          {Type: intProperty, Min: 1, Max: math.MaxInt16},
+	"replica.fetch.max.bytes":                   {Type: intProperty},
+	"replica.fetch.response.max.bytes":          {Type: intProperty},
+	"replica.lag.time.max.ms":                   {Type: longProperty},
+	"replica.selector.class":                    {Type: stringProperty, Since: "2.4.1"},
+	"socket.receive.buffer.bytes":               {Type: intProperty, Min: -1},
+	"socket.request.max.bytes":                  {Type: intProperty, Min: 1},
+	"socket.send.buffer.bytes":                  {Type: intProperty, Min: -1},
+	"transaction.max.timeout.ms":                {Type: intProperty, Min: 1},
+	"transaction.state.log.min.isr":             {Type: intProperty, Min: 1},
+	"transaction.state.log.replication.factor":  {Type: intProperty, Min: 1, Max: math.MaxInt16},
+	"transactional.id.expiration.ms":            {Type: intProperty, Min: 1},
+	"unclean.leader.election.enable":            {Type: boolProperty},
+	"zookeeper.connection.timeout.ms":           {Type: intProperty},
+	"zookeeper.session.timeout.ms":              {Type: intProperty},
+	"zookeeper.set.acl":                         {Type: boolProperty},
+}
+
+//parses the contents of a server.properties file. Blank lines and comments starting with # or ! are skipped and
+//whitespace around keys and values is dropped, so that "key = value" and "key=value" are the same property.
+func parseServerProperties(props []byte) ([]serverProperty, error) {
+	var parsed []serverProperty
+	var errs []string
+	seen := make(map[string]int)
+
+	for i, line := range strings.Split(string(props), "\n") {
+		lineNum := i + 1
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "!") {
+			continue
+		}
+
+		idx := strings.Index(line, "=")
+		if idx < 0 {
+			errs = append(errs, fmt.Sprintf("line %d: expected key=value but found %q", lineNum, line))
+			continue
+		}
+		key := strings.TrimSpace(line[:idx])
+		value := strings.TrimSpace(line[idx+1:])
+		if key == "" {
+			errs = append(errs, fmt.Sprintf("line %d: missing property name in %q", lineNum, line))
+			continue
+		}
+		if strings.ContainsAny(key, " \t") {
+			errs = append(errs, fmt.Sprintf("line %d: property name %q contains whitespace", lineNum, key))
+			continue
+		}
+		if first, ok := seen[key]; ok {
+			errs = append(errs, fmt.Sprintf("line %d: property %s already set at line %d", lineNum, key, first))
+			continue
+		}
+		seen[key] = lineNum
+		parsed = append(parsed, serverProperty{Key: key, Value: value, Line: lineNum})
+	}
+
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return parsed, nil
+}
+
+//renders the server properties back in server.properties syntax, one key=value per line.
+func renderServerProperties(props []serverProperty) []byte {
+	var b strings.Builder
+	for _, prop := range props {
+		b.WriteString(prop.Key + "=" + prop.Value + "\n")
+	}
+	return []byte(b.String())
+}
+
+//renders a map of server properties such as {"log.retention.hours":"168"} in server.properties syntax, sorted by key.
+func mapServerProperties(props map[string]interface{}) []byte {
+	var keys []string
+	for key := range props {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for _, key := range keys {
+		b.WriteString(fmt.Sprintf("%s=%v\n", key, props[key]))
+	}
+	return []byte(b.String())
+}
+
+//merges two sets of server properties, the overrides taking precedence over the base ones, and sorts the result by
+//key. Sorting makes identical properties render identically whatever order or form they were supplied in.
+func mergeServerProperties(base []serverProperty, overrides []serverProperty) []serverProperty {
+	merged := make(map[string]serverProperty)
+	for _, prop := range base {
+		merged[prop.Key] = prop
+	}
+	for _, prop := range overrides {
+		merged[prop.Key] = prop
+	}
+
+	var r []serverProperty
+	for _, prop := range merged {
+		r = append(r, prop)
+	}
+	sort.Slice(r, func(i, j int) bool { return r[i].Key < r[j].Key })
+	return r
+}
+
+//validates the server properties against the catalog of properties MSK allows, for every kafka version the
+//configuration is meant for. All the offending lines are reported at once.
+func validateServerProperties(props []serverProperty, kafkaVersions []string) error {
+	var errs []string
+
+	for _, prop := range props {
+		spec, ok := serverPropertyCatalog[prop.Key]
+		if !ok {
+			msg := fmt.Sprintf("%s: %s is not a property MSK allows", prop.location(), prop.Key)
+			if suggestion := closestProperty(prop.Key); suggestion != "" {
+				msg = msg + fmt.Sprintf(", did you mean %s?", suggestion)
+			}
+			errs = append(errs, msg)
+			continue
+		}
+
+		for _, version := range kafkaVersions {
+			if spec.Since != "" && compareKafkaVersions(version, spec.Since) < 0 {
+				errs = append(errs, fmt.Sprintf("%s: %s requires kafka version %s or later, but the configuration is for %s", prop.location(), prop.Key, spec.Since, version))
+			}
+		}
+
+		if err := spec.validate(prop.Value, kafkaVersions); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s: %v", prop.location(), prop.Key, err))
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+//validates a single value against the type, range and allowed values of the property.
+func (s propertySpec) validate(value string, kafkaVersions []string) error {
+	if value == "" {
+		return fmt.Errorf("missing value")
+	}
+
+	switch s.Type {
+	case boolProperty:
+		if value != "true" && value != "false" {
+			return fmt.Errorf("%q is not a boolean, expected true or false", value)
+		}
+	case intProperty, longProperty:
+		bitSize := 32
+		if s.Type == longProperty {
+			bitSize = 64
+		}
+		n, err := strconv.ParseInt(value, 10, bitSize)
+		if err != nil {
+			return fmt.Errorf("%q is not a %d bit integer", value, bitSize)
+		}
+		return s.checkRange(float64(n), value)
+	case doubleProperty:
+		n, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", value)
+		}
+		return s.checkRange(n, value)
+	case enumProperty:
+		return s.checkValue(value, kafkaVersions)
+	case listProperty:
+		for _, item := range strings.Split(value, ",") {
+			if err := s.checkValue(strings.TrimSpace(item), kafkaVersions); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+//checks a numeric value against the bounds of the property.
+func (s propertySpec) checkRange(n float64, value string) error {
+	if n < s.Min {
+		return fmt.Errorf("%s is less than the minimum of %v", value, s.Min)
+	}
+	if s.Max != 0 && n > s.Max {
+		return fmt.Errorf("%s is greater than the maximum of %v", value, s.Max)
+	}
+	return nil
+}
+
+//checks an enum or list item against the allowed values of the property and the kafka versions they need.
+func (s propertySpec) checkValue(value string, kafkaVersions []string) error {
+	for _, allowed := range s.Values {
+		if value != allowed {
+			continue
+		}
+		if since, ok := s.ValuesSince[value]; ok {
+			for _, version := range kafkaVersions {
+				if compareKafkaVersions(version, since) < 0 {
+					return fmt.Errorf("%s requires kafka version %s or later, but the configuration is for %s", value, since, version)
+				}
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("%q is not one of %s", value, strings.Join(s.Values, ","))
+}
+
+//compares two kafka versions such as 2.2.1 and 2.4.1.tiered numerically, part by part. Non numeric parts are ignored.
+//returns a negative number, zero or a positive number if a is older than, same as or newer than b.
+func compareKafkaVersions(a, b string) int {
+	parts := func(version string) []int {
+		var r []int
+		for _, part := range strings.Split(version, ".") {
+			n, err := strconv.Atoi(part)
+			if err != nil {
+				break
+			}
+			r = append(r, n)
+		}
+		return r
+	}
+
+	pa, pb := parts(a), parts(b)
+	for i := 0; i < len(pa) || i < len(pb); i++ {
+		var x, y int
+		if i < len(pa) {
+			x = pa[i]
+		}
+		if i < len(pb) {
+			y = pb[i]
+		}
+		if x != y {
+			return x - y
+		}
+	}
+	return 0
+}
+
+//finds the catalog property closest to a misspelled one, if any is close enough to be a likely typo.
+func closestProperty(key string) string {
+	best, bestDistance := "", 4
+	for candidate := range serverPropertyCatalog {
+		if d := editDistance(key, candidate); d < bestDistance || (d == bestDistance && candidate < best) {
+			best, bestDistance = candidate, d
+		}
+	}
+	return best
+}
+
+//levenshtein distance between two strings
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min3(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
@@ -0,0 +1,191 @@
+package preprocesskafka
+
+import (
+	"github.com/tj/assert"
+	"strings"
+	"testing"
+)
+
+func Test_ParseServerProperties(t *testing.T) {
+	cases := []struct {
+		Props    string
+		Expected []serverProperty
+		Err      string
+	}{
+		{
+			Props: "delete.topic.enable=true\nnum.partitions = 5\n",
+			Expected: []serverProperty{
+				{Key: "delete.topic.enable", Value: "true", Line: 1},
+				{Key: "num.partitions", Value: "5", Line: 2},
+			},
+		},
+		{
+			Props: "# retention\n\n  log.retention.hours=168  \n! roll\nlog.roll.ms=604800000",
+			Expected: []serverProperty{
+				{Key: "log.retention.hours", Value: "168", Line: 3},
+				{Key: "log.roll.ms", Value: "604800000", Line: 5},
+			},
+		},
+		{Props: "num.partitions=5\nlog.retention.hours", Err: "line 2: expected key=value"},
+		{Props: "=5", Err: "line 1: missing property name"},
+		{Props: "log retention.hours=5", Err: "line 1: property name \"log retention.hours\" contains whitespace"},
+		{Props: "num.partitions=5\nnum.partitions=6", Err: "line 2: property num.partitions already set at line 1"},
+	}
+
+	for _, c := range cases {
+		props, err := parseServerProperties([]byte(c.Props))
+		if c.Err != "" {
+			assert.NotNil(t, err)
+			assert.Contains(t, err.Error(), c.Err)
+			continue
+		}
+		assert.Nil(t, err)
+		assert.Equal(t, c.Expected, props)
+	}
+}
+
+func Test_ValidateServerProperties(t *testing.T) {
+	cases := []struct {
+		Props    string
+		Versions []string
+		Err      []string
+	}{
+		{
+			//the properties in 2000-msk.yml
+			Props:    "delete.topic.enable=true\nnum.partitions=5\nlog.retention.hours=168\nlog.segment.bytes=1073741824\nzookeeper.connection.timeout.ms=6000\nunclean.leader.election.enable=true",
+			Versions: []string{"1.1.1", "2.2.1"},
+		},
+		{Props: "log.retention.hour=168", Versions: []string{"2.2.1"}, Err: []string{"line 1: log.retention.hour is not a property MSK allows, did you mean log.retention.hours?"}},
+		{Props: "broker.id=1", Versions: []string{"2.2.1"}, Err: []string{"line 1: broker.id is not a property MSK allows"}},
+		{Props: "auto.create.topics.enable=yes", Versions: []string{"2.2.1"}, Err: []string{"line 1: auto.create.topics.enable: \"yes\" is not a boolean"}},
+		{Props: "num.partitions=0", Versions: []string{"2.2.1"}, Err: []string{"line 1: num.partitions: 0 is less than the minimum of 1"}},
+		{Props: "num.partitions=five", Versions: []string{"2.2.1"}, Err: []string{"\"five\" is not a 32 bit integer"}},
+		{Props: "log.segment.bytes=4294967296", Versions: []string{"2.2.1"}, Err: []string{"is not a 32 bit integer"}},
+		{Props: "log.retention.ms=-1", Versions: []string{"2.2.1"}},
+		{Props: "log.retention.ms=-2", Versions: []string{"2.2.1"}, Err: []string{"-2 is less than the minimum of -1"}},
+		{Props: "log.cleaner.min.cleanable.ratio=0.5", Versions: []string{"2.2.1"}},
+		{Props: "log.cleaner.min.cleanable.ratio=1.5", Versions: []string{"2.2.1"}, Err: []string{"1.5 is greater than the maximum of 1"}},
+		{Props: "log.message.timestamp.type=LogAppendTime", Versions: []string{"2.2.1"}},
+		{Props: "log.message.timestamp.type=AppendTime", Versions: []string{"2.2.1"}, Err: []string{"\"AppendTime\" is not one of CreateTime,LogAppendTime"}},
+		{Props: "log.cleanup.policy=compact,delete", Versions: []string{"2.2.1"}},
+		{Props: "log.cleanup.policy=compact,purge", Versions: []string{"2.2.1"}, Err: []string{"\"purge\" is not one of delete,compact"}},
+		{Props: "compression.type=zstd", Versions: []string{"2.2.1"}},
+		{Props: "compression.type=zstd", Versions: []string{"1.1.1", "2.2.1"}, Err: []string{"zstd requires kafka version 2.1.0 or later, but the configuration is for 1.1.1"}},
+		{Props: "replica.selector.class=org.apache.kafka.common.replica.RackAwareReplicaSelector", Versions: []string{"2.4.1.1"}},
+		{Props: "replica.selector.class=org.apache.kafka.common.replica.RackAwareReplicaSelector", Versions: []string{"2.2.1"}, Err: []string{"replica.selector.class requires kafka version 2.4.1 or later"}},
+		{Props: "min.insync.replicas=", Versions: []string{"2.2.1"}, Err: []string{"min.insync.replicas: missing value"}},
+		{
+			//every offending line is reported
+			Props:    "num.partitions=5\nlog.retention.hour=168\ndelete.topic.enable=1",
+			Versions: []string{"2.2.1"},
+			Err:      []string{"line 2: log.retention.hour", "line 3: delete.topic.enable"},
+		},
+	}
+
+	for _, c := range cases {
+		props, err := parseServerProperties([]byte(c.Props))
+		assert.Nil(t, err)
+		err = validateServerProperties(props, c.Versions)
+		if len(c.Err) == 0 {
+			assert.Nil(t, err, c.Props)
+			continue
+		}
+		assert.NotNil(t, err, c.Props)
+		for _, e := range c.Err {
+			assert.Contains(t, err.Error(), e)
+		}
+	}
+}
+
+func Test_CheckServerProperties(t *testing.T) {
+	cases := []struct {
+		File     string
+		Inline   []byte
+		Expected string
+		Err      string
+	}{
+		{
+			//sorted by key and stripped of whitespace
+			Inline:   serverProp,
+			Expected: "auto.create.topics.enable=true\nlog.roll.ms=604800000\nzookeeper.connection.timeout.ms=2000\n",
+		},
+		{
+			//the same properties as a map render identically to the list above
+			Inline: mapServerProperties(map[string]interface{}{
+				"zookeeper.connection.timeout.ms": "2000",
+				"auto.create.topics.enable":       "true",
+				"log.roll.ms":                     "604800000",
+			}),
+			Expected: "auto.create.topics.enable=true\nlog.roll.ms=604800000\nzookeeper.connection.timeout.ms=2000\n",
+		},
+		{
+			//inline properties take precedence over the file's
+			File:     "# from s3\nnum.partitions=3\nlog.retention.hours=72\n",
+			Inline:   []byte("num.partitions=5\n"),
+			Expected: "log.retention.hours=72\nnum.partitions=5\n",
+		},
+		{File: "num.partitions=5\nlog.retention.hour=168", Err: "invalid server properties: s3://bucket/server.properties line 2: log.retention.hour"},
+		{Inline: []byte("num.partitions"), Err: "unable to parse server properties: ServerProperties: line 1: expected key=value"},
+		{Err: "no server properties supplied"},
+	}
+
+	for _, c := range cases {
+		out, err := checkServerProperties([]byte(c.File), "s3://bucket/server.properties", c.Inline, []string{"2.2.1"})
+		if c.Err != "" {
+			assert.NotNil(t, err)
+			assert.True(t, strings.HasPrefix(err.Error(), c.Err), err.Error())
+			continue
+		}
+		assert.Nil(t, err)
+		assert.Equal(t, c.Expected, string(out))
+	}
+}
+
+func Test_InlineServerProperties(t *testing.T) {
+	cases := []struct {
+		Props    map[string]interface{}
+		Expected string
+		Err      bool
+	}{
+		{Props: map[string]interface{}{}, Expected: ""},
+		{Props: map[string]interface{}{"ServerProperties": []interface{}{"num.partitions=5", "delete.topic.enable=true"}}, Expected: "num.partitions=5\ndelete.topic.enable=true\n"},
+		{Props: map[string]interface{}{"ServerProperties": map[string]interface{}{"num.partitions": "5", "delete.topic.enable": true}}, Expected: "delete.topic.enable=true\nnum.partitions=5\n"},
+		{Props: map[string]interface{}{"ServerProperties": "num.partitions=5"}, Err: true},
+		{Props: map[string]interface{}{"ServerProperties": []interface{}{5}}, Err: true},
+	}
+
+	for _, c := range cases {
+		out, err := inlineServerProperties(c.Props)
+		if c.Err {
+			assert.NotNil(t, err)
+			continue
+		}
+		assert.Nil(t, err)
+		assert.Equal(t, c.Expected, string(out))
+	}
+}
+
+func Test_CompareKafkaVersions(t *testing.T) {
+	cases := []struct {
+		A, B     string
+		Expected int
+	}{
+		{"2.2.1", "2.2.1", 0},
+		{"1.1.1", "2.1.0", -1},
+		{"2.4.1.1", "2.4.1", 1},
+		{"2.8.2.tiered", "2.8.2", 0},
+		{"3.5.1", "2.4.1", 1},
+	}
+
+	for _, c := range cases {
+		d := compareKafkaVersions(c.A, c.B)
+		switch {
+		case c.Expected < 0:
+			assert.True(t, d < 0, c.A+" < "+c.B)
+		case c.Expected > 0:
+			assert.True(t, d > 0, c.A+" > "+c.B)
+		default:
+			assert.Equal(t, 0, d, c.A+" == "+c.B)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package preprocesskafka
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"sort"
+	"strings"
+)
+
+//a subnet of the vpc along with its availability zone
+type subnet struct {
+	Id string
+	Az string
+}
+
+//returns the subnets whose route table sends internet bound traffic through private egress i.e. a NAT gateway,
+//a NAT instance, a transit gateway or an egress only internet gateway, sorted by availability zone and subnet id.
+//A subnet without an explicit route table association uses the main route table of the vpc.
+func privateSubnets(routeTables []*ec2.RouteTable, subnets []*ec2.Subnet) []subnet {
+	var mainTable *ec2.RouteTable
+	tableOf := make(map[string]*ec2.RouteTable)
+	for _, routeTable := range routeTables {
+		for _, association := range routeTable.Associations {
+			if aws.BoolValue(association.Main) {
+				mainTable = routeTable
+			} else if association.SubnetId != nil {
+				tableOf[aws.StringValue(association.SubnetId)] = routeTable
+			}
+		}
+	}
+
+	var private []subnet
+	for _, s := range subnets {
+		routeTable, ok := tableOf[aws.StringValue(s.SubnetId)]
+		if !ok {
+			routeTable = mainTable
+		}
+		if routeTable != nil && hasPrivateEgress(routeTable) {
+			private = append(private, subnet{Id: aws.StringValue(s.SubnetId), Az: aws.StringValue(s.AvailabilityZone)})
+		}
+	}
+
+	sort.Slice(private, func(i, j int) bool {
+		if private[i].Az != private[j].Az {
+			return private[i].Az < private[j].Az
+		}
+		return private[i].Id < private[j].Id
+	})
+	return private
+}
+
+//whether the route table has an active default route through private egress and none through an internet gateway.
+func hasPrivateEgress(routeTable *ec2.RouteTable) bool {
+	private := false
+	for _, route := range routeTable.Routes {
+		if aws.StringValue(route.State) == ec2.RouteStateBlackhole {
+			continue
+		}
+		if aws.StringValue(route.DestinationCidrBlock) != "0.0.0.0/0" && aws.StringValue(route.DestinationIpv6CidrBlock) != "::/0" {
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(aws.StringValue(route.GatewayId), "igw-"):
+			if aws.StringValue(route.DestinationCidrBlock) == "0.0.0.0/0" {
+				return false //public subnet
+			}
+		case route.NatGatewayId != nil, route.InstanceId != nil, route.TransitGatewayId != nil, route.EgressOnlyInternetGatewayId != nil:
+			private = true
+		}
+	}
+	return private
+}
+
+//picks the first subnet of each availability zone out of subnets sorted by availability zone.
+func subnetPerAz(subnets []subnet) []subnet {
+	var r []subnet
+	for _, s := range subnets {
+		if len(r) == 0 || r[len(r)-1].Az != s.Az {
+			r = append(r, s)
+		}
+	}
+	return r
+}
@@ -0,0 +1,133 @@
+package preprocesskafka
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/kafka"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//alias of Kafka_Versions resolving to the newest active version offered by MSK.
+const latestKafkaVersion = "latest"
+
+//lists the kafka versions offered by MSK across all pages.
+func (k *MSKclient) kafkaVersions(ctx context.Context) ([]*kafka.KafkaVersion, error) {
+
+	var versions []*kafka.KafkaVersion
+	param := kafka.ListKafkaVersionsInput{}
+	for {
+		out, err := k.Client.ListKafkaVersionsWithContext(ctx, &param)
+		if err != nil {
+			if aerr, ok := err.(awserr.Error); ok {
+				return nil, fmt.Errorf("unable to list kafka versions: %s", aerr.Message())
+			}
+			return nil, fmt.Errorf("unable to list kafka versions: %v", err)
+		}
+		versions = append(versions, out.KafkaVersions...)
+		if aws.StringValue(out.NextToken) == "" {
+			break
+		}
+		param.NextToken = out.NextToken
+	}
+	return versions, nil
+}
+
+//resolves the requested kafka versions against the ones offered by MSK. The latest alias resolves to the newest
+//active version. Unknown versions, and deprecated versions that were not among the previous ones, are reported all at
+//once along with the valid ones. A cluster running a version MSK has since deprecated keeps it that way.
+func resolveKafkaVersions(requested []string, previous []string, available []*kafka.KafkaVersion) ([]string, error) {
+
+	status := make(map[string]string)
+	var active []string
+	for _, v := range available {
+		version := aws.StringValue(v.Version)
+		status[version] = aws.StringValue(v.Status)
+		if status[version] == kafka.KafkaVersionStatusActive {
+			active = append(active, version)
+		}
+	}
+	sort.Slice(active, func(i, j int) bool {
+		if d := compareKafkaVersions(active[i], active[j]); d != 0 {
+			return d < 0
+		}
+		return active[i] < active[j]
+	})
+
+	if len(requested) == 0 {
+		return nil, fmt.Errorf("no Kafka_Versions supplied, valid versions are %s", strings.Join(active, ","))
+	}
+
+	kept := make(map[string]bool)
+	for _, version := range previous {
+		kept[version] = true
+	}
+
+	var resolved []string
+	var errs []string
+	seen := make(map[string]bool)
+	for _, version := range requested {
+		if strings.EqualFold(version, latestKafkaVersion) {
+			version = newestRelease(active)
+			if version == "" {
+				errs = append(errs, "latest: MSK offers no active kafka version")
+				continue
+			}
+		}
+
+		switch status[version] {
+		case kafka.KafkaVersionStatusActive:
+		case kafka.KafkaVersionStatusDeprecated:
+			if !kept[version] {
+				errs = append(errs, fmt.Sprintf("%s: kafka version is deprecated", version))
+				continue
+			}
+			log.Printf("kafka version %s is deprecated by MSK, keeping it as it was supplied before", version)
+		default:
+			errs = append(errs, fmt.Sprintf("%s: kafka version is not offered by MSK", version))
+			continue
+		}
+
+		if !seen[version] {
+			seen[version] = true
+			resolved = append(resolved, version)
+		}
+	}
+
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("invalid Kafka_Versions: %s. valid versions are %s", strings.Join(errs, "; "), strings.Join(active, ","))
+	}
+	return resolved, nil
+}
+
+//the newest of the sorted versions which is a plain release i.e. made of numbers only, such as 2.8.1 and not 2.8.2.tiered.
+func newestRelease(sorted []string) string {
+	for i := len(sorted) - 1; i >= 0; i-- {
+		release := true
+		for _, part := range strings.Split(sorted[i], ".") {
+			if _, err := strconv.Atoi(part); err != nil {
+				release = false
+				break
+			}
+		}
+		if release {
+			return sorted[i]
+		}
+	}
+	return ""
+}
+
+//the newest of the given versions, to be used as the kafka version of a cluster using the configuration.
+func newestKafkaVersion(versions []string) string {
+	var newest string
+	for _, version := range versions {
+		if newest == "" || compareKafkaVersions(version, newest) > 0 {
+			newest = version
+		}
+	}
+	return newest
+}
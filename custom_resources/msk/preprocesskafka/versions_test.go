@@ -0,0 +1,87 @@
+package preprocesskafka
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kafka"
+	"github.com/tj/assert"
+	"testing"
+)
+
+func kafkaVersion(version, status string) *kafka.KafkaVersion {
+	return &kafka.KafkaVersion{Version: aws.String(version), Status: aws.String(status)}
+}
+
+func Test_MockListKafkaVersions(t *testing.T) {
+	mock := &mockMsk{
+		versionsResp: kafka.ListKafkaVersionsOutput{
+			KafkaVersions: []*kafka.KafkaVersion{kafkaVersion("2.2.1", kafka.KafkaVersionStatusDeprecated)},
+			NextToken:     aws.String("next"),
+		},
+		nextVersionsResp: kafka.ListKafkaVersionsOutput{
+			KafkaVersions: []*kafka.KafkaVersion{kafkaVersion("3.5.1", kafka.KafkaVersionStatusActive)},
+		},
+	}
+	mskApi := MSKclient{Client: mock}
+	versions, err := mskApi.kafkaVersions(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, []*kafka.KafkaVersion{kafkaVersion("2.2.1", kafka.KafkaVersionStatusDeprecated), kafkaVersion("3.5.1", kafka.KafkaVersionStatusActive)}, versions)
+}
+
+func Test_ResolveKafkaVersions(t *testing.T) {
+	available := []*kafka.KafkaVersion{
+		kafkaVersion("1.1.1", kafka.KafkaVersionStatusDeprecated),
+		kafkaVersion("2.2.1", kafka.KafkaVersionStatusDeprecated),
+		kafkaVersion("2.8.1", kafka.KafkaVersionStatusActive),
+		kafkaVersion("3.5.1", kafka.KafkaVersionStatusActive),
+		kafkaVersion("3.10.0", kafka.KafkaVersionStatusActive),
+		kafkaVersion("3.10.0.tiered", kafka.KafkaVersionStatusActive),
+	}
+
+	cases := []struct {
+		Requested []string
+		Previous  []string
+		Expected  []string
+		Err       []string
+	}{
+		{Requested: []string{"2.8.1", "3.5.1"}, Expected: []string{"2.8.1", "3.5.1"}},
+		{Requested: []string{"latest"}, Expected: []string{"3.10.0"}},
+		{Requested: []string{"3.10.0", "LATEST"}, Expected: []string{"3.10.0"}},
+		{Requested: []string{"3.10.0.tiered"}, Expected: []string{"3.10.0.tiered"}},
+		{
+			Requested: []string{"1.1.1", "2.2.1", "9.9.9"},
+			Err: []string{
+				"1.1.1: kafka version is deprecated",
+				"2.2.1: kafka version is deprecated",
+				"9.9.9: kafka version is not offered by MSK",
+				"valid versions are 2.8.1,3.5.1,3.10.0,3.10.0.tiered",
+			},
+		},
+		{Requested: nil, Err: []string{"no Kafka_Versions supplied"}},
+		//a version deprecated since it was supplied is kept, newly added deprecated ones are still refused.
+		{Requested: []string{"2.2.1", "2.8.1"}, Previous: []string{"2.2.1"}, Expected: []string{"2.2.1", "2.8.1"}},
+		{Requested: []string{"1.1.1", "2.2.1"}, Previous: []string{"2.2.1"}, Err: []string{"1.1.1: kafka version is deprecated"}},
+	}
+
+	for _, c := range cases {
+		versions, err := resolveKafkaVersions(c.Requested, c.Previous, available)
+		if len(c.Err) > 0 {
+			assert.NotNil(t, err)
+			for _, e := range c.Err {
+				assert.Contains(t, err.Error(), e)
+			}
+			continue
+		}
+		assert.Nil(t, err)
+		assert.Equal(t, c.Expected, versions)
+	}
+
+	_, err := resolveKafkaVersions([]string{"latest"}, nil, []*kafka.KafkaVersion{kafkaVersion("2.2.1", kafka.KafkaVersionStatusDeprecated)})
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "MSK offers no active kafka version")
+}
+
+func Test_NewestKafkaVersion(t *testing.T) {
+	assert.Equal(t, "3.10.0", newestKafkaVersion([]string{"2.8.1", "3.10.0", "3.5.1"}))
+	assert.Equal(t, "", newestKafkaVersion(nil))
+}
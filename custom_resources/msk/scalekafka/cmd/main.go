@@ -0,0 +1,12 @@
+package main
+
+import (
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/krunal4amity/cfn-infra/custom_resources/msk/scalekafka"
+	"github.com/krunal4amity/cfn-infra/custom_resources/resource"
+)
+
+//lambda function serving only the KafkaScaling custom resource. Packaged as scalekafka.zip
+func main() {
+	lambda.Start(resource.LambdaWrap(resource.Serve(scalekafka.Handler{})))
+}
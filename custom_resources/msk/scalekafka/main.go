@@ -0,0 +1,241 @@
+package scalekafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-lambda-go/cfn"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/kafka"
+	"github.com/aws/aws-sdk-go/service/kafka/kafkaiface"
+	"github.com/krunal4amity/cfn-infra/custom_resources/msk/mskcluster"
+	"github.com/krunal4amity/cfn-infra/custom_resources/resource"
+	"log"
+	"strconv"
+	"strings"
+)
+
+//MSK client
+type MSKclient struct {
+	Client kafkaiface.KafkaAPI
+}
+
+//the largest broker volume MSK offers, in GiB.
+const maxVolumeSize = 16384
+
+//the desired size of the cluster.
+type clusterSize struct {
+	BrokersPerAz int64 //brokers in each availability zone i.e. client subnet of the cluster
+	VolumeSize   int64 //ebs volume size of each broker in GiB
+}
+
+//the current state of the cluster.
+type clusterState struct {
+	CurrentVersion string
+	State          string
+	Azs            int64
+	BrokerCount    int64
+	VolumeSize     int64
+}
+
+//reads and validates the desired size given under ResourceProperties.
+func desiredSize(properties map[string]interface{}) (clusterSize, error) {
+	var errs []string
+	number := func(name string, min, max int64) int64 {
+		n, err := strconv.ParseInt(fmt.Sprint(properties[name]), 10, 64)
+		if err != nil || n < min || n > max {
+			errs = append(errs, fmt.Sprintf("%s must be a number between %d and %d, got %v", name, min, max, properties[name]))
+		}
+		return n
+	}
+
+	size := clusterSize{
+		BrokersPerAz: number("BrokersPerAz", 1, 100),
+		VolumeSize:   number("VolumeSize", 1, maxVolumeSize),
+	}
+	if _, ok := properties["ClusterArn"].(string); !ok {
+		errs = append(errs, "missing ClusterArn")
+	}
+	if len(errs) > 0 {
+		return clusterSize{}, fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return size, nil
+}
+
+//finds out the current state of the cluster.
+func (m *MSKclient) clusterState(ctx context.Context, clusterArn string) (clusterState, error) {
+
+	info, err := mskcluster.Describe(ctx, m.Client, clusterArn)
+	if err != nil {
+		return clusterState{}, err
+	}
+	return newClusterState(info), nil
+}
+
+//the state of the cluster as described.
+func newClusterState(info *kafka.ClusterInfo) clusterState {
+	state := clusterState{
+		CurrentVersion: aws.StringValue(info.CurrentVersion),
+		State:          aws.StringValue(info.State),
+		BrokerCount:    aws.Int64Value(info.NumberOfBrokerNodes),
+	}
+	if group := info.BrokerNodeGroupInfo; group != nil {
+		state.Azs = int64(len(group.ClientSubnets))
+		if group.StorageInfo != nil && group.StorageInfo.EbsStorageInfo != nil {
+			state.VolumeSize = aws.Int64Value(group.StorageInfo.EbsStorageInfo.VolumeSize)
+		}
+	}
+	return state
+}
+
+//brings the cluster to the desired size. The broker count is updated first and the storage afterwards, each waited upon,
+//since MSK runs a single operation on a cluster at a time. Storage can only grow, which is checked before anything is
+//updated. Returns the final state of the cluster, or an error wrapping resource.ErrOutOfTime while an operation is still
+//in progress.
+//Scaling picks up where it left off: an operation in progress, e.g. the one started by a previous invocation, is waited
+//upon first and the steps already done are skipped.
+func (m *MSKclient) scale(ctx context.Context, clusterArn string, size clusterSize) (clusterState, error) {
+
+	info, err := mskcluster.WaitForOperationInProgress(ctx, m.Client, clusterArn)
+	if err != nil {
+		return clusterState{}, err
+	}
+	state := newClusterState(info)
+	if state.State != kafka.ClusterStateActive {
+		return clusterState{}, fmt.Errorf("cluster %s is %s, it can only be scaled when %s", clusterArn, state.State, kafka.ClusterStateActive)
+	}
+	if size.VolumeSize < state.VolumeSize {
+		return clusterState{}, fmt.Errorf("broker storage of cluster %s cannot shrink from %d GiB to %d GiB", clusterArn, state.VolumeSize, size.VolumeSize)
+	}
+	if state.Azs == 0 {
+		return clusterState{}, fmt.Errorf("cluster %s has no client subnets", clusterArn)
+	}
+
+	brokers := size.BrokersPerAz * state.Azs
+	if brokers != state.BrokerCount {
+		log.Printf("updating the broker count of cluster %s from %d to %d", clusterArn, state.BrokerCount, brokers)
+		out, err := m.Client.UpdateBrokerCountWithContext(ctx, &kafka.UpdateBrokerCountInput{
+			ClusterArn:                aws.String(clusterArn),
+			CurrentVersion:            aws.String(state.CurrentVersion),
+			TargetNumberOfBrokerNodes: aws.Int64(brokers),
+		})
+		if err != nil {
+			return clusterState{}, fmt.Errorf("unable to update the broker count of cluster %s to %d : %v", clusterArn, brokers, err)
+		}
+		err = mskcluster.WaitForOperation(ctx, m.Client, aws.StringValue(out.ClusterOperationArn))
+		if err != nil {
+			return clusterState{}, err
+		}
+		//every operation moves the cluster to a new version.
+		state, err = m.clusterState(ctx, clusterArn)
+		if err != nil {
+			return clusterState{}, err
+		}
+	}
+
+	if size.VolumeSize > state.VolumeSize {
+		log.Printf("updating the broker storage of cluster %s from %d GiB to %d GiB", clusterArn, state.VolumeSize, size.VolumeSize)
+		out, err := m.Client.UpdateBrokerStorageWithContext(ctx, &kafka.UpdateBrokerStorageInput{
+			ClusterArn:     aws.String(clusterArn),
+			CurrentVersion: aws.String(state.CurrentVersion),
+			TargetBrokerEBSVolumeInfo: []*kafka.BrokerEBSVolumeInfo{{
+				KafkaBrokerNodeId: aws.String("All"),
+				VolumeSizeGB:      aws.Int64(size.VolumeSize),
+			}},
+		})
+		if err != nil {
+			return clusterState{}, fmt.Errorf("unable to update the broker storage of cluster %s to %d GiB : %v", clusterArn, size.VolumeSize, err)
+		}
+		err = mskcluster.WaitForOperation(ctx, m.Client, aws.StringValue(out.ClusterOperationArn))
+		if err != nil {
+			return clusterState{}, err
+		}
+		state, err = m.clusterState(ctx, clusterArn)
+		if err != nil {
+			return clusterState{}, err
+		}
+	}
+	return state, nil
+}
+
+//this handler accepts inputs under ResourceProperties as shown below.
+//{
+// "ClusterArn":"arn:aws:kafka:us-west-2:508718283261:cluster/mm/f68810de-4c55-44ad-929c-1fe3b91e4f6b-3",
+// "BrokersPerAz":"2" (the cluster gets BrokersPerAz times the number of its client subnets brokers)
+// "VolumeSize":"500" (GiB, can only grow)
+//}
+//The handler returns the following response (sample output), the state of the cluster once scaled.
+//{
+//		"BrokerCount":"6",
+//		"VolumeSize":"500",
+//		"State":"ACTIVE",
+//		"CurrentVersion":"K3AEGXETSR30VB"
+//}
+//Cluster operations easily outlast a lambda function. When the function is about to time out, the request is continued
+//in a new invocation which waits for the operation in progress and carries on, so the function has to be served with
+//resource.LambdaWrap and be allowed to invoke itself.
+type Handler struct{}
+
+var _ resource.Handler = Handler{}
+
+//Create brings the cluster to the desired size.
+func (h Handler) Create(ctx context.Context, event cfn.Event) (physicalResourceId string, data map[string]interface{}, err error) {
+
+	log.Println("CREATE: scaling kafka cluster.")
+	log.Printf("event data :%+v\n", event)
+
+	return scale(ctx, event)
+}
+
+//Update brings the cluster to the new desired size.
+func (h Handler) Update(ctx context.Context, event cfn.Event) (physicalResourceId string, data map[string]interface{}, err error) {
+
+	log.Println("UPDATE: scaling kafka cluster.")
+	log.Printf("event data :%+v\n", event)
+
+	return scale(ctx, event)
+}
+
+//Delete leaves the cluster as is, brokers and storage are deleted along with the cluster.
+func (h Handler) Delete(ctx context.Context, event cfn.Event) (physicalResourceId string, data map[string]interface{}, err error) {
+
+	log.Printf("event data :%+v\n", event)
+	log.Println("DELETE: leaving the brokers and storage of the cluster as they are.")
+
+	return event.PhysicalResourceID, nil, nil
+}
+
+//scales the cluster of the event, returning its final state as response data.
+func scale(ctx context.Context, event cfn.Event) (physicalResourceId string, data map[string]interface{}, err error) {
+
+	size, err := desiredSize(event.ResourceProperties)
+	if err != nil {
+		return "", nil, err
+	}
+	clusterArn := event.ResourceProperties["ClusterArn"].(string)
+
+	sess, err := session.NewSession()
+	if err != nil {
+		return "", nil, fmt.Errorf("unable to create a new session: %v", err)
+	}
+	mskapi := MSKclient{Client: kafka.New(sess)}
+
+	state, err := mskapi.scale(ctx, clusterArn, size)
+	if errors.Is(err, resource.ErrOutOfTime) {
+		log.Printf("cluster %s is still being scaled.", clusterArn)
+		return "", nil, resource.Continue(ctx, event)
+	}
+	if err != nil {
+		return "", nil, err
+	}
+
+	data = map[string]interface{}{
+		"BrokerCount":    strconv.FormatInt(state.BrokerCount, 10),
+		"VolumeSize":     strconv.FormatInt(state.VolumeSize, 10),
+		"State":          state.State,
+		"CurrentVersion": state.CurrentVersion,
+	}
+	log.Printf("data to be returned is :%v\n", data)
+	return clusterArn + "/scaling", data, nil
+}
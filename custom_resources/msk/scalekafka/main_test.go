@@ -0,0 +1,209 @@
+package scalekafka
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/kafka"
+	"github.com/aws/aws-sdk-go/service/kafka/kafkaiface"
+	"github.com/krunal4amity/cfn-infra/custom_resources/msk/mskcluster"
+	"github.com/krunal4amity/cfn-infra/custom_resources/resource"
+	"github.com/tj/assert"
+	"testing"
+	"time"
+)
+
+// a cluster changing its size once an operation on it completes.
+type mockMSK struct {
+	kafkaiface.KafkaAPI
+	state    string
+	azs      int
+	brokers  int64
+	volume   int64
+	version  int
+	pending  func() //the change of the operation in progress
+	running  string //arn of the operation in progress when the cluster is UPDATING
+	polls    int    //polls of each operation before it completes
+	left     int    //polls left of the operation in progress
+	failOp   bool
+	calls    []string
+	versions []string //CurrentVersion given with each update
+}
+
+func (m *mockMSK) DescribeClusterWithContext(aws.Context, *kafka.DescribeClusterInput, ...request.Option) (*kafka.DescribeClusterOutput, error) {
+	var subnets []*string
+	for i := 0; i < m.azs; i++ {
+		subnets = append(subnets, aws.String("subnet"))
+	}
+	return &kafka.DescribeClusterOutput{ClusterInfo: &kafka.ClusterInfo{
+		CurrentVersion:      aws.String(string(rune('A' + m.version))),
+		State:               aws.String(m.state),
+		NumberOfBrokerNodes: aws.Int64(m.brokers),
+		BrokerNodeGroupInfo: &kafka.BrokerNodeGroupInfo{
+			ClientSubnets: subnets,
+			StorageInfo:   &kafka.StorageInfo{EbsStorageInfo: &kafka.EBSStorageInfo{VolumeSize: aws.Int64(m.volume)}},
+		},
+	}}, nil
+}
+
+func (m *mockMSK) UpdateBrokerCountWithContext(_ aws.Context, in *kafka.UpdateBrokerCountInput, _ ...request.Option) (*kafka.UpdateBrokerCountOutput, error) {
+	m.calls = append(m.calls, "UpdateBrokerCount")
+	m.versions = append(m.versions, aws.StringValue(in.CurrentVersion))
+	m.pending = func() { m.brokers = aws.Int64Value(in.TargetNumberOfBrokerNodes) }
+	m.left = m.polls
+	return &kafka.UpdateBrokerCountOutput{ClusterOperationArn: aws.String("countOp")}, nil
+}
+
+func (m *mockMSK) UpdateBrokerStorageWithContext(_ aws.Context, in *kafka.UpdateBrokerStorageInput, _ ...request.Option) (*kafka.UpdateBrokerStorageOutput, error) {
+	m.calls = append(m.calls, "UpdateBrokerStorage")
+	m.versions = append(m.versions, aws.StringValue(in.CurrentVersion))
+	m.pending = func() { m.volume = aws.Int64Value(in.TargetBrokerEBSVolumeInfo[0].VolumeSizeGB) }
+	m.left = m.polls
+	return &kafka.UpdateBrokerStorageOutput{ClusterOperationArn: aws.String("storageOp")}, nil
+}
+
+func (m *mockMSK) DescribeClusterOperationWithContext(_ aws.Context, in *kafka.DescribeClusterOperationInput, _ ...request.Option) (*kafka.DescribeClusterOperationOutput, error) {
+	m.calls = append(m.calls, "DescribeClusterOperation")
+	info := &kafka.ClusterOperationInfo{OperationState: aws.String("UPDATE_IN_PROGRESS")}
+	m.left--
+	switch {
+	case m.failOp:
+		info.OperationState = aws.String("UPDATE_FAILED")
+		info.ErrorInfo = &kafka.ErrorInfo{ErrorCode: aws.String("Failed"), ErrorString: aws.String("mocked failure")}
+	case m.left < 0:
+		info.OperationState = aws.String("UPDATE_COMPLETE")
+		m.pending()
+		m.version++
+		m.state = kafka.ClusterStateActive
+		m.running = ""
+	}
+	return &kafka.DescribeClusterOperationOutput{ClusterOperationInfo: info}, nil
+}
+
+func (m *mockMSK) ListClusterOperationsWithContext(aws.Context, *kafka.ListClusterOperationsInput, ...request.Option) (*kafka.ListClusterOperationsOutput, error) {
+	m.calls = append(m.calls, "ListClusterOperations")
+	out := &kafka.ListClusterOperationsOutput{ClusterOperationInfoList: []*kafka.ClusterOperationInfo{
+		{OperationArn: aws.String("monitoringOp"), OperationState: aws.String("UPDATE_COMPLETE")},
+	}}
+	if m.running != "" {
+		out.ClusterOperationInfoList = append([]*kafka.ClusterOperationInfo{
+			{OperationArn: aws.String(m.running), OperationState: aws.String("UPDATE_IN_PROGRESS")},
+		}, out.ClusterOperationInfoList...)
+	}
+	return out, nil
+}
+
+func Test_DesiredSize(t *testing.T) {
+	size, err := desiredSize(map[string]interface{}{"ClusterArn": "dummyArn", "BrokersPerAz": "2", "VolumeSize": "500"})
+	assert.Nil(t, err)
+	assert.Equal(t, clusterSize{BrokersPerAz: 2, VolumeSize: 500}, size)
+
+	_, err = desiredSize(map[string]interface{}{"BrokersPerAz": "0", "VolumeSize": "20000"})
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "BrokersPerAz must be a number between 1 and 100, got 0")
+	assert.Contains(t, err.Error(), "VolumeSize must be a number between 1 and 16384, got 20000")
+	assert.Contains(t, err.Error(), "missing ClusterArn")
+}
+
+func Test_MockScale(t *testing.T) {
+	mskcluster.PollInterval = time.Millisecond
+	outOfTime, cancel := context.WithTimeout(context.Background(), resource.ResponseMargin)
+	defer cancel()
+	resumed := &mockMSK{state: kafka.ClusterStateUpdating, azs: 3, brokers: 3, volume: 100, running: "countOp"}
+	resumed.pending = func() { resumed.brokers = 6 }
+	cases := []struct {
+		Name     string
+		Ctx      context.Context
+		Mock     *mockMSK
+		Size     clusterSize
+		Calls    []string
+		Versions []string
+		Brokers  int64
+		Volume   int64
+		Err      string
+	}{
+		{
+			Name:     "brokers then storage",
+			Mock:     &mockMSK{state: kafka.ClusterStateActive, azs: 3, brokers: 3, volume: 100, polls: 1},
+			Size:     clusterSize{BrokersPerAz: 2, VolumeSize: 500},
+			Calls:    []string{"UpdateBrokerCount", "DescribeClusterOperation", "DescribeClusterOperation", "UpdateBrokerStorage", "DescribeClusterOperation", "DescribeClusterOperation"},
+			Versions: []string{"A", "B"},
+			Brokers:  6,
+			Volume:   500,
+		},
+		{
+			Name:    "storage only",
+			Mock:    &mockMSK{state: kafka.ClusterStateActive, azs: 3, brokers: 6, volume: 100},
+			Size:    clusterSize{BrokersPerAz: 2, VolumeSize: 200},
+			Calls:   []string{"UpdateBrokerStorage", "DescribeClusterOperation"},
+			Brokers: 6,
+			Volume:  200,
+		},
+		{
+			Name:    "unchanged",
+			Mock:    &mockMSK{state: kafka.ClusterStateActive, azs: 3, brokers: 6, volume: 200},
+			Size:    clusterSize{BrokersPerAz: 2, VolumeSize: 200},
+			Brokers: 6,
+			Volume:  200,
+		},
+		{
+			Name: "storage shrinks",
+			Mock: &mockMSK{state: kafka.ClusterStateActive, azs: 3, brokers: 3, volume: 500},
+			Size: clusterSize{BrokersPerAz: 2, VolumeSize: 100},
+			Err:  "broker storage of cluster dummyArn cannot shrink from 500 GiB to 100 GiB",
+		},
+		{
+			Name:  "not active",
+			Mock:  &mockMSK{state: kafka.ClusterStateUpdating, azs: 3, brokers: 3, volume: 100},
+			Size:  clusterSize{BrokersPerAz: 2, VolumeSize: 100},
+			Calls: []string{"ListClusterOperations"},
+			Err:   "cluster dummyArn is UPDATING",
+		},
+		{
+			Name:  "out of time",
+			Ctx:   outOfTime,
+			Mock:  &mockMSK{state: kafka.ClusterStateActive, azs: 3, brokers: 3, volume: 100, polls: 1},
+			Size:  clusterSize{BrokersPerAz: 2, VolumeSize: 500},
+			Calls: []string{"UpdateBrokerCount", "DescribeClusterOperation"},
+			Err:   resource.ErrOutOfTime.Error(),
+		},
+		{
+			//the broker count update started by a previous invocation is still in progress
+			Name:     "resumed",
+			Mock:     resumed,
+			Size:     clusterSize{BrokersPerAz: 2, VolumeSize: 500},
+			Calls:    []string{"ListClusterOperations", "DescribeClusterOperation", "UpdateBrokerStorage", "DescribeClusterOperation"},
+			Versions: []string{"B"},
+			Brokers:  6,
+			Volume:   500,
+		},
+		{
+			Name:  "operation fails",
+			Mock:  &mockMSK{state: kafka.ClusterStateActive, azs: 3, brokers: 3, volume: 100, failOp: true},
+			Size:  clusterSize{BrokersPerAz: 2, VolumeSize: 500},
+			Calls: []string{"UpdateBrokerCount", "DescribeClusterOperation"},
+			Err:   "cluster operation countOp failed: Failed-mocked failure",
+		},
+	}
+
+	for _, c := range cases {
+		ctx := c.Ctx
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		mskApi := MSKclient{Client: c.Mock}
+		state, err := mskApi.scale(ctx, "dummyArn", c.Size)
+		assert.Equal(t, c.Calls, c.Mock.calls, c.Name)
+		if c.Err != "" {
+			assert.NotNil(t, err, c.Name)
+			assert.Contains(t, err.Error(), c.Err, c.Name)
+			continue
+		}
+		assert.Nil(t, err, c.Name)
+		if c.Versions != nil {
+			assert.Equal(t, c.Versions, c.Mock.versions, c.Name)
+		}
+		assert.Equal(t, c.Brokers, state.BrokerCount, c.Name)
+		assert.Equal(t, c.Volume, state.VolumeSize, c.Name)
+	}
+}
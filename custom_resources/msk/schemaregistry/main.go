@@ -0,0 +1,410 @@
+package schemaregistry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-lambda-go/cfn"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/krunal4amity/cfn-infra/custom_resources/resource"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//S3 client
+type S3client struct {
+	Client s3iface.S3API
+}
+
+//schema types the registry understands. AVRO is the default.
+const (
+	schemaTypeAvro     = "AVRO"
+	schemaTypeJson     = "JSON"
+	schemaTypeProtobuf = "PROTOBUF"
+)
+
+//compatibility levels a subject can be given.
+var compatibilityLevels = map[string]bool{
+	"BACKWARD":            true,
+	"BACKWARD_TRANSITIVE": true,
+	"FORWARD":             true,
+	"FORWARD_TRANSITIVE":  true,
+	"FULL":                true,
+	"FULL_TRANSITIVE":     true,
+	"NONE":                true,
+}
+
+//time the registry has to answer a request.
+const registryTimeout = 30 * time.Second
+
+//a subject of the registry as given under Subjects.
+type subject struct {
+	Name          string
+	SchemaType    string //AVRO, JSON or PROTOBUF
+	Schema        string //the schema itself, read from SchemaS3Uri when given there
+	SchemaS3Uri   string
+	Compatibility string //left as the registry has it when empty
+}
+
+//the subjects of a registry as given under ResourceProperties.
+type registrySettings struct {
+	RegistryUrl    string
+	Subjects       []subject
+	DeleteSubjects bool //soft-delete the subjects on delete, and the ones removed from Subjects on update
+}
+
+//reads and validates the resource properties. Every invalid property is reported.
+func settings(properties map[string]interface{}) (registrySettings, error) {
+	var s registrySettings
+	var errs []string
+
+	s.RegistryUrl, _ = properties["RegistryUrl"].(string)
+	if u, err := url.Parse(s.RegistryUrl); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errs = append(errs, fmt.Sprintf("RegistryUrl must be an http(s) url, got %q", s.RegistryUrl))
+	}
+	s.DeleteSubjects, _ = strconv.ParseBool(fmt.Sprint(properties["DeleteSubjects"]))
+
+	subjects, _ := properties["Subjects"].([]interface{})
+	if len(subjects) == 0 {
+		errs = append(errs, "missing Subjects")
+	}
+	seen := make(map[string]bool)
+	for i, item := range subjects {
+		props, ok := item.(map[string]interface{})
+		if !ok {
+			errs = append(errs, fmt.Sprintf("subject %d: expected an object", i+1))
+			continue
+		}
+		sub, subErrs := subjectOf(props)
+		for _, e := range subErrs {
+			errs = append(errs, fmt.Sprintf("subject %d: %s", i+1, e))
+		}
+		if sub.Name != "" && seen[sub.Name] {
+			errs = append(errs, fmt.Sprintf("subject %d: %s is given more than once", i+1, sub.Name))
+		}
+		seen[sub.Name] = true
+		s.Subjects = append(s.Subjects, sub)
+	}
+
+	if len(errs) > 0 {
+		return registrySettings{}, fmt.Errorf("invalid properties: %s", strings.Join(errs, "; "))
+	}
+	return s, nil
+}
+
+//reads a subject. Avro and JSON schemas may be given as objects, which are sent to the registry as JSON.
+func subjectOf(props map[string]interface{}) (subject, []string) {
+	var s subject
+	var errs []string
+
+	s.Name, _ = props["Name"].(string)
+	if s.Name == "" {
+		errs = append(errs, "missing Name")
+	}
+
+	s.SchemaType = schemaTypeAvro
+	if t, ok := props["SchemaType"]; ok {
+		s.SchemaType = strings.ToUpper(fmt.Sprint(t))
+	}
+	switch s.SchemaType {
+	case schemaTypeAvro, schemaTypeJson, schemaTypeProtobuf:
+	default:
+		errs = append(errs, fmt.Sprintf("SchemaType must be AVRO, JSON or PROTOBUF, got %s", s.SchemaType))
+	}
+
+	s.SchemaS3Uri, _ = props["SchemaS3Uri"].(string)
+	switch schema := props["Schema"].(type) {
+	case nil:
+	case string:
+		s.Schema = schema
+	case map[string]interface{}, []interface{}:
+		if s.SchemaType == schemaTypeProtobuf {
+			errs = append(errs, "a PROTOBUF Schema must be given as a string")
+			break
+		}
+		b, err := json.Marshal(schema)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("unable to encode Schema: %v", err))
+		}
+		s.Schema = string(b)
+	default:
+		errs = append(errs, "Schema must be a string or an object")
+	}
+	if (s.Schema == "") == (s.SchemaS3Uri == "") {
+		errs = append(errs, "exactly one of Schema and SchemaS3Uri is required")
+	}
+
+	if c, ok := props["Compatibility"]; ok {
+		s.Compatibility = strings.ToUpper(fmt.Sprint(c))
+		if !compatibilityLevels[s.Compatibility] {
+			errs = append(errs, fmt.Sprintf("unknown Compatibility %s", s.Compatibility))
+		}
+	}
+	return s, errs
+}
+
+//reads a schema file from the given s3 uri e.g. s3://mybucket/schemas/orders.avsc
+func (c *S3client) schemaFile(ctx context.Context, uri string) (string, error) {
+
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "s3" || u.Host == "" || strings.TrimPrefix(u.Path, "/") == "" {
+		return "", fmt.Errorf("%s is not a valid s3 uri of the form s3://bucket/key", uri)
+	}
+
+	param := s3.GetObjectInput{
+		Bucket: aws.String(u.Host),
+		Key:    aws.String(strings.TrimPrefix(u.Path, "/")),
+	}
+
+	out, err := c.Client.GetObjectWithContext(ctx, &param)
+	if err != nil {
+		return "", fmt.Errorf("unable to get schema file %s: %v", uri, err)
+	}
+	defer out.Body.Close()
+
+	b, err := ioutil.ReadAll(out.Body)
+	if err != nil {
+		return "", fmt.Errorf("unable to read schema file %s: %v", uri, err)
+	}
+	return string(b), nil
+}
+
+//reads the schemas of the subjects given in s3.
+func (c *S3client) loadSchemas(ctx context.Context, subjects []subject) error {
+	for i, s := range subjects {
+		if s.SchemaS3Uri == "" {
+			continue
+		}
+		schema, err := c.schemaFile(ctx, s.SchemaS3Uri)
+		if err != nil {
+			return err
+		}
+		subjects[i].Schema = schema
+	}
+	return nil
+}
+
+//sets the compatibility of the desired subjects and registers their schemas where they differ from the latest version, the
+//compatibility first so that a new schema is checked against it. Subjects of the previous settings no longer desired are soft-deleted if
+//DeleteSubjects is set. Returns the version of every desired subject holding its schema.
+func reconcile(ctx context.Context, r RegistryClient, desired, previous registrySettings) ([]schemaVersion, error) {
+
+	var versions []schemaVersion
+	wanted := make(map[string]bool)
+	for _, s := range desired.Subjects {
+		wanted[s.Name] = true
+
+		if s.Compatibility != "" {
+			current, err := r.compatibility(ctx, s.Name)
+			if err != nil {
+				return nil, err
+			}
+			if current != s.Compatibility {
+				log.Printf("setting the compatibility of subject %s to %s", s.Name, s.Compatibility)
+				err = r.setCompatibility(ctx, s.Name, s.Compatibility)
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		//a schema held by an earlier version only, as when going back to it, is registered again.
+		latest, err := r.latestVersion(ctx, s.Name)
+		if err != nil {
+			return nil, err
+		}
+		if latest != nil && latest.holds(s) {
+			log.Printf("schema of subject %s is unchanged, it is version %d", s.Name, latest.Version)
+			versions = append(versions, latest.schemaVersion)
+			continue
+		}
+
+		id, err := r.registerSchema(ctx, s)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("registered schema %d under subject %s", id, s.Name)
+		version, err := r.registeredVersion(ctx, s, id)
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, *version)
+	}
+
+	var removed []string
+	for _, s := range previous.Subjects {
+		if !wanted[s.Name] {
+			removed = append(removed, s.Name)
+		}
+	}
+	if len(removed) == 0 {
+		return versions, nil
+	}
+	if !desired.DeleteSubjects {
+		log.Printf("subjects %v were removed from Subjects. Set DeleteSubjects to delete them.", removed)
+		return versions, nil
+	}
+	old := RegistryClient{Client: r.Client, URL: previous.RegistryUrl}
+	for _, name := range removed {
+		log.Printf("deleting subject %s removed from Subjects", name)
+		err := old.deleteSubject(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return versions, nil
+}
+
+//the versions and schema ids of the subjects as response data.
+func responseData(versions []schemaVersion) map[string]interface{} {
+	var v, ids []string
+	for _, version := range versions {
+		v = append(v, fmt.Sprintf("%s=%d", version.Subject, version.Version))
+		ids = append(ids, fmt.Sprintf("%s=%d", version.Subject, version.Id))
+	}
+	sort.Strings(v)
+	sort.Strings(ids)
+	return map[string]interface{}{
+		"Versions":  strings.Join(v, ","),
+		"SchemaIds": strings.Join(ids, ","),
+	}
+}
+
+//the physical resource id, stable across updates so that a change of RegistryUrl does not delete the subjects.
+func physicalId(logicalId string) string {
+	return logicalId + "/subjects"
+}
+
+//this handler accepts inputs under ResourceProperties as shown below.
+//{
+// "RegistryUrl":"http://dataplatform-schemareg-dev.private.example.com:8081",
+// "Subjects":[
+//			{
+//				"Name":"dev-orders-value"
+//				"SchemaType":"AVRO" (optional. AVRO (default), JSON or PROTOBUF)
+//				"Schema":"{\"type\":\"record\",...}" (the schema, an object for AVRO and JSON works too)
+//				"SchemaS3Uri":"s3://mybucket/schemas/orders.avsc" (instead of Schema)
+//				"Compatibility":"BACKWARD" (optional. left as the registry has it when not given)
+//			},
+//			...
+//		]
+// "DeleteSubjects":"optional. true to soft-delete the subjects on delete and the ones removed from Subjects on update"
+//}
+//The handler returns the following response (sample output), sorted by subject.
+//{
+//		"Versions":"dev-orders-value=3,dev-payments-value=1",
+//		"SchemaIds":"dev-orders-value=21,dev-payments-value=7"
+//}
+//A new version is only registered when the schema is not registered under the subject yet. The registry is waited for
+//on create and update, so the lambda function needs a timeout long enough for its instances to start.
+type Handler struct{}
+
+var _ resource.Handler = Handler{}
+
+//clients of the registry and of s3.
+func clients(registryUrl string) (RegistryClient, S3client) {
+	sess := session.Must(session.NewSession())
+	return RegistryClient{Client: &http.Client{Timeout: registryTimeout}, URL: registryUrl}, S3client{Client: s3.New(sess)}
+}
+
+//Create sets the compatibility of the subjects and registers their schemas.
+func (h Handler) Create(ctx context.Context, event cfn.Event) (physicalResourceId string, data map[string]interface{}, err error) {
+
+	log.Println("CREATE: registering schemas.")
+	log.Printf("event data :%+v\n", event)
+
+	desired, err := settings(event.ResourceProperties)
+	if err != nil {
+		return "", nil, err
+	}
+
+	registry, s3api := clients(desired.RegistryUrl)
+	err = s3api.loadSchemas(ctx, desired.Subjects)
+	if err != nil {
+		return "", nil, err
+	}
+	err = registry.waitUntilReady(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+	versions, err := reconcile(ctx, registry, desired, registrySettings{})
+	if err != nil {
+		return "", nil, err
+	}
+	data = responseData(versions)
+	log.Printf("data to be returned is :%v\n", data)
+	return physicalId(event.LogicalResourceID), data, nil
+}
+
+//Update registers the schemas that changed, sets the compatibility of the subjects and soft-deletes the subjects removed
+//from Subjects if DeleteSubjects is set.
+func (h Handler) Update(ctx context.Context, event cfn.Event) (physicalResourceId string, data map[string]interface{}, err error) {
+
+	log.Println("UPDATE: registering schemas.")
+	log.Printf("event data :%+v\n", event)
+
+	desired, err := settings(event.ResourceProperties)
+	if err != nil {
+		return "", nil, err
+	}
+	//the previous settings were valid when they were applied.
+	previous, _ := settings(event.OldResourceProperties)
+
+	registry, s3api := clients(desired.RegistryUrl)
+	err = s3api.loadSchemas(ctx, desired.Subjects)
+	if err != nil {
+		return "", nil, err
+	}
+	err = registry.waitUntilReady(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+	versions, err := reconcile(ctx, registry, desired, previous)
+	if err != nil {
+		return "", nil, err
+	}
+	data = responseData(versions)
+	log.Printf("data to be returned is :%v\n", data)
+	return event.PhysicalResourceID, data, nil
+}
+
+//Delete soft-deletes the subjects if DeleteSubjects is set and leaves them alone otherwise.
+func (h Handler) Delete(ctx context.Context, event cfn.Event) (physicalResourceId string, data map[string]interface{}, err error) {
+
+	log.Println("DELETE: removing subjects.")
+	log.Printf("event data :%+v\n", event)
+
+	//a failed create leaves no subjects behind.
+	if event.PhysicalResourceID != physicalId(event.LogicalResourceID) {
+		log.Printf("DELETE: %s was not created by this resource. Taking a clean exit...", event.PhysicalResourceID)
+		return event.PhysicalResourceID, nil, nil
+	}
+
+	current, err := settings(event.ResourceProperties)
+	if err != nil {
+		return "", nil, err
+	}
+	if !current.DeleteSubjects {
+		log.Println("DELETE: DeleteSubjects is not set, leaving the subjects in the registry.")
+		return event.PhysicalResourceID, nil, nil
+	}
+
+	registry, _ := clients(current.RegistryUrl)
+	for _, s := range current.Subjects {
+		log.Printf("deleting subject %s", s.Name)
+		err = registry.deleteSubject(ctx, s.Name)
+		if err != nil {
+			return "", nil, err
+		}
+	}
+	return event.PhysicalResourceID, nil, nil
+}
@@ -0,0 +1,338 @@
+package schemaregistry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/krunal4amity/cfn-infra/custom_resources/resource"
+	"github.com/tj/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+//a schema registry holding subjects in memory, standing in for its REST API.
+type fakeRegistry struct {
+	sync.Mutex
+	subjects      map[string][]schemaRequest //schemas of every version of a subject
+	compatibility map[string]string
+	incompatible  map[string]bool //schemas refused as incompatible with the earlier versions
+	keepVersions  bool            //a schema some version already holds is not added again, as confluent registries do
+	ids           map[schemaRequest]int
+	calls         []string
+}
+
+func newFakeRegistry() *fakeRegistry {
+	return &fakeRegistry{
+		subjects:      make(map[string][]schemaRequest),
+		compatibility: make(map[string]string),
+		incompatible:  make(map[string]bool),
+		ids:           make(map[schemaRequest]int),
+	}
+}
+
+func (f *fakeRegistry) fail(w http.ResponseWriter, status, code int, message string) {
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"error_code": code, "message": message})
+}
+
+func (f *fakeRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+
+	path := r.URL.EscapedPath()
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	for i := range parts {
+		parts[i], _ = url.PathUnescape(parts[i])
+	}
+	f.calls = append(f.calls, r.Method+" "+path)
+	w.Header().Set("Content-Type", registryContentType)
+
+	var in struct {
+		schemaRequest
+		Compatibility string `json:"compatibility"`
+	}
+	_ = json.NewDecoder(r.Body).Decode(&in)
+
+	switch {
+	case r.Method == http.MethodPost && len(parts) == 2 && parts[0] == "subjects":
+		versions, ok := f.subjects[parts[1]]
+		if !ok {
+			f.fail(w, http.StatusNotFound, errSubjectNotFound, "Subject not found.")
+			return
+		}
+		for i, schema := range versions {
+			if schema == in.schemaRequest {
+				_ = json.NewEncoder(w).Encode(schemaVersion{Subject: parts[1], Id: f.ids[schema], Version: i + 1})
+				return
+			}
+		}
+		f.fail(w, http.StatusNotFound, errSchemaNotFound, "Schema not found")
+	case r.Method == http.MethodGet && len(parts) == 4 && parts[0] == "subjects" && parts[2] == "versions" && parts[3] == "latest":
+		versions, ok := f.subjects[parts[1]]
+		if !ok {
+			f.fail(w, http.StatusNotFound, errSubjectNotFound, "Subject not found.")
+			return
+		}
+		latest := versions[len(versions)-1]
+		_ = json.NewEncoder(w).Encode(latestSchema{
+			schemaVersion: schemaVersion{Subject: parts[1], Id: f.ids[latest], Version: len(versions)},
+			Schema:        latest.Schema,
+			SchemaType:    latest.SchemaType,
+		})
+	case r.Method == http.MethodPost && len(parts) == 3 && parts[0] == "subjects" && parts[2] == "versions":
+		if f.incompatible[in.Schema] {
+			f.fail(w, http.StatusConflict, http.StatusConflict, "Schema being registered is incompatible with an earlier schema")
+			return
+		}
+		if _, ok := f.ids[in.schemaRequest]; !ok {
+			f.ids[in.schemaRequest] = len(f.ids) + 1
+		}
+		for _, schema := range f.subjects[parts[1]] {
+			if f.keepVersions && schema == in.schemaRequest {
+				_ = json.NewEncoder(w).Encode(map[string]int{"id": f.ids[in.schemaRequest]})
+				return
+			}
+		}
+		f.subjects[parts[1]] = append(f.subjects[parts[1]], in.schemaRequest)
+		_ = json.NewEncoder(w).Encode(map[string]int{"id": f.ids[in.schemaRequest]})
+	case r.Method == http.MethodGet && len(parts) == 2 && parts[0] == "config":
+		level, ok := f.compatibility[parts[1]]
+		if !ok {
+			f.fail(w, http.StatusNotFound, errSubjectConfigNotFound, "Subject does not have subject-level compatibility configured")
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{"compatibilityLevel": level})
+	case r.Method == http.MethodPut && len(parts) == 2 && parts[0] == "config":
+		f.compatibility[parts[1]] = in.Compatibility
+		_ = json.NewEncoder(w).Encode(map[string]string{"compatibility": in.Compatibility})
+	case r.Method == http.MethodDelete && len(parts) == 2 && parts[0] == "subjects":
+		if _, ok := f.subjects[parts[1]]; !ok {
+			f.fail(w, http.StatusNotFound, errSubjectNotFound, "Subject not found.")
+			return
+		}
+		delete(f.subjects, parts[1])
+		_ = json.NewEncoder(w).Encode([]int{1})
+	default:
+		f.fail(w, http.StatusNotFound, http.StatusNotFound, "HTTP 404 Not Found")
+	}
+}
+
+type mockS3 struct {
+	s3iface.S3API
+	objects map[string]string
+}
+
+func (m *mockS3) GetObjectWithContext(_ aws.Context, in *s3.GetObjectInput, _ ...request.Option) (*s3.GetObjectOutput, error) {
+	body, ok := m.objects[aws.StringValue(in.Bucket)+"/"+aws.StringValue(in.Key)]
+	if !ok {
+		return nil, awserr.New(s3.ErrCodeNoSuchKey, "The specified key does not exist.", nil)
+	}
+	return &s3.GetObjectOutput{Body: ioutil.NopCloser(bytes.NewReader([]byte(body)))}, nil
+}
+
+const ordersV1 = `{"type":"record","name":"Order","fields":[{"name":"id","type":"string"}]}`
+const ordersV2 = `{"type":"record","name":"Order","fields":[{"name":"id","type":"string"},{"name":"total","type":"double","default":0}]}`
+
+func Test_Settings(t *testing.T) {
+	s, err := settings(map[string]interface{}{
+		"RegistryUrl":    "http://schemareg.example.com:8081",
+		"DeleteSubjects": "true",
+		"Subjects": []interface{}{
+			map[string]interface{}{"Name": "orders-value", "Schema": ordersV1, "Compatibility": "backward"},
+			map[string]interface{}{"Name": "events-value", "SchemaType": "JSON", "Schema": map[string]interface{}{"type": "object"}},
+			map[string]interface{}{"Name": "users-value", "SchemaType": "PROTOBUF", "SchemaS3Uri": "s3://bucket/users.proto"},
+		},
+	})
+	assert.Nil(t, err)
+	assert.True(t, s.DeleteSubjects)
+	assert.Equal(t, []subject{
+		{Name: "orders-value", SchemaType: schemaTypeAvro, Schema: ordersV1, Compatibility: "BACKWARD"},
+		{Name: "events-value", SchemaType: schemaTypeJson, Schema: `{"type":"object"}`},
+		{Name: "users-value", SchemaType: schemaTypeProtobuf, SchemaS3Uri: "s3://bucket/users.proto"},
+	}, s.Subjects)
+
+	_, err = settings(map[string]interface{}{
+		"RegistryUrl": "schemareg.example.com",
+		"Subjects": []interface{}{
+			map[string]interface{}{"Name": "a", "Schema": ordersV1, "SchemaS3Uri": "s3://bucket/a.avsc"},
+			map[string]interface{}{"Name": "a", "SchemaType": "XML", "Schema": "x"},
+			map[string]interface{}{"Schema": ordersV1, "Compatibility": "SOMETIMES"},
+			map[string]interface{}{"Name": "b", "SchemaType": "PROTOBUF", "Schema": map[string]interface{}{}},
+		},
+	})
+	assert.NotNil(t, err)
+	for _, e := range []string{
+		`RegistryUrl must be an http(s) url, got "schemareg.example.com"`,
+		"subject 1: exactly one of Schema and SchemaS3Uri is required",
+		"subject 2: SchemaType must be AVRO, JSON or PROTOBUF, got XML",
+		"subject 2: a is given more than once",
+		"subject 3: missing Name",
+		"subject 3: unknown Compatibility SOMETIMES",
+		"subject 4: a PROTOBUF Schema must be given as a string",
+	} {
+		assert.Contains(t, err.Error(), e)
+	}
+}
+
+func Test_LoadSchemas(t *testing.T) {
+	s3api := S3client{Client: &mockS3{objects: map[string]string{"bucket/users.proto": "syntax = \"proto3\";"}}}
+	subjects := []subject{{Name: "orders-value", Schema: ordersV1}, {Name: "users-value", SchemaS3Uri: "s3://bucket/users.proto"}}
+	err := s3api.loadSchemas(context.Background(), subjects)
+	assert.Nil(t, err)
+	assert.Equal(t, "syntax = \"proto3\";", subjects[1].Schema)
+
+	err = s3api.loadSchemas(context.Background(), []subject{{Name: "x", SchemaS3Uri: "s3://bucket/missing.avsc"}})
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "unable to get schema file s3://bucket/missing.avsc")
+}
+
+func Test_FakeReconcile(t *testing.T) {
+	ctx := context.Background()
+	fake := newFakeRegistry()
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	registry := RegistryClient{Client: server.Client(), URL: server.URL}
+
+	created := registrySettings{RegistryUrl: server.URL, Subjects: []subject{
+		{Name: "orders-value", SchemaType: schemaTypeAvro, Schema: ordersV1, Compatibility: "BACKWARD"},
+		{Name: "events-value", SchemaType: schemaTypeJson, Schema: `{"type":"object"}`},
+	}}
+	versions, err := reconcile(ctx, registry, created, registrySettings{})
+	assert.Nil(t, err)
+	assert.Equal(t, []schemaVersion{{Subject: "orders-value", Id: 1, Version: 1}, {Subject: "events-value", Id: 2, Version: 1}}, versions)
+	assert.Equal(t, "BACKWARD", fake.compatibility["orders-value"])
+	assert.Equal(t, map[string]interface{}{"Versions": "events-value=1,orders-value=1", "SchemaIds": "events-value=2,orders-value=1"}, responseData(versions))
+
+	//nothing changes, nothing is registered or set.
+	fake.calls = nil
+	_, err = reconcile(ctx, registry, created, created)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"GET /config/orders-value", "GET /subjects/orders-value/versions/latest", "GET /subjects/events-value/versions/latest"}, fake.calls)
+
+	//a new schema becomes a new version, and the removed subject is kept without DeleteSubjects.
+	updated := registrySettings{RegistryUrl: server.URL, Subjects: []subject{
+		{Name: "orders-value", SchemaType: schemaTypeAvro, Schema: ordersV2, Compatibility: "FULL"},
+	}}
+	fake.calls = nil
+	versions, err = reconcile(ctx, registry, updated, created)
+	assert.Nil(t, err)
+	assert.Equal(t, []schemaVersion{{Subject: "orders-value", Id: 3, Version: 2}}, versions)
+	assert.Equal(t, []string{"GET /config/orders-value", "PUT /config/orders-value", "GET /subjects/orders-value/versions/latest",
+		"POST /subjects/orders-value/versions", "GET /subjects/orders-value/versions/latest"}, fake.calls)
+	assert.Equal(t, "FULL", fake.compatibility["orders-value"])
+	assert.Contains(t, fake.subjects, "events-value")
+
+	//going back to an earlier schema registers it again, as the latest version.
+	back := registrySettings{RegistryUrl: server.URL, Subjects: []subject{{Name: "orders-value", SchemaType: schemaTypeAvro, Schema: ordersV1}}}
+	versions, err = reconcile(ctx, registry, back, updated)
+	assert.Nil(t, err)
+	assert.Equal(t, []schemaVersion{{Subject: "orders-value", Id: 1, Version: 3}}, versions)
+	assert.Equal(t, 3, len(fake.subjects["orders-value"]))
+
+	//the same schema of another type is a new version too.
+	retyped := registrySettings{RegistryUrl: server.URL, Subjects: []subject{{Name: "orders-value", SchemaType: schemaTypeJson, Schema: ordersV1}}}
+	versions, err = reconcile(ctx, registry, retyped, back)
+	assert.Nil(t, err)
+	assert.Equal(t, []schemaVersion{{Subject: "orders-value", Id: 4, Version: 4}}, versions)
+
+	//a registry keeping the earlier version holding the schema reports that one.
+	fake.keepVersions = true
+	versions, err = reconcile(ctx, registry, back, retyped)
+	assert.Nil(t, err)
+	assert.Equal(t, []schemaVersion{{Subject: "orders-value", Id: 1, Version: 1}}, versions)
+	assert.Equal(t, 4, len(fake.subjects["orders-value"]))
+	fake.keepVersions = false
+
+	//removed subjects are soft-deleted with DeleteSubjects.
+	back.DeleteSubjects = true
+	_, err = reconcile(ctx, registry, back, created)
+	assert.Nil(t, err)
+	assert.NotContains(t, fake.subjects, "events-value")
+
+	//an incompatible schema is refused.
+	fake.incompatible["bad"] = true
+	_, err = reconcile(ctx, registry, registrySettings{RegistryUrl: server.URL, Subjects: []subject{
+		{Name: "orders-value", SchemaType: schemaTypeAvro, Schema: "bad", Compatibility: "FULL"},
+	}}, back)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "the schema of subject orders-value is not FULL compatible with its earlier versions: 409-Schema being registered is incompatible")
+}
+
+func Test_FakeRegistryClient(t *testing.T) {
+	ctx := context.Background()
+	fake := newFakeRegistry()
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	registry := RegistryClient{Client: server.Client(), URL: server.URL + "/"}
+
+	//JSON and PROTOBUF schemas carry their type, AVRO ones leave it to the registry default.
+	var bodies []string
+	capture := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		bodies = append(bodies, string(b))
+		_, _ = w.Write([]byte(`{"id":1}`))
+	}))
+	defer capture.Close()
+	captureClient := RegistryClient{Client: capture.Client(), URL: capture.URL}
+	_, err := captureClient.registerSchema(ctx, subject{Name: "a", SchemaType: schemaTypeAvro, Schema: "x"})
+	assert.Nil(t, err)
+	_, err = captureClient.registerSchema(ctx, subject{Name: "b", SchemaType: schemaTypeProtobuf, Schema: "y"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{`{"schema":"x"}`, `{"schema":"y","schemaType":"PROTOBUF"}`}, bodies)
+
+	//the registry is waited for until it answers.
+	readyPollInterval = time.Millisecond
+	var polls int
+	starting := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if polls++; polls < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte(`[]`))
+	}))
+	defer starting.Close()
+	assert.Nil(t, (&RegistryClient{Client: starting.Client(), URL: starting.URL}).waitUntilReady(ctx))
+	assert.Equal(t, 3, polls)
+
+	//but not past the time left to respond to cloudformation.
+	outOfTime, cancel := context.WithTimeout(ctx, resource.ResponseMargin)
+	defer cancel()
+	polls = 0
+	err = (&RegistryClient{Client: starting.Client(), URL: starting.URL}).waitUntilReady(outOfTime)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "gave up waiting for the schema registry at "+starting.URL+" (lambda function is about to time out)")
+	assert.Equal(t, 1, polls)
+
+	//subjects that do not exist are fine to delete and have no compatibility.
+	assert.Nil(t, registry.deleteSubject(ctx, "missing"))
+	level, err := registry.compatibility(ctx, "missing")
+	assert.Nil(t, err)
+	assert.Equal(t, "", level)
+
+	//names are escaped in the path.
+	_, err = registry.registerSchema(ctx, subject{Name: "a/b", SchemaType: schemaTypeAvro, Schema: ordersV1})
+	assert.Nil(t, err)
+	assert.Contains(t, fake.calls, "POST /subjects/a%2Fb/versions")
+	assert.Contains(t, fake.subjects, "a/b")
+
+	//errors that are not the registry's own are reported with the http status.
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+		_, _ = w.Write([]byte("bad gateway"))
+	}))
+	defer down.Close()
+	_, err = (&RegistryClient{Client: down.Client(), URL: down.URL}).lookupSchema(ctx, subject{Name: "a", Schema: "x"})
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "unable to look up the schema of subject a: 502-bad gateway")
+}
@@ -0,0 +1,269 @@
+package schemaregistry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/krunal4amity/cfn-infra/custom_resources/resource"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+//content type of the schema registry REST API.
+const registryContentType = "application/vnd.schemaregistry.v1+json"
+
+//error codes of the schema registry REST API.
+const (
+	errSubjectNotFound       = 40401
+	errVersionNotFound       = 40402
+	errSchemaNotFound        = 40403
+	errSubjectConfigNotFound = 40408
+)
+
+//Schema Registry client speaking its REST API.
+type RegistryClient struct {
+	Client *http.Client
+	URL    string //e.g. http://dataplatform-schemareg-dev.private.example.com:8081
+}
+
+//an error returned by the schema registry.
+type registryError struct {
+	Status  int
+	Code    int    `json:"error_code"`
+	Message string `json:"message"`
+}
+
+func (e *registryError) Error() string {
+	return fmt.Sprintf("%d-%s", e.Code, e.Message)
+}
+
+//whether the error is a registry error of one of the given codes.
+func isRegistryError(err error, codes ...int) bool {
+	rerr, ok := err.(*registryError)
+	if !ok {
+		return false
+	}
+	for _, code := range codes {
+		if rerr.Code == code {
+			return true
+		}
+	}
+	return false
+}
+
+//a version of a schema registered under a subject.
+type schemaVersion struct {
+	Subject string `json:"subject"`
+	Id      int    `json:"id"`
+	Version int    `json:"version"`
+}
+
+//the latest version of a subject along with its schema. The registry leaves out the schemaType of AVRO schemas.
+type latestSchema struct {
+	schemaVersion
+	Schema     string `json:"schema"`
+	SchemaType string `json:"schemaType"`
+}
+
+//whether the latest version holds the schema of the subject, with the same schema type.
+func (l *latestSchema) holds(s subject) bool {
+	schemaType := l.SchemaType
+	if schemaType == "" {
+		schemaType = schemaTypeAvro
+	}
+	return l.Schema == s.Schema && schemaType == s.SchemaType
+}
+
+//the schema as sent to the registry. The registry assumes AVRO when no schemaType is given, which is all registries
+//before confluent 5.5 understand.
+type schemaRequest struct {
+	Schema     string `json:"schema"`
+	SchemaType string `json:"schemaType,omitempty"`
+}
+
+func newSchemaRequest(s subject) schemaRequest {
+	r := schemaRequest{Schema: s.Schema}
+	if s.SchemaType != schemaTypeAvro {
+		r.SchemaType = s.SchemaType
+	}
+	return r
+}
+
+//sends a request to the registry and decodes its response into out, unless out is nil.
+func (r *RegistryClient) do(ctx context.Context, method, path string, in interface{}, out interface{}) error {
+
+	var body []byte
+	if in != nil {
+		var err error
+		body, err = json.Marshal(in)
+		if err != nil {
+			return fmt.Errorf("unable to encode request to %s : %v", path, err)
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(r.URL, "/")+path, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("unable to create request to %s : %v", path, err)
+	}
+	req.Header.Set("Accept", registryContentType)
+	if in != nil {
+		req.Header.Set("Content-Type", registryContentType)
+	}
+
+	resp, err := r.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("unable to reach the schema registry at %s : %v", r.URL, err)
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("unable to read the response of %s %s : %v", method, path, err)
+	}
+
+	if resp.StatusCode >= 300 {
+		rerr := &registryError{Status: resp.StatusCode}
+		if json.Unmarshal(b, rerr) != nil || rerr.Code == 0 {
+			rerr.Code = resp.StatusCode
+			rerr.Message = strings.TrimSpace(string(b))
+		}
+		return rerr
+	}
+	if out == nil {
+		return nil
+	}
+	err = json.Unmarshal(b, out)
+	if err != nil {
+		return fmt.Errorf("unable to decode the response of %s %s : %v", method, path, err)
+	}
+	return nil
+}
+
+//interval between two checks of whether the registry is up.
+var readyPollInterval = 10 * time.Second
+
+//waits for the registry to answer, since its instances may still be starting along with the stack. Gives up when the
+//lambda function is about to time out.
+func (r *RegistryClient) waitUntilReady(ctx context.Context) error {
+	for {
+		err := r.do(ctx, http.MethodGet, "/subjects", nil, nil)
+		if err == nil {
+			return nil
+		}
+		log.Printf("schema registry at %s is not ready : %v", r.URL, err)
+
+		if serr := resource.Sleep(ctx, readyPollInterval); serr != nil {
+			return fmt.Errorf("gave up waiting for the schema registry at %s (%v) : %v", r.URL, serr, err)
+		}
+	}
+}
+
+//finds out the latest version of the subject, nil if the subject has none.
+func (r *RegistryClient) latestVersion(ctx context.Context, name string) (*latestSchema, error) {
+
+	var latest latestSchema
+	err := r.do(ctx, http.MethodGet, "/subjects/"+url.PathEscape(name)+"/versions/latest", nil, &latest)
+	if err != nil {
+		if isRegistryError(err, errSubjectNotFound, errVersionNotFound) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("unable to get the latest version of subject %s: %v", name, err)
+	}
+	return &latest, nil
+}
+
+//finds out the version of the subject holding the schema, whichever it is, nil if the subject has no such schema.
+func (r *RegistryClient) lookupSchema(ctx context.Context, s subject) (*schemaVersion, error) {
+
+	var version schemaVersion
+	err := r.do(ctx, http.MethodPost, "/subjects/"+url.PathEscape(s.Name), newSchemaRequest(s), &version)
+	if err != nil {
+		if isRegistryError(err, errSubjectNotFound, errSchemaNotFound) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("unable to look up the schema of subject %s: %v", s.Name, err)
+	}
+	return &version, nil
+}
+
+//registers the schema as a new version of the subject. Returns the schema id.
+func (r *RegistryClient) registerSchema(ctx context.Context, s subject) (int, error) {
+
+	var out struct {
+		Id int `json:"id"`
+	}
+	err := r.do(ctx, http.MethodPost, "/subjects/"+url.PathEscape(s.Name)+"/versions", newSchemaRequest(s), &out)
+	if err != nil {
+		if rerr, ok := err.(*registryError); ok && rerr.Status == http.StatusConflict {
+			return 0, fmt.Errorf("the schema of subject %s is not %s compatible with its earlier versions: %v", s.Name, s.Compatibility, err)
+		}
+		return 0, fmt.Errorf("unable to register the schema of subject %s: %v", s.Name, err)
+	}
+	return out.Id, nil
+}
+
+//finds out the version of the subject the schema registered with the id is: the latest one, unless the registry kept an
+//earlier version holding the same schema instead of adding one.
+func (r *RegistryClient) registeredVersion(ctx context.Context, s subject, id int) (*schemaVersion, error) {
+
+	latest, err := r.latestVersion(ctx, s.Name)
+	if err != nil {
+		return nil, err
+	}
+	if latest != nil && latest.Id == id {
+		return &latest.schemaVersion, nil
+	}
+	version, err := r.lookupSchema(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+	if version == nil {
+		return nil, fmt.Errorf("schema %d registered under subject %s is not found there", id, s.Name)
+	}
+	log.Printf("the registry kept version %d of subject %s for schema %d", version.Version, s.Name, id)
+	return version, nil
+}
+
+//finds out the compatibility level set on the subject, empty if none is.
+func (r *RegistryClient) compatibility(ctx context.Context, name string) (string, error) {
+
+	var out struct {
+		CompatibilityLevel string `json:"compatibilityLevel"`
+	}
+	err := r.do(ctx, http.MethodGet, "/config/"+url.PathEscape(name), nil, &out)
+	if err != nil {
+		if isRegistryError(err, errSubjectNotFound, errSubjectConfigNotFound) {
+			return "", nil
+		}
+		return "", fmt.Errorf("unable to get the compatibility of subject %s: %v", name, err)
+	}
+	return out.CompatibilityLevel, nil
+}
+
+//sets the compatibility level of the subject.
+func (r *RegistryClient) setCompatibility(ctx context.Context, name, level string) error {
+
+	err := r.do(ctx, http.MethodPut, "/config/"+url.PathEscape(name), map[string]string{"compatibility": level}, nil)
+	if err != nil {
+		return fmt.Errorf("unable to set the compatibility of subject %s to %s: %v", name, level, err)
+	}
+	return nil
+}
+
+//soft-deletes all versions of the subject. Their schemas stay in the registry and the subject can be registered again.
+//A subject that does not exist is fine.
+func (r *RegistryClient) deleteSubject(ctx context.Context, name string) error {
+
+	err := r.do(ctx, http.MethodDelete, "/subjects/"+url.PathEscape(name), nil, nil)
+	if err != nil {
+		if isRegistryError(err, errSubjectNotFound) {
+			return nil
+		}
+		return fmt.Errorf("unable to delete subject %s: %v", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"github.com/krunal4amity/cfn-infra/custom_resources/eks/ekscluster"
+	"github.com/krunal4amity/cfn-infra/custom_resources/es/publishlogoptions"
+	"github.com/krunal4amity/cfn-infra/custom_resources/msk/consumergroup"
+	"github.com/krunal4amity/cfn-infra/custom_resources/msk/kafkaacls"
+	"github.com/krunal4amity/cfn-infra/custom_resources/msk/kafkascram"
+	"github.com/krunal4amity/cfn-infra/custom_resources/msk/monitorkafka"
+	"github.com/krunal4amity/cfn-infra/custom_resources/msk/postprocesskafka"
+	"github.com/krunal4amity/cfn-infra/custom_resources/msk/preprocesskafka"
+	"github.com/krunal4amity/cfn-infra/custom_resources/msk/scalekafka"
+	"github.com/krunal4amity/cfn-infra/custom_resources/msk/schemaregistry"
+	"github.com/krunal4amity/cfn-infra/custom_resources/resource"
+)
+
+//every custom resource served by the multi-resource lambda function. Add new handlers here.
+func init() {
+	resource.Register("EksCluster", ekscluster.Handler{})
+	resource.Register("ESPublishLogOptions", publishlogoptions.Handler{})
+	resource.Register("KafkaPreProcessor", preprocesskafka.Handler{})
+	resource.Register("KafkaPostProcessor", postprocesskafka.Handler{})
+	resource.Register("KafkaAcls", kafkaacls.Handler{})
+	resource.Register("KafkaScramSecrets", kafkascram.Handler{})
+	resource.Register("KafkaScaling", scalekafka.Handler{})
+	resource.Register("SchemaRegistrySubjects", schemaregistry.Handler{})
+	resource.Register("KafkaConsumerGroup", consumergroup.Handler{})
+	resource.Register("KafkaMonitoring", monitorkafka.Handler{})
+}
@@ -0,0 +1,15 @@
+package main
+
+import (
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/krunal4amity/cfn-infra/custom_resources/resource"
+	"log"
+)
+
+//a single lambda function serving every custom resource registered in handlers.go. The resource is picked by its
+//cloudformation type i.e. Custom::<Name>, so the custom resources in the templates need to use that type instead of
+//AWS::CloudFormation::CustomResource. Packaged as multiresource.zip
+func main() {
+	log.Printf("serving custom resource types : %v", resource.Registered())
+	lambda.Start(resource.LambdaWrap(resource.Dispatch))
+}
@@ -0,0 +1,114 @@
+package resource
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-lambda-go/cfn"
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"log"
+)
+
+//MaxContinuations is the number of times a request is continued in a new invocation before giving up. Along with the 15
+//minutes a lambda function runs at most, it covers the hour cloudformation waits on a custom resource.
+const MaxContinuations = 4
+
+//ErrContinued is returned by a handler which handed the request over to a new invocation of its lambda function with
+//Continue. The new invocation responds to cloudformation in its place.
+var ErrContinued = errors.New("request continued in a new invocation")
+
+//Request is the event a lambda function wrapped by LambdaWrap receives: the cloudformation event, and the number of
+//times it was continued.
+type Request struct {
+	cfn.Event
+	Continuations int `json:",omitempty"`
+}
+
+type continuationsKey struct{}
+
+//invokes the lambda function of the current invocation asynchronously with the payload.
+var invokeAsync = func(ctx context.Context, payload []byte) error {
+	sess, err := session.NewSession()
+	if err != nil {
+		return fmt.Errorf("unable to create a new session: %v", err)
+	}
+	_, err = lambda.New(sess).InvokeWithContext(ctx, &lambda.InvokeInput{
+		FunctionName:   aws.String(lambdacontext.FunctionName),
+		InvocationType: aws.String(lambda.InvocationTypeEvent),
+		Payload:        payload,
+	})
+	return err
+}
+
+//Continue hands the event over to a new invocation of the lambda function, for a handler running out of time with
+//work left. The handler returns the ErrContinued returned, and the new invocation serves the event again from the start,
+//so the handler needs to pick up where it left off. Gives up after MaxContinuations.
+func Continue(ctx context.Context, event cfn.Event) error {
+	continuations, _ := ctx.Value(continuationsKey{}).(int)
+	if continuations >= MaxContinuations {
+		return fmt.Errorf("gave up after %d invocations of the lambda function", continuations+1)
+	}
+
+	payload, err := json.Marshal(Request{Event: event, Continuations: continuations + 1})
+	if err != nil {
+		return fmt.Errorf("unable to continue request %s : %v", event.RequestID, err)
+	}
+	err = invokeAsync(ctx, payload)
+	if err != nil {
+		return fmt.Errorf("unable to continue request %s in a new invocation : %v", event.RequestID, err)
+	}
+	log.Printf("request %s continued in invocation %d", event.RequestID, continuations+2)
+	return ErrContinued
+}
+
+//send responds to cloudformation, replaced by the tests.
+var send = func(r *cfn.Response) error {
+	return r.Send()
+}
+
+//LambdaWrap turns the function into a lambda function responding to cloudformation, as cfn.LambdaWrap does, except that
+//no response is sent for a request the function continued in a new invocation.
+func LambdaWrap(fn cfn.CustomResourceFunction) func(ctx context.Context, request Request) (reason string, err error) {
+	return func(ctx context.Context, request Request) (reason string, err error) {
+		event := request.Event
+		r := cfn.NewResponse(&event)
+
+		funcDidPanic := true
+		defer func() {
+			if funcDidPanic {
+				r.Status = cfn.StatusFailed
+				r.Reason = "Function panicked, see log stream for details"
+				_ = send(r)
+			}
+		}()
+
+		ctx = context.WithValue(ctx, continuationsKey{}, request.Continuations)
+		r.PhysicalResourceID, r.Data, err = fn(ctx, event)
+		funcDidPanic = false
+
+		switch {
+		case err == ErrContinued:
+			return err.Error(), nil
+		case err != nil:
+			r.Status = cfn.StatusFailed
+			r.Reason = err.Error()
+			log.Printf("sending status failed: %s", r.Reason)
+		default:
+			r.Status = cfn.StatusSuccess
+			if r.PhysicalResourceID == "" {
+				log.Println("PhysicalResourceID must exist on creation, copying Log Stream name")
+				r.PhysicalResourceID = lambdacontext.LogStreamName
+			}
+		}
+
+		err = send(r)
+		if err != nil {
+			reason = err.Error()
+		}
+		return reason, err
+	}
+}
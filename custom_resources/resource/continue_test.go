@@ -0,0 +1,73 @@
+package resource
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/aws/aws-lambda-go/cfn"
+	"github.com/tj/assert"
+	"testing"
+)
+
+func Test_LambdaWrapContinue(t *testing.T) {
+	var sent []*cfn.Response
+	send = func(r *cfn.Response) error {
+		sent = append(sent, r)
+		return nil
+	}
+	var invoked []Request
+	invokeAsync = func(ctx context.Context, payload []byte) error {
+		var request Request
+		err := json.Unmarshal(payload, &request)
+		invoked = append(invoked, request)
+		return err
+	}
+
+	//continues until done on the third invocation
+	calls := 0
+	fn := LambdaWrap(func(ctx context.Context, event cfn.Event) (string, map[string]interface{}, error) {
+		calls++
+		if calls < 3 {
+			return "", nil, Continue(ctx, event)
+		}
+		return "scaled", nil, nil
+	})
+	request := Request{Event: cfn.Event{RequestID: "req", RequestType: cfn.RequestCreate}}
+	for {
+		_, err := fn(context.Background(), request)
+		assert.Nil(t, err)
+		if len(sent) > 0 {
+			break
+		}
+		request = invoked[len(invoked)-1]
+	}
+	assert.Equal(t, 3, calls)
+	assert.Equal(t, 2, len(invoked))
+	assert.Equal(t, 2, invoked[1].Continuations)
+	assert.Equal(t, "req", invoked[1].RequestID)
+	assert.Equal(t, 1, len(sent))
+	assert.Equal(t, cfn.StatusSuccess, sent[0].Status)
+	assert.Equal(t, "scaled", sent[0].PhysicalResourceID)
+
+	//gives up after MaxContinuations
+	sent, invoked = nil, nil
+	fn = LambdaWrap(func(ctx context.Context, event cfn.Event) (string, map[string]interface{}, error) {
+		return "", nil, Continue(ctx, event)
+	})
+	_, err := fn(context.Background(), Request{Event: cfn.Event{RequestID: "req"}, Continuations: MaxContinuations})
+	assert.Nil(t, err)
+	assert.Empty(t, invoked)
+	assert.Equal(t, 1, len(sent))
+	assert.Equal(t, cfn.StatusFailed, sent[0].Status)
+	assert.Equal(t, "gave up after 5 invocations of the lambda function", sent[0].Reason)
+
+	//a failed invocation fails the request
+	sent = nil
+	invokeAsync = func(ctx context.Context, payload []byte) error {
+		return errors.New("AccessDeniedException")
+	}
+	_, err = fn(context.Background(), Request{Event: cfn.Event{RequestID: "req"}})
+	assert.Nil(t, err)
+	assert.Equal(t, cfn.StatusFailed, sent[0].Status)
+	assert.Equal(t, "unable to continue request req in a new invocation : AccessDeniedException", sent[0].Reason)
+}
@@ -0,0 +1,31 @@
+package resource
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+//ResponseMargin is the time a handler leaves before the deadline of its lambda function to respond to cloudformation.
+//A function killed by its timeout sends no response, leaving the stack waiting on the custom resource for an hour.
+var ResponseMargin = 30 * time.Second
+
+//ErrOutOfTime is returned by Sleep when waiting any longer would leave no time to respond to cloudformation.
+var ErrOutOfTime = errors.New("lambda function is about to time out")
+
+//Sleep pauses for d between two polls of something to complete. It returns ErrOutOfTime right away instead if the pause
+//would end within ResponseMargin of the deadline of ctx, which for a lambda function is its timeout.
+func Sleep(ctx context.Context, d time.Duration) error {
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline)-d < ResponseMargin {
+		return ErrOutOfTime
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
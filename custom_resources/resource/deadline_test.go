@@ -0,0 +1,28 @@
+package resource
+
+import (
+	"context"
+	"github.com/tj/assert"
+	"testing"
+	"time"
+)
+
+func Test_Sleep(t *testing.T) {
+	assert.Nil(t, Sleep(context.Background(), time.Millisecond))
+
+	//plenty of time left
+	ctx, cancel := context.WithTimeout(context.Background(), ResponseMargin+time.Minute)
+	defer cancel()
+	assert.Nil(t, Sleep(ctx, time.Millisecond))
+
+	//the pause would eat into the margin
+	ctx, cancel = context.WithTimeout(context.Background(), ResponseMargin+time.Second)
+	defer cancel()
+	start := time.Now()
+	assert.Equal(t, ErrOutOfTime, Sleep(ctx, 2*time.Second))
+	assert.True(t, time.Since(start) < time.Second)
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(t, context.Canceled, Sleep(ctx, time.Minute))
+}
@@ -0,0 +1,91 @@
+package resource
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-lambda-go/cfn"
+	"log"
+	"sort"
+	"sync"
+)
+
+//prefix of the cloudformation resource type every registered handler is served under e.g. Custom::KafkaPreProcessor
+const TypePrefix = "Custom::"
+
+//Handler is implemented by every custom resource. Each method receives the raw cloudformation event and returns
+//the physical resource id and the response data in the same way as a cfn.CustomResourceFunction does.
+type Handler interface {
+	Create(ctx context.Context, event cfn.Event) (physicalResourceId string, data map[string]interface{}, err error)
+	Update(ctx context.Context, event cfn.Event) (physicalResourceId string, data map[string]interface{}, err error)
+	Delete(ctx context.Context, event cfn.Event) (physicalResourceId string, data map[string]interface{}, err error)
+}
+
+var (
+	mu       sync.RWMutex
+	handlers = make(map[string]Handler)
+)
+
+//Serve turns a single handler into a cfn.CustomResourceFunction by routing on the request type of the event.
+//Used by the per-handler lambda functions.
+func Serve(h Handler) cfn.CustomResourceFunction {
+	return func(ctx context.Context, event cfn.Event) (string, map[string]interface{}, error) {
+		switch event.RequestType {
+		case cfn.RequestCreate:
+			return h.Create(ctx, event)
+		case cfn.RequestUpdate:
+			return h.Update(ctx, event)
+		case cfn.RequestDelete:
+			return h.Delete(ctx, event)
+		}
+		return "", nil, fmt.Errorf("unsupported request type %s", event.RequestType)
+	}
+}
+
+//Register makes the handler available to Dispatch under the resource type Custom::<name>.
+//Registering the same name twice is a programming error and panics.
+func Register(name string, h Handler) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	resourceType := TypePrefix + name
+	if h == nil {
+		panic("resource: nil handler registered for " + resourceType)
+	}
+	if _, ok := handlers[resourceType]; ok {
+		panic("resource: handler registered twice for " + resourceType)
+	}
+	handlers[resourceType] = h
+}
+
+//Registered returns the sorted list of resource types that can be dispatched.
+func Registered() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	var types []string
+	for t := range handlers {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	return types
+}
+
+//Dispatch finds the handler registered for event.ResourceType and serves the event with it.
+//Used by the multi-resource lambda function.
+func Dispatch(ctx context.Context, event cfn.Event) (physicalResourceId string, data map[string]interface{}, err error) {
+	mu.RLock()
+	h, ok := handlers[event.ResourceType]
+	mu.RUnlock()
+
+	if !ok {
+		//a failed delete would leave the stack stuck in DELETE_FAILED, hence unknown resources are let go on delete.
+		if event.RequestType == cfn.RequestDelete {
+			log.Printf("DELETE: no handler registered for %s. Taking a clean exit...", event.ResourceType)
+			return event.PhysicalResourceID, nil, nil
+		}
+		return "", nil, fmt.Errorf("no handler registered for resource type %s. Registered types are %v", event.ResourceType, Registered())
+	}
+
+	log.Printf("dispatching %s request for %s", event.RequestType, event.ResourceType)
+	return Serve(h)(ctx, event)
+}
@@ -0,0 +1,54 @@
+package resource
+
+import (
+	"context"
+	"github.com/aws/aws-lambda-go/cfn"
+	"github.com/tj/assert"
+	"testing"
+)
+
+type mockHandler struct {
+	name string
+}
+
+func (m mockHandler) Create(ctx context.Context, event cfn.Event) (string, map[string]interface{}, error) {
+	return m.name + "-created", nil, nil
+}
+
+func (m mockHandler) Update(ctx context.Context, event cfn.Event) (string, map[string]interface{}, error) {
+	return m.name + "-updated", nil, nil
+}
+
+func (m mockHandler) Delete(ctx context.Context, event cfn.Event) (string, map[string]interface{}, error) {
+	return m.name + "-deleted", nil, nil
+}
+
+func Test_Dispatch(t *testing.T) {
+	Register("First", mockHandler{"first"})
+	Register("Second", mockHandler{"second"})
+
+	cases := []struct {
+		Event    cfn.Event
+		Expected string
+		Err      bool
+	}{
+		{Event: cfn.Event{RequestType: cfn.RequestCreate, ResourceType: "Custom::First"}, Expected: "first-created"},
+		{Event: cfn.Event{RequestType: cfn.RequestUpdate, ResourceType: "Custom::Second"}, Expected: "second-updated"},
+		{Event: cfn.Event{RequestType: cfn.RequestDelete, ResourceType: "Custom::First"}, Expected: "first-deleted"},
+		{Event: cfn.Event{RequestType: cfn.RequestCreate, ResourceType: "Custom::Unknown"}, Err: true},
+		{Event: cfn.Event{RequestType: cfn.RequestDelete, ResourceType: "Custom::Unknown", PhysicalResourceID: "abc"}, Expected: "abc"},
+	}
+
+	for _, c := range cases {
+		id, _, err := Dispatch(context.Background(), c.Event)
+		if c.Err {
+			assert.NotNil(t, err)
+			continue
+		}
+		assert.Nil(t, err)
+		assert.Equal(t, c.Expected, id)
+	}
+
+	assert.Equal(t, []string{"Custom::First", "Custom::Second"}, Registered())
+	assert.Panics(t, func() { Register("First", mockHandler{"again"}) })
+}